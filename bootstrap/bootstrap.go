@@ -12,6 +12,7 @@ import (
 	"subuk/vmango/libvirt"
 	"subuk/vmango/util"
 	"subuk/vmango/web"
+	"time"
 
 	"github.com/rs/zerolog"
 )
@@ -60,6 +61,37 @@ func Web(configFilename string) {
 		os.Exit(1)
 	}
 
+	builtinFlavors := []*libcompute.Flavor{}
+	for _, flavorConfig := range cfg.Flavors {
+		flavor := &libcompute.Flavor{
+			Name:      flavorConfig.Name,
+			VCpus:     flavorConfig.Vcpus,
+			Memory:    libcompute.NewSize(uint64(flavorConfig.MemoryMb), libcompute.SizeUnitM),
+			RootDisk:  libcompute.NewSize(uint64(flavorConfig.RootDiskGb), libcompute.SizeUnitG),
+			Hugepages: flavorConfig.Hugepages,
+			NodeIds:   flavorConfig.Nodes,
+		}
+		for _, size := range flavorConfig.ExtraDisksGb {
+			flavor.ExtraDisks = append(flavor.ExtraDisks, libcompute.NewSize(uint64(size), libcompute.SizeUnitG))
+		}
+		builtinFlavors = append(builtinFlavors, flavor)
+	}
+	flavorRepo, err := filesystem.NewFlavorRepository(util.ExpandHomeDir(cfg.FlavorFile), builtinFlavors)
+	if err != nil {
+		logger.Error().Err(err).Msg("cannot initialize flavor storage")
+		os.Exit(1)
+	}
+	presetRepo, err := filesystem.NewVirtualMachinePresetRepository(util.ExpandHomeDir(cfg.PresetFile))
+	if err != nil {
+		logger.Error().Err(err).Msg("cannot initialize preset storage")
+		os.Exit(1)
+	}
+	scheduleRepo, err := filesystem.NewScheduleRepository(util.ExpandHomeDir(cfg.ScheduleFile))
+	if err != nil {
+		logger.Error().Err(err).Msg("cannot initialize schedule storage")
+		os.Exit(1)
+	}
+
 	epub := filesystem.NewScriptedComputeEventBroker(logger.With().Str("component", "compute-event-broker").Logger())
 	for _, sub := range cfg.Subscribes {
 		epub.Subscribe(sub.Event, sub.Script, sub.Mandatory)
@@ -85,11 +117,21 @@ func Web(configFilename string) {
 				Msg("unknown libvirt configdrive write format")
 			os.Exit(1)
 		}
+		diskDriver := compute.VirtualMachineVolumeDriver{
+			Cache:        c.DiskCache,
+			Io:           c.DiskIo,
+			Discard:      c.DiskDiscard,
+			DetectZeroes: c.DiskDetectZeroes,
+		}
+		if err := diskDriver.Validate(); err != nil {
+			logger.Error().Err(err).Str("libvirt", c.Name).Msg("invalid libvirt disk driver defaults")
+			os.Exit(1)
+		}
 		vmRepSettings[c.Name] = libvirt.NodeSettings{
 			CdSuffix:             c.ConfigDriveSuffix,
 			Emulator:             c.Emulator,
 			QcowPreallocMetadata: c.QcowPreallocMetadata,
-			Cache:                c.Cache,
+			DiskDriver:           diskDriver,
 		}
 		vmManSettings[c.Name] = compute.VirtualMachineManagerNodeSettings{
 			CdPool:   c.ConfigDrivePool,
@@ -98,6 +140,10 @@ func Web(configFilename string) {
 		}
 
 	}
+	if err := libvirt.StartEventLoop(logger.With().Str("component", "libvirt-events").Logger()); err != nil {
+		logger.Error().Err(err).Msg("cannot start libvirt event loop")
+		os.Exit(1)
+	}
 	connectionPool := libvirt.NewConnectionPool(nodeUri, nodeOrder, logger.With().Str("component", "libvirt-connection-pool").Logger())
 
 	vmRepo := libvirt.NewVirtualMachineRepository(connectionPool, vmRepSettings, logger.With().Str("component", "vm-repository").Logger())
@@ -108,14 +154,25 @@ func Web(configFilename string) {
 
 	network := libcompute.NewNetworkService(netRepo)
 	keys := libcompute.NewKeyService(keyRepo)
+	flavors := libcompute.NewFlavorService(flavorRepo)
+	presets := libcompute.NewVirtualMachinePresetService(presetRepo)
 	volpools := libcompute.NewVolumePoolService(volpoolRepo)
 	nodes := libcompute.NewNodeService(nodeRepo)
 	volumes := libcompute.NewVolumeService(volumeRepo)
 	vms := libcompute.NewVirtualMachineService(vmRepo)
 
 	vmanager := libcompute.NewVirtualMachineManager(vms, volumes, epub, vmManSettings)
+	schedules := libcompute.NewScheduleService(scheduleRepo, vms, vmanager, logger.With().Str("component", "schedule-service").Logger())
+	go schedules.Run(30*time.Second, nil)
+	expiry := libcompute.NewVirtualMachineExpiryService(vms, vmanager, epub, libcompute.VirtualMachineExpirySettings{
+		Warn:          time.Duration(cfg.Expiry.WarnHours) * time.Hour,
+		Grace:         time.Duration(cfg.Expiry.GraceHours) * time.Hour,
+		Renew:         time.Duration(cfg.Expiry.RenewDays) * 24 * time.Hour,
+		DeleteVolumes: cfg.Expiry.DeleteVolumes,
+	}, logger.With().Str("component", "expiry-service").Logger())
+	go expiry.Run(time.Minute, nil)
 
-	webenv := web.New(cfg, logger, network, keys, volpools, nodes, volumes, vms, vmanager)
+	webenv := web.New(cfg, logger, network, keys, flavors, presets, volpools, nodes, volumes, vms, vmanager, schedules, expiry)
 	server := http.Server{
 		Addr:    cfg.Web.Listen,
 		Handler: webenv,
@@ -7,6 +7,7 @@ const (
 	DeviceBusVirtio
 	DeviceBusIde
 	DeviceBusScsi
+	DeviceBusSata
 )
 
 func (DeviceBus DeviceBus) String() string {
@@ -19,6 +20,8 @@ func (DeviceBus DeviceBus) String() string {
 		return "ide"
 	case DeviceBusScsi:
 		return "scsi"
+	case DeviceBusSata:
+		return "sata"
 	}
 }
 
@@ -32,5 +35,7 @@ func NewDeviceBus(input string) DeviceBus {
 		return DeviceBusIde
 	case "scsi":
 		return DeviceBusScsi
+	case "sata":
+		return DeviceBusSata
 	}
 }
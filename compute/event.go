@@ -2,6 +2,7 @@ package compute
 
 import (
 	"fmt"
+	"time"
 )
 
 type Event interface {
@@ -45,3 +46,68 @@ func (e *EventVirtualMachineCreated) Plain() map[string]string {
 	}
 	return data
 }
+
+func expiryEventData(name string, vm *VirtualMachine, deleteAt time.Time) map[string]string {
+	return map[string]string{
+		"event":         name,
+		"vm_id":         vm.Id,
+		"vm_node":       vm.NodeId,
+		"vm_owner":      vm.Owner,
+		"vm_expires_at": vm.ExpiresAt.Format(time.RFC3339),
+		"vm_delete_at":  deleteAt.Format(time.RFC3339),
+	}
+}
+
+// EventVirtualMachineExpiring is published once when machine expiration is near
+type EventVirtualMachineExpiring struct {
+	vm       *VirtualMachine
+	deleteAt time.Time
+}
+
+func NewEventVirtualMachineExpiring(vm *VirtualMachine, deleteAt time.Time) *EventVirtualMachineExpiring {
+	return &EventVirtualMachineExpiring{vm: vm, deleteAt: deleteAt}
+}
+
+func (e *EventVirtualMachineExpiring) Name() string {
+	return "vm_expiring"
+}
+
+func (e *EventVirtualMachineExpiring) Plain() map[string]string {
+	return expiryEventData(e.Name(), e.vm, e.deleteAt)
+}
+
+// EventVirtualMachineExpired is published when expired machine is shut down
+type EventVirtualMachineExpired struct {
+	vm       *VirtualMachine
+	deleteAt time.Time
+}
+
+func NewEventVirtualMachineExpired(vm *VirtualMachine, deleteAt time.Time) *EventVirtualMachineExpired {
+	return &EventVirtualMachineExpired{vm: vm, deleteAt: deleteAt}
+}
+
+func (e *EventVirtualMachineExpired) Name() string {
+	return "vm_expired"
+}
+
+func (e *EventVirtualMachineExpired) Plain() map[string]string {
+	return expiryEventData(e.Name(), e.vm, e.deleteAt)
+}
+
+// EventVirtualMachineExpiredDeleted is published after expired machine is deleted
+type EventVirtualMachineExpiredDeleted struct {
+	vm       *VirtualMachine
+	deleteAt time.Time
+}
+
+func NewEventVirtualMachineExpiredDeleted(vm *VirtualMachine, deleteAt time.Time) *EventVirtualMachineExpiredDeleted {
+	return &EventVirtualMachineExpiredDeleted{vm: vm, deleteAt: deleteAt}
+}
+
+func (e *EventVirtualMachineExpiredDeleted) Name() string {
+	return "vm_expired_deleted"
+}
+
+func (e *EventVirtualMachineExpiredDeleted) Plain() map[string]string {
+	return expiryEventData(e.Name(), e.vm, e.deleteAt)
+}
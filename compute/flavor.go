@@ -0,0 +1,40 @@
+package compute
+
+type Flavor struct {
+	Name       string
+	VCpus      int
+	Memory     Size
+	RootDisk   Size
+	ExtraDisks []Size
+	Hugepages  bool
+	NodeIds    []string
+	ReadOnly   bool
+}
+
+func (flavor *Flavor) AllowedOn(nodeId string) bool {
+	if len(flavor.NodeIds) == 0 {
+		return true
+	}
+	for _, allowedNodeId := range flavor.NodeIds {
+		if allowedNodeId == nodeId {
+			return true
+		}
+	}
+	return false
+}
+
+// FitsOn returns how many machines of this flavor fit into free memory of the node
+func (flavor *Flavor) FitsOn(node *Node) uint64 {
+	if !flavor.AllowedOn(node.Id) || flavor.Memory.Bytes() == 0 {
+		return 0
+	}
+	free := uint64(0)
+	for _, numa := range node.Numas {
+		if flavor.Hugepages {
+			free += numa.Pages2mFree*2*1024*1024 + numa.Pages1gFree*1024*1024*1024
+			continue
+		}
+		free += numa.Pages4kFreeSize().Bytes()
+	}
+	return free / flavor.Memory.Bytes()
+}
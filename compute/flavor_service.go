@@ -0,0 +1,23 @@
+package compute
+
+import "errors"
+
+var ErrFlavorNotFound = errors.New("flavor not found")
+var ErrFlavorAlreadyExists = errors.New("flavor already exists")
+var ErrFlavorReadOnly = errors.New("flavor is defined in configuration file")
+
+type FlavorRepository interface {
+	List() ([]*Flavor, error)
+	Get(name string) (*Flavor, error)
+	Add(flavor *Flavor) error
+	Update(flavor *Flavor) error
+	Delete(name string) error
+}
+
+type FlavorService struct {
+	FlavorRepository
+}
+
+func NewFlavorService(repo FlavorRepository) *FlavorService {
+	return &FlavorService{repo}
+}
@@ -85,6 +85,7 @@ type Node struct {
 	CpuArch        Arch
 	CpuVendor      string
 	CpuModel       string
+	CpuModels      []string // Usable custom cpu models
 	CpuInfo        string
 	ThreadsPerCore int
 	Iommu          bool
@@ -117,3 +118,12 @@ func (n *Node) Has1gPages() bool {
 	}
 	return false
 }
+
+// FreeMemory returns memory available in regular pages
+func (n *Node) FreeMemory() Size {
+	bytes := uint64(0)
+	for _, numa := range n.Numas {
+		bytes += numa.Pages4kFreeSize().Bytes()
+	}
+	return Size{Value: bytes, Unit: SizeUnitB}
+}
@@ -0,0 +1,107 @@
+package compute
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	NodeDeviceTypePci = "pci"
+	NodeDeviceTypeUsb = "usb"
+)
+
+// NodeDevice is a host pci or usb device which can be passed to machine.
+// Pci address has domain:bus:slot.function form, usb address is bus:device.
+type NodeDevice struct {
+	NodeId        string
+	Name          string
+	Type          string
+	Address       string
+	Class         string // Pci class code, e.g. 0x020000 for ethernet controller
+	Vendor        string
+	VendorId      string
+	Product       string
+	ProductId     string
+	Driver        string
+	IommuGroup    int      // Pci iommu group, -1 if unknown
+	PhysFunction  string   // Address of physical function for sr-iov virtual function
+	VirtFunctions []string // Addresses of sr-iov virtual functions
+	AttachedTo    string   // Machine which has the device attached
+}
+
+func (device *NodeDevice) IsPci() bool {
+	return device.Type == NodeDeviceTypePci
+}
+
+func (device *NodeDevice) IsVirtFunction() bool {
+	return device.PhysFunction != ""
+}
+
+// IsPciBridge reports if device is pci bridge, bridges are not passed to
+// machines and don't break iommu group isolation
+func (device *NodeDevice) IsPciBridge() bool {
+	return device.IsPci() && strings.HasPrefix(device.Class, "0x0604")
+}
+
+// VirtualMachineAttachedHostDevice is a host device passed to machine
+type VirtualMachineAttachedHostDevice struct {
+	Type    string
+	Address string
+}
+
+// vfioDrivers are host drivers which don't use device, so it can be
+// assigned to machine without unbinding it from host
+var vfioDrivers = map[string]bool{"": true, "vfio-pci": true, "pci-stub": true}
+
+// CheckHostDeviceAttach checks that device with specified name can be passed to
+// machine. Pci devices require iommu and all other endpoints of its iommu group
+// must be either unused by host or already attached to the same machine.
+func CheckHostDeviceAttach(node *Node, devices []*NodeDevice, name, vmId string) (*NodeDevice, error) {
+	var device *NodeDevice
+	for _, candidate := range devices {
+		if candidate.Name == name {
+			device = candidate
+			break
+		}
+	}
+	if device == nil {
+		return nil, fmt.Errorf("device %s not found on node %s", name, node.Id)
+	}
+	if device.AttachedTo != "" {
+		return nil, fmt.Errorf("device %s is already attached to %s", device.Address, device.AttachedTo)
+	}
+	if !device.IsPci() {
+		return device, nil
+	}
+	if device.IsPciBridge() {
+		return nil, fmt.Errorf("pci bridge %s cannot be passed to machine", device.Address)
+	}
+	if !node.Iommu {
+		return nil, fmt.Errorf("iommu is not enabled on node %s", node.Id)
+	}
+	if device.IommuGroup < 0 {
+		return nil, fmt.Errorf("device %s has no iommu group", device.Address)
+	}
+	vfAddresses := map[string]bool{}
+	for _, vfAddress := range device.VirtFunctions {
+		vfAddresses[vfAddress] = true
+	}
+	for _, other := range devices {
+		if other == device || !other.IsPci() {
+			continue
+		}
+		if vfAddresses[other.Address] && other.AttachedTo != "" {
+			return nil, fmt.Errorf("virtual function %s of device %s is attached to %s", other.Address, device.Address, other.AttachedTo)
+		}
+		if other.IommuGroup != device.IommuGroup || other.IsPciBridge() {
+			continue
+		}
+		if other.AttachedTo != "" && other.AttachedTo != vmId {
+			return nil, fmt.Errorf("device %s shares iommu group %d with %s attached to %s", device.Address, device.IommuGroup, other.Address, other.AttachedTo)
+		}
+		if other.AttachedTo == "" && !vfioDrivers[other.Driver] {
+			return nil, fmt.Errorf("device %s shares iommu group %d with %s used by host driver %s, it must be unbound from host first", device.Address, device.IommuGroup, other.Address, other.Driver)
+		}
+	}
+	return device, nil
+}
@@ -0,0 +1,49 @@
+package compute
+
+import "testing"
+
+func TestCheckHostDeviceAttach(t *testing.T) {
+	node := &Node{Id: "node1", Iommu: true}
+	devices := []*NodeDevice{
+		{Name: "pci_0000_00_01_0", Type: NodeDeviceTypePci, Address: "0000:00:01.0", Class: "0x060400", Driver: "pcieport", IommuGroup: 1},
+		{Name: "pci_0000_03_00_0", Type: NodeDeviceTypePci, Address: "0000:03:00.0", Class: "0x020000", Driver: "vfio-pci", IommuGroup: 1},
+		{Name: "pci_0000_03_00_1", Type: NodeDeviceTypePci, Address: "0000:03:00.1", Class: "0x020000", IommuGroup: 1, AttachedTo: "vm1"},
+		{Name: "pci_0000_04_00_0", Type: NodeDeviceTypePci, Address: "0000:04:00.0", Class: "0x010700", Driver: "mpt3sas", IommuGroup: 2},
+		{Name: "pci_0000_04_00_1", Type: NodeDeviceTypePci, Address: "0000:04:00.1", Class: "0x010700", Driver: "mpt3sas", IommuGroup: 2},
+		{Name: "pci_0000_05_00_0", Type: NodeDeviceTypePci, Address: "0000:05:00.0", Class: "0x020000", Driver: "ixgbe", IommuGroup: 3, VirtFunctions: []string{"0000:05:10.0"}},
+		{Name: "pci_0000_05_10_0", Type: NodeDeviceTypePci, Address: "0000:05:10.0", Class: "0x020000", IommuGroup: 4, PhysFunction: "0000:05:00.0", AttachedTo: "vm2"},
+		{Name: "pci_0000_06_00_0", Type: NodeDeviceTypePci, Address: "0000:06:00.0", Class: "0x030000", IommuGroup: -1},
+		{Name: "usb_1_4", Type: NodeDeviceTypeUsb, Address: "001:004", IommuGroup: -1},
+	}
+	tests := []struct {
+		name    string
+		device  string
+		vmId    string
+		wantErr bool
+	}{
+		{"SameMachineGroup", "pci_0000_03_00_0", "vm1", false},
+		{"OtherMachineGroup", "pci_0000_03_00_0", "vm3", true},
+		{"HostDriverInGroup", "pci_0000_04_00_0", "vm1", true},
+		{"AlreadyAttached", "pci_0000_03_00_1", "vm1", true},
+		{"Bridge", "pci_0000_00_01_0", "vm1", true},
+		{"VirtFunctionInUse", "pci_0000_05_00_0", "vm1", true},
+		{"NoIommuGroup", "pci_0000_06_00_0", "vm1", true},
+		{"Usb", "usb_1_4", "vm1", false},
+		{"Unknown", "pci_0000_07_00_0", "vm1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, err := CheckHostDeviceAttach(node, devices, tt.device, tt.vmId)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CheckHostDeviceAttach() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && device.Name != tt.device {
+				t.Errorf("CheckHostDeviceAttach() = %s, want %s", device.Name, tt.device)
+			}
+		})
+	}
+
+	if _, err := CheckHostDeviceAttach(&Node{Id: "node2"}, devices, "pci_0000_03_00_0", "vm1"); err == nil {
+		t.Errorf("CheckHostDeviceAttach() without iommu succeeded")
+	}
+}
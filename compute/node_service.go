@@ -19,6 +19,7 @@ type NodeListOptions struct {
 type NodeRepository interface {
 	Get(node string, options NodeGetOptions) (*Node, error)
 	List(options NodeListOptions) ([]*Node, error)
+	ListDevices(node string) ([]*NodeDevice, error)
 }
 
 type NodeService struct {
@@ -0,0 +1,198 @@
+package compute
+
+import (
+	"errors"
+	"fmt"
+	"subuk/vmango/cron"
+	"subuk/vmango/util"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// ScheduleActions lists actions which can be run by schedule
+var ScheduleActions = []string{"start", "shutdown", "reboot", "snapshot"}
+
+// Schedule runs action periodically on one machine or on all machines with a tag
+type Schedule struct {
+	Id        string
+	Name      string
+	Cron      string
+	Timezone  string
+	Action    string
+	MachineId string
+	NodeId    string
+	Tag       string
+	Enabled   bool
+}
+
+func (schedule *Schedule) Validate() error {
+	if schedule.Name == "" {
+		return fmt.Errorf("schedule name cannot be empty")
+	}
+	if _, err := cron.Parse(schedule.Cron); err != nil {
+		return util.NewError(err, "invalid cron expression")
+	}
+	if _, err := time.LoadLocation(schedule.Timezone); err != nil {
+		return util.NewError(err, "invalid timezone")
+	}
+	validAction := false
+	for _, action := range ScheduleActions {
+		if action == schedule.Action {
+			validAction = true
+		}
+	}
+	if !validAction {
+		return fmt.Errorf("unknown action %s", schedule.Action)
+	}
+	if (schedule.MachineId == "") == (schedule.Tag == "") {
+		return fmt.Errorf("either machine or tag must be specified")
+	}
+	return nil
+}
+
+// Next returns the first activation time after specified time
+func (schedule *Schedule) Next(after time.Time) (time.Time, error) {
+	expr, err := cron.Parse(schedule.Cron)
+	if err != nil {
+		return time.Time{}, util.NewError(err, "invalid cron expression")
+	}
+	location, err := time.LoadLocation(schedule.Timezone)
+	if err != nil {
+		return time.Time{}, util.NewError(err, "invalid timezone")
+	}
+	return expr.Next(after.In(location)), nil
+}
+
+type ScheduleExecutionResult struct {
+	MachineId string
+	NodeId    string
+	Skipped   bool
+	Error     string
+}
+
+type ScheduleExecution struct {
+	ScheduleId string
+	Action     string
+	Started    time.Time
+	Finished   time.Time
+	Error      string
+	Results    []ScheduleExecutionResult
+}
+
+func (execution *ScheduleExecution) Failed() bool {
+	if execution.Error != "" {
+		return true
+	}
+	for _, result := range execution.Results {
+		if result.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+type ScheduleRepository interface {
+	List() ([]*Schedule, error)
+	Get(id string) (*Schedule, error)
+	Save(schedule *Schedule) error
+	Delete(id string) error
+	AddExecution(execution *ScheduleExecution) error
+	ListExecutions(scheduleId string) ([]*ScheduleExecution, error)
+}
+
+type ScheduleService struct {
+	ScheduleRepository
+	vms     *VirtualMachineService
+	manager *VirtualMachineManager
+	logger  zerolog.Logger
+}
+
+func NewScheduleService(repo ScheduleRepository, vms *VirtualMachineService, manager *VirtualMachineManager, logger zerolog.Logger) *ScheduleService {
+	return &ScheduleService{ScheduleRepository: repo, vms: vms, manager: manager, logger: logger}
+}
+
+// Execute runs schedule action on all target machines and saves execution to the history.
+// Start of running machines and shutdown of stopped ones are skipped.
+func (service *ScheduleService) Execute(schedule *Schedule) (*ScheduleExecution, error) {
+	execution := &ScheduleExecution{ScheduleId: schedule.Id, Action: schedule.Action, Started: time.Now()}
+	vms := []*VirtualMachine{}
+	if schedule.MachineId != "" {
+		vm, err := service.vms.Get(schedule.MachineId, schedule.NodeId)
+		if err != nil {
+			execution.Error = util.NewError(err, "cannot get machine").Error()
+		} else {
+			vms = append(vms, vm)
+		}
+	} else {
+		allVms, err := service.vms.List(VirtualMachineListOptions{})
+		if err != nil {
+			execution.Error = util.NewError(err, "cannot list machines").Error()
+		}
+		for _, vm := range allVms {
+			if vm.HasTag(schedule.Tag) {
+				vms = append(vms, vm)
+			}
+		}
+	}
+	targets := []VirtualMachineBulkTarget{}
+	for _, vm := range vms {
+		if schedule.Action == "start" && vm.IsRunning() || schedule.Action == "shutdown" && !vm.IsRunning() {
+			execution.Results = append(execution.Results, ScheduleExecutionResult{MachineId: vm.Id, NodeId: vm.NodeId, Skipped: true})
+			continue
+		}
+		targets = append(targets, VirtualMachineBulkTarget{Id: vm.Id, NodeId: vm.NodeId})
+	}
+	for _, result := range service.manager.BulkAction(targets, schedule.Action, false) {
+		executionResult := ScheduleExecutionResult{MachineId: result.Id, NodeId: result.NodeId}
+		if result.Error != nil {
+			executionResult.Error = result.Error.Error()
+		}
+		execution.Results = append(execution.Results, executionResult)
+	}
+	execution.Finished = time.Now()
+	if err := service.AddExecution(execution); err != nil {
+		return execution, util.NewError(err, "cannot save execution")
+	}
+	return execution, nil
+}
+
+// Run checks schedules every interval and executes due ones until stop channel is closed
+func (service *ScheduleService) Run(interval time.Duration, stop <-chan struct{}) {
+	lastCheck := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			schedules, err := service.List()
+			if err != nil {
+				service.logger.Warn().Err(err).Msg("cannot list schedules")
+				continue
+			}
+			for _, schedule := range schedules {
+				if !schedule.Enabled {
+					continue
+				}
+				next, err := schedule.Next(lastCheck)
+				if err != nil {
+					service.logger.Warn().Err(err).Str("schedule", schedule.Id).Msg("cannot get next schedule activation")
+					continue
+				}
+				if next.IsZero() || next.After(now) {
+					continue
+				}
+				go func(schedule *Schedule) {
+					if _, err := service.Execute(schedule); err != nil {
+						service.logger.Warn().Err(err).Str("schedule", schedule.Id).Msg("schedule execution failed")
+					}
+				}(schedule)
+			}
+			lastCheck = now
+		}
+	}
+}
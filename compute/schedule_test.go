@@ -0,0 +1,155 @@
+package compute
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+type fakeScheduleVirtualMachineRepository struct {
+	VirtualMachineRepository
+	vms       []*VirtualMachine
+	actionErr error
+	mu        sync.Mutex
+	actions   []string
+}
+
+func (repo *fakeScheduleVirtualMachineRepository) List(options VirtualMachineListOptions) ([]*VirtualMachine, error) {
+	return repo.vms, nil
+}
+
+func (repo *fakeScheduleVirtualMachineRepository) Get(id, node string) (*VirtualMachine, error) {
+	for _, vm := range repo.vms {
+		if vm.Id == id && vm.NodeId == node {
+			return vm, nil
+		}
+	}
+	return nil, errors.New("machine not found")
+}
+
+func (repo *fakeScheduleVirtualMachineRepository) action(name, id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.actions = append(repo.actions, name+" "+id)
+	return repo.actionErr
+}
+
+func (repo *fakeScheduleVirtualMachineRepository) Start(id, node string) error {
+	return repo.action("start", id)
+}
+
+func (repo *fakeScheduleVirtualMachineRepository) Shutdown(id, node string) error {
+	return repo.action("shutdown", id)
+}
+
+func (repo *fakeScheduleVirtualMachineRepository) Reboot(id, node string) error {
+	return repo.action("reboot", id)
+}
+
+type fakeScheduleRepository struct {
+	ScheduleRepository
+	addErr     error
+	executions []*ScheduleExecution
+}
+
+func (repo *fakeScheduleRepository) AddExecution(execution *ScheduleExecution) error {
+	if repo.addErr != nil {
+		return repo.addErr
+	}
+	repo.executions = append(repo.executions, execution)
+	return nil
+}
+
+func newTestScheduleService(repo *fakeScheduleRepository, vmRepo *fakeScheduleVirtualMachineRepository) *ScheduleService {
+	vms := NewVirtualMachineService(vmRepo)
+	return &ScheduleService{ScheduleRepository: repo, vms: vms, manager: NewVirtualMachineManager(vms, nil, nil, nil)}
+}
+
+func TestScheduleServiceExecuteTag(t *testing.T) {
+	vmRepo := &fakeScheduleVirtualMachineRepository{vms: []*VirtualMachine{
+		{Id: "web1", NodeId: "node1", State: StateRunning, Tags: []string{"web"}},
+		{Id: "web2", NodeId: "node1", State: StateStopped, Tags: []string{"web"}},
+		{Id: "web3", NodeId: "node2", State: StateStopped, Tags: []string{"web"}},
+		{Id: "db1", NodeId: "node1", State: StateStopped, Tags: []string{"db"}},
+	}}
+	repo := &fakeScheduleRepository{}
+	service := newTestScheduleService(repo, vmRepo)
+	execution, err := service.Execute(&Schedule{Id: "s1", Action: "start", Tag: "web"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if execution.Failed() {
+		t.Errorf("Execute() failed: %+v", execution)
+	}
+	want := []ScheduleExecutionResult{
+		{MachineId: "web1", NodeId: "node1", Skipped: true},
+		{MachineId: "web2", NodeId: "node1"},
+		{MachineId: "web3", NodeId: "node2"},
+	}
+	if !reflect.DeepEqual(execution.Results, want) {
+		t.Errorf("Execute() results = %+v, want %+v", execution.Results, want)
+	}
+	sort.Strings(vmRepo.actions)
+	if want := []string{"start web2", "start web3"}; !reflect.DeepEqual(vmRepo.actions, want) {
+		t.Errorf("Execute() actions = %v, want %v", vmRepo.actions, want)
+	}
+	if len(repo.executions) != 1 || repo.executions[0] != execution {
+		t.Errorf("Execute() saved executions = %+v, want the returned one", repo.executions)
+	}
+}
+
+func TestScheduleServiceExecuteMachineNotFound(t *testing.T) {
+	vmRepo := &fakeScheduleVirtualMachineRepository{}
+	repo := &fakeScheduleRepository{}
+	service := newTestScheduleService(repo, vmRepo)
+	execution, err := service.Execute(&Schedule{Id: "s1", Action: "reboot", MachineId: "web1", NodeId: "node1"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	if execution.Error == "" || !execution.Failed() {
+		t.Errorf("Execute() execution error is empty: %+v", execution)
+	}
+	if len(vmRepo.actions) != 0 {
+		t.Errorf("Execute() actions = %v, want none", vmRepo.actions)
+	}
+	if len(repo.executions) != 1 {
+		t.Errorf("Execute() saved %d executions, want 1", len(repo.executions))
+	}
+}
+
+func TestScheduleServiceExecuteActionError(t *testing.T) {
+	vmRepo := &fakeScheduleVirtualMachineRepository{
+		vms:       []*VirtualMachine{{Id: "web1", NodeId: "node1", State: StateRunning}},
+		actionErr: errors.New("domain is locked"),
+	}
+	repo := &fakeScheduleRepository{}
+	service := newTestScheduleService(repo, vmRepo)
+	execution, err := service.Execute(&Schedule{Id: "s1", Action: "reboot", MachineId: "web1", NodeId: "node1"})
+	if err != nil {
+		t.Fatalf("Execute() error = %v", err)
+	}
+	want := []ScheduleExecutionResult{{MachineId: "web1", NodeId: "node1", Error: "domain is locked"}}
+	if !reflect.DeepEqual(execution.Results, want) {
+		t.Errorf("Execute() results = %+v, want %+v", execution.Results, want)
+	}
+	if !execution.Failed() {
+		t.Errorf("Execute() execution is not failed")
+	}
+}
+
+func TestScheduleServiceExecuteSaveError(t *testing.T) {
+	vmRepo := &fakeScheduleVirtualMachineRepository{
+		vms: []*VirtualMachine{{Id: "web1", NodeId: "node1", State: StateStopped}},
+	}
+	repo := &fakeScheduleRepository{addErr: errors.New("disk full")}
+	service := newTestScheduleService(repo, vmRepo)
+	execution, err := service.Execute(&Schedule{Id: "s1", Action: "shutdown", MachineId: "web1", NodeId: "node1"})
+	if err == nil {
+		t.Fatalf("Execute() error = nil, want save error")
+	}
+	if execution == nil || len(execution.Results) != 1 || !execution.Results[0].Skipped {
+		t.Errorf("Execute() execution = %+v, want skipped shutdown", execution)
+	}
+}
@@ -1,5 +1,24 @@
 package compute
 
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+var virtualMachineNameValid = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?$`)
+
+// ValidateVirtualMachineName checks that name is safe to use as hostname, domain and volume name
+func ValidateVirtualMachineName(name string) error {
+	if name == "" {
+		return fmt.Errorf("name cannot be empty")
+	}
+	if len(name) > 63 || !virtualMachineNameValid.MatchString(name) {
+		return fmt.Errorf("invalid name '%s': only latin letters, digits, dots and dashes are allowed, it must start and end with a letter or digit", name)
+	}
+	return nil
+}
+
 type VirtualMachineConsoleStream interface {
 	Read(buf []byte) (int, error)
 	Write(buf []byte) (int, error)
@@ -28,14 +47,17 @@ func (state VirtualMachineState) String() string {
 }
 
 type VirtualMachineCpuPin struct {
-	Vcpus    map[uint][]uint
-	Emulator []uint
+	Vcpus       map[uint][]uint
+	Emulator    []uint
+	IOThreads   map[uint][]uint
+	MemoryNodes []uint // Numa cells for strict memory placement, empty keeps memory placement unchanged
 }
 
 type VirtualMachineConfig struct {
-	Hostname string
-	Keys     []*Key
-	Userdata []byte
+	Hostname   string
+	InstanceId string // Cloud-init instance id, new one is generated if empty
+	Keys       []*Key
+	Userdata   []byte
 }
 
 type VirtualMachineGraphic struct {
@@ -49,22 +71,44 @@ func (g VirtualMachineGraphic) Vnc() bool {
 }
 
 type VirtualMachine struct {
-	Id         string
-	Firmware   string
-	NodeId     string
-	VCpus      int
-	Arch       Arch
-	State      VirtualMachineState
-	Memory     Size
-	Interfaces []*VirtualMachineAttachedInterface
-	Volumes    []*VirtualMachineAttachedVolume
-	Config     *VirtualMachineConfig
-	Cpupin     *VirtualMachineCpuPin
-	GuestAgent bool
-	Autostart  bool
-	Graphic    VirtualMachineGraphic
-	VideoModel VideoModel
-	Hugepages  bool
+	Id           string
+	Firmware     string
+	NodeId       string
+	VCpus        int
+	MaxVCpus     int // Vcpus limit for hotplug, not more than VCpus means no vcpu hotplug
+	Cpu          VirtualMachineCpu
+	Arch         Arch
+	State        VirtualMachineState
+	Memory       Size
+	MaxMemory    Size // Memory limit for hotplug, used only with memory slots
+	MemorySlots  int  // Dimm slots, zero means no memory hotplug
+	DimmMemory   Size // Memory of hot-plugged dimms, included in Memory
+	Interfaces   []*VirtualMachineAttachedInterface
+	Volumes      []*VirtualMachineAttachedVolume
+	HostDevices  []*VirtualMachineAttachedHostDevice
+	Config       *VirtualMachineConfig
+	Cpupin       *VirtualMachineCpuPin
+	IOThreads    uint
+	GuestAgent   bool
+	Autostart    bool
+	Graphic      VirtualMachineGraphic
+	VideoModel   VideoModel
+	Hugepages    bool
+	HugepageSize string
+	Numa         VirtualMachineNuma
+	Flavor       string
+	Tags         []string
+	Owner        string
+	ExpiresAt    time.Time
+	BootMenu     bool
+	SecureBoot   bool
+	Tpm          bool
+	Rng          bool   // Virtio rng fed from host /dev/urandom
+	Watchdog     string // I6300esb watchdog action, empty means no watchdog
+	Pvpanic      bool   // Lets guest report kernel panic, which triggers OnCrash action
+	OnPoweroff   string
+	OnReboot     string
+	OnCrash      string
 }
 
 func (vm *VirtualMachine) AttachmentInfo(path string) *VirtualMachineAttachedVolume {
@@ -84,15 +128,58 @@ func (vm *VirtualMachine) IpAddressList() []string {
 	return iplist
 }
 
+func (vm *VirtualMachine) HasTag(tag string) bool {
+	for _, vmTag := range vm.Tags {
+		if vmTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// HasExpiry reports if machine is deleted automatically after expiration
+func (vm *VirtualMachine) HasExpiry() bool {
+	return !vm.ExpiresAt.IsZero()
+}
+
+// MaxVCpuCount returns vcpus limit, vcpus above current count can be hot-plugged
+func (vm *VirtualMachine) MaxVCpuCount() int {
+	if vm.MaxVCpus > vm.VCpus {
+		return vm.MaxVCpus
+	}
+	return vm.VCpus
+}
+
+func (vm *VirtualMachine) MemoryHotplug() bool {
+	return vm.MemorySlots > 0
+}
+
+// BaseMemory returns memory available at boot without hot-plugged dimms
+func (vm *VirtualMachine) BaseMemory() Size {
+	if vm.DimmMemory.Unit == SizeUnitUnknown || vm.DimmMemory.Bytes() >= vm.Memory.Bytes() {
+		return vm.Memory
+	}
+	return NewSize(vm.Memory.Bytes()-vm.DimmMemory.Bytes(), SizeUnitB)
+}
+
 func (vm *VirtualMachine) IsRunning() bool {
 	return vm.State == StateRunning
 }
 
+// HasFirmwareState reports if machine keeps secure boot variables or tpm state
+// in node local files, which are not copied between nodes
+func (vm *VirtualMachine) HasFirmwareState() bool {
+	return vm.SecureBoot || vm.Tpm
+}
+
 type VirtualMachineAttachedVolume struct {
 	Path       string
 	Alias      string
 	DeviceType DeviceType
 	DeviceBus  DeviceBus
+	BootOrder  uint
+	IoTune     VirtualMachineVolumeIoTune
+	Driver     VirtualMachineVolumeDriver
 }
 
 type VirtualMachineAttachedInterface struct {
@@ -101,4 +188,6 @@ type VirtualMachineAttachedInterface struct {
 	Model         string
 	IpAddressList []string
 	AccessVlan    uint
+	BootOrder     uint
+	Bandwidth     VirtualMachineInterfaceBandwidth
 }
@@ -0,0 +1,228 @@
+package compute
+
+import (
+	"strings"
+)
+
+const VirtualMachineManifestName = "vmango.json"
+const VirtualMachineManifestVersion = 1
+
+type VirtualMachineManifestInterface struct {
+	NetworkName string
+	Mac         string
+	Model       string
+	AccessVlan  uint
+	BootOrder   uint
+	Bandwidth   VirtualMachineInterfaceBandwidth
+}
+
+type VirtualMachineManifestDisk struct {
+	File       string
+	Name       string
+	Format     string
+	Size       uint64
+	Alias      string
+	DeviceType string
+	DeviceBus  string
+	BootOrder  uint
+	IoTune     VirtualMachineVolumeIoTune
+	Driver     VirtualMachineVolumeDriver
+}
+
+type VirtualMachineManifestNumaCell struct {
+	Cpus   []uint
+	Memory uint64
+}
+
+type VirtualMachineManifestNuma struct {
+	Mode    string
+	Nodeset []uint
+	Cells   []VirtualMachineManifestNumaCell
+}
+
+type VirtualMachineManifestConfig struct {
+	Hostname string
+	Keys     []*Key
+	Userdata string
+}
+
+// VirtualMachineManifest describes machine stored in export archive.
+// Archive is a tar file with manifest as the first entry followed by disk images,
+// so it can be written and read as a stream.
+type VirtualMachineManifest struct {
+	Version     int
+	Id          string
+	Arch        string
+	Firmware    string
+	SecureBoot  bool
+	Tpm         bool
+	Rng         bool
+	Watchdog    string
+	Pvpanic     bool
+	OnPoweroff  string
+	OnReboot    string
+	OnCrash     string
+	VCpus       int
+	MaxVCpus    int
+	Cpu         VirtualMachineCpu
+	Memory      uint64
+	MaxMemory   uint64
+	MemorySlots int
+	Numa        VirtualMachineManifestNuma
+	BootMenu    bool
+	GuestAgent  bool
+	Autostart   bool
+	Graphic     string
+	VideoModel  string
+	Hugepages   bool
+	Hugepage    string
+	Flavor      string
+	Tags        []string
+	Interfaces  []VirtualMachineManifestInterface
+	Disks       []VirtualMachineManifestDisk
+	Config      *VirtualMachineManifestConfig
+}
+
+type VirtualMachineImportOptions struct {
+	NodeId      string
+	Pool        string
+	Id          string
+	NetworkName string
+	ResetMacs   bool
+	Start       bool
+}
+
+func NewVirtualMachineManifest(vm *VirtualMachine) *VirtualMachineManifest {
+	manifest := &VirtualMachineManifest{
+		Version:     VirtualMachineManifestVersion,
+		Id:          vm.Id,
+		Arch:        vm.Arch.String(),
+		Firmware:    vm.Firmware,
+		SecureBoot:  vm.SecureBoot,
+		Tpm:         vm.Tpm,
+		Rng:         vm.Rng,
+		Watchdog:    vm.Watchdog,
+		Pvpanic:     vm.Pvpanic,
+		OnPoweroff:  vm.OnPoweroff,
+		OnReboot:    vm.OnReboot,
+		OnCrash:     vm.OnCrash,
+		VCpus:       vm.VCpus,
+		MaxVCpus:    vm.MaxVCpus,
+		Cpu:         vm.Cpu,
+		Memory:      vm.Memory.Bytes(),
+		MemorySlots: vm.MemorySlots,
+		Numa:        VirtualMachineManifestNuma{Mode: vm.Numa.Mode, Nodeset: vm.Numa.Nodeset},
+		BootMenu:    vm.BootMenu,
+		GuestAgent:  vm.GuestAgent,
+		Autostart:   vm.Autostart,
+		Graphic:     vm.Graphic.Type.String(),
+		VideoModel:  vm.VideoModel.String(),
+		Hugepages:   vm.Hugepages,
+		Hugepage:    vm.HugepageSize,
+		Flavor:      vm.Flavor,
+		Tags:        vm.Tags,
+	}
+	if vm.MemoryHotplug() {
+		manifest.MaxMemory = vm.MaxMemory.Bytes()
+	}
+	for _, cell := range vm.Numa.Cells {
+		manifest.Numa.Cells = append(manifest.Numa.Cells, VirtualMachineManifestNumaCell{Cpus: cell.Cpus, Memory: cell.Memory.Bytes()})
+	}
+	for _, iface := range vm.Interfaces {
+		manifest.Interfaces = append(manifest.Interfaces, VirtualMachineManifestInterface{
+			NetworkName: iface.NetworkName,
+			Mac:         iface.Mac,
+			Model:       iface.Model,
+			AccessVlan:  iface.AccessVlan,
+			BootOrder:   iface.BootOrder,
+			Bandwidth:   iface.Bandwidth,
+		})
+	}
+	if vm.Config != nil {
+		manifest.Config = &VirtualMachineManifestConfig{
+			Hostname: vm.Config.Hostname,
+			Keys:     vm.Config.Keys,
+			Userdata: string(vm.Config.Userdata),
+		}
+	}
+	return manifest
+}
+
+// VirtualMachine returns machine described by manifest without volumes
+func (manifest *VirtualMachineManifest) VirtualMachine(options VirtualMachineImportOptions) *VirtualMachine {
+	vm := &VirtualMachine{
+		Id:           manifest.Id,
+		NodeId:       options.NodeId,
+		Arch:         NewArch(manifest.Arch),
+		Firmware:     manifest.Firmware,
+		SecureBoot:   manifest.SecureBoot,
+		Tpm:          manifest.Tpm,
+		Rng:          manifest.Rng,
+		Watchdog:     manifest.Watchdog,
+		Pvpanic:      manifest.Pvpanic,
+		OnPoweroff:   manifest.OnPoweroff,
+		OnReboot:     manifest.OnReboot,
+		OnCrash:      manifest.OnCrash,
+		VCpus:        manifest.VCpus,
+		MaxVCpus:     manifest.MaxVCpus,
+		Cpu:          manifest.Cpu,
+		Memory:       NewSize(manifest.Memory, SizeUnitB),
+		MemorySlots:  manifest.MemorySlots,
+		Numa:         VirtualMachineNuma{Mode: manifest.Numa.Mode, Nodeset: manifest.Numa.Nodeset},
+		BootMenu:     manifest.BootMenu,
+		GuestAgent:   manifest.GuestAgent,
+		Autostart:    manifest.Autostart,
+		Graphic:      VirtualMachineGraphic{Type: NewGraphicType(manifest.Graphic)},
+		VideoModel:   NewVideoModel(manifest.VideoModel),
+		Hugepages:    manifest.Hugepages,
+		HugepageSize: manifest.Hugepage,
+		Flavor:       manifest.Flavor,
+		Tags:         manifest.Tags,
+	}
+	if options.Id != "" {
+		vm.Id = options.Id
+	}
+	if manifest.MemorySlots > 0 {
+		vm.MaxMemory = NewSize(manifest.MaxMemory, SizeUnitB)
+	}
+	for _, cell := range manifest.Numa.Cells {
+		vm.Numa.Cells = append(vm.Numa.Cells, VirtualMachineNumaCell{Cpus: cell.Cpus, Memory: NewSize(cell.Memory, SizeUnitB)})
+	}
+	for _, iface := range manifest.Interfaces {
+		attachedIface := &VirtualMachineAttachedInterface{
+			NetworkName: iface.NetworkName,
+			Mac:         iface.Mac,
+			Model:       iface.Model,
+			AccessVlan:  iface.AccessVlan,
+			BootOrder:   iface.BootOrder,
+			Bandwidth:   iface.Bandwidth,
+		}
+		if options.NetworkName != "" {
+			attachedIface.NetworkName = options.NetworkName
+		}
+		if options.ResetMacs {
+			attachedIface.Mac = ""
+		}
+		vm.Interfaces = append(vm.Interfaces, attachedIface)
+	}
+	if manifest.Config != nil {
+		vm.Config = &VirtualMachineConfig{
+			Hostname: manifest.Config.Hostname,
+			Keys:     manifest.Config.Keys,
+			Userdata: []byte(manifest.Config.Userdata),
+		}
+		if vm.Config.Hostname == manifest.Id {
+			vm.Config.Hostname = vm.Id
+		}
+	}
+	return vm
+}
+
+// VolumeName returns name for imported disk volume.
+// Volumes named after original machine are renamed after the imported one.
+func (manifest *VirtualMachineManifest) VolumeName(disk VirtualMachineManifestDisk, vmId string) string {
+	if strings.HasPrefix(disk.Name, manifest.Id) {
+		return vmId + strings.TrimPrefix(disk.Name, manifest.Id)
+	}
+	return disk.Name
+}
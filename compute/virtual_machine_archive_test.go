@@ -0,0 +1,58 @@
+package compute
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestVirtualMachineManifestRoundTrip(t *testing.T) {
+	vm := &VirtualMachine{
+		Id:          "web",
+		NodeId:      "node1",
+		Arch:        ArchAmd64,
+		VCpus:       2,
+		MaxVCpus:    8,
+		Cpu:         VirtualMachineCpu{Mode: "custom", Model: "Skylake-Server", Features: []VirtualMachineCpuFeature{{Name: "vmx", Policy: "disable"}}, Sockets: 1, Cores: 2, Threads: 1},
+		Memory:      NewSize(2, SizeUnitG),
+		MaxMemory:   NewSize(16, SizeUnitG),
+		MemorySlots: 4,
+		Numa: VirtualMachineNuma{
+			Mode:    NumaModeStrict,
+			Nodeset: []uint{0},
+			Cells:   []VirtualMachineNumaCell{{Cpus: []uint{0}, Memory: NewSize(1, SizeUnitG)}, {Cpus: []uint{1}, Memory: NewSize(1, SizeUnitG)}},
+		},
+		BootMenu: true,
+		Interfaces: []*VirtualMachineAttachedInterface{{
+			NetworkName: "default",
+			Mac:         "52:54:00:00:00:01",
+			Model:       "virtio",
+			BootOrder:   2,
+			Bandwidth:   VirtualMachineInterfaceBandwidth{Inbound: VirtualMachineInterfaceBandwidthLimit{Average: 1000, Peak: 2000, Burst: 512}},
+		}},
+	}
+	manifest := NewVirtualMachineManifest(vm)
+	content, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatalf("cannot serialize manifest: %s", err)
+	}
+	decoded := &VirtualMachineManifest{}
+	if err := json.Unmarshal(content, decoded); err != nil {
+		t.Fatalf("cannot parse manifest: %s", err)
+	}
+	imported := decoded.VirtualMachine(VirtualMachineImportOptions{NodeId: "node1"})
+	if imported.MaxVCpus != vm.MaxVCpus || imported.MemorySlots != vm.MemorySlots || imported.MaxMemory.Bytes() != vm.MaxMemory.Bytes() || imported.BootMenu != vm.BootMenu {
+		t.Errorf("imported hotplug and boot settings = %d %d %d %t, want %d %d %d %t",
+			imported.MaxVCpus, imported.MemorySlots, imported.MaxMemory.Bytes(), imported.BootMenu,
+			vm.MaxVCpus, vm.MemorySlots, vm.MaxMemory.Bytes(), vm.BootMenu)
+	}
+	if !reflect.DeepEqual(imported.Cpu, vm.Cpu) {
+		t.Errorf("imported cpu = %+v, want %+v", imported.Cpu, vm.Cpu)
+	}
+	if imported.Numa.Mode != vm.Numa.Mode || FormatCpuSet(imported.Numa.Nodeset) != FormatCpuSet(vm.Numa.Nodeset) || imported.Numa.CellsString() != vm.Numa.CellsString() {
+		t.Errorf("imported numa = %+v, want %+v", imported.Numa, vm.Numa)
+	}
+	if len(imported.Interfaces) != 1 || imported.Interfaces[0].BootOrder != 2 || imported.Interfaces[0].Bandwidth != vm.Interfaces[0].Bandwidth {
+		t.Errorf("imported interfaces = %+v, want boot order and bandwidth of %+v", imported.Interfaces, vm.Interfaces[0])
+	}
+}
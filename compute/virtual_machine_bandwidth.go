@@ -0,0 +1,77 @@
+package compute
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VirtualMachineInterfaceBandwidthLimit limits interface traffic in one direction.
+// Average and peak are in KiB/s, burst is KiB allowed at peak speed, zero average means unlimited.
+type VirtualMachineInterfaceBandwidthLimit struct {
+	Average uint
+	Peak    uint
+	Burst   uint
+}
+
+func (limit VirtualMachineInterfaceBandwidthLimit) Empty() bool {
+	return limit == VirtualMachineInterfaceBandwidthLimit{}
+}
+
+func (limit VirtualMachineInterfaceBandwidthLimit) String() string {
+	if limit.Average == 0 {
+		return "unlimited"
+	}
+	description := fmt.Sprintf("%d KiB/s", limit.Average)
+	if limit.Peak > 0 {
+		description += fmt.Sprintf(", peak %d KiB/s", limit.Peak)
+	}
+	if limit.Burst > 0 {
+		description += fmt.Sprintf(", burst %d KiB", limit.Burst)
+	}
+	return description
+}
+
+// VirtualMachineInterfaceBandwidth is traffic limit of interface, inbound is the traffic
+// received by machine and outbound is the traffic sent by machine
+type VirtualMachineInterfaceBandwidth struct {
+	Inbound  VirtualMachineInterfaceBandwidthLimit
+	Outbound VirtualMachineInterfaceBandwidthLimit
+}
+
+func (bandwidth VirtualMachineInterfaceBandwidth) Empty() bool {
+	return bandwidth.Inbound.Empty() && bandwidth.Outbound.Empty()
+}
+
+func (bandwidth VirtualMachineInterfaceBandwidth) Validate() error {
+	for _, direction := range []struct {
+		name  string
+		limit VirtualMachineInterfaceBandwidthLimit
+	}{{"inbound", bandwidth.Inbound}, {"outbound", bandwidth.Outbound}} {
+		if direction.limit.Average == 0 && (direction.limit.Peak > 0 || direction.limit.Burst > 0) {
+			return fmt.Errorf("%s peak and burst require average bandwidth", direction.name)
+		}
+		if direction.limit.Peak > 0 && direction.limit.Peak < direction.limit.Average {
+			return fmt.Errorf("%s peak cannot be less than average bandwidth", direction.name)
+		}
+	}
+	return nil
+}
+
+func (bandwidth VirtualMachineInterfaceBandwidth) String() string {
+	items := []string{}
+	if !bandwidth.Inbound.Empty() {
+		items = append(items, "in "+bandwidth.Inbound.String())
+	}
+	if !bandwidth.Outbound.Empty() {
+		items = append(items, "out "+bandwidth.Outbound.String())
+	}
+	return strings.Join(items, "; ")
+}
+
+// SetInterfaceBandwidth changes traffic limits of interface, running machine gets them live
+func (service *VirtualMachineService) SetInterfaceBandwidth(id, node, mac string, bandwidth VirtualMachineInterfaceBandwidth) error {
+	if err := bandwidth.Validate(); err != nil {
+		return err
+	}
+	return service.VirtualMachineRepository.SetInterfaceBandwidth(id, node, mac, bandwidth)
+}
@@ -0,0 +1,23 @@
+package compute
+
+import "testing"
+
+func TestVirtualMachineInterfaceBandwidthValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		bandwidth VirtualMachineInterfaceBandwidth
+		wantErr   bool
+	}{
+		{"Empty", VirtualMachineInterfaceBandwidth{}, false},
+		{"Full", VirtualMachineInterfaceBandwidth{Inbound: VirtualMachineInterfaceBandwidthLimit{Average: 1000, Peak: 2000, Burst: 4096}}, false},
+		{"PeakWithoutAverage", VirtualMachineInterfaceBandwidth{Outbound: VirtualMachineInterfaceBandwidthLimit{Peak: 2000}}, true},
+		{"PeakBelowAverage", VirtualMachineInterfaceBandwidth{Outbound: VirtualMachineInterfaceBandwidthLimit{Average: 2000, Peak: 1000}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.bandwidth.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
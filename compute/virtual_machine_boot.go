@@ -0,0 +1,61 @@
+package compute
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VirtualMachineBoot defines boot menu and boot order of machine devices.
+// Order keys are volume paths and interface mac addresses,
+// devices without order are not bootable.
+type VirtualMachineBoot struct {
+	Menu  bool
+	Order map[string]uint
+}
+
+func (boot VirtualMachineBoot) Validate() error {
+	devices := map[uint]string{}
+	for device, order := range boot.Order {
+		if order == 0 {
+			continue
+		}
+		if other, exists := devices[order]; exists {
+			return fmt.Errorf("boot order %d is used by both %s and %s", order, other, device)
+		}
+		devices[order] = device
+	}
+	return nil
+}
+
+// ValidateDevices checks that every ordered device is a volume or interface of the machine
+func (boot VirtualMachineBoot) ValidateDevices(vm *VirtualMachine) error {
+	devices := map[string]bool{}
+	for _, volume := range vm.Volumes {
+		devices[volume.Path] = true
+	}
+	for _, iface := range vm.Interfaces {
+		devices[strings.ToLower(iface.Mac)] = true
+	}
+	for device, order := range boot.Order {
+		if order > 0 && !devices[device] && !devices[strings.ToLower(device)] {
+			return fmt.Errorf("boot device %s not found", device)
+		}
+	}
+	return nil
+}
+
+// Boot returns current boot settings of the machine
+func (vm *VirtualMachine) Boot() VirtualMachineBoot {
+	boot := VirtualMachineBoot{Menu: vm.BootMenu, Order: map[string]uint{}}
+	for _, volume := range vm.Volumes {
+		if volume.BootOrder > 0 {
+			boot.Order[volume.Path] = volume.BootOrder
+		}
+	}
+	for _, iface := range vm.Interfaces {
+		if iface.BootOrder > 0 {
+			boot.Order[iface.Mac] = iface.BootOrder
+		}
+	}
+	return boot
+}
@@ -0,0 +1,165 @@
+package compute
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+const VirtualMachineBulkMaxCount = 100
+
+var namePatternRange = regexp.MustCompile(`\{(\d+)\.\.(\d+)\}`)
+
+type VirtualMachinePlacement int
+
+const (
+	VirtualMachinePlacementUnknown    = VirtualMachinePlacement(0)
+	VirtualMachinePlacementNode       = VirtualMachinePlacement(1)
+	VirtualMachinePlacementRoundRobin = VirtualMachinePlacement(2)
+	VirtualMachinePlacementFreeMemory = VirtualMachinePlacement(3)
+)
+
+func (placement VirtualMachinePlacement) String() string {
+	switch placement {
+	default:
+		return "unknown"
+	case VirtualMachinePlacementNode:
+		return "node"
+	case VirtualMachinePlacementRoundRobin:
+		return "round-robin"
+	case VirtualMachinePlacementFreeMemory:
+		return "free-memory"
+	}
+}
+
+func NewVirtualMachinePlacement(input string) VirtualMachinePlacement {
+	switch input {
+	default:
+		return VirtualMachinePlacementUnknown
+	case "node":
+		return VirtualMachinePlacementNode
+	case "round-robin":
+		return VirtualMachinePlacementRoundRobin
+	case "free-memory":
+		return VirtualMachinePlacementFreeMemory
+	}
+}
+
+// ExpandNamePattern returns machine names for bulk creation.
+// Pattern may contain one numeric range like ci-worker-{01..10}, zero padding
+// of the range start is kept for all names. Pattern without range gets
+// numeric suffix if count is greater than one.
+func ExpandNamePattern(pattern string, count int) ([]string, error) {
+	matches := namePatternRange.FindAllStringSubmatchIndex(pattern, -1)
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("name pattern can contain only one range")
+	}
+	if len(matches) == 0 {
+		if count <= 1 {
+			return []string{pattern}, nil
+		}
+		if count > VirtualMachineBulkMaxCount {
+			return nil, fmt.Errorf("cannot create more than %d machines at once", VirtualMachineBulkMaxCount)
+		}
+		width := len(strconv.Itoa(count))
+		names := []string{}
+		for idx := 1; idx <= count; idx++ {
+			names = append(names, fmt.Sprintf("%s-%0*d", pattern, width, idx))
+		}
+		return names, nil
+	}
+	match := matches[0]
+	startRaw := pattern[match[2]:match[3]]
+	start, err := strconv.Atoi(startRaw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid range start %s", startRaw)
+	}
+	end, err := strconv.Atoi(pattern[match[4]:match[5]])
+	if err != nil {
+		return nil, fmt.Errorf("invalid range end %s", pattern[match[4]:match[5]])
+	}
+	if end < start {
+		return nil, fmt.Errorf("range end %d is less than start %d", end, start)
+	}
+	total := end - start + 1
+	if total > VirtualMachineBulkMaxCount {
+		return nil, fmt.Errorf("cannot create more than %d machines at once", VirtualMachineBulkMaxCount)
+	}
+	if count > 1 && count != total {
+		return nil, fmt.Errorf("count %d does not match name range with %d names", count, total)
+	}
+	width := 0
+	if len(startRaw) > 1 && startRaw[0] == '0' {
+		width = len(startRaw)
+	}
+	names := []string{}
+	for idx := start; idx <= end; idx++ {
+		names = append(names, fmt.Sprintf("%s%0*d%s", pattern[:match[0]], width, idx, pattern[match[1]:]))
+	}
+	return names, nil
+}
+
+// PlaceVirtualMachines chooses node for each of count machines with specified memory size.
+// Free memory placement puts every machine to the node with the most free memory left
+// after previous machines, any other placement distributes machines round-robin.
+func PlaceVirtualMachines(nodes []*Node, count int, memory Size, placement VirtualMachinePlacement) []string {
+	nodeIds := []string{}
+	if len(nodes) == 0 {
+		return nodeIds
+	}
+	if placement != VirtualMachinePlacementFreeMemory {
+		for idx := 0; idx < count; idx++ {
+			nodeIds = append(nodeIds, nodes[idx%len(nodes)].Id)
+		}
+		return nodeIds
+	}
+	free := make([]int64, len(nodes))
+	for idx, node := range nodes {
+		free[idx] = int64(node.FreeMemory().Bytes())
+	}
+	for idx := 0; idx < count; idx++ {
+		best := 0
+		for nodeIdx := range nodes {
+			if free[nodeIdx] > free[best] {
+				best = nodeIdx
+			}
+		}
+		free[best] -= int64(memory.Bytes())
+		nodeIds = append(nodeIds, nodes[best].Id)
+	}
+	return nodeIds
+}
+
+type VirtualMachineBulkTarget struct {
+	Id     string
+	NodeId string
+}
+
+type VirtualMachineBulkResult struct {
+	Id     string
+	NodeId string
+	Error  error
+}
+
+// BulkAction runs state action or deletion for all targets concurrently.
+// Results are returned in the same order as targets.
+func (manager *VirtualMachineManager) BulkAction(targets []VirtualMachineBulkTarget, action string, deleteVolumes bool) []VirtualMachineBulkResult {
+	results := make([]VirtualMachineBulkResult, len(targets))
+	wg := &sync.WaitGroup{}
+	for idx, target := range targets {
+		wg.Add(1)
+		go func(idx int, target VirtualMachineBulkTarget) {
+			defer wg.Done()
+			var err error
+			if action == "delete" {
+				err = manager.Delete(target.Id, target.NodeId, deleteVolumes)
+			} else {
+				err = manager.vms.Action(target.Id, target.NodeId, action)
+			}
+			results[idx] = VirtualMachineBulkResult{Id: target.Id, NodeId: target.NodeId, Error: err}
+		}(idx, target)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,54 @@
+package compute
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandNamePattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		count   int
+		want    []string
+		wantErr bool
+	}{
+		{"Single", "web", 1, []string{"web"}, false},
+		{"Count", "web", 3, []string{"web-1", "web-2", "web-3"}, false},
+		{"CountPadded", "web", 10, []string{"web-01", "web-02", "web-03", "web-04", "web-05", "web-06", "web-07", "web-08", "web-09", "web-10"}, false},
+		{"Range", "ci-{8..10}", 0, []string{"ci-8", "ci-9", "ci-10"}, false},
+		{"RangePadded", "ci-worker-{08..10}.local", 3, []string{"ci-worker-08.local", "ci-worker-09.local", "ci-worker-10.local"}, false},
+		{"RangeCountMismatch", "ci-{1..3}", 2, nil, true},
+		{"RangeReversed", "ci-{3..1}", 0, nil, true},
+		{"TwoRanges", "ci-{1..3}-{1..3}", 0, nil, true},
+		{"TooMany", "ci-{1..1000}", 0, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExpandNamePattern(tt.pattern, tt.count)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ExpandNamePattern() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ExpandNamePattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlaceVirtualMachines(t *testing.T) {
+	gb := uint64(1024 * 1024 / 4)
+	nodes := []*Node{
+		{Id: "small", Numas: []NodeNuma{{Pages4kFree: 2 * gb}}},
+		{Id: "big", Numas: []NodeNuma{{Pages4kFree: 5 * gb}}},
+	}
+	got := PlaceVirtualMachines(nodes, 3, NewSize(2, SizeUnitG), VirtualMachinePlacementRoundRobin)
+	if want := []string{"small", "big", "small"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("round-robin placement = %v, want %v", got, want)
+	}
+	got = PlaceVirtualMachines(nodes, 3, NewSize(2, SizeUnitG), VirtualMachinePlacementFreeMemory)
+	if want := []string{"big", "big", "small"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("free memory placement = %v, want %v", got, want)
+	}
+}
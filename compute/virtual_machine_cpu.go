@@ -0,0 +1,137 @@
+package compute
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	CpuModeMaximum         = "maximum"
+	CpuModeHostPassthrough = "host-passthrough"
+	CpuModeHostModel       = "host-model"
+	CpuModeCustom          = "custom"
+)
+
+var CpuModes = []string{CpuModeMaximum, CpuModeHostPassthrough, CpuModeHostModel, CpuModeCustom}
+
+const (
+	CpuFeatureRequire  = "require"
+	CpuFeatureDisable  = "disable"
+	CpuFeatureForce    = "force"
+	CpuFeatureOptional = "optional"
+	CpuFeatureForbid   = "forbid"
+)
+
+var CpuFeaturePolicies = []string{CpuFeatureRequire, CpuFeatureDisable, CpuFeatureForce, CpuFeatureOptional, CpuFeatureForbid}
+
+func isCpuFeaturePolicy(policy string) bool {
+	for _, known := range CpuFeaturePolicies {
+		if policy == known {
+			return true
+		}
+	}
+	return false
+}
+
+type VirtualMachineCpuFeature struct {
+	Name   string
+	Policy string
+}
+
+// VirtualMachineCpu describes guest cpu, zero topology means
+// it is derived from vcpu count and host threads
+type VirtualMachineCpu struct {
+	Mode     string
+	Model    string
+	Features []VirtualMachineCpuFeature
+	Sockets  int
+	Cores    int
+	Threads  int
+}
+
+func (cpu VirtualMachineCpu) HasTopology() bool {
+	return cpu.Sockets > 0 || cpu.Cores > 0 || cpu.Threads > 0
+}
+
+func (cpu VirtualMachineCpu) Validate(vcpus int) error {
+	switch cpu.Mode {
+	default:
+		return fmt.Errorf("unknown cpu mode %s", cpu.Mode)
+	case "", CpuModeMaximum, CpuModeHostPassthrough, CpuModeHostModel:
+		if cpu.Model != "" {
+			return fmt.Errorf("cpu model can be specified only for %s mode", CpuModeCustom)
+		}
+	case CpuModeCustom:
+		if cpu.Model == "" {
+			return fmt.Errorf("cpu model required for %s mode", CpuModeCustom)
+		}
+	}
+	for _, feature := range cpu.Features {
+		if feature.Name == "" {
+			return fmt.Errorf("cpu feature name cannot be empty")
+		}
+		if !isCpuFeaturePolicy(feature.Policy) {
+			return fmt.Errorf("unknown policy %s for cpu feature %s", feature.Policy, feature.Name)
+		}
+	}
+	if cpu.HasTopology() {
+		if cpu.Sockets <= 0 || cpu.Cores <= 0 || cpu.Threads <= 0 {
+			return fmt.Errorf("sockets, cores and threads must be specified together")
+		}
+		if cpu.Sockets*cpu.Cores*cpu.Threads != vcpus {
+			return fmt.Errorf("cpu topology %d sockets * %d cores * %d threads doesn't match %d vcpus", cpu.Sockets, cpu.Cores, cpu.Threads, vcpus)
+		}
+	}
+	return nil
+}
+
+// FeaturesString formats features in the form accepted by ParseCpuFeatures
+func (cpu VirtualMachineCpu) FeaturesString() string {
+	items := []string{}
+	for _, feature := range cpu.Features {
+		switch feature.Policy {
+		case CpuFeatureRequire:
+			items = append(items, "+"+feature.Name)
+		case CpuFeatureDisable:
+			items = append(items, "-"+feature.Name)
+		default:
+			items = append(items, feature.Policy+":"+feature.Name)
+		}
+	}
+	return strings.Join(items, " ")
+}
+
+// ParseCpuFeatures parses space or comma separated feature list,
+// features prefixed with - are disabled, others are required.
+// Other libvirt policies are written as policy:name.
+// For example "+vmx -hle optional:pdpe1gb" requires vmx, disables hle
+// and enables pdpe1gb if host supports it.
+func ParseCpuFeatures(value string) ([]VirtualMachineCpuFeature, error) {
+	features := []VirtualMachineCpuFeature{}
+	seen := map[string]bool{}
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' }) {
+		feature := VirtualMachineCpuFeature{Name: item, Policy: CpuFeatureRequire}
+		switch item[0] {
+		case '-':
+			feature = VirtualMachineCpuFeature{Name: item[1:], Policy: CpuFeatureDisable}
+		case '+':
+			feature.Name = item[1:]
+		default:
+			if idx := strings.Index(item, ":"); idx >= 0 {
+				feature = VirtualMachineCpuFeature{Name: item[idx+1:], Policy: item[:idx]}
+				if !isCpuFeaturePolicy(feature.Policy) {
+					return nil, fmt.Errorf("unknown policy %s for cpu feature %s", feature.Policy, feature.Name)
+				}
+			}
+		}
+		if feature.Name == "" {
+			return nil, fmt.Errorf("empty cpu feature name in %q", value)
+		}
+		if seen[feature.Name] {
+			return nil, fmt.Errorf("cpu feature %s specified twice", feature.Name)
+		}
+		seen[feature.Name] = true
+		features = append(features, feature)
+	}
+	return features, nil
+}
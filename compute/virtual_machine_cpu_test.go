@@ -0,0 +1,70 @@
+package compute
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCpuFeatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []VirtualMachineCpuFeature
+		wantErr bool
+	}{
+		{"Empty", "", []VirtualMachineCpuFeature{}, false},
+		{"Mixed", "vmx, -hle +pdpe1gb", []VirtualMachineCpuFeature{{"vmx", CpuFeatureRequire}, {"hle", CpuFeatureDisable}, {"pdpe1gb", CpuFeatureRequire}}, false},
+		{"Policies", "force:vmx optional:pdpe1gb forbid:hle", []VirtualMachineCpuFeature{{"vmx", CpuFeatureForce}, {"pdpe1gb", CpuFeatureOptional}, {"hle", CpuFeatureForbid}}, false},
+		{"UnknownPolicy", "maybe:vmx", nil, true},
+		{"NoName", "vmx -", nil, true},
+		{"Duplicate", "vmx -vmx", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCpuFeatures(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCpuFeatures() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCpuFeatures() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVirtualMachineCpuFeaturesStringRoundTrip(t *testing.T) {
+	cpu := VirtualMachineCpu{Features: []VirtualMachineCpuFeature{
+		{"vmx", CpuFeatureRequire}, {"hle", CpuFeatureDisable}, {"pdpe1gb", CpuFeatureOptional}, {"x2apic", CpuFeatureForce}, {"rtm", CpuFeatureForbid},
+	}}
+	got, err := ParseCpuFeatures(cpu.FeaturesString())
+	if err != nil {
+		t.Fatalf("ParseCpuFeatures() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, cpu.Features) {
+		t.Errorf("ParseCpuFeatures(FeaturesString()) = %v, want %v", got, cpu.Features)
+	}
+}
+
+func TestVirtualMachineCpuValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cpu     VirtualMachineCpu
+		vcpus   int
+		wantErr bool
+	}{
+		{"Default", VirtualMachineCpu{}, 4, false},
+		{"Custom", VirtualMachineCpu{Mode: CpuModeCustom, Model: "Skylake-Server"}, 4, false},
+		{"CustomNoModel", VirtualMachineCpu{Mode: CpuModeCustom}, 4, true},
+		{"ModelWithPassthrough", VirtualMachineCpu{Mode: CpuModeHostPassthrough, Model: "Skylake-Server"}, 4, true},
+		{"Topology", VirtualMachineCpu{Mode: CpuModeHostPassthrough, Sockets: 1, Cores: 2, Threads: 2}, 4, false},
+		{"TopologyMismatch", VirtualMachineCpu{Sockets: 2, Cores: 2, Threads: 2}, 4, true},
+		{"TopologyPartial", VirtualMachineCpu{Cores: 4}, 4, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.cpu.Validate(tt.vcpus); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package compute
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParseCpuSet parses host cpu list in libvirt cpuset format, e.g. "0-3,8"
+func ParseCpuSet(value string) ([]uint, error) {
+	cpus := []uint{}
+	seen := map[uint]bool{}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.ParseUint(strings.TrimSpace(bounds[0]), 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu %q in cpuset %q", bounds[0], value)
+		}
+		end := start
+		if len(bounds) == 2 {
+			end, err = strconv.ParseUint(strings.TrimSpace(bounds[1]), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cpu %q in cpuset %q", bounds[1], value)
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid cpu range %q in cpuset %q", part, value)
+			}
+		}
+		for cpu := start; cpu <= end; cpu++ {
+			if !seen[uint(cpu)] {
+				seen[uint(cpu)] = true
+				cpus = append(cpus, uint(cpu))
+			}
+		}
+	}
+	sort.Slice(cpus, func(i, j int) bool { return cpus[i] < cpus[j] })
+	return cpus, nil
+}
+
+// FormatCpuSet formats host cpu list in libvirt cpuset format,
+// consecutive cpus are collapsed into ranges
+func FormatCpuSet(cpus []uint) string {
+	sorted := append([]uint{}, cpus...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	parts := []string{}
+	for idx := 0; idx < len(sorted); {
+		end := idx
+		for end+1 < len(sorted) && sorted[end+1] <= sorted[end]+1 {
+			end++
+		}
+		if sorted[idx] == sorted[end] {
+			parts = append(parts, strconv.FormatUint(uint64(sorted[idx]), 10))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[idx], sorted[end]))
+		}
+		idx = end + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// Validate checks pinning against machine vcpus, iothreads and host cpu count
+func (pin *VirtualMachineCpuPin) Validate(vcpus int, iothreads uint, hostCpus int) error {
+	check := func(name string, cpus []uint) error {
+		if len(cpus) == 0 {
+			return fmt.Errorf("empty cpuset for %s", name)
+		}
+		for _, cpu := range cpus {
+			if int(cpu) >= hostCpus {
+				return fmt.Errorf("host cpu %d for %s doesn't exist, node has %d cpus", cpu, name, hostCpus)
+			}
+		}
+		return nil
+	}
+	for vcpu, cpus := range pin.Vcpus {
+		if int(vcpu) >= vcpus {
+			return fmt.Errorf("vcpu %d doesn't exist, machine has %d vcpus", vcpu, vcpus)
+		}
+		if err := check(fmt.Sprintf("vcpu %d", vcpu), cpus); err != nil {
+			return err
+		}
+	}
+	if pin.Emulator != nil {
+		if err := check("emulator", pin.Emulator); err != nil {
+			return err
+		}
+	}
+	for iothread, cpus := range pin.IOThreads {
+		if iothread == 0 || iothread > iothreads {
+			return fmt.Errorf("iothread %d doesn't exist, machine has %d iothreads", iothread, iothreads)
+		}
+		if err := check(fmt.Sprintf("iothread %d", iothread), cpus); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nodeCpuDedicated returns true if host cpu is exclusively pinned
+// to vcpu of another machine
+func nodeCpuDedicated(cpu NodeCpu, vmId string) bool {
+	for _, pin := range cpu.Pins {
+		if pin.VmId != vmId && strings.HasPrefix(pin.Desc, "vcpu-") {
+			return true
+		}
+	}
+	return false
+}
+
+// AutoCpuPin pins every vcpu to its own free host cpu within single numa cell
+// and places machine memory strictly to the same cell. Max vcpu count should
+// be passed, so hot-plugged vcpus are pinned too.
+// Cpus pinned to vcpus of other machines are not free, the cell with the least
+// sufficient number of free cpus is chosen to keep larger cells available.
+// Sibling threads are allocated together, fully free cores first. Emulator and iothreads are pinned to
+// remaining non-dedicated cpus of the cell, or to vcpu cpus if there are none.
+// Node must be fetched with pins.
+func AutoCpuPin(node *Node, vmId string, vcpus int, iothreads uint) (*VirtualMachineCpuPin, error) {
+	if vcpus <= 0 {
+		return nil, fmt.Errorf("machine has no vcpus")
+	}
+	free := map[int][]uint{}
+	for cpuId, cpu := range node.Cpus {
+		if cpu.NumaId < 0 || nodeCpuDedicated(cpu, vmId) {
+			continue
+		}
+		free[cpu.NumaId] = append(free[cpu.NumaId], uint(cpuId))
+	}
+	numaId := -1
+	for id, cpus := range free {
+		if len(cpus) < vcpus {
+			continue
+		}
+		if numaId < 0 || len(cpus) < len(free[numaId]) || (len(cpus) == len(free[numaId]) && id < numaId) {
+			numaId = id
+		}
+	}
+	if numaId < 0 {
+		return nil, fmt.Errorf("no numa cell on node %s has %d free cpus", node.Id, vcpus)
+	}
+
+	cpus := free[numaId]
+	// Fully free cores go first, so sibling threads aren't shared with other machines
+	coreFree := map[[2]int]int{}
+	for _, cpuId := range cpus {
+		coreFree[[2]int{node.Cpus[cpuId].SocketId, node.Cpus[cpuId].CoreId}]++
+	}
+	sort.Slice(cpus, func(i, j int) bool {
+		a, b := node.Cpus[cpus[i]], node.Cpus[cpus[j]]
+		freeA, freeB := coreFree[[2]int{a.SocketId, a.CoreId}], coreFree[[2]int{b.SocketId, b.CoreId}]
+		if freeA != freeB {
+			return freeA > freeB
+		}
+		if a.SocketId != b.SocketId {
+			return a.SocketId < b.SocketId
+		}
+		if a.CoreId != b.CoreId {
+			return a.CoreId < b.CoreId
+		}
+		return cpus[i] < cpus[j]
+	})
+
+	pin := &VirtualMachineCpuPin{
+		Vcpus:       map[uint][]uint{},
+		IOThreads:   map[uint][]uint{},
+		MemoryNodes: []uint{uint(numaId)},
+	}
+	for vcpu := 0; vcpu < vcpus; vcpu++ {
+		pin.Vcpus[uint(vcpu)] = []uint{cpus[vcpu]}
+	}
+	housekeeping := append([]uint{}, cpus[vcpus:]...)
+	if len(housekeeping) == 0 {
+		housekeeping = append(housekeeping, cpus[:vcpus]...)
+	}
+	sort.Slice(housekeeping, func(i, j int) bool { return housekeeping[i] < housekeeping[j] })
+	pin.Emulator = housekeeping
+	for iothread := uint(1); iothread <= iothreads; iothread++ {
+		pin.IOThreads[iothread] = housekeeping
+	}
+	return pin, nil
+}
@@ -0,0 +1,75 @@
+package compute
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCpuSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []uint
+		wantErr bool
+	}{
+		{"Empty", "", []uint{}, false},
+		{"Mixed", "8, 0-2,1", []uint{0, 1, 2, 8}, false},
+		{"Invalid", "0-a", nil, true},
+		{"Reversed", "3-1", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCpuSet(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCpuSet() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCpuSet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatCpuSet(t *testing.T) {
+	if got := FormatCpuSet([]uint{8, 0, 1, 2, 4, 5}); got != "0-2,4-5,8" {
+		t.Errorf("FormatCpuSet() = %s, want 0-2,4-5,8", got)
+	}
+}
+
+func TestAutoCpuPin(t *testing.T) {
+	// Two cells with two cores by two threads, cpus 0-3 in cell 0, 4-7 in cell 1,
+	// sibling threads are n and n+2 within a cell
+	node := &Node{Id: "node1"}
+	for cpuId := 0; cpuId < 8; cpuId++ {
+		node.Cpus = append(node.Cpus, NodeCpu{SocketId: cpuId / 4, CoreId: cpuId % 2, NumaId: cpuId / 4})
+	}
+	node.Cpus[0].Pins = []NodeCpuPin{{VmId: "other", Desc: "vcpu-0"}}
+	node.Cpus[1].Pins = []NodeCpuPin{{VmId: "other", Desc: "emulator"}}
+	node.Cpus[4].Pins = []NodeCpuPin{{VmId: "self", Desc: "vcpu-0"}}
+
+	pin, err := AutoCpuPin(node, "self", 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &VirtualMachineCpuPin{
+		Vcpus:       map[uint][]uint{0: {1}, 1: {3}},
+		Emulator:    []uint{2},
+		IOThreads:   map[uint][]uint{1: {2}},
+		MemoryNodes: []uint{0},
+	}
+	if !reflect.DeepEqual(pin, want) {
+		t.Errorf("AutoCpuPin() = %+v, want %+v", pin, want)
+	}
+
+	pin, err = AutoCpuPin(node, "self", 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pin.Vcpus, map[uint][]uint{0: {4}, 1: {6}, 2: {5}, 3: {7}}) || !reflect.DeepEqual(pin.Emulator, []uint{4, 5, 6, 7}) || !reflect.DeepEqual(pin.MemoryNodes, []uint{1}) {
+		t.Errorf("AutoCpuPin() = %+v", pin)
+	}
+
+	if _, err := AutoCpuPin(node, "self", 5, 0); err == nil {
+		t.Error("AutoCpuPin() expected error for machine larger than numa cell")
+	}
+}
@@ -0,0 +1,240 @@
+package compute
+
+import (
+	"fmt"
+	"subuk/vmango/util"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// expiryShutdownTimeout is how long expired machine is given to stop
+// after graceful shutdown before it is powered off
+const expiryShutdownTimeout = 5 * time.Minute
+
+type VirtualMachineExpiryStage int
+
+const (
+	ExpiryStageNone     = VirtualMachineExpiryStage(0)
+	ExpiryStageWarning  = VirtualMachineExpiryStage(1)
+	ExpiryStageExpired  = VirtualMachineExpiryStage(2)
+	ExpiryStageDeletion = VirtualMachineExpiryStage(3)
+)
+
+func (stage VirtualMachineExpiryStage) String() string {
+	switch stage {
+	default:
+		return "none"
+	case ExpiryStageWarning:
+		return "warning"
+	case ExpiryStageExpired:
+		return "expired"
+	case ExpiryStageDeletion:
+		return "deletion"
+	}
+}
+
+type VirtualMachineExpirySettings struct {
+	Warn          time.Duration // Warn owner this long before expiration
+	Grace         time.Duration // Delete expired machine after this period
+	Renew         time.Duration // Renew extends expiration by this period
+	DeleteVolumes bool
+}
+
+// Stage returns expiration stage of a machine which expires at specified time
+func (settings VirtualMachineExpirySettings) Stage(expiresAt, now time.Time) VirtualMachineExpiryStage {
+	switch {
+	case expiresAt.IsZero() || now.Before(expiresAt.Add(-settings.Warn)):
+		return ExpiryStageNone
+	case now.Before(expiresAt):
+		return ExpiryStageWarning
+	case now.Before(expiresAt.Add(settings.Grace)):
+		return ExpiryStageExpired
+	default:
+		return ExpiryStageDeletion
+	}
+}
+
+// expiryState is processing state of a machine bound to its expiration time,
+// so renewed machine gets new warnings
+type expiryState struct {
+	ExpiresAt  time.Time
+	Notified   VirtualMachineExpiryStage
+	ShutdownAt time.Time
+}
+
+// VirtualMachineExpiryService shuts down expired machines and deletes
+// them after grace period, owners are notified with events
+type VirtualMachineExpiryService struct {
+	vms      *VirtualMachineService
+	manager  *VirtualMachineManager
+	epub     EventPublisher
+	settings VirtualMachineExpirySettings
+	states   map[string]*expiryState
+	mu       *sync.Mutex
+	logger   zerolog.Logger
+}
+
+func NewVirtualMachineExpiryService(vms *VirtualMachineService, manager *VirtualMachineManager, epub EventPublisher, settings VirtualMachineExpirySettings, logger zerolog.Logger) *VirtualMachineExpiryService {
+	return &VirtualMachineExpiryService{
+		vms:      vms,
+		manager:  manager,
+		epub:     epub,
+		settings: settings,
+		states:   map[string]*expiryState{},
+		mu:       &sync.Mutex{},
+		logger:   logger,
+	}
+}
+
+func (service *VirtualMachineExpiryService) Settings() VirtualMachineExpirySettings {
+	return service.settings
+}
+
+func (service *VirtualMachineExpiryService) Stage(vm *VirtualMachine) VirtualMachineExpiryStage {
+	return service.settings.Stage(vm.ExpiresAt, time.Now())
+}
+
+// DeleteAt returns time when expired machine will be deleted
+func (service *VirtualMachineExpiryService) DeleteAt(vm *VirtualMachine) time.Time {
+	if !vm.HasExpiry() {
+		return time.Time{}
+	}
+	return vm.ExpiresAt.Add(service.settings.Grace)
+}
+
+// Renew extends machine expiration by renew period starting from now
+// or from current expiration time if it is in future
+func (service *VirtualMachineExpiryService) Renew(id, node string) (time.Time, error) {
+	vm, err := service.vms.Get(id, node)
+	if err != nil {
+		return time.Time{}, util.NewError(err, "cannot get machine")
+	}
+	if !vm.HasExpiry() {
+		return time.Time{}, fmt.Errorf("machine %s has no expiration date", id)
+	}
+	base := time.Now()
+	if vm.ExpiresAt.After(base) {
+		base = vm.ExpiresAt
+	}
+	expiresAt := base.Add(service.settings.Renew).Truncate(time.Minute)
+	if err := service.vms.SetExpiry(id, node, expiresAt); err != nil {
+		return time.Time{}, util.NewError(err, "cannot set machine expiration")
+	}
+	service.mu.Lock()
+	delete(service.states, node+"/"+id)
+	service.mu.Unlock()
+	return expiresAt, nil
+}
+
+// Check processes all machines with expiration date once,
+// errors of particular machines are logged and don't stop others
+func (service *VirtualMachineExpiryService) Check(now time.Time) error {
+	vms, err := service.vms.List(VirtualMachineListOptions{})
+	if err != nil {
+		return util.NewError(err, "cannot list machines")
+	}
+	service.mu.Lock()
+	defer service.mu.Unlock()
+	listed := map[string]bool{}
+	for _, vm := range vms {
+		listed[vm.NodeId+"/"+vm.Id] = true
+		if err := service.check(vm, now); err != nil {
+			service.logger.Warn().Err(err).Str("id", vm.Id).Str("node", vm.NodeId).Msg("cannot process machine expiration")
+		}
+	}
+	for key := range service.states {
+		if !listed[key] {
+			delete(service.states, key)
+		}
+	}
+	return nil
+}
+
+func (service *VirtualMachineExpiryService) check(vm *VirtualMachine, now time.Time) error {
+	stage := service.settings.Stage(vm.ExpiresAt, now)
+	key := vm.NodeId + "/" + vm.Id
+	if stage == ExpiryStageNone {
+		delete(service.states, key)
+		return nil
+	}
+	state := service.states[key]
+	if state == nil || !state.ExpiresAt.Equal(vm.ExpiresAt) {
+		state = &expiryState{ExpiresAt: vm.ExpiresAt}
+		service.states[key] = state
+	}
+	deleteAt := service.DeleteAt(vm)
+	switch stage {
+	case ExpiryStageWarning:
+		if state.Notified >= ExpiryStageWarning {
+			return nil
+		}
+		state.Notified = ExpiryStageWarning
+		return service.epub.Publish(NewEventVirtualMachineExpiring(vm, deleteAt))
+	case ExpiryStageExpired:
+		if vm.IsRunning() {
+			if err := service.stop(vm, state, now); err != nil {
+				return err
+			}
+		}
+		if state.Notified >= ExpiryStageExpired {
+			return nil
+		}
+		state.Notified = ExpiryStageExpired
+		return service.epub.Publish(NewEventVirtualMachineExpired(vm, deleteAt))
+	case ExpiryStageDeletion:
+		// Listed machine may be renewed or updated since then, deletion
+		// is decided on its current expiration date
+		current, err := service.vms.Get(vm.Id, vm.NodeId)
+		if err != nil {
+			return util.NewError(err, "cannot get machine")
+		}
+		if !current.ExpiresAt.Equal(vm.ExpiresAt) || service.settings.Stage(current.ExpiresAt, now) != ExpiryStageDeletion {
+			return nil
+		}
+		if err := service.manager.Delete(vm.Id, vm.NodeId, service.settings.DeleteVolumes); err != nil {
+			return util.NewError(err, "cannot delete machine")
+		}
+		delete(service.states, key)
+		return service.epub.Publish(NewEventVirtualMachineExpiredDeleted(vm, deleteAt))
+	}
+	return nil
+}
+
+// stop gracefully shuts down expired machine, it is powered off if
+// shutdown fails or guest is still running after shutdown timeout
+func (service *VirtualMachineExpiryService) stop(vm *VirtualMachine, state *expiryState, now time.Time) error {
+	if state.ShutdownAt.IsZero() {
+		state.ShutdownAt = now
+		err := service.vms.Shutdown(vm.Id, vm.NodeId)
+		if err == nil {
+			return nil
+		}
+		service.logger.Warn().Err(err).Str("id", vm.Id).Str("node", vm.NodeId).Msg("cannot shutdown expired machine, powering off")
+	} else if now.Sub(state.ShutdownAt) < expiryShutdownTimeout {
+		return nil
+	} else {
+		service.logger.Warn().Str("id", vm.Id).Str("node", vm.NodeId).Msg("expired machine did not stop after shutdown, powering off")
+	}
+	if err := service.vms.Poweroff(vm.Id, vm.NodeId); err != nil {
+		return util.NewError(err, "cannot poweroff machine")
+	}
+	return nil
+}
+
+// Run checks machines every interval until stop channel is closed
+func (service *VirtualMachineExpiryService) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if err := service.Check(now); err != nil {
+				service.logger.Warn().Err(err).Msg("cannot check machines expiration")
+			}
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package compute
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestVirtualMachineExpirySettingsStage(t *testing.T) {
+	settings := VirtualMachineExpirySettings{Warn: 24 * time.Hour, Grace: 72 * time.Hour}
+	expiresAt := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		expiresAt time.Time
+		now       time.Time
+		want      VirtualMachineExpiryStage
+	}{
+		{"NoExpiry", time.Time{}, expiresAt, ExpiryStageNone},
+		{"BeforeWarning", expiresAt, expiresAt.Add(-25 * time.Hour), ExpiryStageNone},
+		{"Warning", expiresAt, expiresAt.Add(-24 * time.Hour), ExpiryStageWarning},
+		{"Expired", expiresAt, expiresAt, ExpiryStageExpired},
+		{"GraceEnd", expiresAt, expiresAt.Add(72*time.Hour - time.Second), ExpiryStageExpired},
+		{"Deletion", expiresAt, expiresAt.Add(72 * time.Hour), ExpiryStageDeletion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := settings.Stage(tt.expiresAt, tt.now); got != tt.want {
+				t.Errorf("Stage() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeExpiryVirtualMachineRepository struct {
+	VirtualMachineRepository
+	listed  []*VirtualMachine
+	current map[string]*VirtualMachine
+	deleted []string
+	stopped []string
+}
+
+func (repo *fakeExpiryVirtualMachineRepository) List(options VirtualMachineListOptions) ([]*VirtualMachine, error) {
+	return repo.listed, nil
+}
+
+func (repo *fakeExpiryVirtualMachineRepository) Get(id, node string) (*VirtualMachine, error) {
+	return repo.current[id], nil
+}
+
+func (repo *fakeExpiryVirtualMachineRepository) Delete(id, node string) error {
+	repo.deleted = append(repo.deleted, id)
+	return nil
+}
+
+func (repo *fakeExpiryVirtualMachineRepository) Shutdown(id, node string) error {
+	repo.stopped = append(repo.stopped, "shutdown "+id)
+	return nil
+}
+
+func (repo *fakeExpiryVirtualMachineRepository) Poweroff(id, node string) error {
+	repo.stopped = append(repo.stopped, "poweroff "+id)
+	return nil
+}
+
+type fakeExpiryEventPublisher struct {
+	events []Event
+}
+
+func (epub *fakeExpiryEventPublisher) Publish(event Event) error {
+	epub.events = append(epub.events, event)
+	return nil
+}
+
+func TestVirtualMachineExpiryServiceCheckDeletion(t *testing.T) {
+	settings := VirtualMachineExpirySettings{Warn: 24 * time.Hour, Grace: 72 * time.Hour}
+	expiresAt := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	now := expiresAt.Add(100 * time.Hour)
+	repo := &fakeExpiryVirtualMachineRepository{
+		listed: []*VirtualMachine{
+			{Id: "expired", NodeId: "node1", ExpiresAt: expiresAt},
+			{Id: "renewed", NodeId: "node1", ExpiresAt: expiresAt},
+			{Id: "unset", NodeId: "node1", ExpiresAt: expiresAt},
+		},
+		current: map[string]*VirtualMachine{
+			"expired": {Id: "expired", NodeId: "node1", ExpiresAt: expiresAt},
+			"renewed": {Id: "renewed", NodeId: "node1", ExpiresAt: now.Add(24 * time.Hour)},
+			"unset":   {Id: "unset", NodeId: "node1"},
+		},
+	}
+	epub := &fakeExpiryEventPublisher{}
+	vms := NewVirtualMachineService(repo)
+	service := NewVirtualMachineExpiryService(vms, NewVirtualMachineManager(vms, nil, nil, nil), epub, settings, zerolog.Nop())
+	if err := service.Check(now); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if want := []string{"expired"}; !reflect.DeepEqual(repo.deleted, want) {
+		t.Errorf("Check() deleted = %v, want %v", repo.deleted, want)
+	}
+	if len(epub.events) != 1 {
+		t.Errorf("Check() published %d events, want 1", len(epub.events))
+	}
+}
+
+func TestVirtualMachineExpiryServiceCheckPoweroff(t *testing.T) {
+	settings := VirtualMachineExpirySettings{Warn: 24 * time.Hour, Grace: 72 * time.Hour}
+	expiresAt := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	repo := &fakeExpiryVirtualMachineRepository{
+		listed: []*VirtualMachine{{Id: "stuck", NodeId: "node1", State: StateRunning, ExpiresAt: expiresAt}},
+	}
+	epub := &fakeExpiryEventPublisher{}
+	vms := NewVirtualMachineService(repo)
+	service := NewVirtualMachineExpiryService(vms, NewVirtualMachineManager(vms, nil, nil, nil), epub, settings, zerolog.Nop())
+	for _, now := range []time.Time{expiresAt, expiresAt.Add(time.Minute), expiresAt.Add(expiryShutdownTimeout)} {
+		if err := service.Check(now); err != nil {
+			t.Fatalf("Check() error = %v", err)
+		}
+	}
+	if want := []string{"shutdown stuck", "poweroff stuck"}; !reflect.DeepEqual(repo.stopped, want) {
+		t.Errorf("Check() stop calls = %v, want %v", repo.stopped, want)
+	}
+	if len(epub.events) != 1 {
+		t.Errorf("Check() published %d events, want 1", len(epub.events))
+	}
+}
+
+func TestVirtualMachineExpiryServiceCheckForgetsRenewed(t *testing.T) {
+	settings := VirtualMachineExpirySettings{Warn: 24 * time.Hour, Grace: 72 * time.Hour}
+	expiresAt := time.Date(2020, 3, 10, 12, 0, 0, 0, time.UTC)
+	repo := &fakeExpiryVirtualMachineRepository{
+		listed: []*VirtualMachine{{Id: "web", NodeId: "node1", ExpiresAt: expiresAt}},
+	}
+	vms := NewVirtualMachineService(repo)
+	service := NewVirtualMachineExpiryService(vms, NewVirtualMachineManager(vms, nil, nil, nil), &fakeExpiryEventPublisher{}, settings, zerolog.Nop())
+	now := expiresAt.Add(-time.Hour)
+	if err := service.Check(now); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(service.states) != 1 {
+		t.Fatalf("Check() states = %d, want 1", len(service.states))
+	}
+	repo.listed[0].ExpiresAt = expiresAt.Add(30 * 24 * time.Hour)
+	if err := service.Check(now); err != nil {
+		t.Fatalf("Check() error = %v", err)
+	}
+	if len(service.states) != 0 {
+		t.Errorf("Check() states = %d after renewal, want 0", len(service.states))
+	}
+}
@@ -0,0 +1,81 @@
+package compute
+
+import (
+	"fmt"
+	"subuk/vmango/util"
+)
+
+// dimmAlignment is the minimal hot-plugged dimm size granularity
+const dimmAlignment = 2 * 1024 * 1024
+
+// VirtualMachineHotplug describes changes of running machine which can be applied live
+type VirtualMachineHotplug struct {
+	VCpus  int    // New online vcpu count, zero means unchanged
+	Memory uint64 // Size of new dimm in bytes, zero means unchanged
+}
+
+func (plan VirtualMachineHotplug) Empty() bool {
+	return plan.VCpus == 0 && plan.Memory == 0
+}
+
+// PlanHotplug returns changes from existing running machine to updated one which
+// can be applied live. Vcpus are added up to existing limit, memory is increased
+// with a dimm up to existing max memory. Other changes are applied after restart.
+func PlanHotplug(existing, vm *VirtualMachine) VirtualMachineHotplug {
+	plan := VirtualMachineHotplug{}
+	if !existing.IsRunning() {
+		return plan
+	}
+	if vm.VCpus > existing.VCpus && vm.VCpus <= existing.MaxVCpuCount() {
+		plan.VCpus = vm.VCpus
+	}
+	if existing.MemoryHotplug() && vm.Memory.Bytes() > existing.Memory.Bytes() && vm.Memory.Bytes() <= existing.MaxMemory.Bytes() {
+		alignment := uint64(dimmAlignment)
+		if existing.Hugepages {
+			alignment, _ = HugepageSizeBytes(existing.HugepageSize)
+		}
+		if delta := vm.Memory.Bytes() - existing.Memory.Bytes(); delta%alignment == 0 {
+			plan.Memory = delta
+		}
+	}
+	return plan
+}
+
+// Hotplug saves updated running machine and applies live changes to it. Machine is
+// saved first, so changes rejected by validation are never applied live. Planned dimm
+// is counted in dimm memory of saved machine and added to persistent config on attach,
+// so boot memory stays unchanged. Applied part of the plan is returned on error.
+func (service *VirtualMachineService) Hotplug(existing, vm *VirtualMachine) (VirtualMachineHotplug, error) {
+	plan := PlanHotplug(existing, vm)
+	dimmMemory := uint64(0)
+	if existing.DimmMemory.Unit != SizeUnitUnknown {
+		dimmMemory = existing.DimmMemory.Bytes()
+	}
+	vm.DimmMemory = NewSize(dimmMemory+plan.Memory, SizeUnitB)
+	if err := service.Save(vm); err != nil {
+		return VirtualMachineHotplug{}, err
+	}
+	if plan.VCpus > 0 {
+		if err := service.VirtualMachineRepository.SetVcpus(vm.Id, vm.NodeId, plan.VCpus); err != nil {
+			return VirtualMachineHotplug{}, util.NewError(err, "changes are saved, but cannot change vcpu count live")
+		}
+	}
+	if plan.Memory > 0 {
+		if err := service.VirtualMachineRepository.AttachMemory(vm.Id, vm.NodeId, NewSize(plan.Memory, SizeUnitB)); err != nil {
+			return VirtualMachineHotplug{VCpus: plan.VCpus}, util.NewError(err, "changes are saved, but cannot attach %d MiB memory live", plan.Memory/1024/1024)
+		}
+	}
+	return plan, nil
+}
+
+// Describe returns human readable list of applied changes
+func (plan VirtualMachineHotplug) Describe(existing *VirtualMachine) []string {
+	changes := []string{}
+	if plan.VCpus > 0 {
+		changes = append(changes, fmt.Sprintf("vcpus increased from %d to %d", existing.VCpus, plan.VCpus))
+	}
+	if plan.Memory > 0 {
+		changes = append(changes, fmt.Sprintf("%d MiB memory added", plan.Memory/1024/1024))
+	}
+	return changes
+}
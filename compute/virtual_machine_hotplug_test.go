@@ -0,0 +1,95 @@
+package compute
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanHotplug(t *testing.T) {
+	existing := &VirtualMachine{
+		State:       StateRunning,
+		VCpus:       2,
+		MaxVCpus:    8,
+		Memory:      NewSize(4096, SizeUnitM),
+		MaxMemory:   NewSize(16384, SizeUnitM),
+		MemorySlots: 4,
+	}
+	tests := []struct {
+		name    string
+		vcpus   int
+		memoryM uint64
+		want    VirtualMachineHotplug
+	}{
+		{"Unchanged", 2, 4096, VirtualMachineHotplug{}},
+		{"AddBoth", 4, 6144, VirtualMachineHotplug{VCpus: 4, Memory: 2048 * 1024 * 1024}},
+		{"AboveLimits", 16, 32768, VirtualMachineHotplug{}},
+		{"MemoryShrink", 2, 2048, VirtualMachineHotplug{}},
+		{"Unaligned", 2, 4097, VirtualMachineHotplug{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := &VirtualMachine{VCpus: tt.vcpus, Memory: NewSize(tt.memoryM, SizeUnitM)}
+			if got := PlanHotplug(existing, vm); got != tt.want {
+				t.Errorf("PlanHotplug() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	stopped := *existing
+	stopped.State = StateStopped
+	if got := PlanHotplug(&stopped, &VirtualMachine{VCpus: 4, Memory: NewSize(6144, SizeUnitM)}); !got.Empty() {
+		t.Errorf("PlanHotplug() = %+v for stopped machine, want empty", got)
+	}
+}
+
+type fakeHotplugVirtualMachineRepository struct {
+	VirtualMachineRepository
+	calls []string
+}
+
+func (repo *fakeHotplugVirtualMachineRepository) Save(vm *VirtualMachine) error {
+	repo.calls = append(repo.calls, "save")
+	return nil
+}
+
+func (repo *fakeHotplugVirtualMachineRepository) SetVcpus(id, node string, vcpus int) error {
+	repo.calls = append(repo.calls, "vcpus")
+	return nil
+}
+
+func (repo *fakeHotplugVirtualMachineRepository) AttachMemory(id, node string, size Size) error {
+	repo.calls = append(repo.calls, "memory")
+	return nil
+}
+
+func TestHotplugSavesFirst(t *testing.T) {
+	existing := &VirtualMachine{
+		State:       StateRunning,
+		VCpus:       2,
+		MaxVCpus:    8,
+		Memory:      NewSize(4096, SizeUnitM),
+		MaxMemory:   NewSize(16384, SizeUnitM),
+		MemorySlots: 4,
+	}
+	repo := &fakeHotplugVirtualMachineRepository{}
+	service := NewVirtualMachineService(repo)
+	vm := &VirtualMachine{VCpus: 4, MaxVCpus: 8, Memory: NewSize(6144, SizeUnitM), MaxMemory: NewSize(16384, SizeUnitM), MemorySlots: 4}
+	if _, err := service.Hotplug(existing, vm); err != nil {
+		t.Fatalf("Hotplug() error = %v", err)
+	}
+	if want := []string{"save", "vcpus", "memory"}; !reflect.DeepEqual(repo.calls, want) {
+		t.Errorf("Hotplug() calls = %v, want %v", repo.calls, want)
+	}
+	if vm.DimmMemory.Bytes() != 2048*1024*1024 {
+		t.Errorf("Hotplug() dimm memory = %d, want 2 GiB", vm.DimmMemory.Bytes())
+	}
+
+	repo.calls = nil
+	invalid := &VirtualMachine{VCpus: 4, MaxVCpus: 8, Memory: NewSize(6144, SizeUnitM), MaxMemory: NewSize(16384, SizeUnitM), MemorySlots: 4, Watchdog: "explode"}
+	if _, err := service.Hotplug(existing, invalid); err == nil {
+		t.Errorf("Hotplug() error = nil for invalid machine")
+	}
+	if len(repo.calls) != 0 {
+		t.Errorf("Hotplug() calls = %v for invalid machine, want none", repo.calls)
+	}
+}
@@ -0,0 +1,97 @@
+package compute
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VirtualMachineVolumeIoTune limits volume throughput, zero means unlimited.
+// Max values allow bursts above the limit for a short time.
+type VirtualMachineVolumeIoTune struct {
+	TotalBytesSec    uint64
+	ReadBytesSec     uint64
+	WriteBytesSec    uint64
+	TotalIopsSec     uint64
+	ReadIopsSec      uint64
+	WriteIopsSec     uint64
+	TotalBytesSecMax uint64
+	ReadBytesSecMax  uint64
+	WriteBytesSecMax uint64
+	TotalIopsSecMax  uint64
+	ReadIopsSecMax   uint64
+	WriteIopsSecMax  uint64
+}
+
+func (iotune VirtualMachineVolumeIoTune) Empty() bool {
+	return iotune == VirtualMachineVolumeIoTune{}
+}
+
+func (iotune VirtualMachineVolumeIoTune) Validate() error {
+	limits := []struct {
+		name                        string
+		total, read, write          uint64
+		totalMax, readMax, writeMax uint64
+	}{
+		{"bytes", iotune.TotalBytesSec, iotune.ReadBytesSec, iotune.WriteBytesSec, iotune.TotalBytesSecMax, iotune.ReadBytesSecMax, iotune.WriteBytesSecMax},
+		{"iops", iotune.TotalIopsSec, iotune.ReadIopsSec, iotune.WriteIopsSec, iotune.TotalIopsSecMax, iotune.ReadIopsSecMax, iotune.WriteIopsSecMax},
+	}
+	for _, limit := range limits {
+		if limit.total > 0 && (limit.read > 0 || limit.write > 0) {
+			return fmt.Errorf("total %s limit cannot be used together with read or write limits", limit.name)
+		}
+		if limit.totalMax > 0 && (limit.readMax > 0 || limit.writeMax > 0) {
+			return fmt.Errorf("total %s burst cannot be used together with read or write bursts", limit.name)
+		}
+		for _, pair := range []struct {
+			kind       string
+			value, max uint64
+		}{{"total", limit.total, limit.totalMax}, {"read", limit.read, limit.readMax}, {"write", limit.write, limit.writeMax}} {
+			if pair.max > 0 && pair.max < pair.value {
+				return fmt.Errorf("%s %s burst cannot be less than limit", pair.kind, limit.name)
+			}
+			if pair.max > 0 && pair.value == 0 {
+				return fmt.Errorf("%s %s burst requires %s %s limit", pair.kind, limit.name, pair.kind, limit.name)
+			}
+		}
+	}
+	return nil
+}
+
+// String returns short human readable description of limits
+func (iotune VirtualMachineVolumeIoTune) String() string {
+	items := []string{}
+	for _, item := range []struct {
+		name       string
+		value, max uint64
+		bytes      bool
+	}{
+		{"total", iotune.TotalBytesSec, iotune.TotalBytesSecMax, true},
+		{"read", iotune.ReadBytesSec, iotune.ReadBytesSecMax, true},
+		{"write", iotune.WriteBytesSec, iotune.WriteBytesSecMax, true},
+		{"total", iotune.TotalIopsSec, iotune.TotalIopsSecMax, false},
+		{"read", iotune.ReadIopsSec, iotune.ReadIopsSecMax, false},
+		{"write", iotune.WriteIopsSec, iotune.WriteIopsSecMax, false},
+	} {
+		if item.value == 0 {
+			continue
+		}
+		value, max, unit := item.value, item.max, "iops"
+		if item.bytes {
+			value, max, unit = value/1024/1024, max/1024/1024, "MiB/s"
+		}
+		description := fmt.Sprintf("%s %d %s", item.name, value, unit)
+		if max > 0 {
+			description += fmt.Sprintf(" (burst %d)", max)
+		}
+		items = append(items, description)
+	}
+	return strings.Join(items, ", ")
+}
+
+// SetVolumeIoTune changes limits of attached volume, running machine gets them live
+func (service *VirtualMachineService) SetVolumeIoTune(id, node, path string, iotune VirtualMachineVolumeIoTune) error {
+	if err := iotune.Validate(); err != nil {
+		return err
+	}
+	return service.VirtualMachineRepository.SetVolumeIoTune(id, node, path, iotune)
+}
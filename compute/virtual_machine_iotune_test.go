@@ -0,0 +1,25 @@
+package compute
+
+import "testing"
+
+func TestVirtualMachineVolumeIoTuneValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		iotune  VirtualMachineVolumeIoTune
+		wantErr bool
+	}{
+		{"Empty", VirtualMachineVolumeIoTune{}, false},
+		{"ReadWrite", VirtualMachineVolumeIoTune{ReadBytesSec: 100, WriteBytesSec: 50, TotalIopsSec: 500, TotalIopsSecMax: 1000}, false},
+		{"TotalWithRead", VirtualMachineVolumeIoTune{TotalBytesSec: 100, ReadBytesSec: 50}, true},
+		{"TotalBurstWithWriteBurst", VirtualMachineVolumeIoTune{TotalIopsSec: 100, WriteIopsSec: 0, TotalIopsSecMax: 200, WriteIopsSecMax: 300}, true},
+		{"BurstBelowLimit", VirtualMachineVolumeIoTune{WriteIopsSec: 100, WriteIopsSecMax: 50}, true},
+		{"BurstWithoutLimit", VirtualMachineVolumeIoTune{ReadBytesSecMax: 100}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.iotune.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package compute
+
+import "fmt"
+
+var (
+	// LifecycleActions are allowed on poweroff and on reboot actions
+	LifecycleActions = []string{"destroy", "restart", "preserve", "rename-restart"}
+	// CrashActions are allowed on crash actions, crash is reported by pvpanic device
+	CrashActions    = []string{"destroy", "restart", "preserve", "rename-restart", "coredump-destroy", "coredump-restart"}
+	WatchdogActions = []string{"reset", "shutdown", "poweroff", "pause", "inject-nmi", "none"}
+)
+
+const (
+	DefaultOnPoweroff = "destroy"
+	DefaultOnReboot   = "restart"
+	DefaultOnCrash    = "destroy"
+)
+
+// LifecycleAction returns action or default one if action is empty
+func LifecycleAction(action, defaultAction string) string {
+	if action == "" {
+		return defaultAction
+	}
+	return action
+}
+
+func containsString(items []string, needle string) bool {
+	for _, item := range items {
+		if item == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateDevices checks watchdog and lifecycle actions, empty lifecycle
+// action means default one
+func (vm *VirtualMachine) ValidateDevices() error {
+	if vm.Watchdog != "" && !containsString(WatchdogActions, vm.Watchdog) {
+		return fmt.Errorf("unknown watchdog action '%s'", vm.Watchdog)
+	}
+	if vm.OnPoweroff != "" && !containsString(LifecycleActions, vm.OnPoweroff) {
+		return fmt.Errorf("unknown on poweroff action '%s'", vm.OnPoweroff)
+	}
+	if vm.OnReboot != "" && !containsString(LifecycleActions, vm.OnReboot) {
+		return fmt.Errorf("unknown on reboot action '%s'", vm.OnReboot)
+	}
+	if vm.OnCrash != "" && !containsString(CrashActions, vm.OnCrash) {
+		return fmt.Errorf("unknown on crash action '%s'", vm.OnCrash)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package compute
+
+import "testing"
+
+func TestVirtualMachineValidateDevices(t *testing.T) {
+	tests := []struct {
+		name    string
+		vm      VirtualMachine
+		wantErr bool
+	}{
+		{"Defaults", VirtualMachine{}, false},
+		{"All", VirtualMachine{Watchdog: "reset", OnPoweroff: "destroy", OnReboot: "restart", OnCrash: "coredump-restart"}, false},
+		{"UnknownWatchdog", VirtualMachine{Watchdog: "explode"}, true},
+		{"CoredumpOnReboot", VirtualMachine{OnReboot: "coredump-restart"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.vm.ValidateDevices(); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateDevices() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLifecycleAction(t *testing.T) {
+	if got := LifecycleAction("", DefaultOnReboot); got != DefaultOnReboot {
+		t.Errorf("LifecycleAction(\"\") = %s, want %s", got, DefaultOnReboot)
+	}
+	if got := LifecycleAction("preserve", DefaultOnReboot); got != "preserve" {
+		t.Errorf("LifecycleAction(preserve) = %s, want preserve", got)
+	}
+}
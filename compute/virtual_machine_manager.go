@@ -1,11 +1,21 @@
 package compute
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"subuk/vmango/configdrive"
+	"subuk/vmango/diskimage"
 	"subuk/vmango/util"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
@@ -25,6 +35,7 @@ type VirtualMachineManagerClonedVolumeParams struct {
 	Alias        string
 	DeviceType   DeviceType
 	DeviceBus    DeviceBus
+	BootOrder    uint
 }
 
 type VirtualMachineManagerCreatedVolumeParams struct {
@@ -35,6 +46,7 @@ type VirtualMachineManagerCreatedVolumeParams struct {
 	Alias      string
 	DeviceType DeviceType
 	DeviceBus  DeviceBus
+	BootOrder  uint
 }
 
 type VirtualMachineManagerCreateParams struct {
@@ -45,18 +57,22 @@ type VirtualMachineManagerCreateParams struct {
 }
 
 type VirtualMachineManager struct {
-	vms      *VirtualMachineService
-	volumes  *VolumeService
-	settings map[string]VirtualMachineManagerNodeSettings
-	epub     EventPublisher
+	vms          *VirtualMachineService
+	volumes      *VolumeService
+	settings     map[string]VirtualMachineManagerNodeSettings
+	epub         EventPublisher
+	migrations   map[string]*VirtualMachineMigration
+	migrationsMu *sync.Mutex
 }
 
 func NewVirtualMachineManager(vms *VirtualMachineService, volumes *VolumeService, epub EventPublisher, settings map[string]VirtualMachineManagerNodeSettings) *VirtualMachineManager {
 	return &VirtualMachineManager{
-		vms:      vms,
-		volumes:  volumes,
-		epub:     epub,
-		settings: settings,
+		vms:          vms,
+		volumes:      volumes,
+		epub:         epub,
+		settings:     settings,
+		migrations:   map[string]*VirtualMachineMigration{},
+		migrationsMu: &sync.Mutex{},
 	}
 }
 
@@ -79,6 +95,7 @@ func (manager *VirtualMachineManager) Create(vm *VirtualMachine, cloneVols []Vir
 			Alias:      p.Alias,
 			DeviceType: p.DeviceType,
 			DeviceBus:  p.DeviceBus,
+			BootOrder:  p.BootOrder,
 		})
 	}
 	for _, p := range newVols {
@@ -98,37 +115,16 @@ func (manager *VirtualMachineManager) Create(vm *VirtualMachine, cloneVols []Vir
 			Alias:      p.Alias,
 			DeviceType: p.DeviceType,
 			DeviceBus:  p.DeviceBus,
+			BootOrder:  p.BootOrder,
 		})
 	}
 	if err := manager.vms.Save(vm); err != nil {
 		return err
 	}
-	settings := manager.settings[vm.NodeId]
 	if vm.Config != nil {
-		cdFile, err := manager.generateConfigDrive(vm.Config, settings.CdFormat)
+		cdVolume, err := manager.createConfigDrive(vm.Id, vm.NodeId, vm.Config)
 		if err != nil {
-			return util.NewError(err, "cannot generate configdrive")
-		}
-		cdLen, err := cdFile.Seek(0, io.SeekEnd)
-		if err != nil {
-			return util.NewError(err, "cannot get configdrive length")
-		}
-		if _, err := cdFile.Seek(0, io.SeekStart); err != nil {
-			return util.NewError(err, "configdrive seek to start failed")
-		}
-		cdVolumeParams := VolumeCreateParams{
-			NodeId: vm.NodeId,
-			Name:   vm.Id + settings.CdSuffix,
-			Pool:   settings.CdPool,
-			Format: VolumeFormatIso,
-			Size:   NewSize(uint64(cdLen), SizeUnitB),
-		}
-		cdVolume, err := manager.volumes.Create(cdVolumeParams)
-		if err != nil {
-			return util.NewError(err, "cannot create configdrive volume")
-		}
-		if err := manager.volumes.Upload(cdVolume.Path, cdVolume.NodeId, cdFile, cdVolume.Size.Bytes()); err != nil {
-			return util.NewError(err, "cannot upload configdrive volume")
+			return err
 		}
 		attachedVolume := &VirtualMachineAttachedVolume{
 			Path:       cdVolume.Path,
@@ -174,7 +170,638 @@ func (manager *VirtualMachineManager) Delete(id, node string, deleteVolumes bool
 	return nil
 }
 
+func (manager *VirtualMachineManager) Rename(id, node, newId string, renameVolumes bool) error {
+	if err := ValidateVirtualMachineName(newId); err != nil {
+		return err
+	}
+	if newId == id {
+		return fmt.Errorf("new name is the same as current")
+	}
+	vm, err := manager.vms.Get(id, node)
+	if err != nil {
+		return util.NewError(err, "cannot fetch vm info")
+	}
+	if vm.IsRunning() {
+		return fmt.Errorf("vm must be stopped")
+	}
+	if err := manager.vms.Rename(id, node, newId); err != nil {
+		return util.NewError(err, "cannot rename vm")
+	}
+	if !renameVolumes {
+		return nil
+	}
+	// Old volumes are deleted only after all new ones are attached,
+	// so rename can be rolled back if any volume fails to move
+	moved, err := manager.renameVolumes(vm, id, node, newId)
+	if err != nil {
+		return manager.rollbackRename(id, node, newId, moved, err)
+	}
+	for _, volume := range moved {
+		if err := manager.volumes.Delete(volume.oldPath, node); err != nil {
+			return util.NewError(err, "cannot delete old volume %s", volume.oldPath)
+		}
+	}
+	return nil
+}
+
+type renamedVolume struct {
+	oldPath  string
+	newPath  string
+	replaced bool
+}
+
+// renameVolumes copies root and configdrive volumes of renamed machine and attaches copies
+// instead of the old ones. Returned list contains created volumes even if error occurred.
+func (manager *VirtualMachineManager) renameVolumes(vm *VirtualMachine, id, node, newId string) ([]*renamedVolume, error) {
+	settings := manager.settings[node]
+	moved := []*renamedVolume{}
+	for _, attachedVolume := range vm.Volumes {
+		var newVolume *Volume
+		switch filepath.Base(attachedVolume.Path) {
+		default:
+			continue
+		case id + "_root":
+			volume, err := manager.volumes.Get(attachedVolume.Path, node)
+			if err != nil {
+				return moved, util.NewError(err, "cannot fetch root volume info")
+			}
+			params := VolumeCloneParams{
+				NodeId:       node,
+				Format:       volume.Format,
+				OriginalPath: volume.Path,
+				NewName:      newId + "_root",
+				NewPool:      volume.Pool,
+				NewSize:      volume.Size,
+			}
+			newVolume, err = manager.volumes.Clone(params)
+			if err != nil {
+				return moved, util.NewError(err, "cannot copy root volume")
+			}
+		case id + settings.CdSuffix:
+			if vm.Config == nil {
+				continue
+			}
+			config := *vm.Config
+			config.Hostname = newId
+			cdVolume, err := manager.createConfigDrive(newId, node, &config)
+			if err != nil {
+				return moved, err
+			}
+			newVolume = cdVolume
+		}
+		volume := &renamedVolume{oldPath: attachedVolume.Path, newPath: newVolume.Path}
+		moved = append(moved, volume)
+		if err := manager.vms.ReplaceVolume(newId, node, volume.oldPath, volume.newPath); err != nil {
+			return moved, util.NewError(err, "cannot replace volume %s", volume.oldPath)
+		}
+		volume.replaced = true
+	}
+	return moved, nil
+}
+
+// rollbackRename reattaches old volumes, deletes copies and restores machine name.
+// Original error is returned, rollback errors are added to it.
+func (manager *VirtualMachineManager) rollbackRename(id, node, newId string, moved []*renamedVolume, cause error) error {
+	for _, volume := range moved {
+		if volume.replaced {
+			if err := manager.vms.ReplaceVolume(newId, node, volume.newPath, volume.oldPath); err != nil {
+				return util.NewError(cause, "rename failed, cannot reattach volume %s (%s)", volume.oldPath, err)
+			}
+		}
+		if err := manager.volumes.Delete(volume.newPath, node); err != nil {
+			return util.NewError(cause, "rename failed, cannot delete volume copy %s (%s)", volume.newPath, err)
+		}
+	}
+	if err := manager.vms.Rename(newId, node, id); err != nil {
+		return util.NewError(cause, "rename failed, cannot restore machine name %s (%s)", id, err)
+	}
+	return cause
+}
+
+// GrowRootVolume resizes <id>_root volume of stopped machine if it is smaller than size.
+// Volume of running machine is not changed, true is returned if it needs to be resized.
+func (manager *VirtualMachineManager) GrowRootVolume(id, node string, size Size) (bool, error) {
+	vm, err := manager.vms.Get(id, node)
+	if err != nil {
+		return false, util.NewError(err, "cannot fetch vm info")
+	}
+	for _, attachedVolume := range vm.Volumes {
+		if filepath.Base(attachedVolume.Path) != id+"_root" {
+			continue
+		}
+		volume, err := manager.volumes.Get(attachedVolume.Path, node)
+		if err != nil {
+			return false, util.NewError(err, "cannot fetch root volume info")
+		}
+		if volume.Size.Bytes() >= size.Bytes() {
+			return false, nil
+		}
+		if vm.IsRunning() {
+			return true, nil
+		}
+		if err := manager.volumes.Resize(volume.Path, node, size); err != nil {
+			return false, util.NewError(err, "cannot resize root volume")
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+func (manager *VirtualMachineManager) Migrate(id, node, targetNode string, options VirtualMachineMigrateOptions) (*VirtualMachineMigration, error) {
+	if node == targetNode {
+		return nil, fmt.Errorf("vm is already on node %s", targetNode)
+	}
+	if _, ok := manager.settings[targetNode]; !ok {
+		return nil, ErrUnknownNode
+	}
+	vm, err := manager.vms.Get(id, node)
+	if err != nil {
+		return nil, util.NewError(err, "cannot fetch vm info")
+	}
+	if options.Offline {
+		if vm.IsRunning() {
+			return nil, fmt.Errorf("vm must be stopped for offline migration")
+		}
+		if options.TargetPool == "" {
+			return nil, fmt.Errorf("no target pool specified")
+		}
+		if vm.HasFirmwareState() {
+			return nil, fmt.Errorf("offline migration of vm with secure boot or tpm is not supported, nvram and tpm state would be lost")
+		}
+	} else if !vm.IsRunning() {
+		return nil, fmt.Errorf("vm must be running for live migration")
+	}
+
+	manager.migrationsMu.Lock()
+	key := node + "/" + id
+	if existing := manager.migrations[key]; existing != nil && !existing.Snapshot().IsFinished() {
+		manager.migrationsMu.Unlock()
+		return nil, fmt.Errorf("vm migration is already in progress")
+	}
+	migration := &VirtualMachineMigration{
+		VmId:         id,
+		SourceNodeId: node,
+		TargetNodeId: targetNode,
+		Options:      options,
+		Started:      time.Now(),
+		mu:           &sync.Mutex{},
+	}
+	manager.migrations[key] = migration
+	manager.pruneMigrations()
+	manager.migrationsMu.Unlock()
+
+	go func() {
+		if options.Offline {
+			migration.finish(manager.migrateOffline(vm, migration))
+			return
+		}
+		migration.finish(manager.vms.Migrate(id, node, targetNode, options, migration.setProgress))
+	}()
+	return migration, nil
+}
+
+// copyVolume streams volume data to new volume on target node and verifies its checksum.
+// Target is created with physical size of the source and exactly that many bytes are
+// uploaded and compared, so it doesn't keep any data beyond the copied content.
+func (manager *VirtualMachineManager) copyVolume(source *Volume, targetNode, targetPool string, migration *VirtualMachineMigration) (*Volume, error) {
+	length := source.PhysicalSize.Bytes()
+	if length == 0 && source.Size.Bytes() != 0 {
+		return nil, fmt.Errorf("size of volume %s data is unknown, libvirt 3.0.0 or newer is required", source.Path)
+	}
+	params := VolumeCreateParams{
+		NodeId: targetNode,
+		Name:   source.Name,
+		Pool:   targetPool,
+		Format: source.Format,
+		Size:   source.PhysicalSize,
+	}
+	target, err := manager.volumes.Create(params)
+	if err != nil {
+		return nil, util.NewError(err, "cannot create volume %s on target node", source.Name)
+	}
+	content, err := manager.volumes.Download(source.Path, source.NodeId)
+	if err != nil {
+		return target, util.NewError(err, "cannot start volume %s download", source.Path)
+	}
+	defer content.Close()
+	sourceHash := sha256.New()
+	reader := io.TeeReader(&migrationProgressReader{reader: content, migration: migration}, sourceHash)
+	if err := manager.volumes.Upload(target.Path, targetNode, reader, length); err != nil {
+		return target, util.NewError(err, "cannot upload volume %s to target node", source.Path)
+	}
+
+	targetContent, err := manager.volumes.Download(target.Path, targetNode)
+	if err != nil {
+		return target, util.NewError(err, "cannot start verification download of %s", target.Path)
+	}
+	defer targetContent.Close()
+	targetHash := sha256.New()
+	copied, err := io.Copy(targetHash, io.LimitReader(targetContent, int64(length)))
+	if err != nil {
+		return target, util.NewError(err, "verification download of %s failed", target.Path)
+	}
+	if uint64(copied) != length || !bytes.Equal(sourceHash.Sum(nil), targetHash.Sum(nil)) {
+		return target, fmt.Errorf("checksum mismatch for volume %s after copy", source.Path)
+	}
+	return target, nil
+}
+
+// migrateOffline copies writable disks owned by the machine to target node and
+// removes them from source after the machine is defined on target. Cdroms and
+// volumes shared with other machines are not copied, they must exist on target
+// node with the same path.
+func (manager *VirtualMachineManager) migrateOffline(vm *VirtualMachine, migration *VirtualMachineMigration) error {
+	nodeVms, err := manager.vms.List(VirtualMachineListOptions{NodeIds: []string{vm.NodeId}})
+	if err != nil {
+		return util.NewError(err, "cannot list source node vms")
+	}
+	shared := map[string]bool{}
+	for _, other := range nodeVms {
+		if other.Id == vm.Id {
+			continue
+		}
+		for _, attachedVolume := range other.Volumes {
+			shared[attachedVolume.Path] = true
+		}
+	}
+
+	sourceVolumes := []*Volume{}
+	volumePaths := map[string]string{}
+	total := uint64(0)
+	for _, attachedVolume := range vm.Volumes {
+		if attachedVolume.Path == "" {
+			continue
+		}
+		if attachedVolume.DeviceType != DeviceTypeDisk || shared[attachedVolume.Path] {
+			if _, err := manager.volumes.Get(attachedVolume.Path, migration.TargetNodeId); err != nil {
+				return util.NewError(err, "volume %s is not copied and must exist on target node", attachedVolume.Path)
+			}
+			volumePaths[attachedVolume.Path] = attachedVolume.Path
+			continue
+		}
+		volume, err := manager.volumes.Get(attachedVolume.Path, vm.NodeId)
+		if err != nil {
+			return util.NewError(err, "cannot fetch volume %s info", attachedVolume.Path)
+		}
+		sourceVolumes = append(sourceVolumes, volume)
+		total += volume.PhysicalSize.Bytes()
+	}
+	migration.setProgress(VirtualMachineMigrationProgress{DataTotal: total, DataRemaining: total})
+
+	targetVolumes := []*Volume{}
+	cleanup := func() {
+		for _, volume := range targetVolumes {
+			manager.volumes.Delete(volume.Path, volume.NodeId) // Ignore error
+		}
+	}
+	for _, volume := range sourceVolumes {
+		targetVolume, err := manager.copyVolume(volume, migration.TargetNodeId, migration.Options.TargetPool, migration)
+		if targetVolume != nil {
+			targetVolumes = append(targetVolumes, targetVolume)
+		}
+		if err != nil {
+			cleanup()
+			return err
+		}
+		volumePaths[volume.Path] = targetVolume.Path
+	}
+	if err := manager.vms.CopyDefinition(vm.Id, vm.NodeId, migration.TargetNodeId, volumePaths); err != nil {
+		cleanup()
+		return util.NewError(err, "cannot define vm on target node")
+	}
+	if err := manager.vms.Delete(vm.Id, vm.NodeId); err != nil {
+		return util.NewError(err, "cannot delete vm from source node")
+	}
+	for _, volume := range sourceVolumes {
+		if err := manager.volumes.Delete(volume.Path, volume.NodeId); err != nil {
+			return util.NewError(err, "cannot delete volume %s from source node", volume.Path)
+		}
+	}
+	return nil
+}
+
+// pruneMigrations removes the oldest finished migrations, so only
+// last migrationHistorySize of them are available for status page.
+// Must be called with migrationsMu held.
+func (manager *VirtualMachineManager) pruneMigrations() {
+	finished := []string{}
+	finishedAt := map[string]time.Time{}
+	for key, migration := range manager.migrations {
+		snapshot := migration.Snapshot()
+		if !snapshot.IsFinished() {
+			continue
+		}
+		finished = append(finished, key)
+		finishedAt[key] = snapshot.Finished
+	}
+	if len(finished) <= migrationHistorySize {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finishedAt[finished[i]].After(finishedAt[finished[j]])
+	})
+	for _, key := range finished[migrationHistorySize:] {
+		delete(manager.migrations, key)
+	}
+}
+
+func (manager *VirtualMachineManager) Migration(id, node string) *VirtualMachineMigration {
+	manager.migrationsMu.Lock()
+	defer manager.migrationsMu.Unlock()
+	return manager.migrations[node+"/"+id]
+}
+
+// VirtualMachineExport is a checked export of stopped machine ready to be streamed
+type VirtualMachineExport struct {
+	Id       string
+	NodeId   string
+	manifest []byte
+	files    []string
+	volumes  []*Volume
+}
+
+// Export writes archive of stopped machine with its volumes
+func (manager *VirtualMachineManager) Export(id, node string, w io.Writer) error {
+	export, err := manager.PrepareExport(id, node)
+	if err != nil {
+		return err
+	}
+	return manager.WriteExport(export, w)
+}
+
+// PrepareExport checks that machine can be exported and builds its manifest,
+// nothing is written yet, so errors can be reported to the client
+func (manager *VirtualMachineManager) PrepareExport(id, node string) (*VirtualMachineExport, error) {
+	vm, err := manager.vms.Get(id, node)
+	if err != nil {
+		return nil, util.NewError(err, "cannot fetch vm info")
+	}
+	if vm.IsRunning() {
+		return nil, fmt.Errorf("vm must be stopped")
+	}
+	if vm.HasFirmwareState() {
+		return nil, fmt.Errorf("export of vm with secure boot or tpm is not supported, nvram and tpm state would be lost")
+	}
+	manifest := NewVirtualMachineManifest(vm)
+	settings := manager.settings[node]
+	volumes := []*Volume{}
+	for _, attachedVolume := range vm.Volumes {
+		if attachedVolume.Path == "" {
+			continue
+		}
+		// Configdrive is generated again on import
+		if vm.Config != nil && filepath.Base(attachedVolume.Path) == id+settings.CdSuffix {
+			continue
+		}
+		volume, err := manager.volumes.Get(attachedVolume.Path, node)
+		if err != nil {
+			return nil, util.NewError(err, "cannot fetch volume %s info", attachedVolume.Path)
+		}
+		if volume.PhysicalSize.Bytes() == 0 && volume.Size.Bytes() != 0 {
+			return nil, fmt.Errorf("size of volume %s data is unknown, libvirt 3.0.0 or newer is required", volume.Path)
+		}
+		manifest.Disks = append(manifest.Disks, VirtualMachineManifestDisk{
+			File:       fmt.Sprintf("disks/%02d_%s", len(volumes), volume.Name),
+			Name:       volume.Name,
+			Format:     exportVolumeFormat(volume.Format).String(),
+			Size:       volume.Size.Bytes(),
+			Alias:      attachedVolume.Alias,
+			DeviceType: attachedVolume.DeviceType.String(),
+			DeviceBus:  attachedVolume.DeviceBus.String(),
+			BootOrder:  attachedVolume.BootOrder,
+			IoTune:     attachedVolume.IoTune,
+			Driver:     attachedVolume.Driver,
+		})
+		volumes = append(volumes, volume)
+	}
+	manifestContent, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, util.NewError(err, "cannot serialize manifest")
+	}
+	files := []string{}
+	for _, disk := range manifest.Disks {
+		files = append(files, disk.File)
+	}
+	return &VirtualMachineExport{Id: vm.Id, NodeId: vm.NodeId, manifest: manifestContent, files: files, volumes: volumes}, nil
+}
+
+// WriteExport streams manifest and volumes of prepared export into tar archive
+func (manager *VirtualMachineManager) WriteExport(export *VirtualMachineExport, w io.Writer) error {
+	archive := tar.NewWriter(w)
+	header := &tar.Header{Name: VirtualMachineManifestName, Mode: 0644, Size: int64(len(export.manifest)), ModTime: time.Now()}
+	if err := archive.WriteHeader(header); err != nil {
+		return util.NewError(err, "cannot write manifest header")
+	}
+	if _, err := archive.Write(export.manifest); err != nil {
+		return util.NewError(err, "cannot write manifest")
+	}
+	for idx, volume := range export.volumes {
+		if err := manager.exportVolume(archive, export.files[idx], volume); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return util.NewError(err, "cannot finish archive")
+	}
+	return nil
+}
+
+// exportVolumeFormat returns format of volume in archive, iso images are
+// raw ones, only raw and qcow2 are accepted on import
+func exportVolumeFormat(format VolumeFormat) VolumeFormat {
+	if format == VolumeFormatIso {
+		return VolumeFormatRaw
+	}
+	return format
+}
+
+// exportVolume streams volume content into archive, tar header requires
+// exact size of the content, so physical size of the volume is used.
+// Tar writer fails if downloaded content length doesn't match it.
+func (manager *VirtualMachineManager) exportVolume(archive *tar.Writer, name string, volume *Volume) error {
+	content, err := manager.volumes.Download(volume.Path, volume.NodeId)
+	if err != nil {
+		return util.NewError(err, "cannot start volume %s download", volume.Path)
+	}
+	defer content.Close()
+	header := &tar.Header{Name: name, Mode: 0644, Size: int64(volume.PhysicalSize.Bytes()), ModTime: time.Now()}
+	if err := archive.WriteHeader(header); err != nil {
+		return util.NewError(err, "cannot write volume %s header", volume.Path)
+	}
+	if _, err := io.Copy(archive, content); err != nil {
+		return util.NewError(err, "cannot write volume %s", volume.Path)
+	}
+	if err := archive.Flush(); err != nil {
+		return util.NewError(err, "volume %s download is shorter than its size", volume.Path)
+	}
+	return nil
+}
+
+// importVolume stores disk from archive in temporary file and checks it with qemu-img
+// before upload. Qcow2 header may refer to host files, qemu opens them on machine start.
+func (manager *VirtualMachineManager) importVolume(content io.Reader, disk VirtualMachineManifestDisk, params VolumeCreateParams) (*Volume, error) {
+	tmpfile, err := ioutil.TempFile("", "vmango-import")
+	if err != nil {
+		return nil, util.NewError(err, "cannot create temporary file")
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+	size, err := io.Copy(tmpfile, content)
+	if err != nil {
+		return nil, util.NewError(err, "cannot read disk %s from archive", disk.File)
+	}
+	info, err := diskimage.GetInfo(tmpfile.Name(), params.Format.String())
+	if err != nil {
+		return nil, util.NewError(err, "cannot check disk %s", disk.File)
+	}
+	if err := info.CheckExternalFiles(tmpfile.Name(), ""); err != nil {
+		return nil, util.NewError(err, "disk %s is rejected", disk.File)
+	}
+	if _, err := tmpfile.Seek(0, io.SeekStart); err != nil {
+		return nil, util.NewError(err, "temporary file seek to start failed")
+	}
+	volume, err := manager.volumes.Create(params)
+	if err != nil {
+		return nil, util.NewError(err, "cannot create volume %s", params.Name)
+	}
+	if err := manager.volumes.Upload(volume.Path, volume.NodeId, tmpfile, uint64(size)); err != nil {
+		manager.volumes.Delete(volume.Path, volume.NodeId) // Ignore error
+		return nil, util.NewError(err, "cannot upload volume %s", volume.Path)
+	}
+	return volume, nil
+}
+
+func (manager *VirtualMachineManager) Import(content io.Reader, options VirtualMachineImportOptions) (*VirtualMachine, error) {
+	archive := tar.NewReader(content)
+	header, err := archive.Next()
+	if err != nil {
+		return nil, util.NewError(err, "cannot read archive")
+	}
+	if header.Name != VirtualMachineManifestName {
+		return nil, fmt.Errorf("archive must start with %s, got %s", VirtualMachineManifestName, header.Name)
+	}
+	manifest := &VirtualMachineManifest{}
+	if err := json.NewDecoder(archive).Decode(manifest); err != nil {
+		return nil, util.NewError(err, "cannot parse manifest")
+	}
+	if manifest.Version != VirtualMachineManifestVersion {
+		return nil, fmt.Errorf("unsupported manifest version %d", manifest.Version)
+	}
+	vm := manifest.VirtualMachine(options)
+	if err := ValidateVirtualMachineName(vm.Id); err != nil {
+		return nil, err
+	}
+
+	disks := map[string]VirtualMachineManifestDisk{}
+	for _, disk := range manifest.Disks {
+		if format := NewVolumeFormat(disk.Format); format != VolumeFormatRaw && format != VolumeFormatQcow2 {
+			return nil, fmt.Errorf("disk %s has unsupported format '%s', only raw and qcow2 can be imported", disk.File, disk.Format)
+		}
+		disks[disk.File] = disk
+	}
+	createdVolumes := map[string]*Volume{}
+	cleanup := func() {
+		for _, volume := range createdVolumes {
+			manager.volumes.Delete(volume.Path, volume.NodeId) // Ignore error
+		}
+	}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			cleanup()
+			return nil, util.NewError(err, "cannot read archive")
+		}
+		disk, exists := disks[header.Name]
+		if !exists {
+			cleanup()
+			return nil, fmt.Errorf("unexpected file %s in archive", header.Name)
+		}
+		params := VolumeCreateParams{
+			NodeId: options.NodeId,
+			Name:   manifest.VolumeName(disk, vm.Id),
+			Pool:   options.Pool,
+			Format: NewVolumeFormat(disk.Format),
+			Size:   NewSize(disk.Size, SizeUnitB),
+		}
+		volume, err := manager.importVolume(archive, disk, params)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		createdVolumes[disk.File] = volume
+	}
+	for _, disk := range manifest.Disks {
+		volume, exists := createdVolumes[disk.File]
+		if !exists {
+			cleanup()
+			return nil, fmt.Errorf("disk %s not found in archive", disk.File)
+		}
+		vm.Volumes = append(vm.Volumes, &VirtualMachineAttachedVolume{
+			Path:       volume.Path,
+			Alias:      disk.Alias,
+			DeviceType: NewDeviceType(disk.DeviceType),
+			DeviceBus:  NewDeviceBus(disk.DeviceBus),
+			BootOrder:  disk.BootOrder,
+			IoTune:     disk.IoTune,
+			Driver:     disk.Driver,
+		})
+	}
+	if err := manager.Create(vm, nil, nil, options.Start); err != nil {
+		cleanup()
+		return nil, err
+	}
+	return vm, nil
+}
+
+func (manager *VirtualMachineManager) createConfigDrive(vmId, nodeId string, config *VirtualMachineConfig) (*Volume, error) {
+	settings := manager.settings[nodeId]
+	cdFile, err := manager.generateConfigDrive(config, settings.CdFormat)
+	if err != nil {
+		return nil, util.NewError(err, "cannot generate configdrive")
+	}
+	defer cdFile.Close()
+	cdLen, err := cdFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, util.NewError(err, "cannot get configdrive length")
+	}
+	if _, err := cdFile.Seek(0, io.SeekStart); err != nil {
+		return nil, util.NewError(err, "configdrive seek to start failed")
+	}
+	cdVolumeParams := VolumeCreateParams{
+		NodeId: nodeId,
+		Name:   vmId + settings.CdSuffix,
+		Pool:   settings.CdPool,
+		Format: VolumeFormatIso,
+		Size:   NewSize(uint64(cdLen), SizeUnitB),
+	}
+	cdVolume, err := manager.volumes.Create(cdVolumeParams)
+	if err != nil {
+		return nil, util.NewError(err, "cannot create configdrive volume")
+	}
+	if err := manager.volumes.Upload(cdVolume.Path, cdVolume.NodeId, cdFile, cdVolume.Size.Bytes()); err != nil {
+		return nil, util.NewError(err, "cannot upload configdrive volume")
+	}
+	return cdVolume, nil
+}
+
 func (manager *VirtualMachineManager) generateConfigDrive(config *VirtualMachineConfig, format configdrive.Format) (*os.File, error) {
+	file, err := configdrive.GenerateIso(newConfigDriveData(config, format))
+	if err != nil {
+		return nil, util.NewError(err, "cannot generate iso")
+	}
+	return file, nil
+}
+
+// newConfigDriveData returns cloud-init data for machine config, instance id is kept
+// if config has it, so cloud-init doesn't run first boot modules again after rename
+func newConfigDriveData(config *VirtualMachineConfig, format configdrive.Format) configdrive.Data {
+	instanceId := config.InstanceId
+	if instanceId == "" {
+		instanceId = uuid.New().String()
+	}
 	var data configdrive.Data
 	switch format {
 	default:
@@ -190,7 +817,7 @@ func (manager *VirtualMachineManager) generateConfigDrive(config *VirtualMachine
 				Name:        config.Hostname,
 				Meta:        map[string]string{},
 				PublicKeys:  map[string]string{},
-				UUID:        uuid.New().String(),
+				UUID:        instanceId,
 			},
 		}
 		for _, key := range config.Keys {
@@ -201,7 +828,7 @@ func (manager *VirtualMachineManager) generateConfigDrive(config *VirtualMachine
 		nocloudData := &configdrive.NoCloud{
 			Userdata: config.Userdata,
 			Metadata: configdrive.NoCloudMetadata{
-				InstanceId:    uuid.New().String(),
+				InstanceId:    instanceId,
 				Hostname:      config.Hostname,
 				LocalHostname: config.Hostname,
 			},
@@ -211,10 +838,5 @@ func (manager *VirtualMachineManager) generateConfigDrive(config *VirtualMachine
 		}
 		data = nocloudData
 	}
-
-	file, err := configdrive.GenerateIso(data)
-	if err != nil {
-		return nil, util.NewError(err, "cannot generate iso")
-	}
-	return file, nil
+	return data
 }
@@ -0,0 +1,376 @@
+package compute
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"subuk/vmango/configdrive"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeRenameVirtualMachineRepository struct {
+	VirtualMachineRepository
+	vm         *VirtualMachine
+	replaceErr error
+	calls      []string
+}
+
+func (repo *fakeRenameVirtualMachineRepository) Get(id, node string) (*VirtualMachine, error) {
+	return repo.vm, nil
+}
+
+func (repo *fakeRenameVirtualMachineRepository) Rename(id, node, newId string) error {
+	repo.calls = append(repo.calls, "rename "+id+" "+newId)
+	return nil
+}
+
+func (repo *fakeRenameVirtualMachineRepository) ReplaceVolume(machineId, node, oldPath, newPath string) error {
+	repo.calls = append(repo.calls, "replace "+oldPath+" "+newPath)
+	return repo.replaceErr
+}
+
+type fakeRenameVolumeRepository struct {
+	VolumeRepository
+	calls []string
+}
+
+func (repo *fakeRenameVolumeRepository) Get(path, node string) (*Volume, error) {
+	return &Volume{Path: path, NodeId: node, Pool: "default"}, nil
+}
+
+func (repo *fakeRenameVolumeRepository) Clone(params VolumeCloneParams) (*Volume, error) {
+	repo.calls = append(repo.calls, "clone "+params.OriginalPath+" "+params.NewName)
+	return &Volume{Path: "/pool/" + params.NewName, NodeId: params.NodeId, Pool: params.NewPool}, nil
+}
+
+func (repo *fakeRenameVolumeRepository) Delete(path, node string) error {
+	repo.calls = append(repo.calls, "delete "+path)
+	return nil
+}
+
+func newTestRenameManager(vmRepo *fakeRenameVirtualMachineRepository, volRepo *fakeRenameVolumeRepository) *VirtualMachineManager {
+	return NewVirtualMachineManager(NewVirtualMachineService(vmRepo), NewVolumeService(volRepo), nil, nil)
+}
+
+func TestVirtualMachineManagerRename(t *testing.T) {
+	vmRepo := &fakeRenameVirtualMachineRepository{vm: &VirtualMachine{
+		Id:      "web",
+		NodeId:  "node1",
+		State:   StateStopped,
+		Volumes: []*VirtualMachineAttachedVolume{{Path: "/pool/web_root"}, {Path: "/pool/shared.iso"}},
+	}}
+	volRepo := &fakeRenameVolumeRepository{}
+	if err := newTestRenameManager(vmRepo, volRepo).Rename("web", "node1", "app", true); err != nil {
+		t.Fatalf("Rename() error = %v", err)
+	}
+	if want := []string{"rename web app", "replace /pool/web_root /pool/app_root"}; !reflect.DeepEqual(vmRepo.calls, want) {
+		t.Errorf("Rename() machine calls = %v, want %v", vmRepo.calls, want)
+	}
+	if want := []string{"clone /pool/web_root app_root", "delete /pool/web_root"}; !reflect.DeepEqual(volRepo.calls, want) {
+		t.Errorf("Rename() volume calls = %v, want %v", volRepo.calls, want)
+	}
+}
+
+func TestVirtualMachineManagerRenameRollback(t *testing.T) {
+	vmRepo := &fakeRenameVirtualMachineRepository{
+		vm: &VirtualMachine{
+			Id:      "web",
+			NodeId:  "node1",
+			State:   StateStopped,
+			Volumes: []*VirtualMachineAttachedVolume{{Path: "/pool/web_root"}},
+		},
+		replaceErr: errors.New("disk is locked"),
+	}
+	volRepo := &fakeRenameVolumeRepository{}
+	if err := newTestRenameManager(vmRepo, volRepo).Rename("web", "node1", "app", true); err == nil {
+		t.Fatalf("Rename() error = nil, want replace error")
+	}
+	if want := []string{"rename web app", "replace /pool/web_root /pool/app_root", "rename app web"}; !reflect.DeepEqual(vmRepo.calls, want) {
+		t.Errorf("Rename() machine calls = %v, want %v", vmRepo.calls, want)
+	}
+	if want := []string{"clone /pool/web_root app_root", "delete /pool/app_root"}; !reflect.DeepEqual(volRepo.calls, want) {
+		t.Errorf("Rename() volume calls = %v, want %v", volRepo.calls, want)
+	}
+}
+
+func TestVirtualMachineManagerRenameInvalidName(t *testing.T) {
+	for _, newId := range []string{"", "web", "app/../etc", "-app", "app name"} {
+		vmRepo := &fakeRenameVirtualMachineRepository{vm: &VirtualMachine{Id: "web", NodeId: "node1", State: StateStopped}}
+		volRepo := &fakeRenameVolumeRepository{}
+		if err := newTestRenameManager(vmRepo, volRepo).Rename("web", "node1", newId, true); err == nil {
+			t.Errorf("Rename(%q) error = nil, want invalid name error", newId)
+		}
+		if len(vmRepo.calls) != 0 || len(volRepo.calls) != 0 {
+			t.Errorf("Rename(%q) calls = %v %v, want none", newId, vmRepo.calls, volRepo.calls)
+		}
+	}
+}
+
+func TestNewConfigDriveDataKeepsInstanceId(t *testing.T) {
+	config := &VirtualMachineConfig{Hostname: "app", InstanceId: "0b4e6a1c-instance"}
+	nocloud := newConfigDriveData(config, configdrive.FormatNoCloud)
+	if nocloud.InstanceId() != config.InstanceId || nocloud.Hostname() != "app" {
+		t.Errorf("newConfigDriveData(nocloud) = %s %s, want %s app", nocloud.InstanceId(), nocloud.Hostname(), config.InstanceId)
+	}
+	openstack := newConfigDriveData(config, configdrive.FormatOpenstack)
+	if openstack.InstanceId() != config.InstanceId {
+		t.Errorf("newConfigDriveData(openstack) instance id = %s, want %s", openstack.InstanceId(), config.InstanceId)
+	}
+	if generated := newConfigDriveData(&VirtualMachineConfig{Hostname: "app"}, configdrive.FormatNoCloud); generated.InstanceId() == "" {
+		t.Errorf("newConfigDriveData() instance id is empty, want generated one")
+	}
+}
+
+func TestVirtualMachineManagerPruneMigrations(t *testing.T) {
+	manager := NewVirtualMachineManager(nil, nil, nil, nil)
+	started := time.Now()
+	for i := 0; i < migrationHistorySize+5; i++ {
+		manager.migrations[fmt.Sprintf("node1/done%d", i)] = &VirtualMachineMigration{
+			Finished: started.Add(time.Duration(i) * time.Minute),
+			mu:       &sync.Mutex{},
+		}
+	}
+	manager.migrations["node1/running"] = &VirtualMachineMigration{mu: &sync.Mutex{}}
+	manager.pruneMigrations()
+	if len(manager.migrations) != migrationHistorySize+1 {
+		t.Errorf("pruneMigrations() left %d migrations, want %d", len(manager.migrations), migrationHistorySize+1)
+	}
+	if manager.Migration("running", "node1") == nil {
+		t.Errorf("pruneMigrations() removed running migration")
+	}
+	for i := 0; i < 5; i++ {
+		if manager.Migration(fmt.Sprintf("done%d", i), "node1") != nil {
+			t.Errorf("pruneMigrations() kept old migration done%d", i)
+		}
+	}
+}
+
+func testImportArchive(t *testing.T, disk VirtualMachineManifestDisk) *bytes.Buffer {
+	manifest, err := json.Marshal(&VirtualMachineManifest{Version: VirtualMachineManifestVersion, Id: "web", Disks: []VirtualMachineManifestDisk{disk}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := &bytes.Buffer{}
+	archive := tar.NewWriter(content)
+	for _, file := range []struct {
+		name    string
+		content []byte
+	}{{VirtualMachineManifestName, manifest}, {disk.File, []byte("disk")}} {
+		if err := archive.WriteHeader(&tar.Header{Name: file.name, Mode: 0644, Size: int64(len(file.content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := archive.Write(file.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestVirtualMachineManagerImportRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		info   string
+	}{
+		{"Vmdk", "vmdk", `{"format": "vmdk"}`},
+		{"BackingFile", "qcow2", `{"format": "qcow2", "backing-filename": "/etc/shadow"}`},
+		{"DataFile", "qcow2", `{"format": "qcow2", "format-specific": {"type": "qcow2", "data": {"data-file": "/dev/sda"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, calls := setupFakeQemuImg(t, tt.info)
+			repo := &fakeImportVolumeRepository{uploaded: map[string]string{}}
+			manager := NewVirtualMachineManager(nil, NewVolumeService(repo), nil, nil)
+			content := testImportArchive(t, VirtualMachineManifestDisk{File: "disks/00_web_root", Name: "web_root", Format: tt.format, Size: 4})
+			if _, err := manager.Import(content, VirtualMachineImportOptions{NodeId: "node1", Pool: "default"}); err == nil {
+				t.Fatalf("Import() error = nil, want rejected disk")
+			}
+			if len(repo.created) != 0 {
+				t.Errorf("Import() created volumes for rejected disk: %+v", repo.created)
+			}
+			if tt.format == "qcow2" {
+				if got := calls(); len(got) != 1 || !strings.HasPrefix(got[0], "info -f qcow2 --output=json ") {
+					t.Errorf("Import() qemu-img calls = %q, want info with explicit format", got)
+				}
+			}
+		})
+	}
+}
+
+type fakeExportVolumeRepository struct {
+	VolumeRepository
+	volumes map[string]*Volume
+	content map[string]string
+}
+
+func (repo *fakeExportVolumeRepository) Get(path, node string) (*Volume, error) {
+	return repo.volumes[path], nil
+}
+
+func (repo *fakeExportVolumeRepository) Download(path, nodeId string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(repo.content[path])), nil
+}
+
+func testExport(t *testing.T, volume *Volume, content string) (*bytes.Buffer, error) {
+	vmRepo := &fakeRenameVirtualMachineRepository{vm: &VirtualMachine{
+		Id:     "web",
+		NodeId: "node1",
+		State:  StateStopped,
+		Memory: NewSize(1, SizeUnitG),
+		Volumes: []*VirtualMachineAttachedVolume{{
+			Path:       volume.Path,
+			DeviceType: DeviceTypeDisk,
+			BootOrder:  1,
+			IoTune:     VirtualMachineVolumeIoTune{TotalIopsSec: 500},
+			Driver:     VirtualMachineVolumeDriver{Cache: "none", Io: "native"},
+		}},
+	}}
+	volRepo := &fakeExportVolumeRepository{
+		volumes: map[string]*Volume{volume.Path: volume},
+		content: map[string]string{volume.Path: content},
+	}
+	manager := NewVirtualMachineManager(NewVirtualMachineService(vmRepo), NewVolumeService(volRepo), nil, nil)
+	output := &bytes.Buffer{}
+	return output, manager.Export("web", "node1", output)
+}
+
+func TestVirtualMachineManagerExport(t *testing.T) {
+	volume := &Volume{Path: "/pool/web_root", Name: "web_root", NodeId: "node1", Format: VolumeFormatQcow2, Size: NewSize(1, SizeUnitG), PhysicalSize: NewSize(4, SizeUnitB)}
+	output, err := testExport(t, volume, "disk")
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	archive := tar.NewReader(output)
+	files := map[string]string{}
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("cannot read exported archive: %s", err)
+		}
+		content, err := ioutil.ReadAll(archive)
+		if err != nil {
+			t.Fatalf("cannot read exported file %s: %s", header.Name, err)
+		}
+		files[header.Name] = string(content)
+	}
+	if files["disks/00_web_root"] != "disk" {
+		t.Errorf("Export() archive files = %q, want disk content", files)
+	}
+	manifest := &VirtualMachineManifest{}
+	if err := json.Unmarshal([]byte(files[VirtualMachineManifestName]), manifest); err != nil {
+		t.Fatalf("cannot parse exported manifest: %s", err)
+	}
+	want := VirtualMachineManifestDisk{
+		File:       "disks/00_web_root",
+		Name:       "web_root",
+		Format:     "qcow2",
+		Size:       volume.Size.Bytes(),
+		DeviceType: DeviceTypeDisk.String(),
+		DeviceBus:  DeviceBusUnknown.String(),
+		BootOrder:  1,
+		IoTune:     VirtualMachineVolumeIoTune{TotalIopsSec: 500},
+		Driver:     VirtualMachineVolumeDriver{Cache: "none", Io: "native"},
+	}
+	if len(manifest.Disks) != 1 || !reflect.DeepEqual(manifest.Disks[0], want) {
+		t.Errorf("Export() manifest disks = %+v, want %+v", manifest.Disks, want)
+	}
+}
+
+func TestVirtualMachineManagerExportSizeMismatch(t *testing.T) {
+	tests := []struct {
+		name         string
+		physicalSize Size
+		content      string
+	}{
+		{"Unknown", NewSize(0, SizeUnitB), "disk"},
+		{"Shorter", NewSize(8, SizeUnitB), "disk"},
+		{"Longer", NewSize(2, SizeUnitB), "disk"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			volume := &Volume{Path: "/pool/web_root", Name: "web_root", NodeId: "node1", Format: VolumeFormatQcow2, Size: NewSize(1, SizeUnitG), PhysicalSize: tt.physicalSize}
+			if _, err := testExport(t, volume, tt.content); err == nil {
+				t.Errorf("Export() error = nil, want size mismatch")
+			}
+		})
+	}
+}
+
+func TestVirtualMachineManagerPrepareExportUnknownSize(t *testing.T) {
+	volume := &Volume{Path: "/pool/web_root", Name: "web_root", NodeId: "node1", Format: VolumeFormatQcow2, Size: NewSize(1, SizeUnitG), PhysicalSize: NewSize(0, SizeUnitB)}
+	vmRepo := &fakeRenameVirtualMachineRepository{vm: &VirtualMachine{
+		Id:      "web",
+		NodeId:  "node1",
+		State:   StateStopped,
+		Memory:  NewSize(1, SizeUnitG),
+		Volumes: []*VirtualMachineAttachedVolume{{Path: volume.Path, DeviceType: DeviceTypeDisk}},
+	}}
+	volRepo := &fakeExportVolumeRepository{volumes: map[string]*Volume{volume.Path: volume}}
+	manager := NewVirtualMachineManager(NewVirtualMachineService(vmRepo), NewVolumeService(volRepo), nil, nil)
+	if _, err := manager.PrepareExport("web", "node1"); err == nil {
+		t.Errorf("PrepareExport() error = nil, want unknown size error")
+	}
+}
+
+type fakeCopyVolumeRepository struct {
+	VolumeRepository
+	content map[string]string
+	created []VolumeCreateParams
+	lengths []uint64
+}
+
+func (repo *fakeCopyVolumeRepository) Create(params VolumeCreateParams) (*Volume, error) {
+	repo.created = append(repo.created, params)
+	path := "/" + params.Pool + "/" + params.Name
+	// Preallocated target is longer than copied content
+	repo.content[path] = strings.Repeat("x", int(params.Size.Bytes())+8)
+	return &Volume{Path: path, Name: params.Name, NodeId: params.NodeId}, nil
+}
+
+func (repo *fakeCopyVolumeRepository) Upload(path, nodeId string, content io.Reader, size uint64) error {
+	repo.lengths = append(repo.lengths, size)
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	repo.content[path] = string(data) + repo.content[path][len(data):]
+	return nil
+}
+
+func (repo *fakeCopyVolumeRepository) Download(path, nodeId string) (io.ReadCloser, error) {
+	return ioutil.NopCloser(strings.NewReader(repo.content[path])), nil
+}
+
+func TestVirtualMachineManagerCopyVolume(t *testing.T) {
+	source := &Volume{Path: "/pool/web_root", Name: "web_root", NodeId: "node1", Format: VolumeFormatQcow2, Size: NewSize(1, SizeUnitG), PhysicalSize: NewSize(4, SizeUnitB)}
+	volRepo := &fakeCopyVolumeRepository{content: map[string]string{source.Path: "disk"}}
+	manager := NewVirtualMachineManager(nil, NewVolumeService(volRepo), nil, nil)
+	migration := &VirtualMachineMigration{mu: &sync.Mutex{}}
+	migration.setProgress(VirtualMachineMigrationProgress{DataTotal: 4, DataRemaining: 4})
+	if _, err := manager.copyVolume(source, "node2", "pool2", migration); err != nil {
+		t.Fatalf("copyVolume() error = %v", err)
+	}
+	if len(volRepo.created) != 1 || volRepo.created[0].Size.Bytes() != 4 || volRepo.created[0].Format != VolumeFormatQcow2 {
+		t.Errorf("copyVolume() created = %+v, want qcow2 volume of physical size", volRepo.created)
+	}
+	if want := []uint64{4}; !reflect.DeepEqual(volRepo.lengths, want) {
+		t.Errorf("copyVolume() upload lengths = %v, want %v", volRepo.lengths, want)
+	}
+	if percent := migration.Snapshot().Progress.Percent(); percent != 100 {
+		t.Errorf("copyVolume() progress = %d%%, want 100%%", percent)
+	}
+}
@@ -0,0 +1,58 @@
+package compute
+
+import (
+	"fmt"
+	"subuk/vmango/util"
+	"time"
+)
+
+// MinMemoryTarget is the lowest allowed balloon target, guest is unlikely to survive less
+const MinMemoryTarget = 128 * 1024 * 1024
+
+// VirtualMachineMemoryStats is memory usage of running machine reported by balloon
+// driver, all sizes are in bytes and zero means that value is not reported
+type VirtualMachineMemoryStats struct {
+	Actual     uint64    // Current balloon target
+	Unused     uint64    // Memory completely unused by guest
+	Available  uint64    // Memory seen by guest
+	Usable     uint64    // Memory guest can use without swapping
+	Rss        uint64    // Resident memory of qemu process on host
+	SwapIn     uint64    // Memory swapped in by guest
+	SwapOut    uint64    // Memory swapped out by guest
+	LastUpdate time.Time // Last time guest reported stats
+}
+
+// GuestReported returns true if guest balloon driver reports its memory usage
+func (stats *VirtualMachineMemoryStats) GuestReported() bool {
+	return stats.Available > 0
+}
+
+// Used returns memory used by guest including caches
+func (stats *VirtualMachineMemoryStats) Used() uint64 {
+	if stats.Unused > stats.Available {
+		return 0
+	}
+	return stats.Available - stats.Unused
+}
+
+// SetMemoryTarget inflates or deflates balloon of running machine, target cannot
+// exceed machine memory. Target is not persistent, full memory is used after restart.
+func (service *VirtualMachineService) SetMemoryTarget(id, node string, target uint64) error {
+	vm, err := service.VirtualMachineRepository.Get(id, node)
+	if err != nil {
+		return util.NewError(err, "cannot get machine")
+	}
+	if !vm.IsRunning() {
+		return fmt.Errorf("machine must be running")
+	}
+	if vm.Hugepages {
+		return fmt.Errorf("memory backed by hugepages cannot be reclaimed with balloon")
+	}
+	if target < MinMemoryTarget {
+		return fmt.Errorf("memory target cannot be less than %d MiB", MinMemoryTarget/1024/1024)
+	}
+	if target > vm.Memory.Bytes() {
+		return fmt.Errorf("memory target cannot exceed machine memory %d MiB", vm.Memory.Bytes()/1024/1024)
+	}
+	return service.VirtualMachineRepository.SetMemoryTarget(id, node, NewSize(target, SizeUnitB))
+}
@@ -0,0 +1,91 @@
+package compute
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// migrationHistorySize is how many finished migrations are kept for status page
+const migrationHistorySize = 16
+
+type VirtualMachineMigrateOptions struct {
+	CopyStorage bool
+	Bandwidth   uint64 // MiB/s, zero means unlimited
+	Offline     bool   // Stopped vm, volumes are copied to TargetPool
+	TargetPool  string
+}
+
+type VirtualMachineMigrationProgress struct {
+	DataTotal     uint64
+	DataProcessed uint64
+	DataRemaining uint64
+}
+
+func (p VirtualMachineMigrationProgress) Percent() int {
+	if p.DataTotal == 0 {
+		return 0
+	}
+	if p.DataProcessed >= p.DataTotal {
+		return 100
+	}
+	return int(100 * p.DataProcessed / p.DataTotal)
+}
+
+type VirtualMachineMigration struct {
+	VmId         string
+	SourceNodeId string
+	TargetNodeId string
+	Options      VirtualMachineMigrateOptions
+	Started      time.Time
+	Finished     time.Time
+	Progress     VirtualMachineMigrationProgress
+	Error        error
+	mu           *sync.Mutex
+}
+
+func (m *VirtualMachineMigration) setProgress(progress VirtualMachineMigrationProgress) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Progress = progress
+}
+
+func (m *VirtualMachineMigration) addProcessed(n uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Progress.DataProcessed += n
+	if m.Progress.DataTotal > m.Progress.DataProcessed {
+		m.Progress.DataRemaining = m.Progress.DataTotal - m.Progress.DataProcessed
+	} else {
+		m.Progress.DataRemaining = 0
+	}
+}
+
+func (m *VirtualMachineMigration) finish(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Finished = time.Now()
+	m.Error = err
+}
+
+// Snapshot returns copy of migration state safe to use while migration is running
+func (m *VirtualMachineMigration) Snapshot() VirtualMachineMigration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m
+}
+
+func (m VirtualMachineMigration) IsFinished() bool {
+	return !m.Finished.IsZero()
+}
+
+type migrationProgressReader struct {
+	reader    io.Reader
+	migration *VirtualMachineMigration
+}
+
+func (r *migrationProgressReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.migration.addProcessed(uint64(n))
+	return n, err
+}
@@ -0,0 +1,204 @@
+package compute
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	HugepageSize2M = "2M"
+	HugepageSize1G = "1G"
+)
+
+var HugepageSizes = []string{HugepageSize2M, HugepageSize1G}
+
+// HugepageSizeBytes returns page size in bytes, empty size means
+// host default which is 2M on supported architectures
+func HugepageSizeBytes(size string) (uint64, error) {
+	switch size {
+	default:
+		return 0, fmt.Errorf("unknown hugepage size %s", size)
+	case "", HugepageSize2M:
+		return 2 * 1024 * 1024, nil
+	case HugepageSize1G:
+		return 1024 * 1024 * 1024, nil
+	}
+}
+
+const (
+	NumaModeStrict      = "strict"
+	NumaModePreferred   = "preferred"
+	NumaModeInterleave  = "interleave"
+	NumaModeRestrictive = "restrictive"
+)
+
+var NumaModes = []string{NumaModeStrict, NumaModePreferred, NumaModeInterleave, NumaModeRestrictive}
+
+type VirtualMachineNumaCell struct {
+	Cpus   []uint
+	Memory Size
+}
+
+// VirtualMachineNuma describes host memory placement with numatune mode
+// and nodeset, and guest numa cells. Empty mode leaves placement to host kernel,
+// no cells means guest has single numa cell.
+type VirtualMachineNuma struct {
+	Mode    string
+	Nodeset []uint
+	Cells   []VirtualMachineNumaCell
+}
+
+func (numa VirtualMachineNuma) Validate(vcpus int, memory Size) error {
+	switch numa.Mode {
+	default:
+		return fmt.Errorf("unknown numa memory mode %s", numa.Mode)
+	case "":
+		if len(numa.Nodeset) > 0 {
+			return fmt.Errorf("numa memory mode required for nodeset")
+		}
+	case NumaModeStrict, NumaModePreferred, NumaModeInterleave, NumaModeRestrictive:
+		if len(numa.Nodeset) == 0 {
+			return fmt.Errorf("numa nodeset required for %s memory mode", numa.Mode)
+		}
+		if numa.Mode == NumaModePreferred && len(numa.Nodeset) > 1 {
+			return fmt.Errorf("%s memory mode accepts single numa node", NumaModePreferred)
+		}
+	}
+	if len(numa.Cells) == 0 {
+		return nil
+	}
+	seen := map[uint]bool{}
+	total := uint64(0)
+	for idx, cell := range numa.Cells {
+		if len(cell.Cpus) == 0 {
+			return fmt.Errorf("guest numa cell %d has no cpus", idx)
+		}
+		if cell.Memory.Bytes() == 0 {
+			return fmt.Errorf("guest numa cell %d has no memory", idx)
+		}
+		for _, cpu := range cell.Cpus {
+			if int(cpu) >= vcpus {
+				return fmt.Errorf("guest numa cell %d: vcpu %d doesn't exist, machine has %d vcpus", idx, cpu, vcpus)
+			}
+			if seen[cpu] {
+				return fmt.Errorf("vcpu %d is assigned to multiple guest numa cells", cpu)
+			}
+			seen[cpu] = true
+		}
+		total += cell.Memory.Bytes()
+	}
+	if len(seen) != vcpus {
+		return fmt.Errorf("guest numa cells have %d vcpus, machine has %d", len(seen), vcpus)
+	}
+	if total != memory.Bytes() {
+		return fmt.Errorf("guest numa cells memory %d MiB doesn't match machine memory %d MiB", total/1024/1024, memory.M())
+	}
+	return nil
+}
+
+// ParseNumaCells parses guest numa cells separated by spaces or semicolons,
+// each cell is a vcpu set and memory in MiB, e.g. "0-3:4096 4-7:4096"
+func ParseNumaCells(value string) ([]VirtualMachineNumaCell, error) {
+	cells := []VirtualMachineNumaCell{}
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == ' ' }) {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid numa cell %q, expected cpus:memory", item)
+		}
+		cpus, err := ParseCpuSet(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		memory, err := strconv.ParseUint(parts[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory %q in numa cell %q", parts[1], item)
+		}
+		cells = append(cells, VirtualMachineNumaCell{Cpus: cpus, Memory: NewSize(memory, SizeUnitM)})
+	}
+	return cells, nil
+}
+
+// CellsString formats cells in the form accepted by ParseNumaCells
+func (numa VirtualMachineNuma) CellsString() string {
+	items := []string{}
+	for _, cell := range numa.Cells {
+		items = append(items, fmt.Sprintf("%s:%d", FormatCpuSet(cell.Cpus), cell.Memory.M()))
+	}
+	return strings.Join(items, " ")
+}
+
+// ValidateMemory checks hugepage size, memory hotplug and numa settings of the machine.
+// Guest numa cells must cover all vcpus including hot-pluggable and boot memory.
+func (vm *VirtualMachine) ValidateMemory() error {
+	if vm.Hugepages {
+		pageSize, err := HugepageSizeBytes(vm.HugepageSize)
+		if err != nil {
+			return err
+		}
+		if vm.Memory.Bytes()%pageSize != 0 {
+			return fmt.Errorf("memory %d MiB is not a multiple of %d MiB hugepage size", vm.Memory.M(), pageSize/1024/1024)
+		}
+	}
+	if vm.MemoryHotplug() {
+		if vm.MaxMemory.Unit == SizeUnitUnknown || vm.MaxMemory.Bytes() < vm.Memory.Bytes() {
+			return fmt.Errorf("max memory must be at least %d MiB", vm.Memory.M())
+		}
+		if vm.MemorySlots > 255 {
+			return fmt.Errorf("too many memory slots %d, maximum is 255", vm.MemorySlots)
+		}
+	}
+	if vm.DimmMemory.Unit != SizeUnitUnknown && vm.DimmMemory.Bytes() > 0 {
+		if vm.DimmMemory.Bytes() >= vm.Memory.Bytes() {
+			return fmt.Errorf("memory cannot be reduced below %d MiB of hot-plugged dimms", vm.DimmMemory.M())
+		}
+		if !vm.MemoryHotplug() {
+			return fmt.Errorf("memory slots required, machine has %d MiB of hot-plugged dimms", vm.DimmMemory.M())
+		}
+	}
+	return vm.Numa.Validate(vm.MaxVCpuCount(), vm.BaseMemory())
+}
+
+// CheckHugepages verifies node has enough free hugepages for the machine.
+// Strict and restrictive modes allocate pages only in nodeset cells, so only they
+// are counted. Pages used by the running machine itself are not free, so running
+// machines shouldn't be checked.
+func CheckHugepages(node *Node, vm *VirtualMachine) error {
+	if !vm.Hugepages {
+		return nil
+	}
+	if err := vm.ValidateMemory(); err != nil {
+		return err
+	}
+	pageSize, _ := HugepageSizeBytes(vm.HugepageSize)
+	needed := vm.Memory.Bytes() / pageSize
+
+	cells := []uint{}
+	if vm.Numa.Mode == NumaModeStrict || vm.Numa.Mode == NumaModeRestrictive {
+		cells = vm.Numa.Nodeset
+	} else {
+		for numaId := range node.Numas {
+			cells = append(cells, uint(numaId))
+		}
+	}
+	free := uint64(0)
+	details := []string{}
+	for _, numaId := range cells {
+		if int(numaId) >= len(node.Numas) {
+			return fmt.Errorf("numa node %d doesn't exist on %s", numaId, node.Id)
+		}
+		cellFree := node.Numas[numaId].Pages2mFree
+		if pageSize == 1024*1024*1024 {
+			cellFree = node.Numas[numaId].Pages1gFree
+		}
+		free += cellFree
+		details = append(details, fmt.Sprintf("cell %d: %d", numaId, cellFree))
+	}
+	if free < needed {
+		return fmt.Errorf(
+			"not enough free %d MiB hugepages on %s: need %d, free %d (%s)",
+			pageSize/1024/1024, node.Id, needed, free, strings.Join(details, ", "),
+		)
+	}
+	return nil
+}
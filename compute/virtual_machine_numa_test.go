@@ -0,0 +1,52 @@
+package compute
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVirtualMachineNumaValidate(t *testing.T) {
+	memory := NewSize(8192, SizeUnitM)
+	cells := []VirtualMachineNumaCell{
+		{Cpus: []uint{0, 1}, Memory: NewSize(4096, SizeUnitM)},
+		{Cpus: []uint{2, 3}, Memory: NewSize(4096, SizeUnitM)},
+	}
+	tests := []struct {
+		name    string
+		numa    VirtualMachineNuma
+		wantErr bool
+	}{
+		{"Default", VirtualMachineNuma{}, false},
+		{"Strict", VirtualMachineNuma{Mode: NumaModeStrict, Nodeset: []uint{1}, Cells: cells}, false},
+		{"NoNodeset", VirtualMachineNuma{Mode: NumaModeStrict}, true},
+		{"NodesetWithoutMode", VirtualMachineNuma{Nodeset: []uint{0}}, true},
+		{"PreferredMultiple", VirtualMachineNuma{Mode: NumaModePreferred, Nodeset: []uint{0, 1}}, true},
+		{"MissingCpu", VirtualMachineNuma{Cells: cells[:1]}, true},
+		{"MemoryMismatch", VirtualMachineNuma{Cells: []VirtualMachineNumaCell{{Cpus: []uint{0, 1, 2, 3}, Memory: NewSize(4096, SizeUnitM)}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.numa.Validate(4, memory); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckHugepages(t *testing.T) {
+	node := &Node{Id: "node1", Numas: []NodeNuma{{Pages1gFree: 2}, {Pages1gFree: 8}}}
+	vm := &VirtualMachine{VCpus: 2, Memory: NewSize(4, SizeUnitG), Hugepages: true, HugepageSize: HugepageSize1G}
+	if err := CheckHugepages(node, vm); err != nil {
+		t.Errorf("CheckHugepages() unexpected error %s", err)
+	}
+	vm.Numa = VirtualMachineNuma{Mode: NumaModeStrict, Nodeset: []uint{0}}
+	err := CheckHugepages(node, vm)
+	if err == nil || !strings.Contains(err.Error(), "need 4, free 2 (cell 0: 2)") {
+		t.Errorf("CheckHugepages() error = %v, want cell 0 shortage", err)
+	}
+	vm.Numa = VirtualMachineNuma{}
+	vm.Memory = NewSize(1536, SizeUnitM)
+	if err := CheckHugepages(node, vm); err == nil {
+		t.Error("CheckHugepages() expected error for memory not multiple of page size")
+	}
+}
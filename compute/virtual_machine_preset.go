@@ -0,0 +1,51 @@
+package compute
+
+import "errors"
+
+var ErrVirtualMachinePresetNotFound = errors.New("preset not found")
+var ErrVirtualMachinePresetAlreadyExists = errors.New("preset already exists")
+
+type VirtualMachinePresetInterface struct {
+	NetworkName string
+	Model       string
+	AccessVlan  uint
+}
+
+// VirtualMachinePreset is a saved set of machine creation parameters.
+// Machine created from the preset gets its root volume cloned from Image,
+// so only a name is required to create one.
+type VirtualMachinePreset struct {
+	Name        string
+	NodeId      string
+	Arch        Arch
+	Image       string
+	RootPool    string
+	RootFormat  VolumeFormat
+	RootSize    Size
+	Flavor      string
+	VCpus       int
+	Memory      Size
+	Interfaces  []VirtualMachinePresetInterface
+	Keys        []string
+	Userdata    string
+	GraphicType GraphicType
+	VideoModel  VideoModel
+	GuestAgent  bool
+	Hugepages   bool
+	Start       bool
+}
+
+type VirtualMachinePresetRepository interface {
+	List() ([]*VirtualMachinePreset, error)
+	Get(name string) (*VirtualMachinePreset, error)
+	Add(preset *VirtualMachinePreset) error
+	Delete(name string) error
+}
+
+type VirtualMachinePresetService struct {
+	VirtualMachinePresetRepository
+}
+
+func NewVirtualMachinePresetService(repo VirtualMachinePresetRepository) *VirtualMachinePresetService {
+	return &VirtualMachinePresetService{repo}
+}
@@ -1,6 +1,9 @@
 package compute
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 type VirtualMachineListOptions struct {
 	NodeIds []string
@@ -11,15 +14,33 @@ type VirtualMachineRepository interface {
 	Get(id, node string) (*VirtualMachine, error)
 	Save(vm *VirtualMachine) error
 	Delete(id, node string) error
+	Rename(id, node, newId string) error
+	Migrate(id, node, targetNode string, options VirtualMachineMigrateOptions, progress func(VirtualMachineMigrationProgress)) error
+	CopyDefinition(id, node, targetNode string, volumePaths map[string]string) error
 	AttachVolume(id, nodeId string, attachedVolume *VirtualMachineAttachedVolume) error
 	DetachVolume(machineId, node, attachmentDeviceName string) error
+	ReplaceVolume(machineId, node, oldPath, newPath string) error
+	SetVolumeIoTune(id, node, path string, iotune VirtualMachineVolumeIoTune) error
 	AttachInterface(id, node string, iface *VirtualMachineAttachedInterface) error
 	DetachInterface(id, node, mac string) error
+	SetInterfaceBandwidth(id, node, mac string, bandwidth VirtualMachineInterfaceBandwidth) error
+	AttachHostDevice(id, node string, device *VirtualMachineAttachedHostDevice) error
+	DetachHostDevice(id, node string, device *VirtualMachineAttachedHostDevice) error
 	GetConsoleStream(id, node string) (VirtualMachineConsoleStream, error)
 	GetGraphicStream(id, node string) (VirtualMachineGraphicStream, error)
 	Poweroff(id, node string) error
+	Shutdown(id, node string) error
+	CreateSnapshot(id, node, name string) error
+	SetExpiry(id, node string, expiresAt time.Time) error
+	SetBoot(id, node string, boot VirtualMachineBoot) error
+	SetCpuPin(id, node string, pin *VirtualMachineCpuPin) error
+	SetVcpus(id, node string, vcpus int) error
+	AttachMemory(id, node string, size Size) error
+	GetMemoryStats(id, node string) (*VirtualMachineMemoryStats, error)
+	SetMemoryTarget(id, node string, target Size) error
 	Reboot(id, node string) error
 	Start(id, node string) error
+	ResetNvram(id, node string) error
 }
 
 type VirtualMachineService struct {
@@ -30,6 +51,23 @@ func NewVirtualMachineService(repo VirtualMachineRepository) *VirtualMachineServ
 	return &VirtualMachineService{repo}
 }
 
+func (service *VirtualMachineService) Save(vm *VirtualMachine) error {
+	if err := vm.ValidateMemory(); err != nil {
+		return err
+	}
+	if err := vm.ValidateDevices(); err != nil {
+		return err
+	}
+	return service.VirtualMachineRepository.Save(vm)
+}
+
+func (service *VirtualMachineService) SetBoot(id, node string, boot VirtualMachineBoot) error {
+	if err := boot.Validate(); err != nil {
+		return err
+	}
+	return service.VirtualMachineRepository.SetBoot(id, node, boot)
+}
+
 func (service *VirtualMachineService) Action(id string, node, action string) error {
 	switch action {
 	default:
@@ -38,7 +76,13 @@ func (service *VirtualMachineService) Action(id string, node, action string) err
 		return service.VirtualMachineRepository.Reboot(id, node)
 	case "poweroff":
 		return service.VirtualMachineRepository.Poweroff(id, node)
+	case "shutdown":
+		return service.VirtualMachineRepository.Shutdown(id, node)
+	case "snapshot":
+		return service.VirtualMachineRepository.CreateSnapshot(id, node, "vmango-"+time.Now().Format("20060102-150405"))
 	case "start":
 		return service.VirtualMachineRepository.Start(id, node)
+	case "reset-nvram":
+		return service.VirtualMachineRepository.ResetNvram(id, node)
 	}
 }
@@ -0,0 +1,93 @@
+package compute
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	VolumeDriverCacheModes        = []string{"none", "writeback", "directsync"}
+	VolumeDriverIoModes           = []string{"native", "threads", "io_uring"}
+	VolumeDriverDiscardModes      = []string{"unmap", "ignore"}
+	VolumeDriverDetectZeroesModes = []string{"off", "on", "unmap"}
+)
+
+// VirtualMachineVolumeDriver sets disk cache and io modes of qemu driver.
+// Empty value means node default or hypervisor default if node has none.
+type VirtualMachineVolumeDriver struct {
+	Cache        string
+	Io           string
+	Discard      string
+	DetectZeroes string
+}
+
+func (driver VirtualMachineVolumeDriver) Empty() bool {
+	return driver == VirtualMachineVolumeDriver{}
+}
+
+// WithDefaults fills empty values from defaults
+func (driver VirtualMachineVolumeDriver) WithDefaults(defaults VirtualMachineVolumeDriver) VirtualMachineVolumeDriver {
+	if driver.Cache == "" {
+		driver.Cache = defaults.Cache
+	}
+	if driver.Io == "" {
+		driver.Io = defaults.Io
+	}
+	if driver.Discard == "" {
+		driver.Discard = defaults.Discard
+	}
+	if driver.DetectZeroes == "" {
+		driver.DetectZeroes = defaults.DetectZeroes
+	}
+	return driver
+}
+
+func (driver VirtualMachineVolumeDriver) Validate() error {
+	for _, option := range []struct {
+		name, value string
+		allowed     []string
+	}{
+		{"cache", driver.Cache, VolumeDriverCacheModes},
+		{"io", driver.Io, VolumeDriverIoModes},
+		{"discard", driver.Discard, VolumeDriverDiscardModes},
+		{"detect_zeroes", driver.DetectZeroes, VolumeDriverDetectZeroesModes},
+	} {
+		if option.value == "" {
+			continue
+		}
+		known := false
+		for _, allowed := range option.allowed {
+			if option.value == allowed {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown disk %s mode '%s', allowed: %s", option.name, option.value, strings.Join(option.allowed, ", "))
+		}
+	}
+	if driver.DetectZeroes == "unmap" && driver.Discard != "unmap" {
+		return fmt.Errorf("detect_zeroes unmap requires discard unmap")
+	}
+	// Native aio needs O_DIRECT, qemu refuses to start otherwise
+	if driver.Io == "native" && driver.Cache != "none" && driver.Cache != "directsync" {
+		return fmt.Errorf("io native requires cache none or directsync")
+	}
+	return nil
+}
+
+// String returns short human readable description of driver modes
+func (driver VirtualMachineVolumeDriver) String() string {
+	items := []string{}
+	for _, item := range []struct{ name, value string }{
+		{"cache", driver.Cache},
+		{"io", driver.Io},
+		{"discard", driver.Discard},
+		{"detect_zeroes", driver.DetectZeroes},
+	} {
+		if item.value != "" {
+			items = append(items, item.name+" "+item.value)
+		}
+	}
+	return strings.Join(items, ", ")
+}
@@ -0,0 +1,34 @@
+package compute
+
+import "testing"
+
+func TestVirtualMachineVolumeDriverValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		driver  VirtualMachineVolumeDriver
+		wantErr bool
+	}{
+		{"Empty", VirtualMachineVolumeDriver{}, false},
+		{"NativeDirect", VirtualMachineVolumeDriver{Cache: "none", Io: "native", Discard: "unmap", DetectZeroes: "unmap"}, false},
+		{"UnknownCache", VirtualMachineVolumeDriver{Cache: "unsafe"}, true},
+		{"NativeWriteback", VirtualMachineVolumeDriver{Cache: "writeback", Io: "native"}, true},
+		{"NativeDefaultCache", VirtualMachineVolumeDriver{Io: "native"}, true},
+		{"DetectZeroesUnmapWithoutDiscard", VirtualMachineVolumeDriver{DetectZeroes: "unmap"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.driver.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVirtualMachineVolumeDriverWithDefaults(t *testing.T) {
+	defaults := VirtualMachineVolumeDriver{Cache: "none", Io: "native", Discard: "unmap"}
+	driver := VirtualMachineVolumeDriver{Io: "threads", DetectZeroes: "on"}.WithDefaults(defaults)
+	expected := VirtualMachineVolumeDriver{Cache: "none", Io: "threads", Discard: "unmap", DetectZeroes: "on"}
+	if driver != expected {
+		t.Errorf("WithDefaults() = %+v, want %+v", driver, expected)
+	}
+}
@@ -23,6 +23,10 @@ type Volume struct {
 	AttachedTo string
 	AttachedAs DeviceType
 	Metadata   VolumeMetadata
+
+	// PhysicalSize is length of volume data on node as it is downloaded,
+	// zero if libvirt doesn't report it
+	PhysicalSize Size
 }
 
 func (volume *Volume) Base() string {
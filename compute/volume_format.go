@@ -7,6 +7,9 @@ const (
 	VolumeFormatRaw     = VolumeFormat(1)
 	VolumeFormatQcow2   = VolumeFormat(2)
 	VolumeFormatIso     = VolumeFormat(3)
+	VolumeFormatVmdk    = VolumeFormat(4)
+	VolumeFormatVhdx    = VolumeFormat(5)
+	VolumeFormatVdi     = VolumeFormat(6)
 )
 
 func (format VolumeFormat) String() string {
@@ -19,6 +22,12 @@ func (format VolumeFormat) String() string {
 		return "qcow2"
 	case VolumeFormatIso:
 		return "iso"
+	case VolumeFormatVmdk:
+		return "vmdk"
+	case VolumeFormatVhdx:
+		return "vhdx"
+	case VolumeFormatVdi:
+		return "vdi"
 	}
 }
 
@@ -32,5 +41,11 @@ func NewVolumeFormat(input string) VolumeFormat {
 		return VolumeFormatQcow2
 	case "iso":
 		return VolumeFormatIso
+	case "vmdk":
+		return VolumeFormatVmdk
+	case "vhdx":
+		return VolumeFormatVhdx
+	case "vdi":
+		return VolumeFormatVdi
 	}
 }
@@ -0,0 +1,148 @@
+package compute
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"subuk/vmango/diskimage"
+	"subuk/vmango/util"
+)
+
+var volumeImportNameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+
+type VolumeImportParams struct {
+	NodeId       string
+	Name         string
+	Pool         string
+	Format       VolumeFormat
+	SourceFormat VolumeFormat
+}
+
+// VolumeImportedMachine contains volumes created from ova bundle
+// and machine configuration from its ovf descriptor
+type VolumeImportedMachine struct {
+	Name     string
+	VCpus    int
+	Memory   Size
+	Firmware string
+	Networks []string
+	Volumes  []*Volume
+}
+
+// Import converts local image file (vmdk, vhdx, vdi, qcow2 or raw) with qemu-img
+// and uploads the result to the new volume. Source format must be specified,
+// images referring to other files are rejected.
+func (service *VolumeService) Import(params VolumeImportParams, filename string) (*Volume, error) {
+	return service.importFile(params, filename, "")
+}
+
+// importFile imports image, vmdk extents of the image are allowed only inside dir
+func (service *VolumeService) importFile(params VolumeImportParams, filename, dir string) (*Volume, error) {
+	if params.Format != VolumeFormatQcow2 && params.Format != VolumeFormatRaw {
+		return nil, fmt.Errorf("volume can be imported only as qcow2 or raw, got %s", params.Format)
+	}
+	if params.SourceFormat == VolumeFormatUnknown {
+		return nil, fmt.Errorf("source image format must be specified")
+	}
+	sourceInfo, err := diskimage.GetInfo(filename, params.SourceFormat.String())
+	if err != nil {
+		return nil, util.NewError(err, "cannot get image info")
+	}
+	if err := sourceInfo.CheckExternalFiles(filename, dir); err != nil {
+		return nil, err
+	}
+	tmpdir, err := ioutil.TempDir("", "vmango-import")
+	if err != nil {
+		return nil, util.NewError(err, "cannot create temporary directory")
+	}
+	defer os.RemoveAll(tmpdir)
+	converted := filepath.Join(tmpdir, "converted")
+	if err := diskimage.Convert(filename, params.SourceFormat.String(), converted, params.Format.String()); err != nil {
+		return nil, util.NewError(err, "cannot convert image")
+	}
+	info, err := diskimage.GetInfo(converted, params.Format.String())
+	if err != nil {
+		return nil, util.NewError(err, "cannot get converted image info")
+	}
+	content, err := os.Open(converted)
+	if err != nil {
+		return nil, util.NewError(err, "cannot open converted image")
+	}
+	defer content.Close()
+	contentLen, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, util.NewError(err, "cannot get converted image length")
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, util.NewError(err, "converted image seek to start failed")
+	}
+	volume, err := service.Create(VolumeCreateParams{
+		NodeId: params.NodeId,
+		Name:   params.Name,
+		Pool:   params.Pool,
+		Format: params.Format,
+		Size:   NewSize(info.VirtualSize, SizeUnitB),
+	})
+	if err != nil {
+		return nil, util.NewError(err, "cannot create volume")
+	}
+	if err := service.Upload(volume.Path, volume.NodeId, content, uint64(contentLen)); err != nil {
+		service.Delete(volume.Path, volume.NodeId) // Ignore error
+		return nil, util.NewError(err, "cannot upload volume")
+	}
+	return volume, nil
+}
+
+// ImportOva imports all disks from ova bundle.
+// Volumes are named after the machine: <name>_root for the first disk, <name>_diskN for others.
+func (service *VolumeService) ImportOva(nodeId, pool string, format VolumeFormat, filename string) (*VolumeImportedMachine, error) {
+	tmpdir, err := ioutil.TempDir("", "vmango-import-ova")
+	if err != nil {
+		return nil, util.NewError(err, "cannot create temporary directory")
+	}
+	defer os.RemoveAll(tmpdir)
+	ovfPath, err := diskimage.ExtractOva(filename, tmpdir)
+	if err != nil {
+		return nil, util.NewError(err, "cannot extract ova")
+	}
+	ovfContent, err := ioutil.ReadFile(ovfPath)
+	if err != nil {
+		return nil, util.NewError(err, "cannot read ovf descriptor")
+	}
+	ovf, err := diskimage.ParseOvf(ovfContent)
+	if err != nil {
+		return nil, err
+	}
+	machine := &VolumeImportedMachine{
+		Name:     strings.Trim(volumeImportNameInvalidChars.ReplaceAllString(ovf.Name, "-"), "-"),
+		VCpus:    ovf.Cpus,
+		Memory:   NewSize(ovf.Memory, SizeUnitB),
+		Networks: ovf.Networks,
+	}
+	if machine.Name == "" {
+		machine.Name = strings.TrimSuffix(filepath.Base(ovfPath), filepath.Ext(ovfPath))
+	}
+	if ovf.Efi {
+		machine.Firmware = "efi"
+	}
+	for idx, disk := range ovf.Disks {
+		name := machine.Name + "_root"
+		if idx > 0 {
+			name = fmt.Sprintf("%s_disk%d", machine.Name, idx)
+		}
+		params := VolumeImportParams{NodeId: nodeId, Name: name, Pool: pool, Format: format, SourceFormat: NewVolumeFormat(disk.Format)}
+		volume, err := service.importFile(params, filepath.Join(tmpdir, filepath.Base(disk.File)), tmpdir)
+		if err != nil {
+			for _, created := range machine.Volumes {
+				service.Delete(created.Path, created.NodeId) // Ignore error
+			}
+			return nil, util.NewError(err, "cannot import disk %s", disk.File)
+		}
+		machine.Volumes = append(machine.Volumes, volume)
+	}
+	return machine, nil
+}
@@ -0,0 +1,131 @@
+package compute
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeQemuImg is installed as qemu-img, it logs arguments, prints info
+// from FAKE_QEMU_IMG_INFO file for source images and creates convert target
+const fakeQemuImg = `#!/bin/sh
+echo "$@" >> "$FAKE_QEMU_IMG_LOG"
+for last; do :; done
+case "$1" in
+info)
+	case "$last" in
+	*/converted) echo '{"format": "raw", "virtual-size": 1048576}' ;;
+	*) cat "$FAKE_QEMU_IMG_INFO" ;;
+	esac
+	;;
+convert)
+	echo converted > "$last"
+	;;
+esac
+`
+
+type fakeImportVolumeRepository struct {
+	VolumeRepository
+	created  []VolumeCreateParams
+	uploaded map[string]string
+}
+
+func (repo *fakeImportVolumeRepository) Create(params VolumeCreateParams) (*Volume, error) {
+	repo.created = append(repo.created, params)
+	return &Volume{Path: "/pool/" + params.Name, NodeId: params.NodeId, Format: params.Format, Size: params.Size}, nil
+}
+
+func (repo *fakeImportVolumeRepository) Upload(path, nodeId string, content io.Reader, size uint64) error {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return err
+	}
+	repo.uploaded[path] = string(data)
+	return nil
+}
+
+// setupFakeQemuImg returns image file and log of qemu-img calls
+func setupFakeQemuImg(t *testing.T, info string) (string, func() []string) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "qemu-img"), []byte(fakeQemuImg), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "info.json"), []byte(info), 0644); err != nil {
+		t.Fatal(err)
+	}
+	image := filepath.Join(dir, "upload")
+	if err := ioutil.WriteFile(image, []byte("image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logFilename := filepath.Join(dir, "calls.log")
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_QEMU_IMG_INFO", filepath.Join(dir, "info.json"))
+	t.Setenv("FAKE_QEMU_IMG_LOG", logFilename)
+	return image, func() []string {
+		content, _ := ioutil.ReadFile(logFilename)
+		calls := []string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			if line != "" {
+				calls = append(calls, line)
+			}
+		}
+		return calls
+	}
+}
+
+func TestVolumeServiceImport(t *testing.T) {
+	image, calls := setupFakeQemuImg(t, `{"format": "qcow2", "virtual-size": 1048576}`)
+	repo := &fakeImportVolumeRepository{uploaded: map[string]string{}}
+	service := NewVolumeService(repo)
+	params := VolumeImportParams{NodeId: "node1", Name: "disk", Pool: "default", Format: VolumeFormatRaw, SourceFormat: VolumeFormatQcow2}
+	volume, err := service.Import(params, image)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	if volume.Path != "/pool/disk" || repo.uploaded["/pool/disk"] != "converted\n" {
+		t.Errorf("Import() volume = %+v, uploaded %q", volume, repo.uploaded)
+	}
+	if len(repo.created) != 1 || repo.created[0].Size.Bytes() != 1048576 {
+		t.Errorf("Import() created volumes = %+v, want one of 1 MiB", repo.created)
+	}
+	got := calls()
+	if len(got) != 3 || got[0] != "info -f qcow2 --output=json "+image || !strings.HasPrefix(got[1], "convert -f qcow2 -O raw "+image+" ") {
+		t.Errorf("Import() qemu-img calls = %q, want info and convert with explicit source format", got)
+	}
+}
+
+func TestVolumeServiceImportRejected(t *testing.T) {
+	tests := []struct {
+		name         string
+		sourceFormat VolumeFormat
+		info         string
+	}{
+		{"NoSourceFormat", VolumeFormatUnknown, `{"format": "raw"}`},
+		{"BackingFile", VolumeFormatQcow2, `{"format": "qcow2", "virtual-size": 1048576, "backing-filename": "/etc/shadow"}`},
+		{"DataFile", VolumeFormatQcow2, `{"format": "qcow2", "virtual-size": 1048576, "format-specific": {"type": "qcow2", "data": {"data-file": "/dev/sda"}}}`},
+		{"VmdkParent", VolumeFormatVmdk, `{"format": "vmdk", "virtual-size": 1048576, "full-backing-filename": "/var/lib/libvirt/images/other.vmdk"}`},
+		{"VmdkExtent", VolumeFormatVmdk, `{"format": "vmdk", "virtual-size": 1048576, "format-specific": {"type": "vmdk", "data": {"extents": [{"filename": "/var/lib/libvirt/images/other.img"}]}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image, calls := setupFakeQemuImg(t, tt.info)
+			repo := &fakeImportVolumeRepository{uploaded: map[string]string{}}
+			service := NewVolumeService(repo)
+			params := VolumeImportParams{NodeId: "node1", Name: "disk", Pool: "default", Format: VolumeFormatQcow2, SourceFormat: tt.sourceFormat}
+			if _, err := service.Import(params, image); err == nil {
+				t.Fatalf("Import() error = nil, want rejected image")
+			}
+			for _, call := range calls() {
+				if strings.HasPrefix(call, "convert") {
+					t.Errorf("Import() converted rejected image: %s", call)
+				}
+			}
+			if len(repo.created) != 0 {
+				t.Errorf("Import() created volumes for rejected image: %+v", repo.created)
+			}
+		})
+	}
+}
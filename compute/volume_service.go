@@ -36,6 +36,7 @@ type VolumeRepository interface {
 	Resize(path, node string, newSize Size) error
 	Delete(path, node string) error
 	Upload(path, nodeId string, content io.Reader, size uint64) error
+	Download(path, nodeId string) (io.ReadCloser, error)
 	List(options VolumeListOptions) ([]*Volume, error)
 }
 
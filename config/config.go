@@ -15,6 +15,7 @@ type UserWebConfig struct {
 	FullName       string `hcl:"full_name"`
 	Email          string `hcl:"email"`
 	HashedPassword string `hcl:"hashed_password"`
+	Admin          bool   `hcl:"admin"`
 }
 
 type WebConfigLink struct {
@@ -30,22 +31,24 @@ type OidcConfig struct {
 	ClientSecret  string   `hcl:"client_secret"`
 	Scopes        []string `hcl:"scopes"`
 	AllowedEmails []string `hcl:"allowed_emails"`
+	AdminEmails   []string `hcl:"admin_emails"`
 }
 
 type WebConfig struct {
-	Listen         string          `hcl:"listen"`
-	Debug          bool            `hcl:"debug"`
-	BaseUrl        string          `hcl:"base_url"`
-	StaticVersion  string          `hcl:"static_version"`
-	SessionSecret  string          `hcl:"session_secret"`
-	SessionSecure  bool            `hcl:"session_secure"`
-	SessionDomain  string          `hcl:"session_domain"`
-	SessionMaxAge  int             `hcl:"session_max_age"`
-	MediaUploadTmp string          `hcl:"media_upload_tmp"`
-	Users          []UserWebConfig `hcl:"user"`
-	Oidc           OidcConfig      `hcl:"oidc"`
-	Links          []WebConfigLink `hcl:"link"`
-	LinksTitle     string          `hcl:"links_title"`
+	Listen            string          `hcl:"listen"`
+	Debug             bool            `hcl:"debug"`
+	BaseUrl           string          `hcl:"base_url"`
+	StaticVersion     string          `hcl:"static_version"`
+	SessionSecret     string          `hcl:"session_secret"`
+	SessionSecure     bool            `hcl:"session_secure"`
+	SessionDomain     string          `hcl:"session_domain"`
+	SessionMaxAge     int             `hcl:"session_max_age"`
+	MediaUploadTmp    string          `hcl:"media_upload_tmp"`
+	Users             []UserWebConfig `hcl:"user"`
+	Oidc              OidcConfig      `hcl:"oidc"`
+	Links             []WebConfigLink `hcl:"link"`
+	LinksTitle        string          `hcl:"links_title"`
+	RestrictToFlavors bool            `hcl:"restrict_to_flavors"`
 }
 
 type ImageConfig struct {
@@ -58,6 +61,16 @@ type ImageConfig struct {
 	Hidden    bool   `hcl:"hidden"`
 }
 
+type FlavorConfig struct {
+	Name         string   `hcl:",key"`
+	Vcpus        int      `hcl:"vcpus"`
+	MemoryMb     int      `hcl:"memory_mb"`
+	RootDiskGb   int      `hcl:"root_disk_gb"`
+	ExtraDisksGb []int    `hcl:"extra_disks_gb"`
+	Hugepages    bool     `hcl:"hugepages"`
+	Nodes        []string `hcl:"nodes"`
+}
+
 type SubscribeConfig struct {
 	Event     string `hcl:",key"`
 	Script    string `hcl:"script"`
@@ -72,17 +85,33 @@ type LibvirtConfig struct {
 	ConfigDriveWriteFormat string `hcl:"config_drive_write_format"`
 	Emulator               string `hcl:"emulator"`
 	QcowPreallocMetadata   bool   `hcl:"qcow_prealloc_metadata"`
-	Cache                  bool   `hcl:"cache"`
+	DiskCache              string `hcl:"disk_cache"`
+	DiskIo                 string `hcl:"disk_io"`
+	DiskDiscard            string `hcl:"disk_discard"`
+	DiskDetectZeroes       string `hcl:"disk_detect_zeroes"`
+	LegacyCache            bool   `hcl:"cache"`
+}
+
+type ExpiryConfig struct {
+	WarnHours     int  `hcl:"warn_hours"`
+	GraceHours    int  `hcl:"grace_hours"`
+	RenewDays     int  `hcl:"renew_days"`
+	DeleteVolumes bool `hcl:"delete_volumes"`
 }
 
 type Config struct {
-	LogLevel   string            `hcl:"log_level"`
-	Images     []ImageConfig     `hcl:"image"`
-	Bridges    []string          `hcl:"bridges"`
-	Libvirts   []LibvirtConfig   `hcl:"libvirt"`
-	KeyFile    string            `hcl:"key_file"`
-	Web        WebConfig         `hcl:"web"`
-	Subscribes []SubscribeConfig `hcl:"subscribe"`
+	LogLevel     string            `hcl:"log_level"`
+	Images       []ImageConfig     `hcl:"image"`
+	Bridges      []string          `hcl:"bridges"`
+	Libvirts     []LibvirtConfig   `hcl:"libvirt"`
+	KeyFile      string            `hcl:"key_file"`
+	FlavorFile   string            `hcl:"flavor_file"`
+	PresetFile   string            `hcl:"preset_file"`
+	ScheduleFile string            `hcl:"schedule_file"`
+	Flavors      []FlavorConfig    `hcl:"flavor"`
+	Web          WebConfig         `hcl:"web"`
+	Subscribes   []SubscribeConfig `hcl:"subscribe"`
+	Expiry       ExpiryConfig      `hcl:"expiry"`
 
 	LegacyLibvirtUri                    string   `hcl:"libvirt_uri"`
 	LegacyLibvirtConfigDriveSuffix      string   `hcl:"libvirt_config_drive_suffix"`
@@ -93,8 +122,16 @@ type Config struct {
 
 func Default() *Config {
 	return &Config{
-		LogLevel: "info",
-		KeyFile:  "~/.vmango/authorized_keys",
+		LogLevel:     "info",
+		KeyFile:      "~/.vmango/authorized_keys",
+		FlavorFile:   "~/.vmango/flavors.json",
+		PresetFile:   "~/.vmango/presets.json",
+		ScheduleFile: "~/.vmango/schedules.json",
+		Expiry: ExpiryConfig{
+			WarnHours:  24,
+			GraceHours: 72,
+			RenewDays:  7,
+		},
 		Web: WebConfig{
 			Listen:         ":8080",
 			Debug:          false,
@@ -104,6 +141,16 @@ func Default() *Config {
 	}
 }
 
+const OLD_CACHE_WARNING = `=======
+
+Option 'cache' of libvirt connection '%s' is deprecated and means writeback disk cache,
+it is ignored if disk_cache is set. Please replace it with:
+
+disk_cache = "writeback"
+
+=======
+`
+
 const OLD_BRIDGES_WARNING = `=======
 
 Please remove 'bridges' option from configuration file and create libvirt networks like this:
@@ -161,6 +208,22 @@ func Parse(filename string) (*Config, error) {
 		if libvirt.ConfigDrivePool == "" {
 			libvirt.ConfigDrivePool = "default"
 		}
+		if libvirt.LegacyCache {
+			fmt.Printf(OLD_CACHE_WARNING, libvirt.Name)
+			if libvirt.DiskCache == "" {
+				libvirt.DiskCache = "writeback"
+			}
+		}
+	}
+	flavor_names := map[string]struct{}{}
+	for _, flavor := range config.Flavors {
+		if _, exists := flavor_names[flavor.Name]; exists {
+			return nil, fmt.Errorf("duplicate flavor '%s'", flavor.Name)
+		}
+		flavor_names[flavor.Name] = struct{}{}
+		if flavor.Vcpus <= 0 || flavor.MemoryMb <= 0 {
+			return nil, fmt.Errorf("vcpus and memory_mb must be specified for flavor '%s'", flavor.Name)
+		}
 	}
 	if len(config.Web.Oidc.Scopes) <= 0 {
 		config.Web.Oidc.Scopes = []string{"openid", "profile", "email"}
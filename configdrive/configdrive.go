@@ -2,5 +2,6 @@ package configdrive
 
 type Data interface {
 	Hostname() string
+	InstanceId() string
 	PublicKeys() []string
 }
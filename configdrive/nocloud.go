@@ -13,6 +13,10 @@ func (data *NoCloud) Hostname() string {
 	return data.Metadata.Hostname
 }
 
+func (data *NoCloud) InstanceId() string {
+	return data.Metadata.InstanceId
+}
+
 func (data *NoCloud) PublicKeys() []string {
 	return data.Metadata.PublicKeys
 }
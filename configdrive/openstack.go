@@ -13,6 +13,10 @@ func (data *Openstack) Hostname() string {
 	return data.Metadata.Hostname
 }
 
+func (data *Openstack) InstanceId() string {
+	return data.Metadata.UUID
+}
+
 func (data *Openstack) PublicKeys() []string {
 	keys := []string{}
 	for _, value := range data.Metadata.PublicKeys {
@@ -0,0 +1,175 @@
+// Package cron parses standard five field cron expressions:
+// minute, hour, day of month, month and day of week.
+// Lists, ranges, steps, month and weekday names and @hourly like macros are supported.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	domStar, dowStar              bool
+}
+
+type field struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = field{0, 59, nil}
+	hourField   = field{0, 23, nil}
+	domField    = field{1, 31, nil}
+	monthField  = field{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dowField = field{0, 7, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+func Parse(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if expanded, ok := macros[spec]; ok {
+		spec = expanded
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields, got %d", len(fields))
+	}
+	schedule := &Schedule{}
+	var err error
+	if schedule.minute, err = minuteField.parse(fields[0]); err != nil {
+		return nil, fmt.Errorf("invalid minute: %s", err)
+	}
+	if schedule.hour, err = hourField.parse(fields[1]); err != nil {
+		return nil, fmt.Errorf("invalid hour: %s", err)
+	}
+	if schedule.dom, err = domField.parse(fields[2]); err != nil {
+		return nil, fmt.Errorf("invalid day of month: %s", err)
+	}
+	if schedule.month, err = monthField.parse(fields[3]); err != nil {
+		return nil, fmt.Errorf("invalid month: %s", err)
+	}
+	if schedule.dow, err = dowField.parse(fields[4]); err != nil {
+		return nil, fmt.Errorf("invalid day of week: %s", err)
+	}
+	// Sunday may be specified as 7
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	schedule.domStar = fields[2] == "*" || fields[2] == "?"
+	schedule.dowStar = fields[4] == "*" || fields[4] == "?"
+	return schedule, nil
+}
+
+func (f field) value(input string) (int, error) {
+	if value, ok := f.names[strings.ToLower(input)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(input)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %s", input)
+	}
+	if value < f.min || value > f.max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", value, f.min, f.max)
+	}
+	return value, nil
+}
+
+func (f field) parse(input string) (uint64, error) {
+	bits := uint64(0)
+	for _, part := range strings.Split(input, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			parsed, err := strconv.Atoi(part[idx+1:])
+			if err != nil || parsed <= 0 {
+				return 0, fmt.Errorf("invalid step %s", part[idx+1:])
+			}
+			step = parsed
+			part = part[:idx]
+		}
+		start, end := f.min, f.max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = f.value(bounds[0]); err != nil {
+				return 0, err
+			}
+			if end, err = f.value(bounds[1]); err != nil {
+				return 0, err
+			}
+			if end < start {
+				return 0, fmt.Errorf("invalid range %s", part)
+			}
+		default:
+			value, err := f.value(part)
+			if err != nil {
+				return 0, err
+			}
+			start = value
+			if step == 1 {
+				end = value
+			}
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func (schedule *Schedule) dayMatches(t time.Time) bool {
+	domMatch := schedule.dom&(1<<uint(t.Day())) != 0
+	dowMatch := schedule.dow&(1<<uint(t.Weekday())) != 0
+	if schedule.domStar || schedule.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the first activation time after t in the location of t.
+// Zero time is returned if expression never matches, for example on 30 of february.
+func (schedule *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if schedule.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !schedule.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if schedule.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+			continue
+		}
+		if schedule.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,42 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleNext(t *testing.T) {
+	utc := time.UTC
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{"0 20 * * *", time.Date(2020, 3, 10, 19, 59, 30, 0, utc), time.Date(2020, 3, 10, 20, 0, 0, 0, utc)},
+		{"0 20 * * *", time.Date(2020, 3, 10, 20, 0, 0, 0, utc), time.Date(2020, 3, 11, 20, 0, 0, 0, utc)},
+		{"0 8 * * mon-fri", time.Date(2020, 3, 13, 9, 0, 0, 0, utc), time.Date(2020, 3, 16, 8, 0, 0, 0, utc)},
+		{"*/15 * * * *", time.Date(2020, 3, 10, 10, 16, 0, 0, utc), time.Date(2020, 3, 10, 10, 30, 0, 0, utc)},
+		{"0 0 1,15 * *", time.Date(2020, 3, 10, 0, 0, 0, 0, utc), time.Date(2020, 3, 15, 0, 0, 0, 0, utc)},
+		{"0 0 13 * 5", time.Date(2020, 3, 10, 0, 0, 0, 0, utc), time.Date(2020, 3, 13, 0, 0, 0, 0, utc)},
+		{"0 0 * * 7", time.Date(2020, 3, 10, 0, 0, 0, 0, utc), time.Date(2020, 3, 15, 0, 0, 0, 0, utc)},
+		{"@monthly", time.Date(2020, 12, 10, 0, 0, 0, 0, utc), time.Date(2021, 1, 1, 0, 0, 0, 0, utc)},
+		{"0 0 30 feb *", time.Date(2020, 1, 1, 0, 0, 0, 0, utc), time.Time{}},
+	}
+	for _, tt := range tests {
+		schedule, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %s", tt.spec, err)
+		}
+		if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+			t.Errorf("Parse(%q).Next(%s) = %s, want %s", tt.spec, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) expected error", spec)
+		}
+	}
+}
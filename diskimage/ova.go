@@ -0,0 +1,56 @@
+package diskimage
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"subuk/vmango/util"
+)
+
+// ExtractOva unpacks ova bundle into directory and returns path to ovf descriptor
+func ExtractOva(filename, dir string) (string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return "", util.NewError(err, "cannot open ova file")
+	}
+	defer file.Close()
+	archive := tar.NewReader(file)
+	ovfPath := ""
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", util.NewError(err, "cannot read ova archive")
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := filepath.Base(header.Name)
+		if name == "." || name == ".." || name == "/" {
+			return "", fmt.Errorf("invalid file name %s in ova archive", header.Name)
+		}
+		target, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			return "", util.NewError(err, "cannot create file %s", name)
+		}
+		if _, err := io.Copy(target, archive); err != nil {
+			target.Close()
+			return "", util.NewError(err, "cannot extract file %s", name)
+		}
+		if err := target.Close(); err != nil {
+			return "", util.NewError(err, "cannot write file %s", name)
+		}
+		if strings.HasSuffix(strings.ToLower(name), ".ovf") {
+			ovfPath = filepath.Join(dir, name)
+		}
+	}
+	if ovfPath == "" {
+		return "", fmt.Errorf("ovf descriptor not found in ova archive")
+	}
+	return ovfPath, nil
+}
@@ -0,0 +1,193 @@
+package diskimage
+
+import (
+	"encoding/xml"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"subuk/vmango/util"
+)
+
+const (
+	ovfResourceCpu    = "3"
+	ovfResourceMemory = "4"
+	ovfResourceDisk   = "17"
+)
+
+type OvfDisk struct {
+	File     string
+	Format   string
+	Capacity uint64
+}
+
+// Ovf contains machine configuration from ovf descriptor
+type Ovf struct {
+	Name     string
+	Cpus     int
+	Memory   uint64
+	Efi      bool
+	Disks    []OvfDisk
+	Networks []string
+}
+
+type ovfEnvelope struct {
+	Files []struct {
+		Id   string `xml:"id,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"References>File"`
+	Disks []struct {
+		DiskId     string `xml:"diskId,attr"`
+		FileRef    string `xml:"fileRef,attr"`
+		Capacity   string `xml:"capacity,attr"`
+		CapacityAu string `xml:"capacityAllocationUnits,attr"`
+		Format     string `xml:"format,attr"`
+	} `xml:"DiskSection>Disk"`
+	Networks []struct {
+		Name string `xml:"name,attr"`
+	} `xml:"NetworkSection>Network"`
+	System struct {
+		Id    string `xml:"id,attr"`
+		Name  string `xml:"Name"`
+		Items []struct {
+			ResourceType    string `xml:"ResourceType"`
+			VirtualQuantity string `xml:"VirtualQuantity"`
+			AllocationUnits string `xml:"AllocationUnits"`
+			HostResource    string `xml:"HostResource"`
+		} `xml:"VirtualHardwareSection>Item"`
+		Configs []struct {
+			Key   string `xml:"key,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"VirtualHardwareSection>Config"`
+	} `xml:"VirtualSystem"`
+}
+
+// ParseAllocationUnits returns multiplier for ovf units like "byte * 2^20"
+func ParseAllocationUnits(units string) (uint64, error) {
+	units = strings.ToLower(strings.Join(strings.Fields(units), ""))
+	switch units {
+	case "", "byte", "bytes":
+		return 1, nil
+	case "kilobytes", "kb":
+		return 1 << 10, nil
+	case "megabytes", "mb":
+		return 1 << 20, nil
+	case "gigabytes", "gb":
+		return 1 << 30, nil
+	}
+	if !strings.HasPrefix(units, "byte*2^") {
+		return 0, fmt.Errorf("unknown allocation units '%s'", units)
+	}
+	power, err := strconv.ParseUint(strings.TrimPrefix(units, "byte*2^"), 10, 8)
+	if err != nil || power > 40 {
+		return 0, fmt.Errorf("invalid allocation units '%s'", units)
+	}
+	return 1 << power, nil
+}
+
+// ovfDiskFormat returns qemu-img format of ovf disk from its format url,
+// like http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized,
+// or from file extension. Empty string is returned if format is unknown.
+func ovfDiskFormat(formatUrl, file string) string {
+	formatUrl = strings.ToLower(formatUrl)
+	for _, format := range []string{"vmdk", "vhdx", "vdi", "qcow2"} {
+		if strings.Contains(formatUrl, format) {
+			return format
+		}
+	}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".vmdk":
+		return "vmdk"
+	case ".vhdx":
+		return "vhdx"
+	case ".vdi":
+		return "vdi"
+	case ".qcow2":
+		return "qcow2"
+	case ".img", ".raw":
+		return "raw"
+	}
+	return ""
+}
+
+func ParseOvf(content []byte) (*Ovf, error) {
+	envelope := ovfEnvelope{}
+	if err := xml.Unmarshal(content, &envelope); err != nil {
+		return nil, util.NewError(err, "cannot parse ovf descriptor")
+	}
+	ovf := &Ovf{Name: envelope.System.Name}
+	if ovf.Name == "" {
+		ovf.Name = envelope.System.Id
+	}
+	files := map[string]string{}
+	for _, file := range envelope.Files {
+		files[file.Id] = file.Href
+	}
+	disks := map[string]OvfDisk{}
+	diskIds := []string{}
+	for _, disk := range envelope.Disks {
+		ovfDisk := OvfDisk{File: files[disk.FileRef], Format: ovfDiskFormat(disk.Format, files[disk.FileRef])}
+		if disk.Capacity != "" {
+			capacity, err := strconv.ParseUint(disk.Capacity, 10, 64)
+			if err != nil {
+				return nil, util.NewError(err, "invalid capacity for disk %s", disk.DiskId)
+			}
+			multiplier, err := ParseAllocationUnits(disk.CapacityAu)
+			if err != nil {
+				return nil, util.NewError(err, "invalid capacity units for disk %s", disk.DiskId)
+			}
+			ovfDisk.Capacity = capacity * multiplier
+		}
+		disks[disk.DiskId] = ovfDisk
+		diskIds = append(diskIds, disk.DiskId)
+	}
+	for _, network := range envelope.Networks {
+		ovf.Networks = append(ovf.Networks, network.Name)
+	}
+
+	// Disk order from hardware section is used if present, it defines boot order
+	orderedDiskIds := []string{}
+	for _, item := range envelope.System.Items {
+		switch strings.TrimSpace(item.ResourceType) {
+		case ovfResourceCpu:
+			cpus, err := strconv.Atoi(strings.TrimSpace(item.VirtualQuantity))
+			if err != nil {
+				return nil, util.NewError(err, "invalid cpu count")
+			}
+			ovf.Cpus = cpus
+		case ovfResourceMemory:
+			quantity, err := strconv.ParseUint(strings.TrimSpace(item.VirtualQuantity), 10, 64)
+			if err != nil {
+				return nil, util.NewError(err, "invalid memory size")
+			}
+			multiplier, err := ParseAllocationUnits(item.AllocationUnits)
+			if err != nil {
+				return nil, util.NewError(err, "invalid memory units")
+			}
+			ovf.Memory = quantity * multiplier
+		case ovfResourceDisk:
+			resource := strings.TrimSpace(item.HostResource)
+			resource = strings.TrimPrefix(resource, "ovf:")
+			resource = strings.TrimPrefix(resource, "/disk/")
+			if _, exists := disks[resource]; exists {
+				orderedDiskIds = append(orderedDiskIds, resource)
+			}
+		}
+	}
+	if len(orderedDiskIds) == 0 {
+		orderedDiskIds = diskIds
+	}
+	for _, diskId := range orderedDiskIds {
+		disk := disks[diskId]
+		if disk.File == "" {
+			continue
+		}
+		ovf.Disks = append(ovf.Disks, disk)
+	}
+	for _, config := range envelope.System.Configs {
+		if config.Key == "firmware" && config.Value == "efi" {
+			ovf.Efi = true
+		}
+	}
+	return ovf, nil
+}
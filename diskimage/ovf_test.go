@@ -0,0 +1,93 @@
+package diskimage
+
+import (
+	"reflect"
+	"testing"
+)
+
+const testOvf = `<?xml version="1.0" encoding="UTF-8"?>
+<Envelope xmlns="http://schemas.dmtf.org/ovf/envelope/1" xmlns:ovf="http://schemas.dmtf.org/ovf/envelope/1" xmlns:rasd="http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData" xmlns:vmw="http://www.vmware.com/schema/ovf">
+  <References>
+    <File ovf:id="file1" ovf:href="appliance-disk1.qcow2"/>
+    <File ovf:id="file2" ovf:href="appliance-disk2"/>
+  </References>
+  <DiskSection>
+    <Disk ovf:diskId="vmdisk2" ovf:fileRef="file2" ovf:capacity="1024" ovf:capacityAllocationUnits="byte * 2^20" ovf:format="http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"/>
+    <Disk ovf:diskId="vmdisk1" ovf:fileRef="file1" ovf:capacity="10" ovf:capacityAllocationUnits="byte * 2^30"/>
+  </DiskSection>
+  <NetworkSection>
+    <Network ovf:name="VM Network"/>
+  </NetworkSection>
+  <VirtualSystem ovf:id="appliance">
+    <Name>appliance</Name>
+    <VirtualHardwareSection>
+      <Item>
+        <rasd:ResourceType>3</rasd:ResourceType>
+        <rasd:VirtualQuantity>4</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:AllocationUnits>byte * 2^20</rasd:AllocationUnits>
+        <rasd:ResourceType>4</rasd:ResourceType>
+        <rasd:VirtualQuantity>2048</rasd:VirtualQuantity>
+      </Item>
+      <Item>
+        <rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+      <Item>
+        <rasd:HostResource>ovf:/disk/vmdisk2</rasd:HostResource>
+        <rasd:ResourceType>17</rasd:ResourceType>
+      </Item>
+      <vmw:Config ovf:required="false" vmw:key="firmware" vmw:value="efi"/>
+    </VirtualHardwareSection>
+  </VirtualSystem>
+</Envelope>`
+
+func TestParseOvf(t *testing.T) {
+	want := &Ovf{
+		Name:   "appliance",
+		Cpus:   4,
+		Memory: 2048 << 20,
+		Efi:    true,
+		Disks: []OvfDisk{
+			OvfDisk{File: "appliance-disk1.qcow2", Format: "qcow2", Capacity: 10 << 30},
+			OvfDisk{File: "appliance-disk2", Format: "vmdk", Capacity: 1024 << 20},
+		},
+		Networks: []string{"VM Network"},
+	}
+	got, err := ParseOvf([]byte(testOvf))
+	if err != nil {
+		t.Fatalf("ParseOvf() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseOvf() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseAllocationUnits(t *testing.T) {
+	tests := []struct {
+		units   string
+		want    uint64
+		wantErr bool
+	}{
+		{"", 1, false},
+		{"byte", 1, false},
+		{"byte * 2^20", 1 << 20, false},
+		{"byte*2^30", 1 << 30, false},
+		{"MegaBytes", 1 << 20, false},
+		{"byte * 2^x", 0, true},
+		{"hertz", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.units, func(t *testing.T) {
+			got, err := ParseAllocationUnits(tt.units)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseAllocationUnits() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseAllocationUnits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package diskimage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"subuk/vmango/util"
+)
+
+type Info struct {
+	Format              string `json:"format"`
+	VirtualSize         uint64 `json:"virtual-size"`
+	ActualSize          uint64 `json:"actual-size"`
+	BackingFilename     string `json:"backing-filename"`
+	FullBackingFilename string `json:"full-backing-filename"`
+	FormatSpecific      struct {
+		Type string `json:"type"`
+		Data struct {
+			DataFile string `json:"data-file"`
+			Extents  []struct {
+				Filename string `json:"filename"`
+			} `json:"extents"`
+		} `json:"data"`
+	} `json:"format-specific"`
+}
+
+// GetInfo returns image size and details reported by qemu-img.
+// Format is required, probing is unsafe for untrusted images.
+func GetInfo(filename, format string) (*Info, error) {
+	if format == "" {
+		return nil, fmt.Errorf("image format must be specified")
+	}
+	cmd := exec.Command("qemu-img", "info", "-f", format, "--output=json", filename)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, util.NewError(err, "image info cmd failed: '%s'", strings.Join(cmd.Args, " "))
+	}
+	info := &Info{}
+	if err := json.Unmarshal(output, info); err != nil {
+		return nil, util.NewError(err, "cannot parse qemu-img info output")
+	}
+	return info, nil
+}
+
+// CheckExternalFiles returns error if image refers to files other than itself.
+// Backing and external data files are never allowed, vmdk extents must be
+// inside dir, empty dir allows only extents stored in the image file itself.
+func (info *Info) CheckExternalFiles(filename, dir string) error {
+	if info.BackingFilename != "" || info.FullBackingFilename != "" {
+		return fmt.Errorf("images with backing file are not allowed")
+	}
+	if info.FormatSpecific.Data.DataFile != "" {
+		return fmt.Errorf("images with external data file are not allowed")
+	}
+	filename, err := filepath.Abs(filename)
+	if err != nil {
+		return util.NewError(err, "cannot get absolute image path")
+	}
+	if dir != "" {
+		if dir, err = filepath.Abs(dir); err != nil {
+			return util.NewError(err, "cannot get absolute directory path")
+		}
+	}
+	for _, extent := range info.FormatSpecific.Data.Extents {
+		path := extent.Filename
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(filepath.Dir(filename), path)
+		}
+		path = filepath.Clean(path)
+		if path == filename {
+			continue
+		}
+		if dir == "" || !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return fmt.Errorf("image extent %s is outside of upload", extent.Filename)
+		}
+	}
+	return nil
+}
+
+// Convert converts image to target format with qemu-img.
+// Source format is required, probing is unsafe for untrusted images.
+func Convert(source, sourceFormat, target, targetFormat string) error {
+	if sourceFormat == "" {
+		return fmt.Errorf("source image format must be specified")
+	}
+	cmd := exec.Command("qemu-img", "convert", "-f", sourceFormat, "-O", targetFormat, source, target)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return util.NewError(err, "image convert cmd failed: '%s': %s", strings.Join(cmd.Args, " "), strings.TrimSpace(string(output)))
+	}
+	return nil
+}
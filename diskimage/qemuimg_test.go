@@ -0,0 +1,37 @@
+package diskimage
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestInfoCheckExternalFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		info    string
+		dir     string
+		wantErr bool
+	}{
+		{"Plain", `{"format": "qcow2"}`, "", false},
+		{"BackingFile", `{"format": "qcow2", "backing-filename": "/etc/shadow"}`, "", true},
+		{"FullBackingFile", `{"format": "vmdk", "full-backing-filename": "/var/lib/libvirt/images/other.qcow2"}`, "/tmp/ova", true},
+		{"DataFile", `{"format": "qcow2", "format-specific": {"type": "qcow2", "data": {"data-file": "/dev/sda"}}}`, "", true},
+		{"MonolithicVmdk", `{"format": "vmdk", "format-specific": {"type": "vmdk", "data": {"extents": [{"filename": "/tmp/ova/disk.vmdk"}]}}}`, "", false},
+		{"ExtentInDir", `{"format": "vmdk", "format-specific": {"type": "vmdk", "data": {"extents": [{"filename": "disk-flat.vmdk"}]}}}`, "/tmp/ova", false},
+		{"ExtentWithoutDir", `{"format": "vmdk", "format-specific": {"type": "vmdk", "data": {"extents": [{"filename": "/tmp/ova/disk-flat.vmdk"}]}}}`, "", true},
+		{"ExtentOutside", `{"format": "vmdk", "format-specific": {"type": "vmdk", "data": {"extents": [{"filename": "/var/lib/libvirt/images/other.img"}]}}}`, "/tmp/ova", true},
+		{"ExtentRelativeEscape", `{"format": "vmdk", "format-specific": {"type": "vmdk", "data": {"extents": [{"filename": "../../etc/shadow"}]}}}`, "/tmp/ova", true},
+		{"ExtentDirPrefix", `{"format": "vmdk", "format-specific": {"type": "vmdk", "data": {"extents": [{"filename": "/tmp/ova-other/disk.vmdk"}]}}}`, "/tmp/ova", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info := &Info{}
+			if err := json.Unmarshal([]byte(tt.info), info); err != nil {
+				t.Fatalf("cannot parse info: %s", err)
+			}
+			if err := info.CheckExternalFiles("/tmp/ova/disk.vmdk", tt.dir); (err != nil) != tt.wantErr {
+				t.Errorf("CheckExternalFiles() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"sync"
+)
+
+type flavorFileEntry struct {
+	Name         string   `json:"name"`
+	VCpus        int      `json:"vcpus"`
+	MemoryMb     uint64   `json:"memory_mb"`
+	RootDiskGb   uint64   `json:"root_disk_gb"`
+	ExtraDisksGb []uint64 `json:"extra_disks_gb,omitempty"`
+	Hugepages    bool     `json:"hugepages,omitempty"`
+	Nodes        []string `json:"nodes,omitempty"`
+}
+
+func newFlavorFileEntry(flavor *compute.Flavor) flavorFileEntry {
+	entry := flavorFileEntry{
+		Name:       flavor.Name,
+		VCpus:      flavor.VCpus,
+		MemoryMb:   flavor.Memory.M(),
+		RootDiskGb: flavor.RootDisk.G(),
+		Hugepages:  flavor.Hugepages,
+		Nodes:      flavor.NodeIds,
+	}
+	for _, size := range flavor.ExtraDisks {
+		entry.ExtraDisksGb = append(entry.ExtraDisksGb, size.G())
+	}
+	return entry
+}
+
+// FlavorRepository stores flavors in json file.
+// Flavors from configuration file are available too, but cannot be changed.
+type FlavorRepository struct {
+	filename string
+	builtin  []*compute.Flavor
+	mu       *sync.Mutex
+}
+
+func NewFlavorRepository(filename string, builtin []*compute.Flavor) (*FlavorRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, util.NewError(err, "cannot create base directory")
+	}
+	for _, flavor := range builtin {
+		flavor.ReadOnly = true
+	}
+	return &FlavorRepository{filename: filename, builtin: builtin, mu: &sync.Mutex{}}, nil
+}
+
+func (repo *FlavorRepository) load() ([]flavorFileEntry, error) {
+	content, err := ioutil.ReadFile(repo.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []flavorFileEntry{}, nil
+		}
+		return nil, util.NewError(err, "cannot read flavor file")
+	}
+	entries := []flavorFileEntry{}
+	if len(content) == 0 {
+		return entries, nil
+	}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, util.NewError(err, "cannot parse flavor file")
+	}
+	return entries, nil
+}
+
+func (repo *FlavorRepository) save(entries []flavorFileEntry) error {
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return util.NewError(err, "cannot serialize flavors")
+	}
+	if err := ioutil.WriteFile(repo.filename, content, 0644); err != nil {
+		return util.NewError(err, "cannot write flavor file")
+	}
+	return nil
+}
+
+func (repo *FlavorRepository) List() ([]*compute.Flavor, error) {
+	repo.mu.Lock()
+	entries, err := repo.load()
+	repo.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	flavors := []*compute.Flavor{}
+	flavors = append(flavors, repo.builtin...)
+	for _, entry := range entries {
+		flavor := &compute.Flavor{
+			Name:      entry.Name,
+			VCpus:     entry.VCpus,
+			Memory:    compute.NewSize(entry.MemoryMb, compute.SizeUnitM),
+			RootDisk:  compute.NewSize(entry.RootDiskGb, compute.SizeUnitG),
+			Hugepages: entry.Hugepages,
+			NodeIds:   entry.Nodes,
+		}
+		for _, size := range entry.ExtraDisksGb {
+			flavor.ExtraDisks = append(flavor.ExtraDisks, compute.NewSize(size, compute.SizeUnitG))
+		}
+		flavors = append(flavors, flavor)
+	}
+	sort.SliceStable(flavors, func(i, j int) bool {
+		return flavors[i].Memory.Bytes() < flavors[j].Memory.Bytes()
+	})
+	return flavors, nil
+}
+
+func (repo *FlavorRepository) Get(name string) (*compute.Flavor, error) {
+	flavors, err := repo.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, flavor := range flavors {
+		if flavor.Name == name {
+			return flavor, nil
+		}
+	}
+	return nil, compute.ErrFlavorNotFound
+}
+
+func (repo *FlavorRepository) Add(flavor *compute.Flavor) error {
+	if _, err := repo.Get(flavor.Name); err == nil {
+		return compute.ErrFlavorAlreadyExists
+	} else if err != compute.ErrFlavorNotFound {
+		return util.NewError(err, "cannot check if flavor already exists")
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	entries, err := repo.load()
+	if err != nil {
+		return err
+	}
+	return repo.save(append(entries, newFlavorFileEntry(flavor)))
+}
+
+func (repo *FlavorRepository) Update(flavor *compute.Flavor) error {
+	for _, builtin := range repo.builtin {
+		if builtin.Name == flavor.Name {
+			return compute.ErrFlavorReadOnly
+		}
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	entries, err := repo.load()
+	if err != nil {
+		return err
+	}
+	for idx, entry := range entries {
+		if entry.Name == flavor.Name {
+			entries[idx] = newFlavorFileEntry(flavor)
+			return repo.save(entries)
+		}
+	}
+	return compute.ErrFlavorNotFound
+}
+
+func (repo *FlavorRepository) Delete(name string) error {
+	for _, flavor := range repo.builtin {
+		if flavor.Name == name {
+			return compute.ErrFlavorReadOnly
+		}
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	entries, err := repo.load()
+	if err != nil {
+		return err
+	}
+	newEntries := []flavorFileEntry{}
+	for _, entry := range entries {
+		if entry.Name == name {
+			continue
+		}
+		newEntries = append(newEntries, entry)
+	}
+	if len(newEntries) == len(entries) {
+		return compute.ErrFlavorNotFound
+	}
+	return repo.save(newEntries)
+}
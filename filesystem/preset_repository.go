@@ -0,0 +1,202 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"sync"
+)
+
+type presetFileInterface struct {
+	Network    string `json:"network"`
+	Model      string `json:"model,omitempty"`
+	AccessVlan uint   `json:"access_vlan,omitempty"`
+}
+
+type presetFileEntry struct {
+	Name        string                `json:"name"`
+	Node        string                `json:"node"`
+	Arch        string                `json:"arch"`
+	Image       string                `json:"image"`
+	RootPool    string                `json:"root_pool"`
+	RootFormat  string                `json:"root_format"`
+	RootSizeB   uint64                `json:"root_size_bytes,omitempty"`
+	Flavor      string                `json:"flavor,omitempty"`
+	VCpus       int                   `json:"vcpus,omitempty"`
+	MemoryB     uint64                `json:"memory_bytes,omitempty"`
+	Interfaces  []presetFileInterface `json:"interfaces,omitempty"`
+	Keys        []string              `json:"keys,omitempty"`
+	Userdata    string                `json:"userdata,omitempty"`
+	GraphicType string                `json:"graphic_type"`
+	VideoModel  string                `json:"video_model"`
+	GuestAgent  bool                  `json:"guest_agent,omitempty"`
+	Hugepages   bool                  `json:"hugepages,omitempty"`
+	Start       bool                  `json:"start,omitempty"`
+}
+
+// VirtualMachinePresetRepository stores machine creation presets in json file
+type VirtualMachinePresetRepository struct {
+	filename string
+	mu       *sync.Mutex
+}
+
+func NewVirtualMachinePresetRepository(filename string) (*VirtualMachinePresetRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, util.NewError(err, "cannot create base directory")
+	}
+	return &VirtualMachinePresetRepository{filename: filename, mu: &sync.Mutex{}}, nil
+}
+
+func (repo *VirtualMachinePresetRepository) load() ([]presetFileEntry, error) {
+	content, err := ioutil.ReadFile(repo.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []presetFileEntry{}, nil
+		}
+		return nil, util.NewError(err, "cannot read preset file")
+	}
+	entries := []presetFileEntry{}
+	if len(content) == 0 {
+		return entries, nil
+	}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, util.NewError(err, "cannot parse preset file")
+	}
+	return entries, nil
+}
+
+func (repo *VirtualMachinePresetRepository) save(entries []presetFileEntry) error {
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return util.NewError(err, "cannot serialize presets")
+	}
+	if err := ioutil.WriteFile(repo.filename, content, 0644); err != nil {
+		return util.NewError(err, "cannot write preset file")
+	}
+	return nil
+}
+
+func (repo *VirtualMachinePresetRepository) entryToPreset(entry presetFileEntry) *compute.VirtualMachinePreset {
+	preset := &compute.VirtualMachinePreset{
+		Name:        entry.Name,
+		NodeId:      entry.Node,
+		Arch:        compute.NewArch(entry.Arch),
+		Image:       entry.Image,
+		RootPool:    entry.RootPool,
+		RootFormat:  compute.NewVolumeFormat(entry.RootFormat),
+		RootSize:    compute.NewSize(entry.RootSizeB, compute.SizeUnitB),
+		Flavor:      entry.Flavor,
+		VCpus:       entry.VCpus,
+		Memory:      compute.NewSize(entry.MemoryB, compute.SizeUnitB),
+		Keys:        entry.Keys,
+		Userdata:    entry.Userdata,
+		GraphicType: compute.NewGraphicType(entry.GraphicType),
+		VideoModel:  compute.NewVideoModel(entry.VideoModel),
+		GuestAgent:  entry.GuestAgent,
+		Hugepages:   entry.Hugepages,
+		Start:       entry.Start,
+	}
+	for _, iface := range entry.Interfaces {
+		preset.Interfaces = append(preset.Interfaces, compute.VirtualMachinePresetInterface{
+			NetworkName: iface.Network,
+			Model:       iface.Model,
+			AccessVlan:  iface.AccessVlan,
+		})
+	}
+	return preset
+}
+
+func (repo *VirtualMachinePresetRepository) List() ([]*compute.VirtualMachinePreset, error) {
+	repo.mu.Lock()
+	entries, err := repo.load()
+	repo.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	presets := []*compute.VirtualMachinePreset{}
+	for _, entry := range entries {
+		presets = append(presets, repo.entryToPreset(entry))
+	}
+	sort.Slice(presets, func(i, j int) bool {
+		return presets[i].Name < presets[j].Name
+	})
+	return presets, nil
+}
+
+func (repo *VirtualMachinePresetRepository) Get(name string) (*compute.VirtualMachinePreset, error) {
+	presets, err := repo.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, preset := range presets {
+		if preset.Name == name {
+			return preset, nil
+		}
+	}
+	return nil, compute.ErrVirtualMachinePresetNotFound
+}
+
+func (repo *VirtualMachinePresetRepository) Add(preset *compute.VirtualMachinePreset) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	entries, err := repo.load()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name == preset.Name {
+			return compute.ErrVirtualMachinePresetAlreadyExists
+		}
+	}
+	entry := presetFileEntry{
+		Name:        preset.Name,
+		Node:        preset.NodeId,
+		Arch:        preset.Arch.String(),
+		Image:       preset.Image,
+		RootPool:    preset.RootPool,
+		RootFormat:  preset.RootFormat.String(),
+		RootSizeB:   preset.RootSize.Bytes(),
+		Flavor:      preset.Flavor,
+		VCpus:       preset.VCpus,
+		MemoryB:     preset.Memory.Bytes(),
+		Keys:        preset.Keys,
+		Userdata:    preset.Userdata,
+		GraphicType: preset.GraphicType.String(),
+		VideoModel:  preset.VideoModel.String(),
+		GuestAgent:  preset.GuestAgent,
+		Hugepages:   preset.Hugepages,
+		Start:       preset.Start,
+	}
+	for _, iface := range preset.Interfaces {
+		entry.Interfaces = append(entry.Interfaces, presetFileInterface{
+			Network:    iface.NetworkName,
+			Model:      iface.Model,
+			AccessVlan: iface.AccessVlan,
+		})
+	}
+	return repo.save(append(entries, entry))
+}
+
+func (repo *VirtualMachinePresetRepository) Delete(name string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	entries, err := repo.load()
+	if err != nil {
+		return err
+	}
+	newEntries := []presetFileEntry{}
+	for _, entry := range entries {
+		if entry.Name == name {
+			continue
+		}
+		newEntries = append(newEntries, entry)
+	}
+	if len(newEntries) == len(entries) {
+		return compute.ErrVirtualMachinePresetNotFound
+	}
+	return repo.save(newEntries)
+}
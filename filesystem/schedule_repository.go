@@ -0,0 +1,260 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// scheduleExecutionsLimit is a number of executions kept in history for each schedule
+const scheduleExecutionsLimit = 50
+
+type scheduleFileEntry struct {
+	Id        string `json:"id"`
+	Name      string `json:"name"`
+	Cron      string `json:"cron"`
+	Timezone  string `json:"timezone"`
+	Action    string `json:"action"`
+	MachineId string `json:"machine,omitempty"`
+	NodeId    string `json:"node,omitempty"`
+	Tag       string `json:"tag,omitempty"`
+	Enabled   bool   `json:"enabled"`
+}
+
+type scheduleFileExecutionResult struct {
+	MachineId string `json:"machine"`
+	NodeId    string `json:"node"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type scheduleFileExecution struct {
+	ScheduleId string                        `json:"schedule"`
+	Action     string                        `json:"action"`
+	Started    time.Time                     `json:"started"`
+	Finished   time.Time                     `json:"finished"`
+	Error      string                        `json:"error,omitempty"`
+	Results    []scheduleFileExecutionResult `json:"results,omitempty"`
+}
+
+type scheduleFile struct {
+	Schedules  []scheduleFileEntry     `json:"schedules"`
+	Executions []scheduleFileExecution `json:"executions"`
+}
+
+func newScheduleFileEntry(schedule *compute.Schedule) scheduleFileEntry {
+	return scheduleFileEntry{
+		Id:        schedule.Id,
+		Name:      schedule.Name,
+		Cron:      schedule.Cron,
+		Timezone:  schedule.Timezone,
+		Action:    schedule.Action,
+		MachineId: schedule.MachineId,
+		NodeId:    schedule.NodeId,
+		Tag:       schedule.Tag,
+		Enabled:   schedule.Enabled,
+	}
+}
+
+func (entry scheduleFileEntry) schedule() *compute.Schedule {
+	return &compute.Schedule{
+		Id:        entry.Id,
+		Name:      entry.Name,
+		Cron:      entry.Cron,
+		Timezone:  entry.Timezone,
+		Action:    entry.Action,
+		MachineId: entry.MachineId,
+		NodeId:    entry.NodeId,
+		Tag:       entry.Tag,
+		Enabled:   entry.Enabled,
+	}
+}
+
+// ScheduleRepository stores schedules and their execution history in json file
+type ScheduleRepository struct {
+	filename string
+	mu       *sync.Mutex
+}
+
+func NewScheduleRepository(filename string) (*ScheduleRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, util.NewError(err, "cannot create base directory")
+	}
+	return &ScheduleRepository{filename: filename, mu: &sync.Mutex{}}, nil
+}
+
+func (repo *ScheduleRepository) load() (*scheduleFile, error) {
+	content, err := ioutil.ReadFile(repo.filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &scheduleFile{}, nil
+		}
+		return nil, util.NewError(err, "cannot read schedule file")
+	}
+	data := &scheduleFile{}
+	if len(content) == 0 {
+		return data, nil
+	}
+	if err := json.Unmarshal(content, data); err != nil {
+		return nil, util.NewError(err, "cannot parse schedule file")
+	}
+	return data, nil
+}
+
+func (repo *ScheduleRepository) save(data *scheduleFile) error {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return util.NewError(err, "cannot serialize schedules")
+	}
+	if err := ioutil.WriteFile(repo.filename, content, 0644); err != nil {
+		return util.NewError(err, "cannot write schedule file")
+	}
+	return nil
+}
+
+func (repo *ScheduleRepository) List() ([]*compute.Schedule, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	data, err := repo.load()
+	if err != nil {
+		return nil, err
+	}
+	schedules := []*compute.Schedule{}
+	for _, entry := range data.Schedules {
+		schedules = append(schedules, entry.schedule())
+	}
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Name < schedules[j].Name
+	})
+	return schedules, nil
+}
+
+func (repo *ScheduleRepository) Get(id string) (*compute.Schedule, error) {
+	schedules, err := repo.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, schedule := range schedules {
+		if schedule.Id == id {
+			return schedule, nil
+		}
+	}
+	return nil, compute.ErrScheduleNotFound
+}
+
+// Save adds new schedule if it has no id or replaces the existing one
+func (repo *ScheduleRepository) Save(schedule *compute.Schedule) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	data, err := repo.load()
+	if err != nil {
+		return err
+	}
+	if schedule.Id == "" {
+		schedule.Id = uuid.New().String()
+		data.Schedules = append(data.Schedules, newScheduleFileEntry(schedule))
+		return repo.save(data)
+	}
+	for idx, existing := range data.Schedules {
+		if existing.Id == schedule.Id {
+			data.Schedules[idx] = newScheduleFileEntry(schedule)
+			return repo.save(data)
+		}
+	}
+	return compute.ErrScheduleNotFound
+}
+
+func (repo *ScheduleRepository) Delete(id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	data, err := repo.load()
+	if err != nil {
+		return err
+	}
+	schedules := []scheduleFileEntry{}
+	for _, entry := range data.Schedules {
+		if entry.Id != id {
+			schedules = append(schedules, entry)
+		}
+	}
+	if len(schedules) == len(data.Schedules) {
+		return compute.ErrScheduleNotFound
+	}
+	executions := []scheduleFileExecution{}
+	for _, execution := range data.Executions {
+		if execution.ScheduleId != id {
+			executions = append(executions, execution)
+		}
+	}
+	data.Schedules = schedules
+	data.Executions = executions
+	return repo.save(data)
+}
+
+func (repo *ScheduleRepository) AddExecution(execution *compute.ScheduleExecution) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	data, err := repo.load()
+	if err != nil {
+		return err
+	}
+	entry := scheduleFileExecution{
+		ScheduleId: execution.ScheduleId,
+		Action:     execution.Action,
+		Started:    execution.Started,
+		Finished:   execution.Finished,
+		Error:      execution.Error,
+	}
+	for _, result := range execution.Results {
+		entry.Results = append(entry.Results, scheduleFileExecutionResult(result))
+	}
+	executions := []scheduleFileExecution{entry}
+	count := 1
+	for _, existing := range data.Executions {
+		if existing.ScheduleId == execution.ScheduleId {
+			if count >= scheduleExecutionsLimit {
+				continue
+			}
+			count++
+		}
+		executions = append(executions, existing)
+	}
+	data.Executions = executions
+	return repo.save(data)
+}
+
+// ListExecutions returns schedule executions, newest first
+func (repo *ScheduleRepository) ListExecutions(scheduleId string) ([]*compute.ScheduleExecution, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	data, err := repo.load()
+	if err != nil {
+		return nil, err
+	}
+	executions := []*compute.ScheduleExecution{}
+	for _, entry := range data.Executions {
+		if entry.ScheduleId != scheduleId {
+			continue
+		}
+		execution := &compute.ScheduleExecution{
+			ScheduleId: entry.ScheduleId,
+			Action:     entry.Action,
+			Started:    entry.Started,
+			Finished:   entry.Finished,
+			Error:      entry.Error,
+		}
+		for _, result := range entry.Results {
+			execution.Results = append(execution.Results, compute.ScheduleExecutionResult(result))
+		}
+		executions = append(executions, execution)
+	}
+	return executions, nil
+}
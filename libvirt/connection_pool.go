@@ -104,6 +104,20 @@ func (p *ConnectionPool) Acquire(node string) (*libvirt.Connect, error) {
 	return p.cache[uri].Conn, nil
 }
 
+// Open establishes new connection bypassing the cache, useful for long running
+// operations which shouldn't block other users of the node. Caller must close it.
+func (p *ConnectionPool) Open(node string) (*libvirt.Connect, error) {
+	uri, nodeExists := p.nodeUri[node]
+	if !nodeExists {
+		return nil, compute.ErrUnknownNode
+	}
+	conn, err := libvirt.NewConnect(uri)
+	if err != nil {
+		return nil, util.NewError(err, "cannot open libvirt connection")
+	}
+	return conn, nil
+}
+
 func (p *ConnectionPool) Release(node string) {
 	uri := p.nodeUri[node]
 	p.cache[uri].Mu.Unlock()
@@ -16,18 +16,27 @@ func NewDeviceNamer() *DeviceNamer {
 	}
 }
 
+// NewDeviceNamerFromDisks returns namer which continues after the last used
+// device name of each bus. Disks may be passed from both live and persistent
+// configs of running domain, so names attached to any of them are not reused.
 func NewDeviceNamerFromDisks(disks []libvirtxml.DomainDisk) *DeviceNamer {
 	namer := &DeviceNamer{state: map[compute.DeviceBus]int{}}
 	for _, disk := range disks {
 		if disk.Target != nil && disk.Target.Dev != "" {
 			devName := disk.Target.Dev
+			bus := compute.DeviceBusUnknown
 			switch devName[0 : len(devName)-1] {
 			case "sd":
-				namer.state[compute.DeviceBusScsi] = int(devName[len(devName)-1]) - int('`')
+				bus = compute.DeviceBusScsi
 			case "hd":
-				namer.state[compute.DeviceBusIde] = int(devName[len(devName)-1]) - int('`')
+				bus = compute.DeviceBusIde
 			case "vd":
-				namer.state[compute.DeviceBusVirtio] = int(devName[len(devName)-1]) - int('`')
+				bus = compute.DeviceBusVirtio
+			default:
+				continue
+			}
+			if next := int(devName[len(devName)-1]) - int('`'); next > namer.state[bus] {
+				namer.state[bus] = next
 			}
 		}
 	}
@@ -39,6 +48,10 @@ func (n *DeviceNamer) Next(bus compute.DeviceBus) string {
 	switch bus {
 	case compute.DeviceBusIde:
 		name = "hd" + string('a'+n.state[bus])
+	case compute.DeviceBusSata:
+		// Sata and scsi disks share sd names
+		bus = compute.DeviceBusScsi
+		name = "sd" + string('a'+n.state[bus])
 	case compute.DeviceBusScsi:
 		name = "sd" + string('a'+n.state[bus])
 	case compute.DeviceBusVirtio:
@@ -29,6 +29,14 @@ func TestNewDeviceNamerFromDisks(t *testing.T) {
 			Target: &libvirtxml.DomainDiskTarget{Dev: "hdd"},
 		},
 	}
+	diskSetUnordered := []libvirtxml.DomainDisk{
+		libvirtxml.DomainDisk{
+			Target: &libvirtxml.DomainDiskTarget{Dev: "vdc"},
+		},
+		libvirtxml.DomainDisk{
+			Target: &libvirtxml.DomainDiskTarget{Dev: "vda"},
+		},
+	}
 	diskSetNoDevOrTarget := []libvirtxml.DomainDisk{
 		libvirtxml.DomainDisk{},
 		libvirtxml.DomainDisk{
@@ -56,6 +64,13 @@ func TestNewDeviceNamerFromDisks(t *testing.T) {
 				compute.DeviceBusIde: 4,
 			}},
 		},
+		{
+			name: "unordered",
+			args: args{disks: diskSetUnordered},
+			want: &DeviceNamer{state: map[compute.DeviceBus]int{
+				compute.DeviceBusVirtio: 3,
+			}},
+		},
 		{
 			name: "no dev",
 			args: args{disks: diskSetNoDevOrTarget},
@@ -75,3 +90,15 @@ func TestNewDeviceNamerFromDisks(t *testing.T) {
 		})
 	}
 }
+
+func TestDeviceNamerNextSataAfterScsi(t *testing.T) {
+	namer := NewDeviceNamerFromDisks([]libvirtxml.DomainDisk{
+		{Target: &libvirtxml.DomainDiskTarget{Dev: "sda", Bus: "scsi"}},
+	})
+	if got := namer.Next(compute.DeviceBusSata); got != "sdb" {
+		t.Errorf("Next(sata) = %s, want sdb", got)
+	}
+	if got := namer.Next(compute.DeviceBusScsi); got != "sdc" {
+		t.Errorf("Next(scsi) = %s, want sdc", got)
+	}
+}
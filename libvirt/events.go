@@ -0,0 +1,25 @@
+package libvirt
+
+import (
+	"subuk/vmango/util"
+
+	"github.com/libvirt/libvirt-go"
+	"github.com/rs/zerolog"
+)
+
+// StartEventLoop registers libvirt default event implementation and runs it
+// in background. It is required for domain event callbacks, e.g. device removal,
+// and must be called before any connection is opened.
+func StartEventLoop(logger zerolog.Logger) error {
+	if err := libvirt.EventRegisterDefaultImpl(); err != nil {
+		return util.NewError(err, "cannot register libvirt event implementation")
+	}
+	go func() {
+		for {
+			if err := libvirt.EventRunDefaultImpl(); err != nil {
+				logger.Error().Err(err).Msg("libvirt event loop iteration failed")
+			}
+		}
+	}()
+	return nil
+}
@@ -3,6 +3,7 @@ package libvirt
 import (
 	"encoding/xml"
 	"fmt"
+	"sort"
 	"subuk/vmango/compute"
 	"subuk/vmango/util"
 
@@ -83,6 +84,13 @@ func (repo *NodeRepository) Get(nodeId string, options compute.NodeGetOptions) (
 			node.ThreadsPerCore = capsConfig.Host.CPU.Topology.Threads
 		}
 	}
+	if domCapsXml, err := conn.GetDomainCapabilities("", "", "", "", 0); err == nil {
+		domCapsConfig := &libvirtxml.DomainCaps{}
+		if err := domCapsConfig.Unmarshal(domCapsXml); err != nil {
+			return nil, util.NewError(err, "cannot parse domain capabilities")
+		}
+		node.CpuModels = domainCapsCustomCpuModels(domCapsConfig)
+	}
 	switch capsConfig.Host.CPU.Arch {
 	default:
 		node.CpuArch = compute.ArchUnknown
@@ -201,15 +209,14 @@ func (repo *NodeRepository) Get(nodeId string, options compute.NodeGetOptions) (
 					VmId: virDomainConfig.Name,
 				})
 			}
-			continue
-		}
-
-		affinity := ParseCpuAffinity(virDomainConfig.CPUTune.EmulatorPin.CPUSet)
-		for _, cpuId := range affinity {
-			node.Cpus[cpuId].Pins = append(node.Cpus[cpuId].Pins, compute.NodeCpuPin{
-				Desc: "emulator",
-				VmId: virDomainConfig.Name,
-			})
+		} else {
+			affinity := ParseCpuAffinity(virDomainConfig.CPUTune.EmulatorPin.CPUSet)
+			for _, cpuId := range affinity {
+				node.Cpus[cpuId].Pins = append(node.Cpus[cpuId].Pins, compute.NodeCpuPin{
+					Desc: "emulator",
+					VmId: virDomainConfig.Name,
+				})
+			}
 		}
 
 		for _, vcpupin := range virDomainConfig.CPUTune.VCPUPin {
@@ -221,6 +228,15 @@ func (repo *NodeRepository) Get(nodeId string, options compute.NodeGetOptions) (
 				})
 			}
 		}
+
+		for _, iothreadpin := range virDomainConfig.CPUTune.IOThreadPin {
+			for _, cpuId := range ParseCpuAffinity(iothreadpin.CPUSet) {
+				node.Cpus[cpuId].Pins = append(node.Cpus[cpuId].Pins, compute.NodeCpuPin{
+					Desc: fmt.Sprintf("iothread-%d", iothreadpin.IOThread),
+					VmId: virDomainConfig.Name,
+				})
+			}
+		}
 	}
 	if options.CpuNumaIdFilter {
 		newCpus := []compute.NodeCpu{}
@@ -235,3 +251,135 @@ func (repo *NodeRepository) Get(nodeId string, options compute.NodeGetOptions) (
 	}
 	return node, nil
 }
+
+// usbRootHubVendorId is the vendor of virtual root hubs, they cannot be passed to machines
+const usbRootHubVendorId = "0x1d6b"
+
+func nodeDevicePciAddress(addr libvirtxml.NodeDevicePCIAddress) string {
+	if addr.Domain == nil || addr.Bus == nil || addr.Slot == nil || addr.Function == nil {
+		return ""
+	}
+	return formatPciAddress(*addr.Domain, *addr.Bus, *addr.Slot, *addr.Function)
+}
+
+// ListDevices returns pci and usb devices of the node with machines they are attached to
+func (repo *NodeRepository) ListDevices(nodeId string) ([]*compute.NodeDevice, error) {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return nil, util.NewError(err, "cannot acquire libvirt connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	attachedTo := map[string]string{}
+	virDomains, err := conn.ListAllDomains(0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot list node domains")
+	}
+	for _, virDomain := range virDomains {
+		virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+		if err != nil {
+			return nil, util.NewError(err, "cannot get domain xml")
+		}
+		virDomainConfig := &libvirtxml.Domain{}
+		if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
+			return nil, util.NewError(err, "cannot unmarshal domain xml")
+		}
+		if virDomainConfig.Devices == nil {
+			continue
+		}
+		for _, hostdevConfig := range virDomainConfig.Devices.Hostdevs {
+			if device := VirtualMachineAttachedHostDeviceFromHostdevConfig(hostdevConfig); device != nil {
+				attachedTo[device.Type+"/"+device.Address] = virDomainConfig.Name
+			}
+		}
+	}
+
+	virDevices, err := conn.ListAllNodeDevices(libvirt.CONNECT_LIST_NODE_DEVICES_CAP_PCI_DEV | libvirt.CONNECT_LIST_NODE_DEVICES_CAP_USB_DEV)
+	if err != nil {
+		return nil, util.NewError(err, "cannot list node devices")
+	}
+	devices := []*compute.NodeDevice{}
+	for _, virDevice := range virDevices {
+		virDeviceXml, err := virDevice.GetXMLDesc(0)
+		virDevice.Free()
+		if err != nil {
+			return nil, util.NewError(err, "cannot get node device xml")
+		}
+		virDeviceConfig := &libvirtxml.NodeDevice{}
+		if err := virDeviceConfig.Unmarshal(virDeviceXml); err != nil {
+			return nil, util.NewError(err, "cannot parse node device xml")
+		}
+		device := &compute.NodeDevice{
+			NodeId:     nodeId,
+			Name:       virDeviceConfig.Name,
+			IommuGroup: -1,
+		}
+		if virDeviceConfig.Driver != nil {
+			device.Driver = virDeviceConfig.Driver.Name
+		}
+		switch {
+		default:
+			continue
+		case virDeviceConfig.Capability.PCI != nil:
+			pci := virDeviceConfig.Capability.PCI
+			device.Type = compute.NodeDeviceTypePci
+			device.Address = nodeDevicePciAddress(libvirtxml.NodeDevicePCIAddress{Domain: pci.Domain, Bus: pci.Bus, Slot: pci.Slot, Function: pci.Function})
+			device.Class = pci.Class
+			device.Vendor, device.VendorId = pci.Vendor.Name, pci.Vendor.ID
+			device.Product, device.ProductId = pci.Product.Name, pci.Product.ID
+			if pci.IOMMUGroup != nil {
+				device.IommuGroup = pci.IOMMUGroup.Number
+			}
+			for _, subcap := range pci.Capabilities {
+				if subcap.PhysFunction != nil {
+					device.PhysFunction = nodeDevicePciAddress(subcap.PhysFunction.Address)
+				}
+				if subcap.VirtFunctions != nil {
+					for _, vfAddress := range subcap.VirtFunctions.Address {
+						device.VirtFunctions = append(device.VirtFunctions, nodeDevicePciAddress(vfAddress))
+					}
+				}
+			}
+		case virDeviceConfig.Capability.USBDevice != nil:
+			usb := virDeviceConfig.Capability.USBDevice
+			if usb.Vendor.ID == usbRootHubVendorId {
+				continue
+			}
+			device.Type = compute.NodeDeviceTypeUsb
+			device.Address = formatUsbAddress(uint(usb.Bus), uint(usb.Device))
+			device.Vendor, device.VendorId = usb.Vendor.Name, usb.Vendor.ID
+			device.Product, device.ProductId = usb.Product.Name, usb.Product.ID
+		}
+		if device.Address == "" {
+			continue
+		}
+		device.AttachedTo = attachedTo[device.Type+"/"+device.Address]
+		devices = append(devices, device)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		if devices[i].Type != devices[j].Type {
+			return devices[i].Type < devices[j].Type
+		}
+		return devices[i].Address < devices[j].Address
+	})
+	return devices, nil
+}
+
+// domainCapsCustomCpuModels returns usable cpu models for custom cpu mode
+func domainCapsCustomCpuModels(domCapsConfig *libvirtxml.DomainCaps) []string {
+	models := []string{}
+	if domCapsConfig.CPU == nil {
+		return models
+	}
+	for _, mode := range domCapsConfig.CPU.Modes {
+		if mode.Name != compute.CpuModeCustom || mode.Supported != "yes" {
+			continue
+		}
+		for _, model := range mode.Models {
+			if model.Usable == "yes" {
+				models = append(models, model.Name)
+			}
+		}
+	}
+	return models
+}
@@ -17,7 +17,7 @@ type NodeSettings struct {
 	CdSuffix             string
 	Emulator             string
 	QcowPreallocMetadata bool
-	Cache                bool
+	DiskDriver           compute.VirtualMachineVolumeDriver // Defaults for disks attached on the node
 }
 
 func ComputeSizeUnitToLibvirtUnit(input compute.SizeUnit) string {
@@ -50,6 +50,28 @@ func ComputeSizeFromLibvirtSize(unit string, value uint64) compute.Size {
 	}
 }
 
+// libvirtSizeToBytes converts libvirt memory size to bytes,
+// empty unit means KiB as in most memory related elements
+func libvirtSizeToBytes(unit string, value uint64) uint64 {
+	switch strings.ToLower(unit) {
+	case "b", "bytes":
+		return value
+	case "", "k", "kib":
+		return value * 1024
+	case "kb":
+		return value * 1000
+	case "m", "mib":
+		return value * 1024 * 1024
+	case "mb":
+		return value * 1000 * 1000
+	case "g", "gib":
+		return value * 1024 * 1024 * 1024
+	case "gb":
+		return value * 1000 * 1000 * 1000
+	}
+	return value * 1024
+}
+
 func ParseCpuAffinity(input string) []uint {
 	cpus := []uint{}
 	for _, part := range strings.Split(input, ",") {
@@ -4,12 +4,13 @@ import (
 	"fmt"
 	"strings"
 	"subuk/vmango/compute"
+	"subuk/vmango/util"
 
 	"github.com/libvirt/libvirt-go"
 	libvirtxml "github.com/libvirt/libvirt-go-xml"
 )
 
-func DomainDiskConfigFromVirtualMachineAttachedVolume(volume *compute.VirtualMachineAttachedVolume, volTargetFormatType, volumeType string, namer *DeviceNamer) *libvirtxml.DomainDisk {
+func DomainDiskConfigFromVirtualMachineAttachedVolume(volume *compute.VirtualMachineAttachedVolume, driver compute.VirtualMachineVolumeDriver, volTargetFormatType, volumeType string, namer *DeviceNamer) *libvirtxml.DomainDisk {
 	diskDriverType := "raw"
 	if volTargetFormatType == "qcow2" {
 		diskDriverType = "qcow2"
@@ -34,7 +35,15 @@ func DomainDiskConfigFromVirtualMachineAttachedVolume(volume *compute.VirtualMac
 		diskConfig.Device = "disk"
 		diskConfig.Target.Bus = volume.DeviceBus.String()
 		diskConfig.Target.Dev = namer.Next(volume.DeviceBus)
+		diskConfig.Driver.Cache = driver.Cache
+		diskConfig.Driver.IO = driver.Io
+		diskConfig.Driver.Discard = driver.Discard
+		diskConfig.Driver.DetectZeros = driver.DetectZeroes
 	}
+	if volume.BootOrder > 0 {
+		diskConfig.Boot = &libvirtxml.DomainDeviceBoot{Order: volume.BootOrder}
+	}
+	diskConfig.IOTune = DomainDiskIOTuneFromVirtualMachineVolumeIoTune(volume.IoTune)
 	switch volumeType {
 	default:
 		panic(fmt.Errorf("unknown volume type '%s'", volumeType))
@@ -51,6 +60,94 @@ func DomainDiskConfigFromVirtualMachineAttachedVolume(volume *compute.VirtualMac
 	return diskConfig
 }
 
+// DomainDiskIOTuneFromVirtualMachineVolumeIoTune returns nil for unlimited volume
+func DomainDiskIOTuneFromVirtualMachineVolumeIoTune(iotune compute.VirtualMachineVolumeIoTune) *libvirtxml.DomainDiskIOTune {
+	if iotune.Empty() {
+		return nil
+	}
+	return &libvirtxml.DomainDiskIOTune{
+		TotalBytesSec:    iotune.TotalBytesSec,
+		ReadBytesSec:     iotune.ReadBytesSec,
+		WriteBytesSec:    iotune.WriteBytesSec,
+		TotalIopsSec:     iotune.TotalIopsSec,
+		ReadIopsSec:      iotune.ReadIopsSec,
+		WriteIopsSec:     iotune.WriteIopsSec,
+		TotalBytesSecMax: iotune.TotalBytesSecMax,
+		ReadBytesSecMax:  iotune.ReadBytesSecMax,
+		WriteBytesSecMax: iotune.WriteBytesSecMax,
+		TotalIopsSecMax:  iotune.TotalIopsSecMax,
+		ReadIopsSecMax:   iotune.ReadIopsSecMax,
+		WriteIopsSecMax:  iotune.WriteIopsSecMax,
+	}
+}
+
+func VirtualMachineVolumeIoTuneFromDomainDiskIOTune(iotuneConfig *libvirtxml.DomainDiskIOTune) compute.VirtualMachineVolumeIoTune {
+	if iotuneConfig == nil {
+		return compute.VirtualMachineVolumeIoTune{}
+	}
+	return compute.VirtualMachineVolumeIoTune{
+		TotalBytesSec:    iotuneConfig.TotalBytesSec,
+		ReadBytesSec:     iotuneConfig.ReadBytesSec,
+		WriteBytesSec:    iotuneConfig.WriteBytesSec,
+		TotalIopsSec:     iotuneConfig.TotalIopsSec,
+		ReadIopsSec:      iotuneConfig.ReadIopsSec,
+		WriteIopsSec:     iotuneConfig.WriteIopsSec,
+		TotalBytesSecMax: iotuneConfig.TotalBytesSecMax,
+		ReadBytesSecMax:  iotuneConfig.ReadBytesSecMax,
+		WriteBytesSecMax: iotuneConfig.WriteBytesSecMax,
+		TotalIopsSecMax:  iotuneConfig.TotalIopsSecMax,
+		ReadIopsSecMax:   iotuneConfig.ReadIopsSecMax,
+		WriteIopsSecMax:  iotuneConfig.WriteIopsSecMax,
+	}
+}
+
+// setDomainDiskSource points disk to another volume, only file and block volumes are supported
+func setDomainDiskSource(diskConfig *libvirtxml.DomainDisk, path string, virVolumeConfig *libvirtxml.StorageVolume) error {
+	switch virVolumeConfig.Type {
+	default:
+		return fmt.Errorf("unsupported volume type '%s' of %s", virVolumeConfig.Type, path)
+	case "file":
+		diskConfig.Source = &libvirtxml.DomainDiskSource{
+			File: &libvirtxml.DomainDiskSourceFile{File: path},
+		}
+	case "block":
+		diskConfig.Source = &libvirtxml.DomainDiskSource{
+			Block: &libvirtxml.DomainDiskSourceBlock{Dev: path},
+		}
+	}
+	if diskConfig.Driver == nil {
+		diskConfig.Driver = &libvirtxml.DomainDiskDriver{Name: "qemu"}
+	}
+	diskConfig.Driver.Type = "raw"
+	if getVolTargetFormatType(virVolumeConfig) == "qcow2" {
+		diskConfig.Driver.Type = "qcow2"
+	}
+	return nil
+}
+
+// applyDomainDiskDrivers sets driver modes of all disks to user overrides merged with node defaults
+func applyDomainDiskDrivers(disks []libvirtxml.DomainDisk, overrides map[string]compute.VirtualMachineVolumeDriver, defaults compute.VirtualMachineVolumeDriver) error {
+	for idx := range disks {
+		diskConfig := &disks[idx]
+		if diskConfig.Device != "disk" {
+			continue
+		}
+		path := VirtualMachineAttachedVolumeFromDomainDiskConfig(*diskConfig).Path
+		driver := overrides[path].WithDefaults(defaults)
+		if err := driver.Validate(); err != nil {
+			return fmt.Errorf("invalid driver of disk %s: %s", path, err)
+		}
+		if diskConfig.Driver == nil {
+			diskConfig.Driver = &libvirtxml.DomainDiskDriver{Name: "qemu"}
+		}
+		diskConfig.Driver.Cache = driver.Cache
+		diskConfig.Driver.IO = driver.Io
+		diskConfig.Driver.Discard = driver.Discard
+		diskConfig.Driver.DetectZeros = driver.DetectZeroes
+	}
+	return nil
+}
+
 func VirtualMachineAttachedVolumeFromDomainDiskConfig(diskConfig libvirtxml.DomainDisk) *compute.VirtualMachineAttachedVolume {
 	volume := &compute.VirtualMachineAttachedVolume{}
 	volume.DeviceBus = compute.NewDeviceBus(diskConfig.Target.Bus)
@@ -70,6 +167,11 @@ func VirtualMachineAttachedVolumeFromDomainDiskConfig(diskConfig libvirtxml.Doma
 		volume.DeviceType = compute.DeviceTypeCdrom
 	}
 
+	volume.IoTune = VirtualMachineVolumeIoTuneFromDomainDiskIOTune(diskConfig.IOTune)
+	if diskConfig.Boot != nil {
+		volume.BootOrder = diskConfig.Boot.Order
+	}
+
 	if diskConfig.Source != nil {
 		if diskConfig.Source.File != nil {
 			volume.Path = diskConfig.Source.File.File
@@ -81,6 +183,52 @@ func VirtualMachineAttachedVolumeFromDomainDiskConfig(diskConfig libvirtxml.Doma
 	return volume
 }
 
+func domainInterfaceBandwidthParams(limit compute.VirtualMachineInterfaceBandwidthLimit) *libvirtxml.DomainInterfaceBandwidthParams {
+	if limit.Average == 0 {
+		return nil
+	}
+	params := &libvirtxml.DomainInterfaceBandwidthParams{}
+	average := int(limit.Average)
+	params.Average = &average
+	if limit.Peak > 0 {
+		peak := int(limit.Peak)
+		params.Peak = &peak
+	}
+	if limit.Burst > 0 {
+		burst := int(limit.Burst)
+		params.Burst = &burst
+	}
+	return params
+}
+
+// DomainInterfaceBandwidthFromVirtualMachineInterfaceBandwidth returns nil for unlimited interface
+func DomainInterfaceBandwidthFromVirtualMachineInterfaceBandwidth(bandwidth compute.VirtualMachineInterfaceBandwidth) *libvirtxml.DomainInterfaceBandwidth {
+	if bandwidth.Empty() {
+		return nil
+	}
+	return &libvirtxml.DomainInterfaceBandwidth{
+		Inbound:  domainInterfaceBandwidthParams(bandwidth.Inbound),
+		Outbound: domainInterfaceBandwidthParams(bandwidth.Outbound),
+	}
+}
+
+func virtualMachineInterfaceBandwidthLimit(params *libvirtxml.DomainInterfaceBandwidthParams) compute.VirtualMachineInterfaceBandwidthLimit {
+	limit := compute.VirtualMachineInterfaceBandwidthLimit{}
+	if params == nil {
+		return limit
+	}
+	if params.Average != nil {
+		limit.Average = uint(*params.Average)
+	}
+	if params.Peak != nil {
+		limit.Peak = uint(*params.Peak)
+	}
+	if params.Burst != nil {
+		limit.Burst = uint(*params.Burst)
+	}
+	return limit
+}
+
 func VirtualMachineAttachedInterfaceFromInterfaceConfig(ifaceConfig libvirtxml.DomainInterface) *compute.VirtualMachineAttachedInterface {
 	iface := &compute.VirtualMachineAttachedInterface{}
 	iface.Mac = ifaceConfig.MAC.Address
@@ -96,20 +244,149 @@ func VirtualMachineAttachedInterfaceFromInterfaceConfig(ifaceConfig libvirtxml.D
 		}
 	}
 
+	if ifaceConfig.Boot != nil {
+		iface.BootOrder = ifaceConfig.Boot.Order
+	}
+
 	if ifaceConfig.VLan != nil {
 		if len(ifaceConfig.VLan.Tags) == 1 && ifaceConfig.VLan.Trunk == "" {
 			iface.AccessVlan = ifaceConfig.VLan.Tags[0].ID
 		}
 	}
+	if ifaceConfig.Bandwidth != nil {
+		iface.Bandwidth.Inbound = virtualMachineInterfaceBandwidthLimit(ifaceConfig.Bandwidth.Inbound)
+		iface.Bandwidth.Outbound = virtualMachineInterfaceBandwidthLimit(ifaceConfig.Bandwidth.Outbound)
+	}
 	return iface
 }
 
+func formatPciAddress(domain, bus, slot, function uint) string {
+	return fmt.Sprintf("%04x:%02x:%02x.%x", domain, bus, slot, function)
+}
+
+func formatUsbAddress(bus, device uint) string {
+	return fmt.Sprintf("%03d:%03d", bus, device)
+}
+
+// VirtualMachineAttachedHostDeviceFromHostdevConfig returns nil for
+// host devices other than pci and usb devices specified by address
+func VirtualMachineAttachedHostDeviceFromHostdevConfig(hostdevConfig libvirtxml.DomainHostdev) *compute.VirtualMachineAttachedHostDevice {
+	if pci := hostdevConfig.SubsysPCI; pci != nil && pci.Source != nil && pci.Source.Address != nil {
+		addr := pci.Source.Address
+		if addr.Domain == nil || addr.Bus == nil || addr.Slot == nil || addr.Function == nil {
+			return nil
+		}
+		return &compute.VirtualMachineAttachedHostDevice{
+			Type:    compute.NodeDeviceTypePci,
+			Address: formatPciAddress(*addr.Domain, *addr.Bus, *addr.Slot, *addr.Function),
+		}
+	}
+	if usb := hostdevConfig.SubsysUSB; usb != nil && usb.Source != nil && usb.Source.Address != nil {
+		addr := usb.Source.Address
+		if addr.Bus == nil || addr.Device == nil {
+			return nil
+		}
+		return &compute.VirtualMachineAttachedHostDevice{
+			Type:    compute.NodeDeviceTypeUsb,
+			Address: formatUsbAddress(*addr.Bus, *addr.Device),
+		}
+	}
+	return nil
+}
+
+func DomainHostdevConfigFromVirtualMachineAttachedHostDevice(device *compute.VirtualMachineAttachedHostDevice) (*libvirtxml.DomainHostdev, error) {
+	switch device.Type {
+	default:
+		return nil, fmt.Errorf("unknown host device type %s", device.Type)
+	case compute.NodeDeviceTypePci:
+		var domain, bus, slot, function uint
+		if _, err := fmt.Sscanf(device.Address, "%x:%x:%x.%x", &domain, &bus, &slot, &function); err != nil {
+			return nil, util.NewError(err, "invalid pci address %s", device.Address)
+		}
+		return &libvirtxml.DomainHostdev{
+			Managed: "yes",
+			SubsysPCI: &libvirtxml.DomainHostdevSubsysPCI{
+				Source: &libvirtxml.DomainHostdevSubsysPCISource{
+					Address: &libvirtxml.DomainAddressPCI{Domain: &domain, Bus: &bus, Slot: &slot, Function: &function},
+				},
+			},
+		}, nil
+	case compute.NodeDeviceTypeUsb:
+		var bus, dev uint
+		if _, err := fmt.Sscanf(device.Address, "%d:%d", &bus, &dev); err != nil {
+			return nil, util.NewError(err, "invalid usb address %s", device.Address)
+		}
+		return &libvirtxml.DomainHostdev{
+			SubsysUSB: &libvirtxml.DomainHostdevSubsysUSB{
+				Source: &libvirtxml.DomainHostdevSubsysUSBSource{
+					Address: &libvirtxml.DomainAddressUSB{Bus: &bus, Device: &dev},
+				},
+			},
+		}, nil
+	}
+}
+
 func VirtualMachineFromDomainConfig(domainConfig *libvirtxml.Domain, domainInfo *libvirt.DomainInfo) (*compute.VirtualMachine, error) {
 	vm := &compute.VirtualMachine{}
 	vm.Id = domainConfig.Name
 	vm.VCpus = int(domainConfig.VCPU.Value)
+	vm.MaxVCpus = int(domainConfig.VCPU.Value)
+	if domainConfig.VCPU.Current > 0 {
+		vm.VCpus = int(domainConfig.VCPU.Current)
+	}
 	vm.Memory = ComputeSizeFromLibvirtSize(domainConfig.Memory.Unit, uint64(domainConfig.Memory.Value))
+	if domainConfig.MaximumMemory != nil && domainConfig.MaximumMemory.Slots > 0 {
+		vm.MaxMemory = compute.NewSize(libvirtSizeToBytes(domainConfig.MaximumMemory.Unit, uint64(domainConfig.MaximumMemory.Value)), compute.SizeUnitB)
+		vm.MemorySlots = int(domainConfig.MaximumMemory.Slots)
+	}
+	dimmMemory := uint64(0)
+	for _, memorydev := range domainConfig.Devices.Memorydevs {
+		if memorydev.Model == "dimm" && memorydev.Target != nil && memorydev.Target.Size != nil {
+			dimmMemory += libvirtSizeToBytes(memorydev.Target.Size.Unit, uint64(memorydev.Target.Size.Value))
+		}
+	}
+	vm.DimmMemory = compute.NewSize(dimmMemory, compute.SizeUnitB)
 	vm.Firmware = domainConfig.OS.Firmware
+	if domainConfig.CPU != nil {
+		vm.Cpu.Mode = domainConfig.CPU.Mode
+		if vm.Cpu.Mode == "" {
+			vm.Cpu.Mode = compute.CpuModeCustom
+		}
+		if domainConfig.CPU.Model != nil {
+			vm.Cpu.Model = domainConfig.CPU.Model.Value
+		}
+		for _, feature := range domainConfig.CPU.Features {
+			vm.Cpu.Features = append(vm.Cpu.Features, compute.VirtualMachineCpuFeature{Name: feature.Name, Policy: feature.Policy})
+		}
+		if domainConfig.CPU.Topology != nil {
+			vm.Cpu.Sockets = domainConfig.CPU.Topology.Sockets
+			vm.Cpu.Cores = domainConfig.CPU.Topology.Cores
+			vm.Cpu.Threads = domainConfig.CPU.Topology.Threads
+		}
+	}
+	vm.BootMenu = domainConfig.OS.BootMenu != nil && domainConfig.OS.BootMenu.Enable == "yes"
+	if domainConfig.OS.Loader != nil && domainConfig.OS.Loader.Secure == "yes" {
+		vm.SecureBoot = true
+	}
+	if domainConfig.OS.FirmwareInfo != nil {
+		for _, feature := range domainConfig.OS.FirmwareInfo.Features {
+			if feature.Name == "secure-boot" && feature.Enabled == "yes" {
+				vm.SecureBoot = true
+			}
+		}
+	}
+	vm.Tpm = len(domainConfig.Devices.TPMs) > 0
+	vm.Rng = len(domainConfig.Devices.RNGs) > 0
+	if domainConfig.Devices.Watchdog != nil {
+		vm.Watchdog = domainConfig.Devices.Watchdog.Action
+		if vm.Watchdog == "" {
+			vm.Watchdog = "reset"
+		}
+	}
+	vm.Pvpanic = len(domainConfig.Devices.Panics) > 0
+	vm.OnPoweroff = domainConfig.OnPoweroff
+	vm.OnReboot = domainConfig.OnReboot
+	vm.OnCrash = domainConfig.OnCrash
 
 	switch domainConfig.OS.Type.Arch {
 	default:
@@ -141,8 +418,9 @@ func VirtualMachineFromDomainConfig(domainConfig *libvirtxml.Domain, domainInfo
 
 	if domainConfig.CPUTune != nil {
 		vm.Cpupin = &compute.VirtualMachineCpuPin{
-			Vcpus:    map[uint][]uint{},
-			Emulator: []uint{},
+			Vcpus:     map[uint][]uint{},
+			Emulator:  []uint{},
+			IOThreads: map[uint][]uint{},
 		}
 		for _, vcpupin := range domainConfig.CPUTune.VCPUPin {
 			vm.Cpupin.Vcpus[vcpupin.VCPU] = ParseCpuAffinity(vcpupin.CPUSet)
@@ -150,7 +428,11 @@ func VirtualMachineFromDomainConfig(domainConfig *libvirtxml.Domain, domainInfo
 		if domainConfig.CPUTune.EmulatorPin != nil {
 			vm.Cpupin.Emulator = ParseCpuAffinity(domainConfig.CPUTune.EmulatorPin.CPUSet)
 		}
+		for _, iothreadpin := range domainConfig.CPUTune.IOThreadPin {
+			vm.Cpupin.IOThreads[iothreadpin.IOThread] = ParseCpuAffinity(iothreadpin.CPUSet)
+		}
 	}
+	vm.IOThreads = domainConfig.IOThreads
 
 	for _, netInterfaceConfig := range domainConfig.Devices.Interfaces {
 		iface := VirtualMachineAttachedInterfaceFromInterfaceConfig(netInterfaceConfig)
@@ -161,6 +443,11 @@ func VirtualMachineFromDomainConfig(domainConfig *libvirtxml.Domain, domainInfo
 		volume := VirtualMachineAttachedVolumeFromDomainDiskConfig(diskConfig)
 		vm.Volumes = append(vm.Volumes, volume)
 	}
+	for _, hostdevConfig := range domainConfig.Devices.Hostdevs {
+		if device := VirtualMachineAttachedHostDeviceFromHostdevConfig(hostdevConfig); device != nil {
+			vm.HostDevices = append(vm.HostDevices, device)
+		}
+	}
 	for _, channel := range domainConfig.Devices.Channels {
 		if channel.Target != nil && channel.Target.VirtIO != nil && channel.Target.VirtIO.Name == "org.qemu.guest_agent.0" {
 			vm.GuestAgent = true
@@ -169,7 +456,56 @@ func VirtualMachineFromDomainConfig(domainConfig *libvirtxml.Domain, domainInfo
 
 	if domainConfig.MemoryBacking != nil && domainConfig.MemoryBacking.MemoryHugePages != nil {
 		vm.Hugepages = true
+		for _, page := range domainConfig.MemoryBacking.MemoryHugePages.Hugepages {
+			switch libvirtSizeToBytes(page.Unit, uint64(page.Size)) {
+			case 2 * 1024 * 1024:
+				vm.HugepageSize = compute.HugepageSize2M
+			case 1024 * 1024 * 1024:
+				vm.HugepageSize = compute.HugepageSize1G
+			}
+		}
+	}
+	if domainConfig.NUMATune != nil && domainConfig.NUMATune.Memory != nil && domainConfig.NUMATune.Memory.Nodeset != "" {
+		vm.Numa.Mode = domainConfig.NUMATune.Memory.Mode
+		if vm.Numa.Mode == "" {
+			vm.Numa.Mode = compute.NumaModeStrict // Libvirt default
+		}
+		vm.Numa.Nodeset = ParseCpuAffinity(domainConfig.NUMATune.Memory.Nodeset)
+	}
+	if domainConfig.CPU != nil && domainConfig.CPU.Numa != nil {
+		for _, cell := range domainConfig.CPU.Numa.Cell {
+			vm.Numa.Cells = append(vm.Numa.Cells, compute.VirtualMachineNumaCell{
+				Cpus:   ParseCpuAffinity(cell.CPUs),
+				Memory: compute.NewSize(libvirtSizeToBytes(cell.Unit, uint64(cell.Memory)), compute.SizeUnitB),
+			})
+		}
+		// Single cell with all vcpus is added for memory hotplug, it is the same as no numa
+		if len(vm.Numa.Cells) == 1 && len(vm.Numa.Cells[0].Cpus) == vm.MaxVCpuCount() {
+			vm.Numa.Cells = nil
+		}
+	}
+
+	metadata, err := parseVmangoDomainMetadata(domainConfig)
+	if err != nil {
+		return nil, err
+	}
+	vm.Flavor = metadata.Flavor
+	vm.Tags = metadata.Tags
+	vm.Owner = metadata.Owner
+	if !metadata.CpuTopology {
+		// Derived topology is not a user setting, it changes with vcpu count
+		vm.Cpu.Sockets, vm.Cpu.Cores, vm.Cpu.Threads = 0, 0, 0
+	}
+	// Driver modes in domain xml include node defaults, only user overrides are kept
+	diskDrivers := metadata.DiskDriverOverrides()
+	for _, volume := range vm.Volumes {
+		volume.Driver = diskDrivers[volume.Path]
+	}
+	expiresAt, err := metadata.ExpiresAt()
+	if err != nil {
+		return nil, err
 	}
+	vm.ExpiresAt = expiresAt
 
 	for _, graphic := range domainConfig.Devices.Graphics {
 		if graphic.VNC != nil {
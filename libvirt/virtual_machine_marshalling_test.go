@@ -0,0 +1,51 @@
+package libvirt
+
+import (
+	"subuk/vmango/compute"
+	"testing"
+
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+func TestApplyDomainDiskDrivers(t *testing.T) {
+	disks := []libvirtxml.DomainDisk{
+		libvirtxml.DomainDisk{
+			Device: "disk",
+			Driver: &libvirtxml.DomainDiskDriver{Name: "qemu", Type: "qcow2", Cache: "writeback"},
+			Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/var/lib/libvirt/images/root.img"}},
+			Target: &libvirtxml.DomainDiskTarget{Dev: "vda", Bus: "virtio"},
+		},
+		libvirtxml.DomainDisk{
+			Device: "disk",
+			Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/var/lib/libvirt/images/data.img"}},
+			Target: &libvirtxml.DomainDiskTarget{Dev: "vdb", Bus: "virtio"},
+		},
+		libvirtxml.DomainDisk{
+			Device: "cdrom",
+			Source: &libvirtxml.DomainDiskSource{File: &libvirtxml.DomainDiskSourceFile{File: "/var/lib/libvirt/images/cd.iso"}},
+			Target: &libvirtxml.DomainDiskTarget{Dev: "hdc", Bus: "ide"},
+		},
+	}
+	overrides := map[string]compute.VirtualMachineVolumeDriver{
+		"/var/lib/libvirt/images/data.img": compute.VirtualMachineVolumeDriver{Cache: "none"},
+	}
+	defaults := compute.VirtualMachineVolumeDriver{Cache: "directsync", Discard: "unmap"}
+	if err := applyDomainDiskDrivers(disks, overrides, defaults); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if disks[0].Driver.Cache != "directsync" || disks[0].Driver.Discard != "unmap" || disks[0].Driver.Type != "qcow2" {
+		t.Errorf("disk without override got driver %+v, want node defaults", disks[0].Driver)
+	}
+	if disks[1].Driver.Cache != "none" || disks[1].Driver.Discard != "unmap" {
+		t.Errorf("disk with override got driver %+v, want override merged with defaults", disks[1].Driver)
+	}
+	if disks[2].Driver != nil {
+		t.Errorf("cdrom got driver %+v, want none", disks[2].Driver)
+	}
+
+	overrides["/var/lib/libvirt/images/data.img"] = compute.VirtualMachineVolumeDriver{Io: "native"}
+	defaults = compute.VirtualMachineVolumeDriver{Cache: "writeback"}
+	if err := applyDomainDiskDrivers(disks, overrides, defaults); err == nil {
+		t.Errorf("io native with inherited writeback cache must fail")
+	}
+}
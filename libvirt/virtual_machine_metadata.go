@@ -0,0 +1,139 @@
+package libvirt
+
+import (
+	"encoding/xml"
+	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"time"
+
+	libvirt "github.com/libvirt/libvirt-go"
+	libvirtxml "github.com/libvirt/libvirt-go-xml"
+)
+
+const vmangoMetadataNamespace = "https://github.com/subuk/vmango/xmlns/instance/1"
+const vmangoMetadataPrefix = "vmango"
+
+// vmangoDomainMetadata is stored in domain <metadata> element
+// and contains vmango specific machine settings
+type vmangoDomainMetadata struct {
+	XMLName xml.Name `xml:"instance"`
+	Flavor  string   `xml:"flavor,omitempty"`
+	Tags    []string `xml:"tags>tag,omitempty"`
+	Owner   string   `xml:"owner,omitempty"`
+	Expires string   `xml:"expires,omitempty"`
+	// CpuTopology is set when cpu topology was specified by user,
+	// otherwise it is derived from vcpu count and host threads on every save
+	CpuTopology bool `xml:"cpu-topology,omitempty"`
+	// DiskDrivers contains only driver modes set by user, node defaults
+	// are merged into domain xml on every save and may change later
+	DiskDrivers []vmangoDiskDriver `xml:"disk-drivers>disk,omitempty"`
+}
+
+type vmangoDiskDriver struct {
+	Path         string `xml:"path,attr"`
+	Cache        string `xml:"cache,attr,omitempty"`
+	Io           string `xml:"io,attr,omitempty"`
+	Discard      string `xml:"discard,attr,omitempty"`
+	DetectZeroes string `xml:"detect_zeroes,attr,omitempty"`
+}
+
+func newVmangoDomainMetadata(vm *compute.VirtualMachine) *vmangoDomainMetadata {
+	metadata := &vmangoDomainMetadata{Flavor: vm.Flavor, Tags: vm.Tags, Owner: vm.Owner, CpuTopology: vm.Cpu.HasTopology()}
+	if vm.HasExpiry() {
+		metadata.Expires = vm.ExpiresAt.Format(time.RFC3339)
+	}
+	for _, volume := range vm.Volumes {
+		metadata.SetDiskDriver(volume.Path, volume.Driver)
+	}
+	return metadata
+}
+
+// DiskDriverOverrides returns driver modes set by user by volume path
+func (metadata *vmangoDomainMetadata) DiskDriverOverrides() map[string]compute.VirtualMachineVolumeDriver {
+	overrides := map[string]compute.VirtualMachineVolumeDriver{}
+	for _, disk := range metadata.DiskDrivers {
+		overrides[disk.Path] = compute.VirtualMachineVolumeDriver{Cache: disk.Cache, Io: disk.Io, Discard: disk.Discard, DetectZeroes: disk.DetectZeroes}
+	}
+	return overrides
+}
+
+// SetDiskDriver replaces driver override of the volume, empty driver removes it
+func (metadata *vmangoDomainMetadata) SetDiskDriver(path string, driver compute.VirtualMachineVolumeDriver) {
+	disks := []vmangoDiskDriver{}
+	for _, disk := range metadata.DiskDrivers {
+		if disk.Path != path {
+			disks = append(disks, disk)
+		}
+	}
+	if !driver.Empty() {
+		disks = append(disks, vmangoDiskDriver{Path: path, Cache: driver.Cache, Io: driver.Io, Discard: driver.Discard, DetectZeroes: driver.DetectZeroes})
+	}
+	metadata.DiskDrivers = disks
+}
+
+// RenameDiskDriver moves driver override to the new volume path
+func (metadata *vmangoDomainMetadata) RenameDiskDriver(oldPath, newPath string) {
+	for idx := range metadata.DiskDrivers {
+		if metadata.DiskDrivers[idx].Path == oldPath {
+			metadata.DiskDrivers[idx].Path = newPath
+		}
+	}
+}
+
+func (metadata *vmangoDomainMetadata) ExpiresAt() (time.Time, error) {
+	if metadata.Expires == "" {
+		return time.Time{}, nil
+	}
+	expiresAt, err := time.Parse(time.RFC3339, metadata.Expires)
+	if err != nil {
+		return time.Time{}, util.NewError(err, "cannot parse expiration time")
+	}
+	return expiresAt, nil
+}
+
+func parseVmangoDomainMetadata(domainConfig *libvirtxml.Domain) (*vmangoDomainMetadata, error) {
+	metadata := &vmangoDomainMetadata{}
+	if domainConfig.Metadata == nil {
+		return metadata, nil
+	}
+	wrapper := struct {
+		Instance *vmangoDomainMetadata `xml:"https://github.com/subuk/vmango/xmlns/instance/1 instance"`
+	}{}
+	if err := xml.Unmarshal([]byte("<metadata>"+domainConfig.Metadata.XML+"</metadata>"), &wrapper); err != nil {
+		return nil, util.NewError(err, "cannot parse domain metadata")
+	}
+	if wrapper.Instance != nil {
+		metadata = wrapper.Instance
+	}
+	return metadata, nil
+}
+
+// updateVmangoDomainMetadata changes metadata stored in persistent domain config
+func updateVmangoDomainMetadata(virDomain *libvirt.Domain, update func(metadata *vmangoDomainMetadata)) error {
+	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return util.NewError(err, "cannot get domain xml")
+	}
+	virDomainConfig := &libvirtxml.Domain{}
+	if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+	metadata, err := parseVmangoDomainMetadata(virDomainConfig)
+	if err != nil {
+		return err
+	}
+	metadata.XMLName = xml.Name{} // Namespace is added by libvirt
+	update(metadata)
+	return setVmangoDomainMetadata(virDomain, metadata)
+}
+
+func setVmangoDomainMetadata(virDomain *libvirt.Domain, metadata *vmangoDomainMetadata) error {
+	content, err := xml.Marshal(metadata)
+	if err != nil {
+		return util.NewError(err, "cannot marshal domain metadata")
+	}
+	if err := virDomain.SetMetadata(libvirt.DOMAIN_METADATA_ELEMENT, string(content), vmangoMetadataPrefix, vmangoMetadataNamespace, libvirt.DOMAIN_AFFECT_CONFIG); err != nil {
+		return util.NewError(err, "cannot set domain metadata")
+	}
+	return nil
+}
@@ -1,12 +1,16 @@
 package libvirt
 
 import (
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/url"
 	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"subuk/vmango/compute"
@@ -69,7 +73,14 @@ func (repo *VirtualMachineRepository) parseConfigDrive(conn *libvirt.Connect, vo
 		return nil, util.NewError(err, "cannot parse configdrive iso")
 	}
 	config := &compute.VirtualMachineConfig{
-		Hostname: data.Hostname(),
+		Hostname:   data.Hostname(),
+		InstanceId: data.InstanceId(),
+	}
+	switch typedData := data.(type) {
+	case *configdrive.NoCloud:
+		config.Userdata = typedData.Userdata
+	case *configdrive.Openstack:
+		config.Userdata = typedData.Userdata
 	}
 	for _, rawKey := range data.PublicKeys() {
 		pubkey, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(rawKey))
@@ -172,8 +183,12 @@ func (repo *VirtualMachineRepository) domainToVm(conn *libvirt.Connect, nodeId s
 	return vm, nil
 }
 
-func (repo *VirtualMachineRepository) attachInterface(virDomainConfig *libvirtxml.Domain, attachedIface *compute.VirtualMachineAttachedInterface) error {
-	if attachedIface.NetworkName == "macos-socket-vmnet" || attachedIface.NetworkName == "qemu-usernet" {
+func isQemuArgsInterface(networkName string) bool {
+	return networkName == "macos-socket-vmnet" || networkName == "qemu-usernet"
+}
+
+func (repo *VirtualMachineRepository) attachInterface(conn *libvirt.Connect, virDomainConfig *libvirtxml.Domain, attachedIface *compute.VirtualMachineAttachedInterface) error {
+	if isQemuArgsInterface(attachedIface.NetworkName) {
 		if virDomainConfig.QEMUCommandline == nil {
 			virDomainConfig.QEMUCommandline = &libvirtxml.DomainQEMUCommandline{}
 		}
@@ -219,6 +234,13 @@ func (repo *VirtualMachineRepository) attachInterface(virDomainConfig *libvirtxm
 		return nil
 	}
 
+	virDomainConfig.Devices.Interfaces = append(virDomainConfig.Devices.Interfaces, repo.interfaceConfig(conn, attachedIface))
+	return nil
+}
+
+// interfaceConfig creates interface connected to libvirt network or,
+// if there is no such network, to host bridge with the same name
+func (repo *VirtualMachineRepository) interfaceConfig(conn *libvirt.Connect, attachedIface *compute.VirtualMachineAttachedInterface) libvirtxml.DomainInterface {
 	if attachedIface.Model == "" {
 		attachedIface.Model = "virtio"
 	}
@@ -231,13 +253,23 @@ func (repo *VirtualMachineRepository) attachInterface(virDomainConfig *libvirtxm
 	domainIface.Source.Network = &libvirtxml.DomainInterfaceSourceNetwork{
 		Network: attachedIface.NetworkName,
 	}
+	if virNetwork, err := conn.LookupNetworkByName(attachedIface.NetworkName); err == nil {
+		virNetwork.Free()
+	} else if virIface, err := conn.LookupInterfaceByName(attachedIface.NetworkName); err == nil {
+		virIface.Free()
+		domainIface.Source.Network = nil
+		domainIface.Source.Bridge = &libvirtxml.DomainInterfaceSourceBridge{Bridge: attachedIface.NetworkName}
+	}
+	if attachedIface.BootOrder > 0 {
+		domainIface.Boot = &libvirtxml.DomainDeviceBoot{Order: attachedIface.BootOrder}
+	}
 	if attachedIface.AccessVlan > 0 {
 		domainIface.VLan = &libvirtxml.DomainInterfaceVLan{
 			Tags: []libvirtxml.DomainInterfaceVLanTag{libvirtxml.DomainInterfaceVLanTag{ID: attachedIface.AccessVlan}},
 		}
 	}
-	virDomainConfig.Devices.Interfaces = append(virDomainConfig.Devices.Interfaces, domainIface)
-	return nil
+	domainIface.Bandwidth = DomainInterfaceBandwidthFromVirtualMachineInterfaceBandwidth(attachedIface.Bandwidth)
+	return domainIface
 }
 
 type virStreamReadWriteCloser struct {
@@ -382,7 +414,11 @@ func (repo *VirtualMachineRepository) GetGraphicStream(id, nodeId string) (compu
 
 func (repo *VirtualMachineRepository) generateNewDomainConfig(conn *libvirt.Connect, vm *compute.VirtualMachine) (*libvirtxml.Domain, error) {
 	settings := repo.settings[vm.NodeId]
-	domCapsXml, err := conn.GetDomainCapabilities(settings.Emulator, vm.Arch.String(), "", "", 0)
+	machine := ""
+	if vm.SecureBoot {
+		machine = "q35" // SMM required for secure boot is available only on q35
+	}
+	domCapsXml, err := conn.GetDomainCapabilities(settings.Emulator, vm.Arch.String(), machine, "", 0)
 	if err != nil {
 		return nil, util.NewError(err, "cannot fetch domain capabilities")
 	}
@@ -405,18 +441,83 @@ func (repo *VirtualMachineRepository) generateNewDomainConfig(conn *libvirt.Conn
 	if vm.Firmware != "" {
 		virDomainConfig.OS.Firmware = vm.Firmware
 	}
+	if vm.SecureBoot {
+		loader, err := domainCapsSecureBootLoader(domCapsConfig)
+		if err != nil {
+			return nil, util.NewError(err, "secure boot is not supported on node %s", vm.NodeId)
+		}
+		// Nvram template is selected by libvirt for the loader from firmware descriptors
+		virDomainConfig.OS.Firmware = ""
+		virDomainConfig.OS.Loader = &libvirtxml.DomainLoader{Path: loader, Readonly: "yes", Type: "pflash", Secure: "yes"}
+		virDomainConfig.Features = &libvirtxml.DomainFeatureList{
+			ACPI: &libvirtxml.DomainFeature{},
+			APIC: &libvirtxml.DomainFeatureAPIC{},
+			SMM:  &libvirtxml.DomainFeatureSMM{State: "on"},
+		}
+	}
+	if vm.BootMenu {
+		virDomainConfig.OS.BootMenu = &libvirtxml.DomainBootMenu{Enable: "yes"}
+	}
 	virDomainConfig.CPU = &libvirtxml.DomainCPU{}
-	virDomainConfig.CPU.Mode = "maximum"
 	virDomainConfig.Clock = &libvirtxml.DomainClock{Offset: "utc"}
-	virDomainConfig.OnPoweroff = "destroy"
-	virDomainConfig.OnReboot = "restart"
-	virDomainConfig.OnCrash = "destroy"
+	virDomainConfig.OnPoweroff = compute.DefaultOnPoweroff
+	virDomainConfig.OnReboot = compute.DefaultOnReboot
+	virDomainConfig.OnCrash = compute.DefaultOnCrash
 	virDomainConfig.Devices = &libvirtxml.DomainDeviceList{Emulator: domCapsConfig.Path}
 	virDomainConfig.Devices.Consoles = append(virDomainConfig.Devices.Consoles, libvirtxml.DomainConsole{})
 
 	return virDomainConfig, nil
 }
 
+// setDomainCpu sets cpu mode, model and features keeping topology and numa settings
+func setDomainCpu(cpuConfig *libvirtxml.DomainCPU, cpu compute.VirtualMachineCpu) {
+	cpuConfig.Mode = cpu.Mode
+	if cpuConfig.Mode == "" {
+		cpuConfig.Mode = compute.CpuModeMaximum
+	}
+	cpuConfig.Match = ""
+	cpuConfig.Check = ""
+	cpuConfig.Model = nil
+	if cpu.Mode == compute.CpuModeCustom {
+		// Fallback is forbidden to keep exactly the same cpu on all nodes for migration
+		cpuConfig.Match = "exact"
+		cpuConfig.Model = &libvirtxml.DomainCPUModel{Value: cpu.Model, Fallback: "forbid"}
+	}
+	cpuConfig.Features = nil
+	for _, feature := range cpu.Features {
+		cpuConfig.Features = append(cpuConfig.Features, libvirtxml.DomainCPUFeature{Name: feature.Name, Policy: feature.Policy})
+	}
+}
+
+// domainCapsSecureBootLoader returns path of secure boot capable efi loader
+// from domain capabilities, error describes what is missing on the node
+func domainCapsSecureBootLoader(domCapsConfig *libvirtxml.DomainCaps) (string, error) {
+	if domCapsConfig.OS == nil || domCapsConfig.OS.Loader == nil || domCapsConfig.OS.Loader.Supported != "yes" {
+		return "", fmt.Errorf("efi loader is not supported")
+	}
+	secure := false
+	for _, enum := range domCapsConfig.OS.Loader.Enums {
+		if enum.Name != "secure" {
+			continue
+		}
+		for _, value := range enum.Values {
+			if value == "yes" {
+				secure = true
+			}
+		}
+	}
+	if !secure {
+		return "", fmt.Errorf("secure loader is not supported by hypervisor")
+	}
+	for _, loader := range domCapsConfig.OS.Loader.Values {
+		name := strings.ToLower(filepath.Base(loader))
+		if strings.Contains(name, "secboot") || strings.Contains(name, ".ms.") || strings.Contains(name, "secure") {
+			return loader, nil
+		}
+	}
+	return "", fmt.Errorf("no secure boot efi firmware found, install ovmf with secure boot support")
+}
+
 func (repo *VirtualMachineRepository) Save(vm *compute.VirtualMachine) error {
 	conn, err := repo.pool.Acquire(vm.NodeId)
 	if err != nil {
@@ -463,12 +564,28 @@ func (repo *VirtualMachineRepository) Save(vm *compute.VirtualMachine) error {
 		}
 	}
 
-	virDomainConfig.VCPU = &libvirtxml.DomainVCPU{Placement: "static", Value: uint(vm.VCpus)}
+	virDomainConfig.VCPU = &libvirtxml.DomainVCPU{Placement: "static", Value: uint(vm.MaxVCpuCount())}
+	if vm.MaxVCpuCount() > vm.VCpus {
+		virDomainConfig.VCPU.Current = uint(vm.VCpus)
+	}
 	virDomainConfig.Memory = &libvirtxml.DomainMemory{Unit: "bytes", Value: uint(vm.Memory.Bytes())}
+	virDomainConfig.CurrentMemory = nil
+	virDomainConfig.MaximumMemory = nil
+	if vm.MemoryHotplug() {
+		virDomainConfig.MaximumMemory = &libvirtxml.DomainMaxMemory{Unit: "bytes", Value: uint(vm.MaxMemory.Bytes()), Slots: uint(vm.MemorySlots)}
+	}
 
 	if vm.Hugepages {
+		hugepages := &libvirtxml.DomainMemoryHugepages{}
+		if vm.HugepageSize != "" {
+			pageSize, err := compute.HugepageSizeBytes(vm.HugepageSize)
+			if err != nil {
+				return err
+			}
+			hugepages.Hugepages = []libvirtxml.DomainMemoryHugepage{{Size: uint(pageSize / 1024), Unit: "KiB"}}
+		}
 		virDomainConfig.MemoryBacking = &libvirtxml.DomainMemoryBacking{
-			MemoryHugePages: &libvirtxml.DomainMemoryHugepages{},
+			MemoryHugePages: hugepages,
 		}
 	} else {
 		if virDomainConfig.MemoryBacking != nil && virDomainConfig.MemoryBacking.MemoryHugePages != nil {
@@ -476,12 +593,50 @@ func (repo *VirtualMachineRepository) Save(vm *compute.VirtualMachine) error {
 		}
 	}
 
-	if capsConfig.Host.CPU != nil && capsConfig.Host.CPU.Topology != nil && capsConfig.Host.CPU.Topology.Threads > 0 {
+	if virDomainConfig.CPU == nil {
+		virDomainConfig.CPU = &libvirtxml.DomainCPU{}
+	}
+	setDomainCpu(virDomainConfig.CPU, vm.Cpu)
+	virDomainConfig.CPU.Numa = nil
+	numaCells := vm.Numa.Cells
+	if len(numaCells) == 0 && (vm.MemoryHotplug() || vm.DimmMemory.Unit != compute.SizeUnitUnknown && vm.DimmMemory.Bytes() > 0) {
+		// Memory hotplug requires guest numa, single cell is the same as no numa for the guest
+		cell := compute.VirtualMachineNumaCell{Memory: vm.BaseMemory()}
+		for cpu := 0; cpu < vm.MaxVCpuCount(); cpu++ {
+			cell.Cpus = append(cell.Cpus, uint(cpu))
+		}
+		numaCells = []compute.VirtualMachineNumaCell{cell}
+	}
+	for idx, cell := range numaCells {
+		if virDomainConfig.CPU.Numa == nil {
+			virDomainConfig.CPU.Numa = &libvirtxml.DomainNuma{}
+		}
+		cellId := uint(idx)
+		virDomainConfig.CPU.Numa.Cell = append(virDomainConfig.CPU.Numa.Cell, libvirtxml.DomainCell{
+			ID:     &cellId,
+			CPUs:   compute.FormatCpuSet(cell.Cpus),
+			Memory: uint(cell.Memory.Bytes() / 1024),
+			Unit:   "KiB",
+		})
+	}
+	virDomainConfig.NUMATune = nil
+	if vm.Numa.Mode != "" {
+		virDomainConfig.NUMATune = &libvirtxml.DomainNUMATune{
+			Memory: &libvirtxml.DomainNUMATuneMemory{Mode: vm.Numa.Mode, Nodeset: compute.FormatCpuSet(vm.Numa.Nodeset)},
+		}
+	}
+	if vm.Cpu.HasTopology() {
+		virDomainConfig.CPU.Topology = &libvirtxml.DomainCPUTopology{
+			Sockets: vm.Cpu.Sockets,
+			Cores:   vm.Cpu.Cores,
+			Threads: vm.Cpu.Threads,
+		}
+	} else if capsConfig.Host.CPU != nil && capsConfig.Host.CPU.Topology != nil && capsConfig.Host.CPU.Topology.Threads > 0 {
 		threadsPerCore := capsConfig.Host.CPU.Topology.Threads
-		if vm.VCpus%threadsPerCore == 0 {
+		if vm.MaxVCpuCount()%threadsPerCore == 0 {
 			virDomainConfig.CPU.Topology = &libvirtxml.DomainCPUTopology{
 				Sockets: VirtualMachineSockets,
-				Cores:   vm.VCpus / threadsPerCore,
+				Cores:   vm.MaxVCpuCount() / threadsPerCore,
 				Threads: threadsPerCore,
 			}
 		} else {
@@ -515,6 +670,57 @@ func (repo *VirtualMachineRepository) Save(vm *compute.VirtualMachine) error {
 		virDomainConfig.Devices.Channels = newChannels
 	}
 
+	if vm.Tpm {
+		if len(virDomainConfig.Devices.TPMs) == 0 {
+			virDomainConfig.Devices.TPMs = []libvirtxml.DomainTPM{{
+				Model:   "tpm-crb",
+				Backend: &libvirtxml.DomainTPMBackend{Emulator: &libvirtxml.DomainTPMBackendEmulator{Version: "2.0"}},
+			}}
+		}
+	} else {
+		virDomainConfig.Devices.TPMs = nil
+	}
+
+	if vm.Rng {
+		if len(virDomainConfig.Devices.RNGs) == 0 {
+			virDomainConfig.Devices.RNGs = []libvirtxml.DomainRNG{{
+				Model:   "virtio",
+				Backend: &libvirtxml.DomainRNGBackend{Random: &libvirtxml.DomainRNGBackendRandom{Device: "/dev/urandom"}},
+			}}
+		}
+	} else {
+		virDomainConfig.Devices.RNGs = nil
+	}
+
+	if vm.Watchdog != "" {
+		if virDomainConfig.Devices.Watchdog == nil {
+			virDomainConfig.Devices.Watchdog = &libvirtxml.DomainWatchdog{Model: "i6300esb"}
+		}
+		virDomainConfig.Devices.Watchdog.Action = vm.Watchdog
+	} else {
+		virDomainConfig.Devices.Watchdog = nil
+	}
+
+	if vm.Pvpanic {
+		if len(virDomainConfig.Devices.Panics) == 0 {
+			// Model is selected by libvirt, isa on x86 and pci on other arches
+			virDomainConfig.Devices.Panics = []libvirtxml.DomainPanic{{}}
+		}
+	} else {
+		virDomainConfig.Devices.Panics = nil
+	}
+
+	virDomainConfig.OnPoweroff = compute.LifecycleAction(vm.OnPoweroff, compute.DefaultOnPoweroff)
+	virDomainConfig.OnReboot = compute.LifecycleAction(vm.OnReboot, compute.DefaultOnReboot)
+	virDomainConfig.OnCrash = compute.LifecycleAction(vm.OnCrash, compute.DefaultOnCrash)
+
+	if virDomainConfig.Devices.MemBalloon == nil {
+		virDomainConfig.Devices.MemBalloon = &libvirtxml.DomainMemBalloon{Model: "virtio"}
+	}
+	if virDomainConfig.Devices.MemBalloon.Model != "none" && virDomainConfig.Devices.MemBalloon.Stats == nil {
+		virDomainConfig.Devices.MemBalloon.Stats = &libvirtxml.DomainMemBalloonStats{Period: memoryStatsPeriod}
+	}
+
 	switch vm.Graphic.Type {
 	default:
 		panic("unknown graphic type")
@@ -554,15 +760,27 @@ func (repo *VirtualMachineRepository) Save(vm *compute.VirtualMachine) error {
 	if isNewVm {
 		namer := NewDeviceNamer()
 		for _, attachedVolume := range vm.Volumes {
-			if err := repo.attachVolume(conn, virDomainConfig, attachedVolume, namer); err != nil {
+			if err := repo.attachVolume(conn, vm.NodeId, virDomainConfig, attachedVolume, namer); err != nil {
 				return util.NewError(err, "cannot attach volume")
 			}
 		}
 		for _, attachedIface := range vm.Interfaces {
-			if err := repo.attachInterface(virDomainConfig, attachedIface); err != nil {
+			if err := repo.attachInterface(conn, virDomainConfig, attachedIface); err != nil {
 				return util.NewError(err, "cannot attach interface")
 			}
 		}
+		if len(vm.Boot().Order) > 0 {
+			// Per device boot order cannot be used together with os boot devices
+			virDomainConfig.OS.BootDevices = nil
+		}
+	}
+	// Node defaults are not stored per disk and may be changed since disks were attached
+	diskDrivers := map[string]compute.VirtualMachineVolumeDriver{}
+	for _, attachedVolume := range vm.Volumes {
+		diskDrivers[attachedVolume.Path] = attachedVolume.Driver
+	}
+	if err := applyDomainDiskDrivers(virDomainConfig.Devices.Disks, diskDrivers, repo.settings[vm.NodeId].DiskDriver); err != nil {
+		return err
 	}
 	virDomainXml, err := virDomainConfig.Marshal()
 	if err != nil {
@@ -576,6 +794,9 @@ func (repo *VirtualMachineRepository) Save(vm *compute.VirtualMachine) error {
 	if err != nil {
 		return util.NewError(err, "cannot lookup domain after define")
 	}
+	if err := setVmangoDomainMetadata(virDomain, newVmangoDomainMetadata(vm)); err != nil {
+		return err
+	}
 	virDomainAutostart, err := virDomain.GetAutostart()
 	if err != nil {
 		return util.NewError(err, "cannot get domain autostart state")
@@ -622,6 +843,342 @@ func (repo *VirtualMachineRepository) Delete(id, nodeId string) error {
 	return nil
 }
 
+// domainUndefineKeepTpm is VIR_DOMAIN_UNDEFINE_KEEP_TPM (libvirt 8.9.0), missing in bindings
+const domainUndefineKeepTpm = libvirt.DomainUndefineFlagsValues(1 << 3)
+
+// undefineDomainKeepState undefines domain keeping its nvram file and tpm state,
+// so it can be defined again with the same uuid. Older libvirt doesn't know
+// keep tpm flag and always removes tpm state, domains with tpm are refused then.
+func undefineDomainKeepState(virDomain *libvirt.Domain, hasTpm bool) error {
+	err := virDomain.UndefineFlags(libvirt.DOMAIN_UNDEFINE_KEEP_NVRAM | domainUndefineKeepTpm)
+	if lErr, ok := err.(libvirt.Error); ok && (lErr.Code == libvirt.ERR_INVALID_ARG || lErr.Code == libvirt.ERR_NO_SUPPORT) {
+		if hasTpm {
+			return fmt.Errorf("libvirt is too old to keep tpm state on undefine, at least 8.9.0 is required")
+		}
+		err = virDomain.UndefineFlags(libvirt.DOMAIN_UNDEFINE_KEEP_NVRAM)
+	}
+	if err != nil {
+		return util.NewError(err, "cannot undefine domain")
+	}
+	return nil
+}
+
+// copyNvram copies nvram volume to the new name in the same storage pool,
+// returns nil if nvram file is not managed by any pool
+func copyNvram(conn *libvirt.Connect, path, newName string) (*libvirt.StorageVol, error) {
+	virVolume, err := conn.LookupStorageVolByPath(path)
+	if err != nil {
+		if lErr, ok := err.(libvirt.Error); ok && lErr.Code == libvirt.ERR_NO_STORAGE_VOL {
+			return nil, nil
+		}
+		return nil, util.NewError(err, "cannot lookup nvram volume")
+	}
+	virPool, err := virVolume.LookupPoolByVolume()
+	if err != nil {
+		return nil, util.NewError(err, "cannot lookup nvram pool")
+	}
+	virVolumeXml, err := virVolume.GetXMLDesc(0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot get nvram volume xml")
+	}
+	virVolumeConfig := &libvirtxml.StorageVolume{}
+	if err := virVolumeConfig.Unmarshal(virVolumeXml); err != nil {
+		return nil, util.NewError(err, "cannot parse nvram volume xml")
+	}
+	virVolumeConfig.Name = newName
+	virVolumeConfig.Key = ""
+	if virVolumeConfig.Target != nil {
+		virVolumeConfig.Target.Path = ""
+	}
+	newVirVolumeXml, err := virVolumeConfig.Marshal()
+	if err != nil {
+		return nil, util.NewError(err, "cannot marshal nvram volume xml")
+	}
+	newVirVolume, err := virPool.StorageVolCreateXMLFrom(newVirVolumeXml, virVolume, 0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot copy nvram volume")
+	}
+	return newVirVolume, nil
+}
+
+func (repo *VirtualMachineRepository) Rename(id, nodeId, newId string) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire libvirt connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "lookup domain failed")
+	}
+	running, err := virDomain.IsActive()
+	if err != nil {
+		return util.NewError(err, "cannot check if domain is running")
+	}
+	if running {
+		return fmt.Errorf("domain must be stopped")
+	}
+	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return util.NewError(err, "cannot fetch domain xml")
+	}
+	virDomainConfig := &libvirtxml.Domain{}
+	if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+	if virDomainConfig.OS != nil && virDomainConfig.OS.NVRam != nil && virDomainConfig.OS.NVRam.NVRam != "" {
+		// Libvirt cannot rename domains with nvram, so domain is redefined
+		// with the new name, the same uuid, tpm state and nvram copied
+		// to the new name. Old nvram is kept until the new domain is defined.
+		autostart, err := virDomain.GetAutostart()
+		if err != nil {
+			return util.NewError(err, "cannot get domain autostart state")
+		}
+		oldNvram := virDomainConfig.OS.NVRam.NVRam
+		newVirNvram, err := copyNvram(conn, oldNvram, newId+"_VARS.fd")
+		if err != nil {
+			return err
+		}
+		if newVirNvram != nil {
+			newNvram, err := newVirNvram.GetPath()
+			if err != nil {
+				if err := newVirNvram.Delete(0); err != nil {
+					repo.logger.Error().Err(err).Str("vm", id).Msg("cannot remove nvram copy")
+				}
+				return util.NewError(err, "cannot get nvram copy path")
+			}
+			virDomainConfig.OS.NVRam.NVRam = newNvram
+		} else {
+			// Not a pool volume, libvirt creates a new one from template
+			repo.logger.Warn().Str("vm", id).Str("nvram", oldNvram).Msg("nvram is not in a storage pool, efi variables are reset on rename")
+			virDomainConfig.OS.NVRam.NVRam = ""
+		}
+		deleteNewNvram := func() {
+			if newVirNvram == nil {
+				return
+			}
+			if err := newVirNvram.Delete(0); err != nil {
+				repo.logger.Error().Err(err).Str("vm", id).Msg("cannot remove nvram copy")
+			}
+		}
+		virDomainConfig.Name = newId
+		newVirDomainXml, err := virDomainConfig.Marshal()
+		if err != nil {
+			deleteNewNvram()
+			return util.NewError(err, "cannot marshal domain xml")
+		}
+		if err := undefineDomainKeepState(virDomain, len(virDomainConfig.Devices.TPMs) > 0); err != nil {
+			deleteNewNvram()
+			return err
+		}
+		newVirDomain, err := conn.DomainDefineXML(newVirDomainXml)
+		if err != nil {
+			deleteNewNvram()
+			restoredVirDomain, restoreErr := conn.DomainDefineXML(virDomainXml)
+			if restoreErr == nil {
+				restoreErr = restoredVirDomain.SetAutostart(autostart)
+			}
+			if restoreErr != nil {
+				repo.logger.Error().Err(restoreErr).Str("vm", id).Msg("cannot restore domain after failed rename")
+			}
+			return util.NewError(err, "cannot define renamed domain")
+		}
+		if newVirNvram != nil {
+			if oldVirNvram, err := conn.LookupStorageVolByPath(oldNvram); err != nil {
+				repo.logger.Error().Err(err).Str("vm", newId).Str("nvram", oldNvram).Msg("cannot lookup old nvram")
+			} else if err := oldVirNvram.Delete(0); err != nil {
+				repo.logger.Error().Err(err).Str("vm", newId).Str("nvram", oldNvram).Msg("cannot remove old nvram")
+			}
+		} else {
+			repo.logger.Warn().Str("vm", newId).Str("nvram", oldNvram).Msg("old nvram is left on node")
+		}
+		if err := newVirDomain.SetAutostart(autostart); err != nil {
+			return util.NewError(err, "cannot restore autostart of renamed domain")
+		}
+		return nil
+	}
+	if err := virDomain.Rename(newId, 0); err != nil {
+		return util.NewError(err, "cannot rename domain")
+	}
+	return nil
+}
+
+func (repo *VirtualMachineRepository) Migrate(id, nodeId, targetNodeId string, options compute.VirtualMachineMigrateOptions, progress func(compute.VirtualMachineMigrationProgress)) error {
+	conn, err := repo.pool.Open(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot open source libvirt connection")
+	}
+	defer conn.Close()
+	targetConn, err := repo.pool.Open(targetNodeId)
+	if err != nil {
+		return util.NewError(err, "cannot open target libvirt connection")
+	}
+	defer targetConn.Close()
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "lookup domain failed")
+	}
+	running, err := virDomain.IsActive()
+	if err != nil {
+		return util.NewError(err, "cannot check if domain is running")
+	}
+	if !running {
+		return fmt.Errorf("domain must be running")
+	}
+	autostart, err := virDomain.GetAutostart()
+	if err != nil {
+		return util.NewError(err, "cannot get domain autostart state")
+	}
+
+	flags := libvirt.MIGRATE_LIVE | libvirt.MIGRATE_PERSIST_DEST | libvirt.MIGRATE_UNDEFINE_SOURCE
+	if options.CopyStorage {
+		flags |= libvirt.MIGRATE_NON_SHARED_DISK
+	}
+
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				stats, err := virDomain.GetJobStats(0)
+				if err != nil {
+					repo.logger.Debug().Err(err).Str("vm", id).Msg("cannot get migration job stats")
+					continue
+				}
+				progress(compute.VirtualMachineMigrationProgress{
+					DataTotal:     stats.DataTotal,
+					DataProcessed: stats.DataProcessed,
+					DataRemaining: stats.DataRemaining,
+				})
+			}
+		}
+	}()
+	repo.logger.Info().Str("vm", id).Str("source", nodeId).Str("target", targetNodeId).Bool("copy_storage", options.CopyStorage).Msg("starting live migration")
+	targetVirDomain, err := virDomain.Migrate(targetConn, flags, "", "", options.Bandwidth)
+	close(done)
+	if err != nil {
+		return util.NewError(err, "migration failed")
+	}
+	if err := targetVirDomain.SetAutostart(autostart); err != nil {
+		return util.NewError(err, "cannot set domain autostart state on target node")
+	}
+	return nil
+}
+
+func (repo *VirtualMachineRepository) CopyDefinition(id, nodeId, targetNodeId string, volumePaths map[string]string) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire source libvirt connection")
+	}
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		repo.pool.Release(nodeId)
+		return util.NewError(err, "lookup domain failed")
+	}
+	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		repo.pool.Release(nodeId)
+		return util.NewError(err, "cannot get domain xml")
+	}
+	autostart, err := virDomain.GetAutostart()
+	if err != nil {
+		repo.pool.Release(nodeId)
+		return util.NewError(err, "cannot get domain autostart state")
+	}
+	repo.pool.Release(nodeId)
+
+	virDomainConfig := &libvirtxml.Domain{}
+	if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+
+	targetConn, err := repo.pool.Acquire(targetNodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire target libvirt connection")
+	}
+	defer repo.pool.Release(targetNodeId)
+
+	if _, err := targetConn.LookupDomainByName(id); err == nil {
+		return fmt.Errorf("domain %s already exists on node %s", id, targetNodeId)
+	}
+
+	settings := repo.settings[targetNodeId]
+	domCapsXml, err := targetConn.GetDomainCapabilities(settings.Emulator, virDomainConfig.OS.Type.Arch, "", "", 0)
+	if err != nil {
+		return util.NewError(err, "cannot fetch target domain capabilities")
+	}
+	domCapsConfig := &libvirtxml.DomainCaps{}
+	if err := domCapsConfig.Unmarshal(domCapsXml); err != nil {
+		return util.NewError(err, "cannot parse target domain capabilities")
+	}
+	virDomainConfig.Devices.Emulator = domCapsConfig.Path
+	if len(virDomainConfig.Devices.TPMs) > 0 || virDomainConfig.OS.Loader != nil && virDomainConfig.OS.Loader.Secure == "yes" {
+		return fmt.Errorf("domain with secure boot or tpm cannot be copied, nvram and tpm state are local to the source node")
+	}
+	if virDomainConfig.OS != nil && virDomainConfig.OS.NVRam != nil {
+		// Nvram file is local to the source node, target creates a new one from template.
+		// Only boot entries are lost, firmware falls back to the default boot path.
+		virDomainConfig.OS.NVRam.NVRam = ""
+	}
+
+	metadata, err := parseVmangoDomainMetadata(virDomainConfig)
+	if err != nil {
+		return err
+	}
+	diskDrivers := map[string]compute.VirtualMachineVolumeDriver{}
+	diskPaths := []string{}
+	for idx, disk := range virDomainConfig.Devices.Disks {
+		volume := VirtualMachineAttachedVolumeFromDomainDiskConfig(disk)
+		if volume.Path == "" {
+			continue
+		}
+		newPath, ok := volumePaths[volume.Path]
+		if !ok {
+			return fmt.Errorf("no target volume for disk %s", volume.Path)
+		}
+		virVolumeConfig, err := getVolumeConfigByPath(targetConn, newPath)
+		if err != nil {
+			return util.NewError(err, "cannot get target volume config")
+		}
+		if err := setDomainDiskSource(&virDomainConfig.Devices.Disks[idx], newPath, virVolumeConfig); err != nil {
+			return err
+		}
+		diskDrivers[newPath] = metadata.DiskDriverOverrides()[volume.Path]
+		diskPaths = append(diskPaths, newPath)
+	}
+	// Disks get defaults of the target node, only user overrides are carried over
+	if err := applyDomainDiskDrivers(virDomainConfig.Devices.Disks, diskDrivers, settings.DiskDriver); err != nil {
+		return err
+	}
+	metadata.XMLName = xml.Name{} // Namespace is added by libvirt
+	metadata.DiskDrivers = nil
+	for _, path := range diskPaths {
+		metadata.SetDiskDriver(path, diskDrivers[path])
+	}
+
+	virDomainXml, err = virDomainConfig.Marshal()
+	if err != nil {
+		return util.NewError(err, "cannot marshal domain xml")
+	}
+	targetVirDomain, err := targetConn.DomainDefineXML(virDomainXml)
+	if err != nil {
+		return util.NewError(err, "cannot define domain on target node")
+	}
+	if err := setVmangoDomainMetadata(targetVirDomain, metadata); err != nil {
+		return err
+	}
+	if err := targetVirDomain.SetAutostart(autostart); err != nil {
+		return util.NewError(err, "cannot set domain autostart state on target node")
+	}
+	return nil
+}
+
 func (repo *VirtualMachineRepository) nodeList(nodeId string) ([]*compute.VirtualMachine, error) {
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
@@ -701,7 +1258,7 @@ func (repo *VirtualMachineRepository) Poweroff(id, nodeId string) error {
 	return domain.Destroy()
 }
 
-func (repo *VirtualMachineRepository) Reboot(id, nodeId string) error {
+func (repo *VirtualMachineRepository) Shutdown(id, nodeId string) error {
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
 		return util.NewError(err, "cannot acquire connection")
@@ -712,10 +1269,10 @@ func (repo *VirtualMachineRepository) Reboot(id, nodeId string) error {
 	if err != nil {
 		return util.NewError(err, "domain lookup failed")
 	}
-	return domain.Reboot(libvirt.DOMAIN_REBOOT_DEFAULT)
+	return domain.Shutdown()
 }
 
-func (repo *VirtualMachineRepository) Start(id, nodeId string) error {
+func (repo *VirtualMachineRepository) CreateSnapshot(id, nodeId, name string) error {
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
 		return util.NewError(err, "cannot acquire connection")
@@ -726,32 +1283,597 @@ func (repo *VirtualMachineRepository) Start(id, nodeId string) error {
 	if err != nil {
 		return util.NewError(err, "domain lookup failed")
 	}
-	return domain.Create()
-}
-
-func (repo *VirtualMachineRepository) attachVolume(conn *libvirt.Connect, virDomainConfig *libvirtxml.Domain, attachedVolume *compute.VirtualMachineAttachedVolume, namer *DeviceNamer) error {
-	virVolumeConfig, err := getVolumeConfigByPath(conn, attachedVolume.Path)
+	snapshotConfig := &libvirtxml.DomainSnapshot{Name: name}
+	snapshotXml, err := snapshotConfig.Marshal()
 	if err != nil {
-		return util.NewError(err, "cannot get volume config")
+		return util.NewError(err, "cannot marshal snapshot xml")
 	}
-	diskConfig := DomainDiskConfigFromVirtualMachineAttachedVolume(
-		attachedVolume,
-		getVolTargetFormatType(virVolumeConfig),
-		virVolumeConfig.Type,
-		namer,
-	)
-	virDomainConfig.Devices.Disks = append(virDomainConfig.Devices.Disks, *diskConfig)
-	return nil
+	snapshot, err := domain.CreateSnapshotXML(snapshotXml, libvirt.DOMAIN_SNAPSHOT_CREATE_ATOMIC)
+	if err != nil {
+		return util.NewError(err, "cannot create snapshot")
+	}
+	return snapshot.Free()
 }
 
-func (repo *VirtualMachineRepository) AttachVolume(id, nodeId string, attachedVolume *compute.VirtualMachineAttachedVolume) error {
+func (repo *VirtualMachineRepository) SetExpiry(id, nodeId string, expiresAt time.Time) error {
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
 		return util.NewError(err, "cannot acquire connection")
 	}
 	defer repo.pool.Release(nodeId)
 
-	virDomain, err := conn.LookupDomainByName(id)
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	domainXml, err := domain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return util.NewError(err, "cannot fetch xml")
+	}
+	domainConfig := &libvirtxml.Domain{}
+	if err := domainConfig.Unmarshal(domainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+	metadata, err := parseVmangoDomainMetadata(domainConfig)
+	if err != nil {
+		return err
+	}
+	metadata.XMLName = xml.Name{} // Namespace is added by libvirt
+	metadata.Expires = ""
+	if !expiresAt.IsZero() {
+		metadata.Expires = expiresAt.Format(time.RFC3339)
+	}
+	return setVmangoDomainMetadata(domain, metadata)
+}
+
+func (repo *VirtualMachineRepository) SetBoot(id, nodeId string, boot compute.VirtualMachineBoot) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	domainXml, err := domain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return util.NewError(err, "cannot fetch xml")
+	}
+	domainConfig := &libvirtxml.Domain{}
+	if err := domainConfig.Unmarshal(domainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+
+	found := map[string]bool{}
+	for idx := range domainConfig.Devices.Disks {
+		diskConfig := &domainConfig.Devices.Disks[idx]
+		path := VirtualMachineAttachedVolumeFromDomainDiskConfig(*diskConfig).Path
+		diskConfig.Boot = nil
+		if order := boot.Order[path]; order > 0 {
+			diskConfig.Boot = &libvirtxml.DomainDeviceBoot{Order: order}
+			found[path] = true
+		}
+	}
+	for idx := range domainConfig.Devices.Interfaces {
+		ifaceConfig := &domainConfig.Devices.Interfaces[idx]
+		ifaceConfig.Boot = nil
+		if ifaceConfig.MAC == nil {
+			continue
+		}
+		mac := strings.ToLower(ifaceConfig.MAC.Address)
+		for device, order := range boot.Order {
+			if order > 0 && strings.ToLower(device) == mac {
+				ifaceConfig.Boot = &libvirtxml.DomainDeviceBoot{Order: order}
+				found[device] = true
+			}
+		}
+	}
+	for device, order := range boot.Order {
+		if order > 0 && !found[device] {
+			return fmt.Errorf("device %s not found", device)
+		}
+	}
+
+	if len(found) > 0 {
+		domainConfig.OS.BootDevices = nil
+	} else {
+		domainConfig.OS.BootDevices = []libvirtxml.DomainBootDevice{{Dev: "cdrom"}, {Dev: "hd"}}
+	}
+	domainConfig.OS.BootMenu = nil
+	if boot.Menu {
+		domainConfig.OS.BootMenu = &libvirtxml.DomainBootMenu{Enable: "yes"}
+	}
+
+	domainXml, err = domainConfig.Marshal()
+	if err != nil {
+		return util.NewError(err, "cannot marshal domain xml")
+	}
+	if _, err := conn.DomainDefineXML(domainXml); err != nil {
+		return util.NewError(err, "cannot update domain")
+	}
+	return nil
+}
+
+// SetCpuPin replaces vcpu, emulator and iothread pinning, nil pin removes it.
+// Running domain is repinned live, absent pins are reset to all online cpus.
+func (repo *VirtualMachineRepository) SetCpuPin(id, nodeId string, pin *compute.VirtualMachineCpuPin) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	running, err := domain.IsActive()
+	if err != nil {
+		return util.NewError(err, "cannot check if domain is running")
+	}
+	domainXml, err := domain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return util.NewError(err, "cannot fetch xml")
+	}
+	domainConfig := &libvirtxml.Domain{}
+	if err := domainConfig.Unmarshal(domainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+	if pin == nil {
+		pin = &compute.VirtualMachineCpuPin{}
+	}
+
+	if running {
+		onlineCpus, _, err := conn.GetCPUMap(0)
+		if err != nil {
+			return util.NewError(err, "cannot get host cpu map")
+		}
+		size := 0
+		allCpus := []uint{}
+		for cpuId, online := range onlineCpus {
+			if cpuId+1 > size {
+				size = cpuId + 1
+			}
+			if online {
+				allCpus = append(allCpus, uint(cpuId))
+			}
+		}
+		cpuMap := func(cpus []uint) []bool {
+			if len(cpus) == 0 {
+				cpus = allCpus
+			}
+			cpumap := make([]bool, size)
+			for _, cpuId := range cpus {
+				if int(cpuId) < size {
+					cpumap[cpuId] = true
+				}
+			}
+			return cpumap
+		}
+		vcpus := uint(0)
+		if domainConfig.VCPU != nil {
+			vcpus = domainConfig.VCPU.Value
+			if domainConfig.VCPU.Current > 0 {
+				vcpus = domainConfig.VCPU.Current // Offline vcpus cannot be pinned live
+			}
+		}
+		for vcpu := uint(0); vcpu < vcpus; vcpu++ {
+			if err := domain.PinVcpuFlags(vcpu, cpuMap(pin.Vcpus[vcpu]), libvirt.DOMAIN_AFFECT_LIVE); err != nil {
+				return util.NewError(err, "cannot pin vcpu %d", vcpu)
+			}
+		}
+		if err := domain.PinEmulator(cpuMap(pin.Emulator), libvirt.DOMAIN_AFFECT_LIVE); err != nil {
+			return util.NewError(err, "cannot pin emulator")
+		}
+		for iothread := uint(1); iothread <= domainConfig.IOThreads; iothread++ {
+			if err := domain.PinIOThread(iothread, cpuMap(pin.IOThreads[iothread]), libvirt.DOMAIN_AFFECT_LIVE); err != nil {
+				return util.NewError(err, "cannot pin iothread %d", iothread)
+			}
+		}
+	}
+
+	if domainConfig.CPUTune == nil {
+		domainConfig.CPUTune = &libvirtxml.DomainCPUTune{}
+	}
+	domainConfig.CPUTune.VCPUPin = nil
+	for vcpu, cpus := range pin.Vcpus {
+		domainConfig.CPUTune.VCPUPin = append(domainConfig.CPUTune.VCPUPin, libvirtxml.DomainCPUTuneVCPUPin{
+			VCPU:   vcpu,
+			CPUSet: compute.FormatCpuSet(cpus),
+		})
+	}
+	sort.Slice(domainConfig.CPUTune.VCPUPin, func(i, j int) bool {
+		return domainConfig.CPUTune.VCPUPin[i].VCPU < domainConfig.CPUTune.VCPUPin[j].VCPU
+	})
+	domainConfig.CPUTune.EmulatorPin = nil
+	if len(pin.Emulator) > 0 {
+		domainConfig.CPUTune.EmulatorPin = &libvirtxml.DomainCPUTuneEmulatorPin{CPUSet: compute.FormatCpuSet(pin.Emulator)}
+	}
+	domainConfig.CPUTune.IOThreadPin = nil
+	for iothread, cpus := range pin.IOThreads {
+		domainConfig.CPUTune.IOThreadPin = append(domainConfig.CPUTune.IOThreadPin, libvirtxml.DomainCPUTuneIOThreadPin{
+			IOThread: iothread,
+			CPUSet:   compute.FormatCpuSet(cpus),
+		})
+	}
+	sort.Slice(domainConfig.CPUTune.IOThreadPin, func(i, j int) bool {
+		return domainConfig.CPUTune.IOThreadPin[i].IOThread < domainConfig.CPUTune.IOThreadPin[j].IOThread
+	})
+	if reflect.DeepEqual(*domainConfig.CPUTune, libvirtxml.DomainCPUTune{}) {
+		domainConfig.CPUTune = nil
+	}
+	if len(pin.MemoryNodes) > 0 {
+		// Memory placement is changed only in persistent config, strict mode cannot be applied live
+		domainConfig.NUMATune = &libvirtxml.DomainNUMATune{
+			Memory: &libvirtxml.DomainNUMATuneMemory{Mode: "strict", Nodeset: compute.FormatCpuSet(pin.MemoryNodes)},
+		}
+	}
+
+	domainXml, err = domainConfig.Marshal()
+	if err != nil {
+		return util.NewError(err, "cannot marshal domain xml")
+	}
+	if _, err := conn.DomainDefineXML(domainXml); err != nil {
+		return util.NewError(err, "cannot update domain")
+	}
+	return nil
+}
+
+// SetVcpus changes online vcpu count of running domain up to its maximum
+func (repo *VirtualMachineRepository) SetVcpus(id, nodeId string, vcpus int) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	if err := domain.SetVcpusFlags(uint(vcpus), libvirt.DOMAIN_VCPU_LIVE); err != nil {
+		return util.NewError(err, "cannot set vcpus")
+	}
+	return nil
+}
+
+// AttachMemory hot-plugs dimm into running domain and adds it to persistent config
+func (repo *VirtualMachineRepository) AttachMemory(id, nodeId string, size compute.Size) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	dimmConfig := &libvirtxml.DomainMemorydev{
+		Model: "dimm",
+		Target: &libvirtxml.DomainMemorydevTarget{
+			Size: &libvirtxml.DomainMemorydevTargetSize{Value: uint(size.Bytes() / 1024), Unit: "KiB"},
+			Node: &libvirtxml.DomainMemorydevTargetNode{Value: 0},
+		},
+	}
+	dimmXml, err := dimmConfig.Marshal()
+	if err != nil {
+		return util.NewError(err, "cannot marshal dimm xml")
+	}
+	if err := domain.AttachDeviceFlags(dimmXml, libvirt.DOMAIN_DEVICE_MODIFY_LIVE|libvirt.DOMAIN_DEVICE_MODIFY_CONFIG); err != nil {
+		return util.NewError(err, "cannot attach dimm")
+	}
+	return nil
+}
+
+// memoryStatsPeriod is how often in seconds qemu polls guest balloon driver for memory stats
+const memoryStatsPeriod = 10
+
+// GetMemoryStats returns balloon stats of running domain. Polling is enabled for domains
+// started without stats period, guest usage is reported by the next call then.
+func (repo *VirtualMachineRepository) GetMemoryStats(id, nodeId string) (*compute.VirtualMachineMemoryStats, error) {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return nil, util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return nil, util.NewError(err, "domain lookup failed")
+	}
+	virStats, err := domain.MemoryStats(uint32(libvirt.DOMAIN_MEMORY_STAT_NR), 0)
+	if err != nil {
+		return nil, util.NewError(err, "cannot get memory stats")
+	}
+	stats := &compute.VirtualMachineMemoryStats{}
+	for _, virStat := range virStats {
+		switch libvirt.DomainMemoryStatTags(virStat.Tag) {
+		case libvirt.DOMAIN_MEMORY_STAT_ACTUAL_BALLOON:
+			stats.Actual = virStat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_UNUSED:
+			stats.Unused = virStat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_AVAILABLE:
+			stats.Available = virStat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_USABLE:
+			stats.Usable = virStat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_RSS:
+			stats.Rss = virStat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_SWAP_IN:
+			stats.SwapIn = virStat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_SWAP_OUT:
+			stats.SwapOut = virStat.Val * 1024
+		case libvirt.DOMAIN_MEMORY_STAT_LAST_UPDATE:
+			stats.LastUpdate = time.Unix(int64(virStat.Val), 0)
+		}
+	}
+	if stats.LastUpdate.IsZero() {
+		if err := domain.SetMemoryStatsPeriod(memoryStatsPeriod, libvirt.DOMAIN_MEM_LIVE); err != nil {
+			repo.logger.Debug().Str("vm", id).Err(err).Msg("cannot enable memory stats polling")
+		}
+	}
+	return stats, nil
+}
+
+// SetMemoryTarget changes balloon target of running domain, persistent config is not changed
+func (repo *VirtualMachineRepository) SetMemoryTarget(id, nodeId string, target compute.Size) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	if err := domain.SetMemoryFlags(target.Bytes()/1024, libvirt.DOMAIN_MEM_LIVE); err != nil {
+		return util.NewError(err, "cannot set memory target")
+	}
+	return nil
+}
+
+// domainStartResetNvram is VIR_DOMAIN_START_RESET_NVRAM, it is missing in libvirt-go bindings
+const domainStartResetNvram = libvirt.DomainCreateFlags(1 << 5)
+
+// ResetNvram starts stopped machine with nvram recreated from template
+func (repo *VirtualMachineRepository) ResetNvram(id, nodeId string) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	running, err := domain.IsActive()
+	if err != nil {
+		return util.NewError(err, "cannot check if domain is running")
+	}
+	if running {
+		return fmt.Errorf("domain must be stopped")
+	}
+	if err := domain.CreateWithFlags(domainStartResetNvram); err != nil {
+		return util.NewError(err, "cannot start domain with nvram reset")
+	}
+	return nil
+}
+
+func (repo *VirtualMachineRepository) Reboot(id, nodeId string) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	return domain.Reboot(libvirt.DOMAIN_REBOOT_DEFAULT)
+}
+
+func (repo *VirtualMachineRepository) Start(id, nodeId string) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	domain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	return domain.Create()
+}
+
+func (repo *VirtualMachineRepository) attachVolume(conn *libvirt.Connect, nodeId string, virDomainConfig *libvirtxml.Domain, attachedVolume *compute.VirtualMachineAttachedVolume, namer *DeviceNamer) error {
+	if attachedVolume.DeviceBus == compute.DeviceBusIde && isDomainQ35(virDomainConfig) {
+		// Q35 has no ide controller, sata is used for the same guests instead
+		sataVolume := *attachedVolume
+		sataVolume.DeviceBus = compute.DeviceBusSata
+		attachedVolume = &sataVolume
+	}
+	diskConfig, err := repo.volumeDiskConfig(conn, nodeId, attachedVolume, namer)
+	if err != nil {
+		return err
+	}
+	virDomainConfig.Devices.Disks = append(virDomainConfig.Devices.Disks, *diskConfig)
+	return nil
+}
+
+func isDomainQ35(virDomainConfig *libvirtxml.Domain) bool {
+	return virDomainConfig.OS != nil && virDomainConfig.OS.Type != nil && strings.Contains(virDomainConfig.OS.Type.Machine, "q35")
+}
+
+// volumeDiskConfig returns disk xml, driver modes not set on the volume are taken from node settings
+func (repo *VirtualMachineRepository) volumeDiskConfig(conn *libvirt.Connect, nodeId string, attachedVolume *compute.VirtualMachineAttachedVolume, namer *DeviceNamer) (*libvirtxml.DomainDisk, error) {
+	driver := attachedVolume.Driver.WithDefaults(repo.settings[nodeId].DiskDriver)
+	if err := driver.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid driver of disk %s: %s", attachedVolume.Path, err)
+	}
+	virVolumeConfig, err := getVolumeConfigByPath(conn, attachedVolume.Path)
+	if err != nil {
+		return nil, util.NewError(err, "cannot get volume config")
+	}
+	return DomainDiskConfigFromVirtualMachineAttachedVolume(
+		attachedVolume,
+		driver,
+		getVolTargetFormatType(virVolumeConfig),
+		virVolumeConfig.Type,
+		namer,
+	), nil
+}
+
+// isDomainActive checks domain state without holding the connection afterwards
+func (repo *VirtualMachineRepository) isDomainActive(id, nodeId string) (bool, error) {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return false, util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return false, util.NewError(err, "domain lookup failed")
+	}
+	running, err := virDomain.IsActive()
+	if err != nil {
+		return false, util.NewError(err, "cannot check if domain is running")
+	}
+	return running, nil
+}
+
+func findDomainDiskByPath(disks []libvirtxml.DomainDisk, path string) *libvirtxml.DomainDisk {
+	for idx := range disks {
+		if VirtualMachineAttachedVolumeFromDomainDiskConfig(disks[idx]).Path == path {
+			return &disks[idx]
+		}
+	}
+	return nil
+}
+
+// detachDeviceTimeout is how long guest is waited to release hot-unplugged device
+const detachDeviceTimeout = 30 * time.Second
+
+// detachVolumeLive detaches disk from running domain and its persistent config.
+// Qemu removes the device only after guest acknowledges unplug, so completion is
+// confirmed with device removed event. Separate connection is used to avoid
+// blocking other operations on the node while waiting.
+func (repo *VirtualMachineRepository) detachVolumeLive(id, nodeId, needlePath string) error {
+	conn, err := repo.pool.Open(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot open connection")
+	}
+	defer conn.Close()
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	liveConfig, inactiveConfig, err := getDomainLiveAndInactiveConfig(virDomain)
+	if err != nil {
+		return err
+	}
+
+	flags := libvirt.DomainDeviceModifyFlags(0)
+	liveDisk := findDomainDiskByPath(liveConfig.Devices.Disks, needlePath)
+	diskConfig := liveDisk
+	if liveDisk != nil {
+		flags |= libvirt.DOMAIN_DEVICE_MODIFY_LIVE
+	}
+	if inactiveDisk := findDomainDiskByPath(inactiveConfig.Devices.Disks, needlePath); inactiveDisk != nil {
+		flags |= libvirt.DOMAIN_DEVICE_MODIFY_CONFIG
+		if diskConfig == nil {
+			diskConfig = inactiveDisk
+		}
+	}
+	if diskConfig == nil {
+		return fmt.Errorf("no disk found")
+	}
+	diskXml, err := diskConfig.Marshal()
+	if err != nil {
+		return util.NewError(err, "cannot marshal disk xml")
+	}
+	alias := ""
+	if liveDisk != nil && liveDisk.Alias != nil {
+		alias = liveDisk.Alias.Name
+	}
+	released, err := detachDeviceAndWait(conn, virDomain, diskXml, flags, alias)
+	if err != nil {
+		return util.NewError(err, "cannot detach disk")
+	}
+	if !released {
+		return fmt.Errorf("guest didn't release disk %s in %s, it is removed from config and will be detached when guest acknowledges", needlePath, detachDeviceTimeout)
+	}
+	return nil
+}
+
+func getDomainLiveAndInactiveConfig(virDomain *libvirt.Domain) (*libvirtxml.Domain, *libvirtxml.Domain, error) {
+	liveXml, err := virDomain.GetXMLDesc(0)
+	if err != nil {
+		return nil, nil, util.NewError(err, "cannot get domain xml")
+	}
+	liveConfig := &libvirtxml.Domain{}
+	if err := liveConfig.Unmarshal(liveXml); err != nil {
+		return nil, nil, util.NewError(err, "cannot parse domain xml")
+	}
+	inactiveXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return nil, nil, util.NewError(err, "cannot get domain xml")
+	}
+	inactiveConfig := &libvirtxml.Domain{}
+	if err := inactiveConfig.Unmarshal(inactiveXml); err != nil {
+		return nil, nil, util.NewError(err, "cannot parse domain xml")
+	}
+	return liveConfig, inactiveConfig, nil
+}
+
+// detachDeviceAndWait detaches device and waits for device removed event with
+// specified alias. Returns false if guest didn't release device in time,
+// empty alias means device is not attached live and nothing to wait for.
+func detachDeviceAndWait(conn *libvirt.Connect, virDomain *libvirt.Domain, deviceXml string, flags libvirt.DomainDeviceModifyFlags, alias string) (bool, error) {
+	removed := make(chan struct{}, 1)
+	if alias != "" {
+		callbackId, err := conn.DomainEventDeviceRemovedRegister(virDomain, func(c *libvirt.Connect, d *libvirt.Domain, event *libvirt.DomainEventDeviceRemoved) {
+			if event.DevAlias == alias {
+				select {
+				case removed <- struct{}{}:
+				default:
+				}
+			}
+		})
+		if err != nil {
+			return false, util.NewError(err, "cannot subscribe to device removal events")
+		}
+		defer conn.DomainEventDeregister(callbackId)
+	}
+	if err := virDomain.DetachDeviceFlags(deviceXml, flags); err != nil {
+		return false, err
+	}
+	if alias == "" {
+		return true, nil
+	}
+	select {
+	case <-removed:
+		return true, nil
+	case <-time.After(detachDeviceTimeout):
+		return false, nil
+	}
+}
+
+func (repo *VirtualMachineRepository) AttachVolume(id, nodeId string, attachedVolume *compute.VirtualMachineAttachedVolume) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	virDomain, err := conn.LookupDomainByName(id)
 	if err != nil {
 		return util.NewError(err, "domain lookup failed")
 	}
@@ -759,9 +1881,6 @@ func (repo *VirtualMachineRepository) AttachVolume(id, nodeId string, attachedVo
 	if err != nil {
 		return util.NewError(err, "cannot check if domain is running")
 	}
-	if running {
-		return fmt.Errorf("domain must be stopped")
-	}
 
 	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
 	if err != nil {
@@ -771,8 +1890,39 @@ func (repo *VirtualMachineRepository) AttachVolume(id, nodeId string, attachedVo
 	if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
 		return util.NewError(err, "cannot parse domain xml")
 	}
+
+	if running {
+		if attachedVolume.DeviceBus == compute.DeviceBusIde || attachedVolume.DeviceBus == compute.DeviceBusSata {
+			return fmt.Errorf("%s devices cannot be attached to running machine, use virtio or scsi bus", attachedVolume.DeviceBus)
+		}
+		liveXml, err := virDomain.GetXMLDesc(0)
+		if err != nil {
+			return util.NewError(err, "cannot get domain xml")
+		}
+		liveConfig := &libvirtxml.Domain{}
+		if err := liveConfig.Unmarshal(liveXml); err != nil {
+			return util.NewError(err, "cannot parse domain xml")
+		}
+		// Live and persistent configs may differ, device name must be free in both
+		disks := append(append([]libvirtxml.DomainDisk{}, virDomainConfig.Devices.Disks...), liveConfig.Devices.Disks...)
+		diskConfig, err := repo.volumeDiskConfig(conn, nodeId, attachedVolume, NewDeviceNamerFromDisks(disks))
+		if err != nil {
+			return err
+		}
+		diskXml, err := diskConfig.Marshal()
+		if err != nil {
+			return util.NewError(err, "cannot marshal disk xml")
+		}
+		if err := virDomain.AttachDeviceFlags(diskXml, libvirt.DOMAIN_DEVICE_MODIFY_LIVE|libvirt.DOMAIN_DEVICE_MODIFY_CONFIG); err != nil {
+			return util.NewError(err, "cannot attach disk")
+		}
+		return updateVmangoDomainMetadata(virDomain, func(metadata *vmangoDomainMetadata) {
+			metadata.SetDiskDriver(attachedVolume.Path, attachedVolume.Driver)
+		})
+	}
+
 	namer := NewDeviceNamerFromDisks(virDomainConfig.Devices.Disks)
-	if err := repo.attachVolume(conn, virDomainConfig, attachedVolume, namer); err != nil {
+	if err := repo.attachVolume(conn, nodeId, virDomainConfig, attachedVolume, namer); err != nil {
 		return util.NewError(err, "cannot add volume xml config")
 	}
 
@@ -784,10 +1934,20 @@ func (repo *VirtualMachineRepository) AttachVolume(id, nodeId string, attachedVo
 		fmt.Println(virDomainXml)
 		return util.NewError(err, "cannot update domain")
 	}
-	return nil
+	return updateVmangoDomainMetadata(virDomain, func(metadata *vmangoDomainMetadata) {
+		metadata.SetDiskDriver(attachedVolume.Path, attachedVolume.Driver)
+	})
 }
 
 func (repo *VirtualMachineRepository) DetachVolume(id, nodeId, needlePath string) error {
+	running, err := repo.isDomainActive(id, nodeId)
+	if err != nil {
+		return err
+	}
+	if running {
+		return repo.detachVolumeLive(id, nodeId, needlePath)
+	}
+
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
 		return util.NewError(err, "cannot acquire connection")
@@ -798,13 +1958,6 @@ func (repo *VirtualMachineRepository) DetachVolume(id, nodeId, needlePath string
 	if err != nil {
 		return util.NewError(err, "domain lookup failed")
 	}
-	running, err := virDomain.IsActive()
-	if err != nil {
-		return util.NewError(err, "cannot check if domain is running")
-	}
-	if running {
-		return fmt.Errorf("domain must be stopped")
-	}
 
 	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
 	if err != nil {
@@ -841,7 +1994,72 @@ func (repo *VirtualMachineRepository) DetachVolume(id, nodeId, needlePath string
 	return nil
 }
 
-func (repo *VirtualMachineRepository) AttachInterface(id, nodeId string, attachedIface *compute.VirtualMachineAttachedInterface) error {
+// SetVolumeIoTune changes throttling of attached volume in persistent config
+// and in running domain
+func (repo *VirtualMachineRepository) SetVolumeIoTune(id, nodeId, path string, iotune compute.VirtualMachineVolumeIoTune) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	running, err := virDomain.IsActive()
+	if err != nil {
+		return util.NewError(err, "cannot check if domain is running")
+	}
+	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return util.NewError(err, "cannot get domain xml")
+	}
+	virDomainConfig := &libvirtxml.Domain{}
+	if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+	diskConfig := findDomainDiskByPath(virDomainConfig.Devices.Disks, path)
+	if diskConfig == nil || diskConfig.Target == nil {
+		return fmt.Errorf("no disk found")
+	}
+	params := &libvirt.DomainBlockIoTuneParameters{
+		TotalBytesSecSet:    true,
+		TotalBytesSec:       iotune.TotalBytesSec,
+		ReadBytesSecSet:     true,
+		ReadBytesSec:        iotune.ReadBytesSec,
+		WriteBytesSecSet:    true,
+		WriteBytesSec:       iotune.WriteBytesSec,
+		TotalIopsSecSet:     true,
+		TotalIopsSec:        iotune.TotalIopsSec,
+		ReadIopsSecSet:      true,
+		ReadIopsSec:         iotune.ReadIopsSec,
+		WriteIopsSecSet:     true,
+		WriteIopsSec:        iotune.WriteIopsSec,
+		TotalBytesSecMaxSet: true,
+		TotalBytesSecMax:    iotune.TotalBytesSecMax,
+		ReadBytesSecMaxSet:  true,
+		ReadBytesSecMax:     iotune.ReadBytesSecMax,
+		WriteBytesSecMaxSet: true,
+		WriteBytesSecMax:    iotune.WriteBytesSecMax,
+		TotalIopsSecMaxSet:  true,
+		TotalIopsSecMax:     iotune.TotalIopsSecMax,
+		ReadIopsSecMaxSet:   true,
+		ReadIopsSecMax:      iotune.ReadIopsSecMax,
+		WriteIopsSecMaxSet:  true,
+		WriteIopsSecMax:     iotune.WriteIopsSecMax,
+	}
+	flags := libvirt.DOMAIN_AFFECT_CONFIG
+	if running {
+		flags |= libvirt.DOMAIN_AFFECT_LIVE
+	}
+	if err := virDomain.SetBlockIoTune(diskConfig.Target.Dev, params, flags); err != nil {
+		return util.NewError(err, "cannot set disk io limits")
+	}
+	return nil
+}
+
+func (repo *VirtualMachineRepository) ReplaceVolume(id, nodeId, oldPath, newPath string) error {
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
 		return util.NewError(err, "cannot acquire connection")
@@ -859,6 +2077,78 @@ func (repo *VirtualMachineRepository) AttachInterface(id, nodeId string, attache
 	if running {
 		return fmt.Errorf("domain must be stopped")
 	}
+
+	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return util.NewError(err, "cannot get domain xml")
+	}
+	virDomainConfig := &libvirtxml.Domain{}
+	if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+	newVolumeConfig, err := getVolumeConfigByPath(conn, newPath)
+	if err != nil {
+		return util.NewError(err, "cannot get new volume config")
+	}
+	needleFound := false
+	for idx, disk := range virDomainConfig.Devices.Disks {
+		volume := VirtualMachineAttachedVolumeFromDomainDiskConfig(disk)
+		if volume.Path != oldPath {
+			continue
+		}
+		if err := setDomainDiskSource(&virDomainConfig.Devices.Disks[idx], newPath, newVolumeConfig); err != nil {
+			return err
+		}
+		needleFound = true
+	}
+	if !needleFound {
+		return fmt.Errorf("no disk found")
+	}
+	virDomainXml, err = virDomainConfig.Marshal()
+	if err != nil {
+		return util.NewError(err, "cannot create domain xml")
+	}
+	if _, err := conn.DomainDefineXML(virDomainXml); err != nil {
+		return util.NewError(err, "cannot update domain")
+	}
+	return updateVmangoDomainMetadata(virDomain, func(metadata *vmangoDomainMetadata) {
+		metadata.RenameDiskDriver(oldPath, newPath)
+	})
+}
+
+func (repo *VirtualMachineRepository) AttachInterface(id, nodeId string, attachedIface *compute.VirtualMachineAttachedInterface) error {
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	running, err := virDomain.IsActive()
+	if err != nil {
+		return util.NewError(err, "cannot check if domain is running")
+	}
+	if running {
+		if isQemuArgsInterface(attachedIface.NetworkName) {
+			return fmt.Errorf("%s interface cannot be attached to running machine", attachedIface.NetworkName)
+		}
+		// Mac is generated here to be the same in live and persistent configs
+		if attachedIface.Mac == "" {
+			attachedIface.Mac = generateMacAddress("52:54:00")
+		}
+		ifaceConfig := repo.interfaceConfig(conn, attachedIface)
+		ifaceXml, err := ifaceConfig.Marshal()
+		if err != nil {
+			return util.NewError(err, "cannot marshal interface xml")
+		}
+		if err := virDomain.AttachDeviceFlags(ifaceXml, libvirt.DOMAIN_DEVICE_MODIFY_LIVE|libvirt.DOMAIN_DEVICE_MODIFY_CONFIG); err != nil {
+			return util.NewError(err, "cannot attach interface")
+		}
+		return nil
+	}
 	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
 	if err != nil {
 		return util.NewError(err, "cannot get domain xml")
@@ -867,7 +2157,7 @@ func (repo *VirtualMachineRepository) AttachInterface(id, nodeId string, attache
 	if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
 		return util.NewError(err, "cannot parse domain xml")
 	}
-	if err := repo.attachInterface(virDomainConfig, attachedIface); err != nil {
+	if err := repo.attachInterface(conn, virDomainConfig, attachedIface); err != nil {
 		return err
 	}
 	virDomainXml, err = virDomainConfig.Marshal()
@@ -880,7 +2170,69 @@ func (repo *VirtualMachineRepository) AttachInterface(id, nodeId string, attache
 	return nil
 }
 
-func (repo *VirtualMachineRepository) detachInterfaceLibvirt(id, nodeId, needleMac string) error {
+func findDomainInterfaceByMac(ifaces []libvirtxml.DomainInterface, mac string) *libvirtxml.DomainInterface {
+	for idx := range ifaces {
+		if ifaces[idx].MAC != nil && strings.EqualFold(ifaces[idx].MAC.Address, mac) {
+			return &ifaces[idx]
+		}
+	}
+	return nil
+}
+
+// detachInterfaceLive detaches interface from running domain and its persistent
+// config, waiting for guest to release it like detachVolumeLive does
+func (repo *VirtualMachineRepository) detachInterfaceLive(id, nodeId, needleMac string) error {
+	conn, err := repo.pool.Open(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot open connection")
+	}
+	defer conn.Close()
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	liveConfig, inactiveConfig, err := getDomainLiveAndInactiveConfig(virDomain)
+	if err != nil {
+		return err
+	}
+
+	flags := libvirt.DomainDeviceModifyFlags(0)
+	liveIface := findDomainInterfaceByMac(liveConfig.Devices.Interfaces, needleMac)
+	ifaceConfig := liveIface
+	if liveIface != nil {
+		flags |= libvirt.DOMAIN_DEVICE_MODIFY_LIVE
+	}
+	if inactiveIface := findDomainInterfaceByMac(inactiveConfig.Devices.Interfaces, needleMac); inactiveIface != nil {
+		flags |= libvirt.DOMAIN_DEVICE_MODIFY_CONFIG
+		if ifaceConfig == nil {
+			ifaceConfig = inactiveIface
+		}
+	}
+	if ifaceConfig == nil {
+		return compute.ErrInterfaceNotFound
+	}
+	ifaceXml, err := ifaceConfig.Marshal()
+	if err != nil {
+		return util.NewError(err, "cannot marshal interface xml")
+	}
+	alias := ""
+	if liveIface != nil && liveIface.Alias != nil {
+		alias = liveIface.Alias.Name
+	}
+	released, err := detachDeviceAndWait(conn, virDomain, ifaceXml, flags, alias)
+	if err != nil {
+		return util.NewError(err, "cannot detach interface")
+	}
+	if !released {
+		return fmt.Errorf("guest didn't release interface %s in %s, it is removed from config and will be detached when guest acknowledges", needleMac, detachDeviceTimeout)
+	}
+	return nil
+}
+
+// SetInterfaceBandwidth changes traffic limits of interface in persistent
+// config and in running domain, zero values remove limits
+func (repo *VirtualMachineRepository) SetInterfaceBandwidth(id, nodeId, mac string, bandwidth compute.VirtualMachineInterfaceBandwidth) error {
 	conn, err := repo.pool.Acquire(nodeId)
 	if err != nil {
 		return util.NewError(err, "cannot acquire connection")
@@ -895,8 +2247,162 @@ func (repo *VirtualMachineRepository) detachInterfaceLibvirt(id, nodeId, needleM
 	if err != nil {
 		return util.NewError(err, "cannot check if domain is running")
 	}
+	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
+	if err != nil {
+		return util.NewError(err, "cannot get domain xml")
+	}
+	virDomainConfig := &libvirtxml.Domain{}
+	if err := virDomainConfig.Unmarshal(virDomainXml); err != nil {
+		return util.NewError(err, "cannot parse domain xml")
+	}
+	ifaceConfig := findDomainInterfaceByMac(virDomainConfig.Devices.Interfaces, mac)
+	if ifaceConfig == nil {
+		return compute.ErrInterfaceNotFound
+	}
+	params := &libvirt.DomainInterfaceParameters{
+		BandwidthInAverageSet:  true,
+		BandwidthInAverage:     bandwidth.Inbound.Average,
+		BandwidthInPeakSet:     true,
+		BandwidthInPeak:        bandwidth.Inbound.Peak,
+		BandwidthInBurstSet:    true,
+		BandwidthInBurst:       bandwidth.Inbound.Burst,
+		BandwidthOutAverageSet: true,
+		BandwidthOutAverage:    bandwidth.Outbound.Average,
+		BandwidthOutPeakSet:    true,
+		BandwidthOutPeak:       bandwidth.Outbound.Peak,
+		BandwidthOutBurstSet:   true,
+		BandwidthOutBurst:      bandwidth.Outbound.Burst,
+	}
+	flags := libvirt.DOMAIN_AFFECT_CONFIG
 	if running {
-		return fmt.Errorf("domain must be stopped")
+		flags |= libvirt.DOMAIN_AFFECT_LIVE
+	}
+	if err := virDomain.SetInterfaceParameters(ifaceConfig.MAC.Address, params, flags); err != nil {
+		return util.NewError(err, "cannot set interface bandwidth")
+	}
+	return nil
+}
+
+func findDomainHostdev(hostdevs []libvirtxml.DomainHostdev, device *compute.VirtualMachineAttachedHostDevice) *libvirtxml.DomainHostdev {
+	for idx := range hostdevs {
+		if attached := VirtualMachineAttachedHostDeviceFromHostdevConfig(hostdevs[idx]); attached != nil && *attached == *device {
+			return &hostdevs[idx]
+		}
+	}
+	return nil
+}
+
+// AttachHostDevice passes host device to machine, running machine gets it live
+func (repo *VirtualMachineRepository) AttachHostDevice(id, nodeId string, device *compute.VirtualMachineAttachedHostDevice) error {
+	hostdevConfig, err := DomainHostdevConfigFromVirtualMachineAttachedHostDevice(device)
+	if err != nil {
+		return err
+	}
+	hostdevXml, err := hostdevConfig.Marshal()
+	if err != nil {
+		return util.NewError(err, "cannot marshal hostdev xml")
+	}
+
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	running, err := virDomain.IsActive()
+	if err != nil {
+		return util.NewError(err, "cannot check if domain is running")
+	}
+	flags := libvirt.DOMAIN_DEVICE_MODIFY_CONFIG
+	if running {
+		flags |= libvirt.DOMAIN_DEVICE_MODIFY_LIVE
+	}
+	if err := virDomain.AttachDeviceFlags(hostdevXml, flags); err != nil {
+		return util.NewError(err, "cannot attach host device")
+	}
+	return nil
+}
+
+// DetachHostDevice returns host device from machine, running machine
+// is waited to release it like in detachVolumeLive
+func (repo *VirtualMachineRepository) DetachHostDevice(id, nodeId string, device *compute.VirtualMachineAttachedHostDevice) error {
+	running, err := repo.isDomainActive(id, nodeId)
+	if err != nil {
+		return err
+	}
+	conn, err := repo.pool.Open(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot open connection")
+	}
+	defer conn.Close()
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
+	}
+	liveConfig, inactiveConfig, err := getDomainLiveAndInactiveConfig(virDomain)
+	if err != nil {
+		return err
+	}
+
+	flags := libvirt.DomainDeviceModifyFlags(0)
+	var liveHostdev *libvirtxml.DomainHostdev
+	if running {
+		liveHostdev = findDomainHostdev(liveConfig.Devices.Hostdevs, device)
+	}
+	hostdevConfig := liveHostdev
+	if liveHostdev != nil {
+		flags |= libvirt.DOMAIN_DEVICE_MODIFY_LIVE
+	}
+	if inactiveHostdev := findDomainHostdev(inactiveConfig.Devices.Hostdevs, device); inactiveHostdev != nil {
+		flags |= libvirt.DOMAIN_DEVICE_MODIFY_CONFIG
+		if hostdevConfig == nil {
+			hostdevConfig = inactiveHostdev
+		}
+	}
+	if hostdevConfig == nil {
+		return fmt.Errorf("host device %s is not attached", device.Address)
+	}
+	hostdevXml, err := hostdevConfig.Marshal()
+	if err != nil {
+		return util.NewError(err, "cannot marshal hostdev xml")
+	}
+	alias := ""
+	if liveHostdev != nil && liveHostdev.Alias != nil {
+		alias = liveHostdev.Alias.Name
+	}
+	released, err := detachDeviceAndWait(conn, virDomain, hostdevXml, flags, alias)
+	if err != nil {
+		return util.NewError(err, "cannot detach host device")
+	}
+	if !released {
+		return fmt.Errorf("guest didn't release host device %s in %s, it is removed from config and will be detached when guest acknowledges", device.Address, detachDeviceTimeout)
+	}
+	return nil
+}
+
+func (repo *VirtualMachineRepository) detachInterfaceLibvirt(id, nodeId, needleMac string) error {
+	running, err := repo.isDomainActive(id, nodeId)
+	if err != nil {
+		return err
+	}
+	if running {
+		return repo.detachInterfaceLive(id, nodeId, needleMac)
+	}
+
+	conn, err := repo.pool.Acquire(nodeId)
+	if err != nil {
+		return util.NewError(err, "cannot acquire connection")
+	}
+	defer repo.pool.Release(nodeId)
+
+	virDomain, err := conn.LookupDomainByName(id)
+	if err != nil {
+		return util.NewError(err, "domain lookup failed")
 	}
 
 	virDomainXml, err := virDomain.GetXMLDesc(libvirt.DOMAIN_XML_INACTIVE)
@@ -1020,10 +2526,9 @@ func (repo *VirtualMachineRepository) detachInterfaceQemuArgs(id, nodeId, needle
 }
 
 func (repo *VirtualMachineRepository) DetachInterface(id, nodeId, needleMac string) error {
-	if err := repo.detachInterfaceLibvirt(id, nodeId, needleMac); err != nil {
-		if err == compute.ErrInterfaceNotFound {
-			return repo.detachInterfaceQemuArgs(id, nodeId, needleMac)
-		}
+	err := repo.detachInterfaceLibvirt(id, nodeId, needleMac)
+	if err == compute.ErrInterfaceNotFound {
+		return repo.detachInterfaceQemuArgs(id, nodeId, needleMac)
 	}
-	return nil
+	return err
 }
@@ -60,6 +60,11 @@ func (repo *VolumeRepository) virVolumeToVolume(nodeId string, pool *libvirt.Sto
 	case "qcow2":
 		volume.Format = compute.VolumeFormatQcow2
 	}
+	if virVolumeConfig.Physical != nil {
+		volume.PhysicalSize = ComputeSizeFromLibvirtSize(virVolumeConfig.Physical.Unit, virVolumeConfig.Physical.Value)
+	} else if volume.Format != compute.VolumeFormatQcow2 {
+		volume.PhysicalSize = volume.Size
+	}
 
 	return volume, nil
 }
@@ -366,25 +371,31 @@ func (w *virStreamWrapper) Write(p []byte) (int, error) {
 	return w.steam.Send(p)
 }
 
+// Upload uses separate connection, so long transfer doesn't block other
+// operations on the node. Stream is aborted if content is not fully sent.
 func (repo *VolumeRepository) Upload(path, nodeId string, content io.Reader, size uint64) error {
-	conn, err := repo.pool.Acquire(nodeId)
+	conn, err := repo.pool.Open(nodeId)
 	if err != nil {
-		return util.NewError(err, "cannot acquire connection")
+		return util.NewError(err, "cannot open connection")
 	}
-	defer repo.pool.Release(nodeId)
+	defer conn.Close()
 	virVolume, err := conn.LookupStorageVolByPath(path)
 	if err != nil {
 		return util.NewError(err, "cannot lookup storage volume")
 	}
+	defer virVolume.Free()
 
 	stream, err := conn.NewStream(0)
 	if err != nil {
 		return util.NewError(err, "cannot initialize upload stream")
 	}
+	defer stream.Free()
 	if err := virVolume.Upload(stream, 0, size, 0); err != nil {
+		stream.Abort()
 		return util.NewError(err, "cannot start upload")
 	}
 	if _, err := io.Copy(&virStreamWrapper{stream}, content); err != nil {
+		stream.Abort()
 		return util.NewError(err, "upload failed")
 	}
 	if err := stream.Finish(); err != nil {
@@ -392,3 +403,58 @@ func (repo *VolumeRepository) Upload(path, nodeId string, content io.Reader, siz
 	}
 	return nil
 }
+
+type virStreamDownloadReader struct {
+	stream *libvirt.Stream
+	volume *libvirt.StorageVol
+	conn   *libvirt.Connect
+	eof    bool
+	failed bool
+}
+
+func (r *virStreamDownloadReader) Read(b []byte) (int, error) {
+	n, err := r.stream.Recv(b)
+	if err == io.EOF {
+		r.eof = true
+	} else if err != nil {
+		r.failed = true
+	}
+	return n, err
+}
+
+// Close finishes stream only if it was read till the end, short read aborts it
+func (r *virStreamDownloadReader) Close() error {
+	defer r.conn.Close()
+	defer r.volume.Free()
+	defer r.stream.Free()
+	if !r.eof || r.failed {
+		return r.stream.Abort()
+	}
+	return r.stream.Finish()
+}
+
+func (repo *VolumeRepository) Download(path, nodeId string) (io.ReadCloser, error) {
+	conn, err := repo.pool.Open(nodeId)
+	if err != nil {
+		return nil, util.NewError(err, "cannot open connection")
+	}
+	virVolume, err := conn.LookupStorageVolByPath(path)
+	if err != nil {
+		conn.Close()
+		return nil, util.NewError(err, "cannot lookup storage volume")
+	}
+	stream, err := conn.NewStream(0)
+	if err != nil {
+		virVolume.Free()
+		conn.Close()
+		return nil, util.NewError(err, "cannot initialize download stream")
+	}
+	if err := virVolume.Download(stream, 0, 0, 0); err != nil {
+		stream.Abort()
+		stream.Free()
+		virVolume.Free()
+		conn.Close()
+		return nil, util.NewError(err, "cannot start download")
+	}
+	return &virStreamDownloadReader{stream: stream, volume: virVolume, conn: conn}, nil
+}
@@ -2,8 +2,9 @@
     exports.Vmango = exports.Vmango || {};
     exports.Vmango.ReactiveForm = function(selector){
         var $form = $(selector);
-        $form.on('submit', function(){
-            var $button = $('button[type=submit]', selector);
+        $form.on('submit', function(event){
+            var submitter = event.originalEvent && event.originalEvent.submitter;
+            var $button = submitter ? $(submitter) : $('button[type=submit]', selector);
             $button.prop('disabled', true);
             $button.html(
                 $button.attr('data-loading') || 'Loading...'
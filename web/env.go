@@ -33,6 +33,14 @@ var UIVolumeFormats = []compute.VolumeFormat{
 	compute.VolumeFormatRaw,
 }
 
+var ImportVolumeFormats = []compute.VolumeFormat{
+	compute.VolumeFormatVmdk,
+	compute.VolumeFormatVhdx,
+	compute.VolumeFormatVdi,
+	compute.VolumeFormatQcow2,
+	compute.VolumeFormatRaw,
+}
+
 var DeviceTypes = []compute.DeviceType{
 	compute.DeviceTypeDisk,
 	compute.DeviceTypeCdrom,
@@ -41,6 +49,7 @@ var DeviceTypes = []compute.DeviceType{
 var DeviceBuses = []compute.DeviceBus{
 	compute.DeviceBusVirtio,
 	compute.DeviceBusScsi,
+	compute.DeviceBusSata,
 	compute.DeviceBusIde,
 }
 
@@ -49,22 +58,26 @@ var InterfaceModels = []string{
 }
 
 type Environ struct {
-	render   *render.Render
-	logger   zerolog.Logger
-	router   *mux.Router
-	sessions sessions.Store
-	random   *rand.Rand
-	networks *libcompute.NetworkService
-	keys     *libcompute.KeyService
-	volpools *libcompute.VolumePoolService
-	nodes    *libcompute.NodeService
-	volumes  *libcompute.VolumeService
-	vms      *libcompute.VirtualMachineService
-	vmanager *libcompute.VirtualMachineManager
-	ws       *websocket.Upgrader
-	cfg      *config.WebConfig
-	oauth2   *oauth2.Config
-	oidcp    *oidc.Provider
+	render    *render.Render
+	logger    zerolog.Logger
+	router    *mux.Router
+	sessions  sessions.Store
+	random    *rand.Rand
+	networks  *libcompute.NetworkService
+	keys      *libcompute.KeyService
+	flavors   *libcompute.FlavorService
+	presets   *libcompute.VirtualMachinePresetService
+	volpools  *libcompute.VolumePoolService
+	nodes     *libcompute.NodeService
+	volumes   *libcompute.VolumeService
+	vms       *libcompute.VirtualMachineService
+	vmanager  *libcompute.VirtualMachineManager
+	schedules *libcompute.ScheduleService
+	expiry    *libcompute.VirtualMachineExpiryService
+	ws        *websocket.Upgrader
+	cfg       *config.WebConfig
+	oauth2    *oauth2.Config
+	oidcp     *oidc.Provider
 }
 
 func TemplateFuncs(env *Environ) []template.FuncMap {
@@ -116,6 +129,14 @@ func TemplateFuncs(env *Environ) []template.FuncMap {
 			"Title": func(s string) string {
 				return strings.Title(s)
 			},
+			"Contains": func(a []string, s string) bool {
+				for _, item := range a {
+					if item == s {
+						return true
+					}
+				}
+				return false
+			},
 			"Join": func(sep string, a []string) string {
 				return strings.Join(a, sep)
 			},
@@ -151,11 +172,15 @@ func New(
 	cfg *config.Config, logger zerolog.Logger,
 	networks *libcompute.NetworkService,
 	keys *libcompute.KeyService,
+	flavors *libcompute.FlavorService,
+	presets *libcompute.VirtualMachinePresetService,
 	volpools *libcompute.VolumePoolService,
 	nodes *libcompute.NodeService,
 	volumes *libcompute.VolumeService,
 	vms *libcompute.VirtualMachineService,
 	vmanager *libcompute.VirtualMachineManager,
+	schedules *libcompute.ScheduleService,
+	expiry *libcompute.VirtualMachineExpiryService,
 ) http.Handler {
 
 	env := &Environ{cfg: &cfg.Web}
@@ -192,11 +217,15 @@ func New(
 	env.router = router
 	env.networks = networks
 	env.keys = keys
+	env.flavors = flavors
+	env.presets = presets
 	env.volpools = volpools
 	env.nodes = nodes
 	env.volumes = volumes
 	env.vms = vms
 	env.vmanager = vmanager
+	env.schedules = schedules
+	env.expiry = expiry
 	env.sessions = sessionStore
 
 	router.HandleFunc("/static/{name:.*}", env.Static(cfg)).Name("static")
@@ -210,6 +239,8 @@ func New(
 
 	router.HandleFunc("/volumes/", env.authenticated(env.VolumeList)).Name("volume-list")
 	router.HandleFunc("/volumes/add/", env.authenticated(env.VolumeAddFormProcess)).Methods("POST").Name("volume-add-form")
+	router.HandleFunc("/volumes/import/", env.authenticated(env.VolumeImportFormProcess)).Methods("POST").Name("volume-import-form")
+	router.HandleFunc("/volumes/import/", env.authenticated(env.VolumeImportFormShow)).Name("volume-import-form")
 	router.HandleFunc("/volumes/{node}/{path}/delete/", env.authenticated(env.VolumeDeleteFormProcess)).Methods("POST").Name("volume-delete-form")
 	router.HandleFunc("/volumes/{node}/{path}/delete/", env.authenticated(env.VolumeDeleteFormShow)).Name("volume-delete-form")
 	router.HandleFunc("/volumes/{node}/{path}/clone/", env.authenticated(env.VolumeCloneFormProcess)).Methods("POST").Name("volume-clone-form")
@@ -219,6 +250,24 @@ func New(
 
 	router.HandleFunc("/networks/", env.authenticated(env.NetworkList)).Name("network-list")
 
+	router.HandleFunc("/flavors/", env.authenticated(env.FlavorList)).Name("flavor-list")
+	router.HandleFunc("/flavors/add/", env.authenticated(env.FlavorAddFormProcess)).Methods("POST").Name("flavor-add")
+	router.HandleFunc("/flavors/{name}/delete/", env.authenticated(env.FlavorDeleteFormProcess)).Methods("POST").Name("flavor-delete-form")
+	router.HandleFunc("/flavors/{name}/delete/", env.authenticated(env.FlavorDeleteFormShow)).Name("flavor-delete-form")
+
+	router.HandleFunc("/presets/", env.authenticated(env.PresetList)).Name("preset-list")
+	router.HandleFunc("/presets/add/", env.authenticated(env.PresetAddFormProcess)).Methods("POST").Name("preset-add")
+	router.HandleFunc("/presets/{name}/delete/", env.authenticated(env.PresetDeleteFormProcess)).Methods("POST").Name("preset-delete-form")
+	router.HandleFunc("/presets/{name}/delete/", env.authenticated(env.PresetDeleteFormShow)).Name("preset-delete-form")
+
+	router.HandleFunc("/schedules/", env.authenticated(env.ScheduleList)).Name("schedule-list")
+	router.HandleFunc("/schedules/add/", env.authenticated(env.ScheduleAddFormProcess)).Methods("POST").Name("schedule-add")
+	router.HandleFunc("/schedules/{id}/", env.authenticated(env.ScheduleDetail)).Name("schedule-detail")
+	router.HandleFunc("/schedules/{id}/toggle/", env.authenticated(env.ScheduleToggleFormProcess)).Methods("POST").Name("schedule-toggle")
+	router.HandleFunc("/schedules/{id}/run/", env.authenticated(env.ScheduleRunFormProcess)).Methods("POST").Name("schedule-run")
+	router.HandleFunc("/schedules/{id}/delete/", env.authenticated(env.ScheduleDeleteFormProcess)).Methods("POST").Name("schedule-delete-form")
+	router.HandleFunc("/schedules/{id}/delete/", env.authenticated(env.ScheduleDeleteFormShow)).Name("schedule-delete-form")
+
 	router.HandleFunc("/keys/", env.authenticated(env.KeyList)).Name("key-list")
 	router.HandleFunc("/keys/add/", env.authenticated(env.KeyAddFormProcess)).Methods("POST").Name("key-add")
 	router.HandleFunc("/keys/{fingerprint}/show/", env.authenticated(env.KeyShow)).Name("key-show")
@@ -228,6 +277,9 @@ func New(
 	router.HandleFunc("/machines/", env.authenticated(env.VirtualMachineList)).Name("virtual-machine-list")
 	router.HandleFunc("/machines/add/", env.authenticated(env.VirtualMachineAddFormProcess)).Methods("POST").Name("virtual-machine-add")
 	router.HandleFunc("/machines/add/", env.authenticated(env.VirtualMachineAddFormShow)).Name("virtual-machine-add")
+	router.HandleFunc("/machines/bulk/", env.authenticated(env.VirtualMachineBulkActionFormProcess)).Methods("POST").Name("virtual-machine-bulk")
+	router.HandleFunc("/machines/import/", env.authenticated(env.VirtualMachineImportFormProcess)).Methods("POST").Name("virtual-machine-import")
+	router.HandleFunc("/machines/import/", env.authenticated(env.VirtualMachineImportFormShow)).Name("virtual-machine-import")
 	router.HandleFunc("/machines/{node}/{id}/", env.authenticated(env.VirtualMachineDetail)).Name("virtual-machine-detail")
 	router.HandleFunc("/machines/{node}/{id}/attach-disk/", env.authenticated(env.VirtualMachineAttachDiskFormProcess)).Methods("POST").Name("virtual-machine-attach-disk")
 	router.HandleFunc("/machines/{node}/{id}/console/", env.authenticated(env.VirtualMachineConsoleShow)).Name("virtual-machine-console-show")
@@ -235,6 +287,9 @@ func New(
 	router.HandleFunc("/machines/{node}/{id}/vnc/", env.authenticated(env.VirtualMachineVncShow)).Name("virtual-machine-vnc-show")
 	router.HandleFunc("/machines/{node}/{id}/vnc/ws/", env.authenticated(env.VirtualMachineVncWs)).Name("virtual-machine-vnc-ws")
 	router.HandleFunc("/machines/{node}/{id}/detach-volume/", env.authenticated(env.VirtualMachineDetachVolumeFormProcess)).Methods("POST").Name("virtual-machine-detach-volume")
+	router.HandleFunc("/machines/{node}/{id}/attach-hostdev/", env.authenticated(env.VirtualMachineAttachHostDeviceFormProcess)).Methods("POST").Name("virtual-machine-attach-hostdev")
+	router.HandleFunc("/machines/{node}/{id}/detach-hostdev/", env.authenticated(env.VirtualMachineDetachHostDeviceFormProcess)).Methods("POST").Name("virtual-machine-detach-hostdev")
+	router.HandleFunc("/machines/{node}/{id}/memory-target/", env.authenticated(env.VirtualMachineMemoryTargetFormProcess)).Methods("POST").Name("virtual-machine-memory-target")
 	router.HandleFunc("/machines/{node}/{id}/attach-interface/", env.authenticated(env.VirtualMachineAttachInterfaceFormProcess)).Methods("POST").Name("virtual-machine-attach-interface")
 	router.HandleFunc("/machines/{node}/{id}/detach-interface/", env.authenticated(env.VirtualMachineDetachInterfaceFormProcess)).Methods("POST").Name("virtual-machine-detach-interface")
 	router.HandleFunc("/machines/{node}/{id}/set-state/{action}/", env.authenticated(env.VirtualMachineStateSetFormProcess)).Name("virtual-machine-state-form").Methods("POST")
@@ -243,8 +298,29 @@ func New(
 	router.HandleFunc("/machines/{node}/{id}/delete/", env.authenticated(env.VirtualMachineDeleteFormShow)).Name("virtual-machine-delete")
 	router.HandleFunc("/machines/{node}/{id}/update/", env.authenticated(env.VirtualMachineUpdateFormProcess)).Name("virtual-machine-update").Methods("POST")
 	router.HandleFunc("/machines/{node}/{id}/update/", env.authenticated(env.VirtualMachineUpdateFormShow)).Name("virtual-machine-update")
+	router.HandleFunc("/machines/{node}/{id}/cpupin/", env.authenticated(env.VirtualMachineCpuPinFormProcess)).Name("virtual-machine-cpupin").Methods("POST")
+	router.HandleFunc("/machines/{node}/{id}/cpupin/", env.authenticated(env.VirtualMachineCpuPinFormShow)).Name("virtual-machine-cpupin")
+	router.HandleFunc("/machines/{node}/{id}/iotune/", env.authenticated(env.VirtualMachineIoTuneFormProcess)).Name("virtual-machine-iotune").Methods("POST")
+	router.HandleFunc("/machines/{node}/{id}/iotune/", env.authenticated(env.VirtualMachineIoTuneFormShow)).Name("virtual-machine-iotune")
+	router.HandleFunc("/machines/{node}/{id}/bandwidth/", env.authenticated(env.VirtualMachineBandwidthFormProcess)).Name("virtual-machine-bandwidth").Methods("POST")
+	router.HandleFunc("/machines/{node}/{id}/bandwidth/", env.authenticated(env.VirtualMachineBandwidthFormShow)).Name("virtual-machine-bandwidth")
+	router.HandleFunc("/machines/{node}/{id}/renew/", env.authenticated(env.VirtualMachineRenewFormProcess)).Name("virtual-machine-renew").Methods("POST")
+	router.HandleFunc("/machines/{node}/{id}/rename/", env.authenticated(env.VirtualMachineRenameFormProcess)).Name("virtual-machine-rename").Methods("POST")
+	router.HandleFunc("/machines/{node}/{id}/rename/", env.authenticated(env.VirtualMachineRenameFormShow)).Name("virtual-machine-rename")
+	router.HandleFunc("/machines/{node}/{id}/migrate/", env.authenticated(env.VirtualMachineMigrateFormProcess)).Name("virtual-machine-migrate").Methods("POST")
+	router.HandleFunc("/machines/{node}/{id}/migrate/", env.authenticated(env.VirtualMachineMigrateFormShow)).Name("virtual-machine-migrate")
+	router.HandleFunc("/machines/{node}/{id}/migrate/status/", env.authenticated(env.VirtualMachineMigrateStatus)).Name("virtual-machine-migrate-status")
+	router.HandleFunc("/machines/{node}/{id}/export/", env.authenticated(env.VirtualMachineExport)).Name("virtual-machine-export")
 
 	router.HandleFunc("/nodes/{id}/", env.authenticated(env.NodeDetail)).Name("node-detail")
+
+	// Api endpoints use http basic auth instead of session, so they are not csrf protected
+	router.HandleFunc("/api/flavors/", env.ApiFlavorList).Methods("GET", "POST").Name("api-flavor-list")
+	router.HandleFunc("/api/flavors/{name}/", env.ApiFlavorDetail).Methods("GET", "POST").Name("api-flavor-detail")
+	router.HandleFunc("/api/flavors/{name}/delete/", env.ApiFlavorDelete).Methods("POST").Name("api-flavor-delete")
+	router.HandleFunc("/api/presets/{name}/create/", env.PresetCreateMachine).Methods("POST").Name("api-preset-create")
+	router.HandleFunc("/api/machines/{node}/{id}/boot/", env.ApiVirtualMachineBootUpdate).Methods("POST").Name("api-virtual-machine-boot")
+	router.HandleFunc("/api/machines/{node}/{id}/memory/", env.ApiVirtualMachineMemory).Methods("GET", "POST").Name("api-virtual-machine-memory")
 	router.HandleFunc("/", env.authenticated(env.NodeList)).Name("node-list")
 
 	if cfg.Web.Oidc.ClientId != "" {
@@ -264,7 +340,14 @@ func New(
 		env.oidcp = oidcp
 	}
 
-	return csrfProtect(env)
+	protected := csrfProtect(env)
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if strings.HasPrefix(req.URL.Path, "/api/") {
+			env.ServeHTTP(rw, req)
+			return
+		}
+		protected.ServeHTTP(rw, req)
+	})
 }
 
 func (env *Environ) error(rw http.ResponseWriter, req *http.Request, err error, message string, status int) {
@@ -318,6 +401,32 @@ func (env *Environ) authenticated(handler http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// isAdmin reports if user is allowed to manage shared resources and host hardware,
+// every user is an admin if no admin users or emails are configured
+func (env *Environ) isAdmin(user *User) bool {
+	if user == nil {
+		return false
+	}
+	return user.Admin || !env.adminsConfigured()
+}
+
+func (env *Environ) adminsConfigured() bool {
+	if len(env.cfg.Oidc.AdminEmails) > 0 {
+		return true
+	}
+	for _, user := range env.cfg.Users {
+		if user.Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// flavorsOnly reports if user is allowed to create and resize machines only with flavors
+func (env *Environ) flavorsOnly(user *User) bool {
+	return env.cfg.RestrictToFlavors && !user.Admin
+}
+
 func (env *Environ) checkPassword(userId string, password string) *User {
 	for _, user := range env.cfg.Users {
 		if user.Id != userId {
@@ -332,12 +441,29 @@ func (env *Environ) checkPassword(userId string, password string) *User {
 			Email:         user.Email,
 			FullName:      user.FullName,
 			Authenticated: true,
+			Admin:         user.Admin,
 		}
 	}
 	env.logger.Warn().Str("id", userId).Msg("user not found")
 	return nil
 }
 
+// apiAuthenticate checks basic auth credentials of api request,
+// error response is written if user is not authenticated
+func (env *Environ) apiAuthenticate(rw http.ResponseWriter, req *http.Request) *User {
+	userId, password, ok := req.BasicAuth()
+	if !ok {
+		http.Error(rw, "authentication required", http.StatusUnauthorized)
+		return nil
+	}
+	user := env.checkPassword(userId, password)
+	if user == nil {
+		http.Error(rw, "authentication failed", http.StatusUnauthorized)
+		return nil
+	}
+	return user
+}
+
 func (env *Environ) ServeHTTP(w http.ResponseWriter, request *http.Request) {
 	env.router.ServeHTTP(w, request)
 }
@@ -94,6 +94,12 @@ func (env *Environ) OidcLoginCallback(rw http.ResponseWriter, req *http.Request)
 		FullName:      claims.Name,
 		Authenticated: true,
 	}
+	for _, adminEmail := range env.cfg.Oidc.AdminEmails {
+		if claims.Email == adminEmail {
+			user.Admin = true
+			break
+		}
+	}
 
 	session := env.Session(req)
 	session.SetAuthUser(user)
@@ -0,0 +1,320 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) FlavorList(rw http.ResponseWriter, req *http.Request) {
+	flavors, err := env.flavors.List()
+	if err != nil {
+		env.error(rw, req, err, "flavor list failed", http.StatusInternalServerError)
+		return
+	}
+	nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "nodes list failed", http.StatusInternalServerError)
+		return
+	}
+	user := env.Session(req).AuthUser()
+	data := struct {
+		Title    string
+		Flavors  []*compute.Flavor
+		Nodes    []*compute.Node
+		Editable bool
+		User     *User
+		Request  *http.Request
+	}{"Flavors", flavors, nodes, env.isAdmin(user), user, req}
+	if err := env.render.HTML(rw, http.StatusOK, "flavor/list", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) FlavorAddFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can manage flavors", http.StatusForbidden)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flavor, err := formFlavorValues(req.Form)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := env.flavors.Add(flavor); err != nil {
+		env.error(rw, req, err, "cannot add flavor", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("flavor-list")
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+func (env *Environ) FlavorDeleteFormShow(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	flavor, err := env.flavors.Get(urlvars["name"])
+	if err != nil {
+		env.error(rw, req, err, "flavor get failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title   string
+		Flavor  *compute.Flavor
+		User    *User
+		Request *http.Request
+	}{"Delete Flavor", flavor, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "flavor/delete", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) FlavorDeleteFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can manage flavors", http.StatusForbidden)
+		return
+	}
+	urlvars := mux.Vars(req)
+	if err := env.flavors.Delete(urlvars["name"]); err != nil {
+		env.error(rw, req, err, "cannot delete flavor", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("flavor-list")
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+// formFlavor returns flavor selected in the form, nil means custom sizing
+func (env *Environ) formFlavor(form url.Values, user *User, nodeId string) (*compute.Flavor, error) {
+	name := form.Get("Flavor")
+	if name == "" {
+		if env.flavorsOnly(user) {
+			return nil, fmt.Errorf("custom sizing is not allowed, please choose a flavor")
+		}
+		return nil, nil
+	}
+	flavor, err := env.flavors.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if !flavor.AllowedOn(nodeId) {
+		return nil, fmt.Errorf("flavor %s is not allowed on node %s", flavor.Name, nodeId)
+	}
+	return flavor, nil
+}
+
+// formFlavorValues parses flavor from the form, the same fields are used by api
+func formFlavorValues(form url.Values) (*compute.Flavor, error) {
+	flavor := &compute.Flavor{
+		Name:      form.Get("Name"),
+		Hugepages: form.Get("Hugepages") == "true",
+	}
+	if flavor.Name == "" {
+		return nil, fmt.Errorf("flavor name cannot be empty")
+	}
+	vcpus, err := strconv.ParseUint(form.Get("Vcpus"), 10, 16)
+	if err != nil || vcpus == 0 {
+		return nil, fmt.Errorf("invalid vcpus value: %s", form.Get("Vcpus"))
+	}
+	flavor.VCpus = int(vcpus)
+	memoryMb, err := strconv.ParseUint(form.Get("MemoryMb"), 10, 64)
+	if err != nil || memoryMb == 0 {
+		return nil, fmt.Errorf("invalid memory size: %s", form.Get("MemoryMb"))
+	}
+	flavor.Memory = compute.NewSize(memoryMb, compute.SizeUnitM)
+	if form.Get("RootDiskGb") != "" {
+		rootDiskGb, err := strconv.ParseUint(form.Get("RootDiskGb"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root disk size: %s", form.Get("RootDiskGb"))
+		}
+		flavor.RootDisk = compute.NewSize(rootDiskGb, compute.SizeUnitG)
+	}
+	for _, value := range strings.Fields(strings.Replace(form.Get("ExtraDisksGb"), ",", " ", -1)) {
+		sizeGb, err := strconv.ParseUint(value, 10, 64)
+		if err != nil || sizeGb == 0 {
+			return nil, fmt.Errorf("invalid extra disk size: %s", value)
+		}
+		flavor.ExtraDisks = append(flavor.ExtraDisks, compute.NewSize(sizeGb, compute.SizeUnitG))
+	}
+	flavor.NodeIds = form["Nodes"]
+	return flavor, nil
+}
+
+// flavorFormValues converts flavor to the form, so api update can change only posted fields
+func flavorFormValues(flavor *compute.Flavor) url.Values {
+	form := url.Values{}
+	form.Set("Name", flavor.Name)
+	form.Set("Vcpus", strconv.Itoa(flavor.VCpus))
+	form.Set("MemoryMb", strconv.FormatUint(flavor.Memory.M(), 10))
+	form.Set("RootDiskGb", strconv.FormatUint(flavor.RootDisk.G(), 10))
+	extraDisks := []string{}
+	for _, size := range flavor.ExtraDisks {
+		extraDisks = append(extraDisks, strconv.FormatUint(size.G(), 10))
+	}
+	form.Set("ExtraDisksGb", strings.Join(extraDisks, ","))
+	if flavor.Hugepages {
+		form.Set("Hugepages", "true")
+	}
+	form["Nodes"] = flavor.NodeIds
+	return form
+}
+
+type apiFlavor struct {
+	Name         string
+	Vcpus        int
+	MemoryMb     uint64
+	RootDiskGb   uint64
+	ExtraDisksGb []uint64
+	Hugepages    bool
+	Nodes        []string
+	ReadOnly     bool
+}
+
+func newApiFlavor(flavor *compute.Flavor) apiFlavor {
+	result := apiFlavor{
+		Name:         flavor.Name,
+		Vcpus:        flavor.VCpus,
+		MemoryMb:     flavor.Memory.M(),
+		RootDiskGb:   flavor.RootDisk.G(),
+		ExtraDisksGb: []uint64{},
+		Hugepages:    flavor.Hugepages,
+		Nodes:        flavor.NodeIds,
+		ReadOnly:     flavor.ReadOnly,
+	}
+	for _, size := range flavor.ExtraDisks {
+		result.ExtraDisksGb = append(result.ExtraDisksGb, size.G())
+	}
+	if result.Nodes == nil {
+		result.Nodes = []string{}
+	}
+	return result
+}
+
+// apiFlavorError writes flavor repository error with matching status code
+func (env *Environ) apiFlavorError(rw http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case compute.ErrFlavorNotFound:
+		status = http.StatusNotFound
+	case compute.ErrFlavorAlreadyExists:
+		status = http.StatusConflict
+	case compute.ErrFlavorReadOnly:
+		status = http.StatusForbidden
+	}
+	if status == http.StatusInternalServerError {
+		env.logger.Warn().Err(err).Msg("flavor api request failed")
+	}
+	http.Error(rw, err.Error(), status)
+}
+
+// ApiFlavorList returns all flavors, including read only ones from configuration file.
+// New flavor is created if request is POST, fields are the same as in the flavor form.
+func (env *Environ) ApiFlavorList(rw http.ResponseWriter, req *http.Request) {
+	user := env.apiAuthenticate(rw, req)
+	if user == nil {
+		return
+	}
+	if req.Method == http.MethodPost {
+		if !env.isAdmin(user) {
+			http.Error(rw, "only administrators can manage flavors", http.StatusForbidden)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		flavor, err := formFlavorValues(req.PostForm)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := env.flavors.Add(flavor); err != nil {
+			env.apiFlavorError(rw, err)
+			return
+		}
+		if err := env.render.JSON(rw, http.StatusCreated, newApiFlavor(flavor)); err != nil {
+			env.logger.Warn().Err(err).Msg("cannot render response")
+		}
+		return
+	}
+	flavors, err := env.flavors.List()
+	if err != nil {
+		env.apiFlavorError(rw, err)
+		return
+	}
+	data := []apiFlavor{}
+	for _, flavor := range flavors {
+		data = append(data, newApiFlavor(flavor))
+	}
+	if err := env.render.JSON(rw, http.StatusOK, data); err != nil {
+		env.logger.Warn().Err(err).Msg("cannot render response")
+	}
+}
+
+// ApiFlavorDetail returns the flavor, it is updated first if request is POST.
+// Fields not specified in request are left unchanged, flavor cannot be renamed.
+func (env *Environ) ApiFlavorDetail(rw http.ResponseWriter, req *http.Request) {
+	user := env.apiAuthenticate(rw, req)
+	if user == nil {
+		return
+	}
+	urlvars := mux.Vars(req)
+	flavor, err := env.flavors.Get(urlvars["name"])
+	if err != nil {
+		env.apiFlavorError(rw, err)
+		return
+	}
+	if req.Method == http.MethodPost {
+		if !env.isAdmin(user) {
+			http.Error(rw, "only administrators can manage flavors", http.StatusForbidden)
+			return
+		}
+		if err := req.ParseForm(); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		form := flavorFormValues(flavor)
+		for key, values := range req.PostForm {
+			form[key] = values
+		}
+		form.Set("Name", flavor.Name)
+		flavor, err = formFlavorValues(form)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := env.flavors.Update(flavor); err != nil {
+			env.apiFlavorError(rw, err)
+			return
+		}
+	}
+	if err := env.render.JSON(rw, http.StatusOK, newApiFlavor(flavor)); err != nil {
+		env.logger.Warn().Err(err).Msg("cannot render response")
+	}
+}
+
+// ApiFlavorDelete deletes the flavor, flavors from configuration file cannot be deleted
+func (env *Environ) ApiFlavorDelete(rw http.ResponseWriter, req *http.Request) {
+	user := env.apiAuthenticate(rw, req)
+	if user == nil {
+		return
+	}
+	if !env.isAdmin(user) {
+		http.Error(rw, "only administrators can manage flavors", http.StatusForbidden)
+		return
+	}
+	urlvars := mux.Vars(req)
+	if err := env.flavors.Delete(urlvars["name"]); err != nil {
+		env.apiFlavorError(rw, err)
+		return
+	}
+	rw.WriteHeader(http.StatusNoContent)
+}
@@ -27,12 +27,17 @@ func (env *Environ) NodeDetail(rw http.ResponseWriter, req *http.Request) {
 		env.error(rw, req, err, "node get failed", http.StatusInternalServerError)
 		return
 	}
+	devices, err := env.nodes.ListDevices(node.Id)
+	if err != nil {
+		env.logger.Warn().Err(err).Str("node", node.Id).Msg("cannot list node devices")
+	}
 	data := struct {
 		Title   string
 		Node    *compute.Node
+		Devices []*compute.NodeDevice
 		User    *User
 		Request *http.Request
-	}{"Node " + node.Id, node, env.Session(req).AuthUser(), req}
+	}{"Node " + node.Id, node, devices, env.Session(req).AuthUser(), req}
 	if err := env.render.HTML(rw, http.StatusOK, "node/detail", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
@@ -0,0 +1,205 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"subuk/vmango/compute"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) PresetList(rw http.ResponseWriter, req *http.Request) {
+	presets, err := env.presets.List()
+	if err != nil {
+		env.error(rw, req, err, "preset list failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title   string
+		Presets []*compute.VirtualMachinePreset
+		BaseUrl string
+		User    *User
+		Request *http.Request
+	}{"Presets", presets, env.cfg.BaseUrl, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "preset/list", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// PresetAddFormProcess saves machine creation form as a new preset
+func (env *Environ) PresetAddFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	name := req.Form.Get("PresetName")
+	if name == "" {
+		http.Error(rw, "preset name cannot be empty", http.StatusBadRequest)
+		return
+	}
+	params, err := env.parseVirtualMachineAddForm(req.Form, env.Session(req).AuthUser())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(params.CloneVols) != 1 || len(params.Vm.Volumes) > 0 || len(req.Form["CreateVolumeName"]) > 0 {
+		http.Error(rw, "preset can contain only root volume cloned from an image", http.StatusBadRequest)
+		return
+	}
+	vm := params.Vm
+	preset := &compute.VirtualMachinePreset{
+		Name:        name,
+		NodeId:      vm.NodeId,
+		Arch:        vm.Arch,
+		Image:       params.CloneVols[0].OriginalPath,
+		RootPool:    params.CloneVols[0].NewPool,
+		RootFormat:  params.CloneVols[0].NewFormat,
+		RootSize:    params.CloneVols[0].NewSize,
+		Flavor:      vm.Flavor,
+		VCpus:       vm.VCpus,
+		Memory:      vm.Memory,
+		Userdata:    string(vm.Config.Userdata),
+		GraphicType: vm.Graphic.Type,
+		VideoModel:  vm.VideoModel,
+		GuestAgent:  vm.GuestAgent,
+		Hugepages:   vm.Hugepages,
+		Start:       params.Start,
+	}
+	for _, iface := range vm.Interfaces {
+		preset.Interfaces = append(preset.Interfaces, compute.VirtualMachinePresetInterface{
+			NetworkName: iface.NetworkName,
+			Model:       iface.Model,
+			AccessVlan:  iface.AccessVlan,
+		})
+	}
+	for _, key := range vm.Config.Keys {
+		preset.Keys = append(preset.Keys, key.Fingerprint)
+	}
+	if err := env.presets.Add(preset); err != nil {
+		env.error(rw, req, err, "cannot add preset", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("preset-list")
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+func (env *Environ) PresetDeleteFormShow(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	preset, err := env.presets.Get(urlvars["name"])
+	if err != nil {
+		env.error(rw, req, err, "preset get failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title   string
+		Preset  *compute.VirtualMachinePreset
+		User    *User
+		Request *http.Request
+	}{"Delete Preset", preset, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "preset/delete", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) PresetDeleteFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := env.presets.Delete(urlvars["name"]); err != nil {
+		env.error(rw, req, err, "cannot delete preset", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("preset-list")
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+// PresetCreateMachine creates machine from the preset.
+// It is an api endpoint authenticated with http basic auth, only Name is required,
+// any other machine creation form field overrides preset value.
+func (env *Environ) PresetCreateMachine(rw http.ResponseWriter, req *http.Request) {
+	user := env.apiAuthenticate(rw, req)
+	if user == nil {
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	urlvars := mux.Vars(req)
+	preset, err := env.presets.Get(urlvars["name"])
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == compute.ErrVirtualMachinePresetNotFound {
+			status = http.StatusNotFound
+		}
+		http.Error(rw, err.Error(), status)
+		return
+	}
+	form := presetFormValues(preset)
+	for key, values := range req.PostForm {
+		form[key] = values
+	}
+	if form.Get("Name") == "" {
+		http.Error(rw, "machine name required", http.StatusBadRequest)
+		return
+	}
+	params, err := env.parseVirtualMachineAddForm(form, user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := env.vmanager.Create(params.Vm, params.CloneVols, params.NewVols, params.Start); err != nil {
+		env.logger.Warn().Err(err).Str("preset", preset.Name).Msg("cannot create vm from preset")
+		http.Error(rw, "cannot create vm: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Id     string
+		NodeId string
+		Url    string
+	}{params.Vm.Id, params.Vm.NodeId, env.url("virtual-machine-detail", "id", params.Vm.Id, "node", params.Vm.NodeId).Path}
+	if err := env.render.JSON(rw, http.StatusCreated, data); err != nil {
+		env.logger.Warn().Err(err).Msg("cannot render response")
+	}
+}
+
+// presetFormValues converts preset to the machine creation form
+func presetFormValues(preset *compute.VirtualMachinePreset) url.Values {
+	form := url.Values{}
+	form.Set("NodeId", preset.NodeId)
+	form.Set("Arch", preset.Arch.String())
+	form.Set("Flavor", preset.Flavor)
+	form.Set("Vcpus", strconv.Itoa(preset.VCpus))
+	form.Set("MemoryValue", strconv.FormatUint(preset.Memory.Bytes(), 10))
+	form.Set("MemoryUnit", "B")
+	form.Set("CloneVolumeOriginalPath", preset.Image)
+	form.Set("CloneVolumeNewName", "__magic_root_suffix__")
+	form.Set("CloneVolumeNewPool", preset.RootPool)
+	form.Set("CloneVolumeNewFormat", preset.RootFormat.String())
+	form.Set("CloneVolumeNewSizeValue", "")
+	if preset.RootSize.Bytes() > 0 {
+		form.Set("CloneVolumeNewSizeValue", strconv.FormatUint(preset.RootSize.Bytes(), 10))
+	}
+	form.Set("CloneVolumeNewSizeUnit", "B")
+	form.Set("CloneVolumeDeviceType", compute.DeviceTypeDisk.String())
+	form.Set("CloneVolumeDeviceBus", compute.DeviceBusVirtio.String())
+	for _, iface := range preset.Interfaces {
+		form.Add("InterfaceNetwork", iface.NetworkName)
+		form.Add("InterfaceMac", "")
+		form.Add("InterfaceModel", iface.Model)
+		accessVlan := ""
+		if iface.AccessVlan > 0 {
+			accessVlan = strconv.FormatUint(uint64(iface.AccessVlan), 10)
+		}
+		form.Add("InterfaceAccessVlan", accessVlan)
+	}
+	form["Keys"] = preset.Keys
+	form.Set("Userdata", preset.Userdata)
+	form.Set("GraphicType", preset.GraphicType.String())
+	form.Set("VideoModel", preset.VideoModel.String())
+	form.Set("GuestAgent", strconv.FormatBool(preset.GuestAgent))
+	form.Set("Hugepages", strconv.FormatBool(preset.Hugepages))
+	form.Set("Start", strconv.FormatBool(preset.Start))
+	return form
+}
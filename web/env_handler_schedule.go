@@ -0,0 +1,166 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+	"subuk/vmango/compute"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+func (env *Environ) ScheduleList(rw http.ResponseWriter, req *http.Request) {
+	schedules, err := env.schedules.List()
+	if err != nil {
+		env.error(rw, req, err, "schedule list failed", http.StatusInternalServerError)
+		return
+	}
+	vms, err := env.vms.List(compute.VirtualMachineListOptions{})
+	if err != nil {
+		env.error(rw, req, err, "vm list failed", http.StatusInternalServerError)
+		return
+	}
+	nextRuns := map[string]time.Time{}
+	for _, schedule := range schedules {
+		next, err := schedule.Next(time.Now())
+		if err != nil {
+			continue
+		}
+		nextRuns[schedule.Id] = next
+	}
+	data := struct {
+		Title     string
+		Schedules []*compute.Schedule
+		NextRuns  map[string]time.Time
+		Actions   []string
+		Vms       []*compute.VirtualMachine
+		User      *User
+		Request   *http.Request
+	}{"Schedules", schedules, nextRuns, compute.ScheduleActions, vms, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "schedule/list", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) ScheduleAddFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	schedule := &compute.Schedule{
+		Name:     req.Form.Get("Name"),
+		Cron:     req.Form.Get("Cron"),
+		Timezone: req.Form.Get("Timezone"),
+		Action:   req.Form.Get("Action"),
+		Tag:      strings.TrimSpace(req.Form.Get("Tag")),
+		Enabled:  true,
+	}
+	if machine := req.Form.Get("Machine"); machine != "" {
+		parts := strings.SplitN(machine, "/", 2)
+		if len(parts) != 2 {
+			http.Error(rw, "invalid machine: "+machine, http.StatusBadRequest)
+			return
+		}
+		schedule.NodeId, schedule.MachineId = parts[0], parts[1]
+	}
+	if err := schedule.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := env.schedules.Save(schedule); err != nil {
+		env.error(rw, req, err, "cannot save schedule", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("schedule-list")
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+func (env *Environ) ScheduleDetail(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	schedule, err := env.schedules.Get(urlvars["id"])
+	if err != nil {
+		status := http.StatusInternalServerError
+		if err == compute.ErrScheduleNotFound {
+			status = http.StatusNotFound
+		}
+		env.error(rw, req, err, "schedule get failed", status)
+		return
+	}
+	executions, err := env.schedules.ListExecutions(schedule.Id)
+	if err != nil {
+		env.error(rw, req, err, "cannot list schedule executions", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title      string
+		Schedule   *compute.Schedule
+		Executions []*compute.ScheduleExecution
+		User       *User
+		Request    *http.Request
+	}{"Schedule " + schedule.Name, schedule, executions, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "schedule/detail", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) ScheduleToggleFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	schedule, err := env.schedules.Get(urlvars["id"])
+	if err != nil {
+		env.error(rw, req, err, "schedule get failed", http.StatusInternalServerError)
+		return
+	}
+	schedule.Enabled = !schedule.Enabled
+	if err := env.schedules.Save(schedule); err != nil {
+		env.error(rw, req, err, "cannot save schedule", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("schedule-list")
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+func (env *Environ) ScheduleRunFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	schedule, err := env.schedules.Get(urlvars["id"])
+	if err != nil {
+		env.error(rw, req, err, "schedule get failed", http.StatusInternalServerError)
+		return
+	}
+	if _, err := env.schedules.Execute(schedule); err != nil {
+		env.error(rw, req, err, "cannot execute schedule", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("schedule-detail", "id", schedule.Id)
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+func (env *Environ) ScheduleDeleteFormShow(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	schedule, err := env.schedules.Get(urlvars["id"])
+	if err != nil {
+		env.error(rw, req, err, "schedule get failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title    string
+		Schedule *compute.Schedule
+		User     *User
+		Request  *http.Request
+	}{"Delete Schedule", schedule, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "schedule/delete", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) ScheduleDeleteFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := env.schedules.Delete(urlvars["id"]); err != nil {
+		env.error(rw, req, err, "cannot delete schedule", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("schedule-list")
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
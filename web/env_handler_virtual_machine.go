@@ -5,8 +5,14 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"reflect"
 	"strconv"
+	"strings"
 	"subuk/vmango/compute"
+	"subuk/vmango/util"
+	"time"
+	"unicode"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -23,17 +29,114 @@ func (env *Environ) VirtualMachineList(rw http.ResponseWriter, req *http.Request
 		env.error(rw, req, err, "vm list failed", http.StatusInternalServerError)
 		return
 	}
+	nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "nodes list failed", http.StatusInternalServerError)
+		return
+	}
+	selectedState := req.URL.Query().Get("state")
+	query := req.URL.Query().Get("q")
+	selectedTag := req.URL.Query().Get("tag")
+	filteredVms := []*compute.VirtualMachine{}
+	for _, vm := range vms {
+		if selectedState != "" && vm.State.String() != selectedState {
+			continue
+		}
+		if query != "" && !strings.Contains(vm.Id, query) {
+			continue
+		}
+		if selectedTag != "" && !vm.HasTag(selectedTag) {
+			continue
+		}
+		filteredVms = append(filteredVms, vm)
+	}
+	selectedNodeId := ""
+	if len(selectedNodeIds) == 1 {
+		selectedNodeId = selectedNodeIds[0]
+	}
 	data := struct {
-		Title string
-		Vms   []*compute.VirtualMachine
-		User  *User
-	}{"Virtual Machines", vms, env.Session(req).AuthUser()}
+		Title          string
+		Vms            []*compute.VirtualMachine
+		Nodes          []*compute.Node
+		SelectedNodeId string
+		SelectedState  string
+		Query          string
+		SelectedTag    string
+		User           *User
+		Request        *http.Request
+	}{"Virtual Machines", filteredVms, nodes, selectedNodeId, selectedState, query, selectedTag, env.Session(req).AuthUser(), req}
 	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/list", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
 	}
 }
 
+// VirtualMachineBulkActionFormProcess applies action to machines selected in the list.
+// First request shows confirmation page, confirmed request runs action and shows per machine results.
+func (env *Environ) VirtualMachineBulkActionFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	action := req.Form.Get("Action")
+	switch action {
+	default:
+		http.Error(rw, "unknown action: "+action, http.StatusBadRequest)
+		return
+	case "start", "shutdown", "poweroff", "reboot", "delete":
+	}
+	targets := []compute.VirtualMachineBulkTarget{}
+	for _, machine := range req.Form["Machine"] {
+		parts := strings.SplitN(machine, "/", 2)
+		if len(parts) != 2 {
+			http.Error(rw, "invalid machine: "+machine, http.StatusBadRequest)
+			return
+		}
+		targets = append(targets, compute.VirtualMachineBulkTarget{NodeId: parts[0], Id: parts[1]})
+	}
+	if len(targets) == 0 {
+		http.Error(rw, "no machines selected", http.StatusBadRequest)
+		return
+	}
+	deleteVolumes := req.Form.Get("DeleteVolumes") == "true"
+
+	if req.Form.Get("Confirm") != "true" {
+		data := struct {
+			Title         string
+			Action        string
+			DeleteVolumes bool
+			Machines      []string
+			User          *User
+			Request       *http.Request
+		}{"Confirm Bulk Action", action, deleteVolumes, req.Form["Machine"], env.Session(req).AuthUser(), req}
+		if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/bulk-confirm", data); err != nil {
+			env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	results := []virtualMachineBulkResult{}
+	for _, result := range env.vmanager.BulkAction(targets, action, deleteVolumes) {
+		item := virtualMachineBulkResult{Name: result.Id, NodeId: result.NodeId}
+		if result.Error != nil {
+			env.logger.Warn().Err(result.Error).Str("id", result.Id).Str("node", result.NodeId).Str("action", action).Msg("bulk action failed")
+			item.Error = result.Error.Error()
+		}
+		results = append(results, item)
+	}
+	data := struct {
+		Title   string
+		Results []virtualMachineBulkResult
+		Deleted bool
+		User    *User
+		Request *http.Request
+	}{"Bulk " + strings.Title(action), results, action == "delete", env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/bulk-result", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
 func (env *Environ) VirtualMachineDetail(rw http.ResponseWriter, req *http.Request) {
 	urlvars := mux.Vars(req)
 	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
@@ -64,19 +167,59 @@ func (env *Environ) VirtualMachineDetail(rw http.ResponseWriter, req *http.Reque
 			continue
 		}
 	}
+	// Node device inventory is slow to collect, so it is loaded only for devices tab
+	activeTab := req.URL.Query().Get("tab")
+	nodeDevices := []*compute.NodeDevice{}
+	if activeTab == "devices" {
+		nodeDevices, err = env.nodes.ListDevices(vm.NodeId)
+		if err != nil {
+			env.logger.Warn().Err(err).Str("node", vm.NodeId).Msg("cannot list node devices")
+		}
+	}
+	attachedDevices := map[string]*compute.NodeDevice{}
+	availableDevices := []*compute.NodeDevice{}
+	for _, device := range nodeDevices {
+		if device.AttachedTo == vm.Id {
+			attachedDevices[device.Address] = device
+			continue
+		}
+		if device.AttachedTo == "" && !device.IsPciBridge() {
+			availableDevices = append(availableDevices, device)
+		}
+	}
+	var memoryStats *compute.VirtualMachineMemoryStats
+	if vm.IsRunning() {
+		memoryStats, err = env.vms.GetMemoryStats(vm.Id, vm.NodeId)
+		if err != nil {
+			env.logger.Debug().Err(err).Str("vm", vm.Id).Msg("cannot get memory stats")
+		}
+	}
 	data := struct {
 		Title            string
 		Vm               *compute.VirtualMachine
+		MemoryStats      *compute.VirtualMachineMemoryStats
 		AttachedVolumes  map[string]*compute.Volume
 		AvailableVolumes []*compute.Volume
+		DevicesLoaded    bool
+		AttachedDevices  map[string]*compute.NodeDevice
+		AvailableDevices []*compute.NodeDevice
 		DeviceTypes      []compute.DeviceType
 		DeviceBuses      []compute.DeviceBus
+		DiskCacheModes   []string
+		DiskIoModes      []string
+		DiskDiscardModes []string
+		DiskDetectZeroes []string
 		InterfaceModels  []string
 		Networks         []*compute.Network
 		ActiveTab        string
+		ExpiryStage      compute.VirtualMachineExpiryStage
+		DeleteAt         time.Time
+		Admin            bool
 		User             *User
 		Request          *http.Request
-	}{"Virtual Machine", vm, attachedVolumes, availableVolumes, DeviceTypes, DeviceBuses, InterfaceModels, networks, req.URL.Query().Get("tab"), env.Session(req).AuthUser(), req}
+	}{"Virtual Machine", vm, memoryStats, attachedVolumes, availableVolumes, activeTab == "devices", attachedDevices, availableDevices, DeviceTypes, DeviceBuses,
+		compute.VolumeDriverCacheModes, compute.VolumeDriverIoModes, compute.VolumeDriverDiscardModes, compute.VolumeDriverDetectZeroesModes,
+		InterfaceModels, networks, activeTab, env.expiry.Stage(vm), env.expiry.DeleteAt(vm), env.isAdmin(env.Session(req).AuthUser()), env.Session(req).AuthUser(), req}
 	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/detail", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
@@ -109,6 +252,17 @@ func (env *Environ) VirtualMachineStateSetFormProcess(rw http.ResponseWriter, re
 		Str("method", req.Method).
 		Msg("Requesting state change")
 	urlvars := mux.Vars(req)
+	if urlvars["action"] == "start" || urlvars["action"] == "reset-nvram" {
+		vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+		if err != nil {
+			env.error(rw, req, err, "cannot get virtual machine", http.StatusInternalServerError)
+			return
+		}
+		if err := env.checkHugepages(vm); err != nil {
+			http.Error(rw, fmt.Sprintf("failed to %s machine: %s", urlvars["action"], err), http.StatusBadRequest)
+			return
+		}
+	}
 	if err := env.vms.Action(urlvars["id"], urlvars["node"], urlvars["action"]); err != nil {
 		http.Error(rw, fmt.Sprintf("failed to %s machine: %s", urlvars["action"], err), http.StatusInternalServerError)
 		return
@@ -140,17 +294,67 @@ func (env *Environ) VirtualMachineAddFormShow(rw http.ResponseWriter, req *http.
 		Keys             []*compute.Key
 		Arches           []compute.Arch
 		Arch             compute.Arch
+		Name             string
+		Vcpus            string
+		MemoryM          string
+		Firmware         string
+		AttachPaths      []string
+		Flavors          []*compute.Flavor
+		FlavorsOnly      bool
+		Preset           *compute.VirtualMachinePreset
+		CpuModes         []string
+		CpuModels        []string
+		HugepageSizes    []string
+		NumaModes        []string
+		WatchdogActions  []string
+		LifecycleActions []string
+		CrashActions     []string
 	}{
-		Title:           "Create Virtual Machine",
-		Request:         req,
-		User:            env.Session(req).AuthUser(),
-		Arches:          []compute.Arch{compute.ArchAmd64, compute.ArchAarch64},
-		DeviceTypes:     DeviceTypes,
-		DeviceBuses:     DeviceBuses,
-		InterfaceModels: InterfaceModels,
-		GraphicTypes:    GraphicTypes,
-		VolumeFormats:   UIVolumeFormats,
-		VideoModels:     VideoModels,
+		Title:            "Create Virtual Machine",
+		Request:          req,
+		User:             env.Session(req).AuthUser(),
+		Name:             req.URL.Query().Get("name"),
+		Vcpus:            req.URL.Query().Get("vcpus"),
+		MemoryM:          req.URL.Query().Get("memory"),
+		Firmware:         req.URL.Query().Get("firmware"),
+		AttachPaths:      req.URL.Query()["attach"],
+		FlavorsOnly:      env.flavorsOnly(env.Session(req).AuthUser()),
+		Arches:           []compute.Arch{compute.ArchAmd64, compute.ArchAarch64},
+		DeviceTypes:      DeviceTypes,
+		DeviceBuses:      DeviceBuses,
+		InterfaceModels:  InterfaceModels,
+		GraphicTypes:     GraphicTypes,
+		VolumeFormats:    UIVolumeFormats,
+		VideoModels:      VideoModels,
+		CpuModes:         compute.CpuModes,
+		HugepageSizes:    compute.HugepageSizes,
+		NumaModes:        compute.NumaModes,
+		WatchdogActions:  compute.WatchdogActions,
+		LifecycleActions: compute.LifecycleActions,
+		CrashActions:     compute.CrashActions,
+		Preset: &compute.VirtualMachinePreset{
+			VCpus:       2,
+			Memory:      compute.NewSize(2048, compute.SizeUnitM),
+			RootFormat:  compute.VolumeFormatQcow2,
+			RootSize:    compute.NewSize(10, compute.SizeUnitG),
+			Interfaces:  []compute.VirtualMachinePresetInterface{{Model: "virtio"}},
+			GraphicType: compute.GraphicTypeNone,
+			VideoModel:  compute.VideoModelNone,
+			GuestAgent:  true,
+			Start:       true,
+		},
+	}
+
+	if presetName := req.URL.Query().Get("preset"); presetName != "" {
+		preset, err := env.presets.Get(presetName)
+		if err != nil {
+			env.error(rw, req, err, "cannot get preset", http.StatusInternalServerError)
+			return
+		}
+		if len(preset.Interfaces) == 0 {
+			preset.Interfaces = []compute.VirtualMachinePresetInterface{{NetworkName: "__VMANGO_NONE__", Model: "virtio"}}
+		}
+		data.Preset = preset
 	}
 
 	nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
@@ -162,6 +366,9 @@ func (env *Environ) VirtualMachineAddFormShow(rw http.ResponseWriter, req *http.
 
 	var selectedNode *compute.Node
 	selectedNodeId := req.URL.Query().Get("node")
+	if selectedNodeId == "" {
+		selectedNodeId = data.Preset.NodeId
+	}
 	for _, node := range nodes {
 		if node.Id == selectedNodeId {
 			selectedNode = node
@@ -172,8 +379,12 @@ func (env *Environ) VirtualMachineAddFormShow(rw http.ResponseWriter, req *http.
 		selectedNode = nodes[0]
 	}
 	data.NodeId = selectedNode.Id
+	data.CpuModels = selectedNode.CpuModels
 
 	selectedArch := compute.NewArch(req.URL.Query().Get("arch"))
+	if selectedArch == compute.ArchUnknown {
+		selectedArch = data.Preset.Arch
+	}
 	if selectedArch == compute.ArchUnknown {
 		selectedArch = selectedNode.CpuArch
 	}
@@ -209,6 +420,17 @@ func (env *Environ) VirtualMachineAddFormShow(rw http.ResponseWriter, req *http.
 	}
 	data.Keys = keys
 
+	flavors, err := env.flavors.List()
+	if err != nil {
+		env.error(rw, req, err, "cannot list flavors", http.StatusInternalServerError)
+		return
+	}
+	for _, flavor := range flavors {
+		if flavor.AllowedOn(selectedNode.Id) {
+			data.Flavors = append(data.Flavors, flavor)
+		}
+	}
+
 	networks, err := env.networks.List(compute.NetworkListOptions{NodeIds: []string{selectedNode.Id}})
 	if err != nil {
 		env.error(rw, req, err, "cannot list networks", http.StatusInternalServerError)
@@ -226,21 +448,24 @@ func (env *Environ) VirtualMachineAddFormShow(rw http.ResponseWriter, req *http.
 	}
 }
 
-func (env *Environ) VirtualMachineAddFormProcess(rw http.ResponseWriter, req *http.Request) {
-	if err := req.ParseForm(); err != nil {
-		http.Error(rw, err.Error(), http.StatusBadRequest)
-		return
-	}
+// virtualMachineAddForm contains machine and its new volumes parsed from the creation form
+type virtualMachineAddForm struct {
+	Vm        *compute.VirtualMachine
+	CloneVols []compute.VirtualMachineManagerClonedVolumeParams
+	NewVols   []compute.VirtualMachineManagerCreatedVolumeParams
+	Start     bool
+}
+
+func (env *Environ) parseVirtualMachineAddForm(form url.Values, user *User) (*virtualMachineAddForm, error) {
 	vm := &compute.VirtualMachine{
-		Id:     req.Form.Get("Name"),
-		NodeId: req.Form.Get("NodeId"),
-		Arch:   compute.NewArch(req.Form.Get("Arch")),
+		Id:     form.Get("Name"),
+		NodeId: form.Get("NodeId"),
+		Arch:   compute.NewArch(form.Get("Arch")),
 	}
 
 	volumes, err := env.volumes.List(compute.VolumeListOptions{NodeIds: []string{vm.NodeId}})
 	if err != nil {
-		env.error(rw, req, err, "cannot list volumes", http.StatusInternalServerError)
-		return
+		return nil, util.NewError(err, "cannot list volumes")
 	}
 	volumeMetadata := map[string]*compute.VolumeMetadata{}
 	for _, volume := range volumes {
@@ -250,147 +475,248 @@ func (env *Environ) VirtualMachineAddFormProcess(rw http.ResponseWriter, req *ht
 		volumeMetadata[volume.Path] = &volume.Metadata
 	}
 
-	vcpus, err := strconv.ParseInt(req.Form.Get("Vcpus"), 10, 16)
+	flavor, err := env.formFlavor(form, user, vm.NodeId)
 	if err != nil {
-		http.Error(rw, "invalid vcpus value: "+err.Error(), http.StatusBadRequest)
-		return
-	}
-	memoryValue, err := strconv.ParseUint(req.Form.Get("MemoryValue"), 10, 64)
-	if err != nil {
-		http.Error(rw, "invalid memory size: "+req.Form.Get("MemoryValue"), http.StatusBadRequest)
-		return
+		return nil, util.NewError(err, "invalid flavor")
 	}
-	memoryUnit := compute.NewSizeUnit(req.Form.Get("MemoryUnit"))
-	if memoryUnit == compute.SizeUnitUnknown {
-		http.Error(rw, "unknown memory size unit: "+req.Form.Get("MemoryUnit"), http.StatusBadRequest)
-		return
+	if flavor != nil {
+		vm.Flavor = flavor.Name
+		vm.VCpus = flavor.VCpus
+		vm.Memory = flavor.Memory
+	} else {
+		vcpus, err := strconv.ParseInt(form.Get("Vcpus"), 10, 16)
+		if err != nil {
+			return nil, util.NewError(err, "invalid vcpus value")
+		}
+		memoryValue, err := strconv.ParseUint(form.Get("MemoryValue"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory size: %s", form.Get("MemoryValue"))
+		}
+		memoryUnit := compute.NewSizeUnit(form.Get("MemoryUnit"))
+		if memoryUnit == compute.SizeUnitUnknown {
+			return nil, fmt.Errorf("unknown memory size unit: %s", form.Get("MemoryUnit"))
+		}
+		vm.VCpus = int(vcpus)
+		vm.Memory = compute.NewSize(memoryValue, memoryUnit)
 	}
-	graphicType := compute.NewGraphicType(req.Form.Get("GraphicType"))
+	graphicType := compute.NewGraphicType(form.Get("GraphicType"))
 	if graphicType == compute.GraphicTypeUnknown {
-		http.Error(rw, "unknown graphic type: "+req.Form.Get("GraphicType"), http.StatusBadRequest)
-		return
+		return nil, fmt.Errorf("unknown graphic type: %s", form.Get("GraphicType"))
 	}
-	videoModel := compute.NewVideoModel(req.Form.Get("VideoModel"))
+	videoModel := compute.NewVideoModel(form.Get("VideoModel"))
 	if videoModel == compute.VideoModelUnknown {
-		http.Error(rw, "unknown video model: "+req.Form.Get("VideoModel"), http.StatusBadRequest)
-		return
+		return nil, fmt.Errorf("unknown video model: %s", form.Get("VideoModel"))
 	}
 
 	newVols := []compute.VirtualMachineManagerCreatedVolumeParams{}
-	newVolsCount := len(req.Form["CreateVolumeName"])
+	newVolsCount := len(form["CreateVolumeName"])
 	for idx := 0; idx < newVolsCount; idx++ {
-		sizeV, err := strconv.ParseUint(req.Form["CreateVolumeSizeValue"][idx], 10, 64)
+		sizeV, err := strconv.ParseUint(form["CreateVolumeSizeValue"][idx], 10, 64)
 		if err != nil {
-			msg := "invalid new volume " + req.Form["CreateVolumeName"][idx] + "size value"
-			http.Error(rw, msg, http.StatusBadRequest)
-			return
+			return nil, fmt.Errorf("invalid new volume %s size value", form["CreateVolumeName"][idx])
 		}
 		volume := compute.VirtualMachineManagerCreatedVolumeParams{
-			Name:       req.Form["CreateVolumeName"][idx],
-			Pool:       req.Form["CreateVolumePool"][idx],
-			Format:     compute.NewVolumeFormat(req.Form["CreateVolumeFormat"][idx]),
-			Size:       compute.NewSize(sizeV, compute.NewSizeUnit(req.Form["CreateVolumeSizeUnit"][idx])),
-			DeviceType: compute.NewDeviceType(req.Form["CreateVolumeDeviceType"][idx]),
-			DeviceBus:  compute.NewDeviceBus(req.Form["CreateVolumeDeviceBus"][idx]),
+			Name:       form["CreateVolumeName"][idx],
+			Pool:       form["CreateVolumePool"][idx],
+			Format:     compute.NewVolumeFormat(form["CreateVolumeFormat"][idx]),
+			Size:       compute.NewSize(sizeV, compute.NewSizeUnit(form["CreateVolumeSizeUnit"][idx])),
+			DeviceType: compute.NewDeviceType(form["CreateVolumeDeviceType"][idx]),
+			DeviceBus:  compute.NewDeviceBus(form["CreateVolumeDeviceBus"][idx]),
 		}
 		newVols = append(newVols, volume)
 	}
 	cloneVols := []compute.VirtualMachineManagerClonedVolumeParams{}
-	cloneVolsCount := len(req.Form["CloneVolumeOriginalPath"])
+	cloneVolsCount := len(form["CloneVolumeOriginalPath"])
 	for idx := 0; idx < cloneVolsCount; idx++ {
 		var sizeValue uint64
-		if req.Form["CloneVolumeNewSizeValue"][idx] != "" {
-			size, err := strconv.ParseUint(req.Form["CloneVolumeNewSizeValue"][idx], 10, 64)
+		if form["CloneVolumeNewSizeValue"][idx] != "" {
+			size, err := strconv.ParseUint(form["CloneVolumeNewSizeValue"][idx], 10, 64)
 			if err != nil {
-				msg := "invalid size specified for cloned volume: " + req.Form["CloneVolumeNewSizeValue"][idx]
-				http.Error(rw, msg, http.StatusBadRequest)
-				return
+				return nil, fmt.Errorf("invalid size specified for cloned volume: %s", form["CloneVolumeNewSizeValue"][idx])
 			}
 			sizeValue = size
 		}
-		newName := req.Form["CloneVolumeNewName"][idx]
+		newName := form["CloneVolumeNewName"][idx]
 		if newName == "__magic_root_suffix__" {
 			newName = fmt.Sprintf("%s_root", vm.Id)
 		}
 		volume := compute.VirtualMachineManagerClonedVolumeParams{
-			OriginalPath: req.Form["CloneVolumeOriginalPath"][idx],
+			OriginalPath: form["CloneVolumeOriginalPath"][idx],
 			NewName:      newName,
-			NewPool:      req.Form["CloneVolumeNewPool"][idx],
-			NewFormat:    compute.NewVolumeFormat(req.Form["CloneVolumeNewFormat"][idx]),
-			NewSize:      compute.NewSize(sizeValue, compute.NewSizeUnit(req.Form["CloneVolumeNewSizeUnit"][idx])),
-			DeviceType:   compute.NewDeviceType(req.Form["CloneVolumeDeviceType"][idx]),
-			DeviceBus:    compute.NewDeviceBus(req.Form["CloneVolumeDeviceBus"][idx]),
+			NewPool:      form["CloneVolumeNewPool"][idx],
+			NewFormat:    compute.NewVolumeFormat(form["CloneVolumeNewFormat"][idx]),
+			NewSize:      compute.NewSize(sizeValue, compute.NewSizeUnit(form["CloneVolumeNewSizeUnit"][idx])),
+			DeviceType:   compute.NewDeviceType(form["CloneVolumeDeviceType"][idx]),
+			DeviceBus:    compute.NewDeviceBus(form["CloneVolumeDeviceBus"][idx]),
 		}
 		cloneVols = append(cloneVols, volume)
 		volumeMd := volumeMetadata[volume.OriginalPath]
-		if req.Form["CloneVolumeNewName"][idx] == "__magic_root_suffix__" && volumeMd != nil {
+		if form["CloneVolumeNewName"][idx] == "__magic_root_suffix__" && volumeMd != nil {
 			if volumeMd.Efi {
 				vm.Firmware = "efi"
 			}
 		}
 	}
-	attachedVols := len(req.Form["AttachVolumePath"])
+	if form.Get("Firmware") == "efi" {
+		vm.Firmware = "efi"
+	}
+	vm.SecureBoot = form.Get("SecureBoot") == "true"
+	if vm.SecureBoot {
+		vm.Firmware = "efi"
+	}
+	vm.Tpm = form.Get("Tpm") == "true"
+	formDevices(form, vm)
+	attachedVols := len(form["AttachVolumePath"])
 	for idx := 0; idx < attachedVols; idx++ {
 		vm.Volumes = append(vm.Volumes, &compute.VirtualMachineAttachedVolume{
-			Path:       req.Form["AttachVolumePath"][idx],
-			DeviceType: compute.NewDeviceType(req.Form["AttachVolumeDeviceType"][idx]),
-			DeviceBus:  compute.NewDeviceBus(req.Form["AttachVolumeDeviceBus"][idx]),
+			Path:       form["AttachVolumePath"][idx],
+			DeviceType: compute.NewDeviceType(form["AttachVolumeDeviceType"][idx]),
+			DeviceBus:  compute.NewDeviceBus(form["AttachVolumeDeviceBus"][idx]),
 		})
 	}
 
-	for idx := 0; idx < len(req.Form["InterfaceNetwork"]); idx++ {
-		if req.Form["InterfaceNetwork"][idx] == "__VMANGO_NONE__" {
+	for idx := 0; idx < len(form["InterfaceNetwork"]); idx++ {
+		if form["InterfaceNetwork"][idx] == "__VMANGO_NONE__" {
 			continue
 		}
 		var accessVlan uint
-		accessVlanRaw := req.Form["InterfaceAccessVlan"][idx]
+		accessVlanRaw := form["InterfaceAccessVlan"][idx]
 		if accessVlanRaw != "" {
 			parsed, err := strconv.ParseUint(accessVlanRaw, 10, 16)
 			if err != nil {
-				http.Error(rw, "invalid vlan: "+err.Error(), http.StatusBadRequest)
-				return
+				return nil, util.NewError(err, "invalid vlan")
 			}
 			accessVlan = uint(parsed)
 		}
 		vm.Interfaces = append(vm.Interfaces, &compute.VirtualMachineAttachedInterface{
-			NetworkName: req.Form["InterfaceNetwork"][idx],
-			Mac:         req.Form["InterfaceMac"][idx],
-			Model:       req.Form["InterfaceModel"][idx],
+			NetworkName: form["InterfaceNetwork"][idx],
+			Mac:         form["InterfaceMac"][idx],
+			Model:       form["InterfaceModel"][idx],
 			AccessVlan:  accessVlan,
 		})
 	}
 
-	vm.VCpus = int(vcpus)
-	vm.Memory = compute.NewSize(memoryValue, memoryUnit)
-	vm.GuestAgent = req.Form.Get("GuestAgent") == "true"
-	vm.Hugepages = req.Form.Get("Hugepages") == "true"
+	vm.Tags = formTags(form.Get("Tags"))
+	expiresAt, err := formExpiresAt(form.Get("ExpiresAt"))
+	if err != nil {
+		return nil, err
+	}
+	vm.ExpiresAt = expiresAt
+	if user != nil {
+		vm.Owner = user.Email
+		if vm.Owner == "" {
+			vm.Owner = user.Id
+		}
+	}
+	vm.GuestAgent = form.Get("GuestAgent") == "true"
+	vm.Hugepages = form.Get("Hugepages") == "true"
+	if flavor != nil {
+		vm.Hugepages = flavor.Hugepages
+		if len(cloneVols) == 0 {
+			return nil, fmt.Errorf("root volume required for flavor")
+		}
+		if env.flavorsOnly(user) {
+			newVols = nil
+		}
+		if flavor.RootDisk.Bytes() > 0 {
+			cloneVols[0].NewSize = flavor.RootDisk
+		}
+		for idx, size := range flavor.ExtraDisks {
+			newVols = append(newVols, compute.VirtualMachineManagerCreatedVolumeParams{
+				Name:       fmt.Sprintf("%s_disk%d", vm.Id, idx+1),
+				Pool:       cloneVols[0].NewPool,
+				Format:     compute.VolumeFormatQcow2,
+				Size:       size,
+				DeviceType: compute.DeviceTypeDisk,
+				DeviceBus:  compute.DeviceBusVirtio,
+			})
+		}
+	}
 	vm.Graphic = compute.VirtualMachineGraphic{
 		Type: graphicType,
 	}
 	vm.VideoModel = videoModel
 	vm.Config = &compute.VirtualMachineConfig{
-		Hostname: req.Form.Get("Name"),
-		Userdata: []byte(req.Form.Get("Userdata")),
+		Hostname: form.Get("Name"),
+		Userdata: []byte(form.Get("Userdata")),
 	}
-	for _, fp := range req.Form["Keys"] {
+	for _, fp := range form["Keys"] {
 		key, err := env.keys.Get(fp)
 		if err != nil {
-			http.Error(rw, "cannot fetch key: "+err.Error(), http.StatusInternalServerError)
-			return
+			return nil, util.NewError(err, "cannot fetch key")
 		}
 		vm.Config.Keys = append(vm.Config.Keys, key)
 	}
-	start := req.Form.Get("Start") == "true"
+	if err := formHotplug(form, vm, nil); err != nil {
+		return nil, err
+	}
+	cpu, err := formCpu(form, vm.MaxVCpuCount())
+	if err != nil {
+		return nil, err
+	}
+	vm.Cpu = cpu
+	if err := env.formNuma(form, vm); err != nil {
+		return nil, err
+	}
+	vm.BootMenu = form.Get("BootMenu") == "true"
+	if form.Get("NetworkBoot") == "true" {
+		if len(vm.Interfaces) == 0 {
+			return nil, fmt.Errorf("network boot requires an interface")
+		}
+		// Boot from network first and fall back to root volume after installation
+		vm.Interfaces[0].BootOrder = 1
+		if len(cloneVols) > 0 {
+			cloneVols[0].BootOrder = 2
+		} else if len(newVols) > 0 {
+			newVols[0].BootOrder = 2
+		}
+	}
+
+	start := form.Get("Start") == "true"
 	vm.Autostart = start
 
-	if err := env.vmanager.Create(vm, cloneVols, newVols, start); err != nil {
-		env.logger.Debug().Interface("vm", vm).Interface("cloneVols", cloneVols).Interface("newVols", newVols).Msg("vm create data")
+	return &virtualMachineAddForm{Vm: vm, CloneVols: cloneVols, NewVols: newVols, Start: start}, nil
+}
+
+func (env *Environ) VirtualMachineAddFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	count := 1
+	if req.Form.Get("Count") != "" {
+		parsed, err := strconv.Atoi(req.Form.Get("Count"))
+		if err != nil || parsed < 1 {
+			http.Error(rw, "invalid count: "+req.Form.Get("Count"), http.StatusBadRequest)
+			return
+		}
+		count = parsed
+	}
+	names, err := compute.ExpandNamePattern(req.Form.Get("Name"), count)
+	if err != nil {
+		http.Error(rw, "invalid name: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(names) > 1 {
+		env.virtualMachineBulkCreate(rw, req, names)
+		return
+	}
+	req.Form.Set("Name", names[0])
+	params, err := env.parseVirtualMachineAddForm(req.Form, env.Session(req).AuthUser())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vm := params.Vm
+
+	if err := env.vmanager.Create(vm, params.CloneVols, params.NewVols, params.Start); err != nil {
+		env.logger.Debug().Interface("vm", vm).Interface("cloneVols", params.CloneVols).Interface("newVols", params.NewVols).Msg("vm create data")
 		env.error(rw, req, err, "cannot create vm", http.StatusInternalServerError)
 		return
 	}
 
 	redirectPath := ""
-	if start {
+	if params.Start {
 		redirectPath = env.url("virtual-machine-console-show", "id", vm.Id, "node", vm.NodeId).Path
 	} else {
 		redirectPath = env.url("virtual-machine-detail", "id", vm.Id, "node", vm.NodeId).Path
@@ -398,6 +724,195 @@ func (env *Environ) VirtualMachineAddFormProcess(rw http.ResponseWriter, req *ht
 	http.Redirect(rw, req, redirectPath, http.StatusFound)
 }
 
+type virtualMachineBulkResult struct {
+	Name   string
+	NodeId string
+	Error  string
+}
+
+// virtualMachineBulkCreate creates machine for each name from the same creation form.
+// Every machine gets its own root volume, config drive and generated mac addresses.
+func (env *Environ) virtualMachineBulkCreate(rw http.ResponseWriter, req *http.Request, names []string) {
+	user := env.Session(req).AuthUser()
+	if len(req.Form["AttachVolumePath"]) > 0 || len(req.Form["CreateVolumeName"]) > 0 || len(req.Form["CloneVolumeNewName"]) > 1 {
+		http.Error(rw, "bulk creation supports only root volume cloned from an image", http.StatusBadRequest)
+		return
+	}
+	for _, name := range req.Form["CloneVolumeNewName"] {
+		if name != "__magic_root_suffix__" {
+			http.Error(rw, "bulk creation supports only root volume named after the machine", http.StatusBadRequest)
+			return
+		}
+	}
+	placement := compute.NewVirtualMachinePlacement(req.Form.Get("Placement"))
+	if placement == compute.VirtualMachinePlacementUnknown {
+		placement = compute.VirtualMachinePlacementNode
+	}
+
+	first, err := env.parseVirtualMachineAddForm(bulkForm(req.Form, names[0], req.Form.Get("NodeId")), user)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nodeIds := []string{}
+	imagePaths := map[string][]string{}
+	if placement == compute.VirtualMachinePlacementNode {
+		for range names {
+			nodeIds = append(nodeIds, first.Vm.NodeId)
+		}
+	} else {
+		nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+		if err != nil {
+			env.error(rw, req, err, "cannot list nodes", http.StatusInternalServerError)
+			return
+		}
+		var flavor *compute.Flavor
+		if first.Vm.Flavor != "" {
+			flavor, err = env.flavors.Get(first.Vm.Flavor)
+			if err != nil {
+				env.error(rw, req, err, "cannot get flavor", http.StatusInternalServerError)
+				return
+			}
+		}
+		imagePaths, err = env.bulkNodeImagePaths(req.Form, first.Vm.NodeId)
+		if err != nil {
+			env.error(rw, req, err, "cannot resolve node resources", http.StatusInternalServerError)
+			return
+		}
+		candidates := []*compute.Node{}
+		for _, node := range nodes {
+			if node.CpuArch != first.Vm.Arch || flavor != nil && !flavor.AllowedOn(node.Id) {
+				continue
+			}
+			if _, ok := imagePaths[node.Id]; !ok {
+				continue
+			}
+			candidates = append(candidates, node)
+		}
+		if len(candidates) == 0 {
+			http.Error(rw, "no nodes with the same image, storage pool and networks available for "+first.Vm.Arch.String(), http.StatusBadRequest)
+			return
+		}
+		nodeIds = compute.PlaceVirtualMachines(candidates, len(names), first.Vm.Memory, placement)
+	}
+
+	results := []virtualMachineBulkResult{}
+	for idx, name := range names {
+		result := virtualMachineBulkResult{Name: name, NodeId: nodeIds[idx]}
+		form := bulkForm(req.Form, name, nodeIds[idx])
+		if paths, ok := imagePaths[nodeIds[idx]]; ok {
+			form["CloneVolumeOriginalPath"] = paths
+		}
+		params, err := env.parseVirtualMachineAddForm(form, user)
+		if err == nil {
+			err = env.vmanager.Create(params.Vm, params.CloneVols, params.NewVols, params.Start)
+		}
+		if err != nil {
+			env.logger.Warn().Err(err).Str("name", name).Str("node", nodeIds[idx]).Msg("bulk vm creation failed")
+			result.Error = err.Error()
+		}
+		results = append(results, result)
+	}
+
+	data := struct {
+		Title   string
+		Results []virtualMachineBulkResult
+		Deleted bool
+		User    *User
+		Request *http.Request
+	}{"Create Virtual Machines", results, false, user, req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/bulk-result", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+// bulkNodeImagePaths finds nodes where machine from the creation form can be created.
+// Images, storage pools and networks are node local, so image is looked up by its
+// pool and volume name, pools and networks by name. Result maps node id to image
+// paths on that node in the order of the form, nodes without any of them are absent.
+func (env *Environ) bulkNodeImagePaths(form url.Values, sourceNodeId string) (map[string][]string, error) {
+	images := []*compute.Volume{}
+	for _, path := range form["CloneVolumeOriginalPath"] {
+		image, err := env.volumes.Get(path, sourceNodeId)
+		if err != nil {
+			return nil, util.NewError(err, "cannot get image %s", path)
+		}
+		images = append(images, image)
+	}
+	volumes, err := env.volumes.List(compute.VolumeListOptions{})
+	if err != nil {
+		return nil, util.NewError(err, "cannot list volumes")
+	}
+	pools, err := env.volpools.List(compute.VolumePoolListOptions{})
+	if err != nil {
+		return nil, util.NewError(err, "cannot list storage pools")
+	}
+	networks, err := env.networks.List(compute.NetworkListOptions{})
+	if err != nil {
+		return nil, util.NewError(err, "cannot list networks")
+	}
+
+	nodePools := map[string]map[string]bool{}
+	for _, pool := range pools {
+		if nodePools[pool.NodeId] == nil {
+			nodePools[pool.NodeId] = map[string]bool{}
+		}
+		nodePools[pool.NodeId][pool.Name] = true
+	}
+	nodeNetworks := map[string]map[string]bool{}
+	for _, network := range networks {
+		if nodeNetworks[network.NodeId] == nil {
+			nodeNetworks[network.NodeId] = map[string]bool{}
+		}
+		nodeNetworks[network.NodeId][network.Name] = true
+	}
+	nodeVolumes := map[string]map[string]string{}
+	for _, volume := range volumes {
+		if nodeVolumes[volume.NodeId] == nil {
+			nodeVolumes[volume.NodeId] = map[string]string{}
+		}
+		nodeVolumes[volume.NodeId][volume.Pool+"/"+volume.Name] = volume.Path
+	}
+
+	result := map[string][]string{}
+nodes:
+	for nodeId, poolNames := range nodePools {
+		for _, poolName := range form["CloneVolumeNewPool"] {
+			if !poolNames[poolName] {
+				continue nodes
+			}
+		}
+		for _, networkName := range form["InterfaceNetwork"] {
+			if networkName != "__VMANGO_NONE__" && !nodeNetworks[nodeId][networkName] {
+				continue nodes
+			}
+		}
+		paths := []string{}
+		for _, image := range images {
+			path, ok := nodeVolumes[nodeId][image.Pool+"/"+image.Name]
+			if !ok {
+				continue nodes
+			}
+			paths = append(paths, path)
+		}
+		result[nodeId] = paths
+	}
+	return result, nil
+}
+
+// bulkForm returns copy of creation form for one machine of the bulk, mac addresses are reset
+func bulkForm(form url.Values, name, nodeId string) url.Values {
+	result := url.Values{}
+	for key, values := range form {
+		result[key] = append([]string{}, values...)
+	}
+	result.Set("Name", name)
+	result.Set("NodeId", nodeId)
+	result["InterfaceMac"] = make([]string, len(form["InterfaceMac"]))
+	return result
+}
+
 func (env *Environ) VirtualMachineDeleteFormShow(rw http.ResponseWriter, req *http.Request) {
 	urlvars := mux.Vars(req)
 	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
@@ -428,85 +943,431 @@ func (env *Environ) VirtualMachineDeleteFormProcess(rw http.ResponseWriter, req
 	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
 }
 
-var GraphicTypes = []compute.GraphicType{
-	compute.GraphicTypeNone,
-	compute.GraphicTypeVnc,
-	compute.GraphicTypeSpice,
-}
-
-var VideoModels = []compute.VideoModel{
-	compute.VideoModelNone,
-	compute.VideoModelCirrus,
-	compute.VideoModelQxl,
-}
-
-func (env *Environ) VirtualMachineUpdateFormShow(rw http.ResponseWriter, req *http.Request) {
+func (env *Environ) VirtualMachineRenameFormShow(rw http.ResponseWriter, req *http.Request) {
 	urlvars := mux.Vars(req)
 	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
 	if err != nil {
-		env.error(rw, req, err, "virtual-machine detail failed", http.StatusInternalServerError)
+		env.error(rw, req, err, "virtual-machine get failed", http.StatusInternalServerError)
 		return
 	}
 	data := struct {
-		Title        string
-		Vm           *compute.VirtualMachine
-		GraphicTypes []compute.GraphicType
-		VideoModels  []compute.VideoModel
-		User         *User
-		Request      *http.Request
-	}{"Update VirtualMachine", vm, GraphicTypes, VideoModels, env.Session(req).AuthUser(), req}
-	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/update", data); err != nil {
+		Title   string
+		Vm      *compute.VirtualMachine
+		User    *User
+		Request *http.Request
+	}{"Rename VirtualMachine", vm, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/rename", data); err != nil {
 		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
 		return
 	}
 }
 
-func (env *Environ) VirtualMachineUpdateFormProcess(rw http.ResponseWriter, req *http.Request) {
+func (env *Environ) VirtualMachineRenameFormProcess(rw http.ResponseWriter, req *http.Request) {
 	urlvars := mux.Vars(req)
 	if err := req.ParseForm(); err != nil {
 		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	vm := &compute.VirtualMachine{
-		Id:         urlvars["id"],
-		NodeId:     urlvars["node"],
-		Autostart:  req.Form.Get("Autostart") == "true",
-		GuestAgent: req.Form.Get("GuestAgent") == "true",
-		VideoModel: compute.NewVideoModel(req.Form.Get("VideoModel")),
-		Hugepages:  req.Form.Get("Hugepages") == "true",
-		Graphic: compute.VirtualMachineGraphic{
-			Type:   compute.NewGraphicType(req.Form.Get("GraphicType")),
-			Listen: req.Form.Get("GraphicListen"),
-		},
-	}
-
-	vcpus, err := strconv.ParseInt(req.Form.Get("Vcpus"), 10, 16)
-	if err != nil {
-		http.Error(rw, "invalid vcpus value: "+err.Error(), http.StatusBadRequest)
+	newId := req.Form.Get("Name")
+	if err := compute.ValidateVirtualMachineName(newId); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
 		return
 	}
-	vm.VCpus = int(vcpus)
-
-	memoryValue, err := strconv.ParseUint(req.Form.Get("MemoryValue"), 10, 32)
-	if err != nil {
-		http.Error(rw, "invalid memoryMb value: "+err.Error(), http.StatusBadRequest)
+	if newId == urlvars["id"] {
+		http.Error(rw, "new name is the same as current", http.StatusBadRequest)
 		return
 	}
-	memoryUnit := compute.NewSizeUnit(req.Form.Get("MemoryUnit"))
-	if memoryUnit == compute.SizeUnitUnknown {
-		http.Error(rw, "unknown memory unit: "+req.Form.Get("MemoryUnit"), http.StatusBadRequest)
+	renameVolumes := req.Form.Get("RenameVolumes") == "true"
+	if err := env.vmanager.Rename(urlvars["id"], urlvars["node"], newId, renameVolumes); err != nil {
+		env.error(rw, req, err, "cannot rename virtual machine", http.StatusInternalServerError)
 		return
 	}
-	vm.Memory = compute.NewSize(memoryValue, memoryUnit)
+	redirectUrl := env.url("virtual-machine-detail", "id", newId, "node", urlvars["node"])
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
 
-	if err := env.vms.Save(vm); err != nil {
+func (env *Environ) VirtualMachineMigrateFormShow(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "virtual-machine get failed", http.StatusInternalServerError)
+		return
+	}
+	nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "cannot list nodes", http.StatusInternalServerError)
+		return
+	}
+	targetNodes := []*compute.Node{}
+	for _, node := range nodes {
+		if node.Id == vm.NodeId {
+			continue
+		}
+		targetNodes = append(targetNodes, node)
+	}
+	targetNodeId := req.URL.Query().Get("target")
+	if targetNodeId == "" && len(targetNodes) > 0 {
+		targetNodeId = targetNodes[0].Id
+	}
+	pools := []*compute.VolumePool{}
+	if targetNodeId != "" && !vm.IsRunning() {
+		targetPools, err := env.volpools.List(compute.VolumePoolListOptions{NodeIds: []string{targetNodeId}})
+		if err != nil {
+			env.error(rw, req, err, "cannot list pools", http.StatusInternalServerError)
+			return
+		}
+		pools = targetPools
+	}
+	data := struct {
+		Title        string
+		Vm           *compute.VirtualMachine
+		Nodes        []*compute.Node
+		TargetNodeId string
+		Pools        []*compute.VolumePool
+		User         *User
+		Request      *http.Request
+	}{"Migrate VirtualMachine", vm, targetNodes, targetNodeId, pools, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/migrate", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineMigrateFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	options := compute.VirtualMachineMigrateOptions{
+		CopyStorage: req.Form.Get("CopyStorage") == "true",
+		Offline:     req.Form.Get("Offline") == "true",
+		TargetPool:  req.Form.Get("TargetPool"),
+	}
+	if bandwidthRaw := req.Form.Get("Bandwidth"); bandwidthRaw != "" {
+		bandwidth, err := strconv.ParseUint(bandwidthRaw, 10, 64)
+		if err != nil {
+			http.Error(rw, "invalid bandwidth value: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		options.Bandwidth = bandwidth
+	}
+	if _, err := env.vmanager.Migrate(urlvars["id"], urlvars["node"], req.Form.Get("TargetNodeId"), options); err != nil {
+		env.error(rw, req, err, "cannot start migration", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("virtual-machine-migrate-status", "id", urlvars["id"], "node", urlvars["node"])
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+func (env *Environ) VirtualMachineMigrateStatus(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	migration := env.vmanager.Migration(urlvars["id"], urlvars["node"])
+	if migration == nil {
+		env.error(rw, req, fmt.Errorf("migration not found"), "no migration for this machine", http.StatusNotFound)
+		return
+	}
+	data := struct {
+		Title     string
+		Migration compute.VirtualMachineMigration
+		User      *User
+		Request   *http.Request
+	}{"Virtual Machine Migration", migration.Snapshot(), env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/migrate-status", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+var GraphicTypes = []compute.GraphicType{
+	compute.GraphicTypeNone,
+	compute.GraphicTypeVnc,
+	compute.GraphicTypeSpice,
+}
+
+var VideoModels = []compute.VideoModel{
+	compute.VideoModelNone,
+	compute.VideoModelCirrus,
+	compute.VideoModelQxl,
+}
+
+func (env *Environ) VirtualMachineUpdateFormShow(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "virtual-machine detail failed", http.StatusInternalServerError)
+		return
+	}
+	flavors, err := env.flavors.List()
+	if err != nil {
+		env.error(rw, req, err, "cannot list flavors", http.StatusInternalServerError)
+		return
+	}
+	allowedFlavors := []*compute.Flavor{}
+	for _, flavor := range flavors {
+		if flavor.AllowedOn(vm.NodeId) {
+			allowedFlavors = append(allowedFlavors, flavor)
+		}
+	}
+	node, err := env.nodes.Get(vm.NodeId, compute.NodeGetOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "cannot get node", http.StatusInternalServerError)
+		return
+	}
+	user := env.Session(req).AuthUser()
+	data := struct {
+		Title            string
+		Vm               *compute.VirtualMachine
+		GraphicTypes     []compute.GraphicType
+		VideoModels      []compute.VideoModel
+		CpuModes         []string
+		CpuModels        []string
+		HugepageSizes    []string
+		NumaModes        []string
+		WatchdogActions  []string
+		LifecycleActions []string
+		CrashActions     []string
+		Flavors          []*compute.Flavor
+		FlavorsOnly      bool
+		User             *User
+		Request          *http.Request
+	}{"Update VirtualMachine", vm, GraphicTypes, VideoModels, compute.CpuModes, node.CpuModels, compute.HugepageSizes, compute.NumaModes,
+		compute.WatchdogActions, compute.LifecycleActions, compute.CrashActions, allowedFlavors, env.flavorsOnly(user), user, req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/update", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineUpdateFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	existing, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "cannot get virtual machine", http.StatusInternalServerError)
+		return
+	}
+	expiresAt, err := formUpdateExpiresAt(req.Form, existing)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vm := &compute.VirtualMachine{
+		Id:         urlvars["id"],
+		NodeId:     urlvars["node"],
+		Autostart:  req.Form.Get("Autostart") == "true",
+		GuestAgent: req.Form.Get("GuestAgent") == "true",
+		VideoModel: compute.NewVideoModel(req.Form.Get("VideoModel")),
+		Hugepages:  req.Form.Get("Hugepages") == "true",
+		Tpm:        req.Form.Get("Tpm") == "true",
+		Graphic: compute.VirtualMachineGraphic{
+			Type:   compute.NewGraphicType(req.Form.Get("GraphicType")),
+			Listen: req.Form.Get("GraphicListen"),
+		},
+		Tags:      formTags(req.Form.Get("Tags")),
+		Owner:     existing.Owner,
+		ExpiresAt: expiresAt,
+	}
+	formDevices(req.Form, vm)
+
+	flavor, err := env.formFlavor(req.Form, env.Session(req).AuthUser(), vm.NodeId)
+	if err != nil {
+		http.Error(rw, "invalid flavor: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if flavor != nil {
+		vm.Flavor = flavor.Name
+		vm.VCpus = flavor.VCpus
+		vm.Memory = flavor.Memory
+		vm.Hugepages = flavor.Hugepages
+	} else {
+		vcpus, err := strconv.ParseInt(req.Form.Get("Vcpus"), 10, 16)
+		if err != nil {
+			http.Error(rw, "invalid vcpus value: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		vm.VCpus = int(vcpus)
+
+		memoryValue, err := strconv.ParseUint(req.Form.Get("MemoryValue"), 10, 32)
+		if err != nil {
+			http.Error(rw, "invalid memoryMb value: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		memoryUnit := compute.NewSizeUnit(req.Form.Get("MemoryUnit"))
+		if memoryUnit == compute.SizeUnitUnknown {
+			http.Error(rw, "unknown memory unit: "+req.Form.Get("MemoryUnit"), http.StatusBadRequest)
+			return
+		}
+		vm.Memory = compute.NewSize(memoryValue, memoryUnit)
+	}
+	vm.DimmMemory = existing.DimmMemory
+	if err := formHotplug(req.Form, vm, existing); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cpu, err := formCpu(req.Form, vm.MaxVCpuCount())
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vm.Cpu = cpu
+	if err := formNumaSettings(req.Form, vm); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !existing.IsRunning() {
+		// Pages of running machine are already allocated and cannot be checked
+		if err := env.checkHugepages(vm); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	boot, err := formBoot(req.Form)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := boot.Validate(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := boot.ValidateDevices(existing); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hotplug, err := env.vms.Hotplug(existing, vm)
+	if err != nil {
 		env.error(rw, req, err, "cannot update virtual machine", http.StatusInternalServerError)
 		return
 	}
+	// Root volume cannot be shrunk back, so it is resized only after machine is saved
+	rootResizeDeferred := false
+	if flavor != nil && flavor.RootDisk.Bytes() > 0 {
+		rootResizeDeferred, err = env.vmanager.GrowRootVolume(vm.Id, vm.NodeId, flavor.RootDisk)
+		if err != nil {
+			env.error(rw, req, err, "cannot resize root volume", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := env.vms.SetBoot(vm.Id, vm.NodeId, boot); err != nil {
+		env.error(rw, req, err, "cannot update boot order", http.StatusInternalServerError)
+		return
+	}
+	if !existing.IsRunning() {
+		redirectUrl := env.url("virtual-machine-detail", "id", urlvars["id"], "node", urlvars["node"])
+		http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+		return
+	}
+	deferred := virtualMachineDeferredChanges(existing, vm, hotplug)
+	pending := []string{}
+	if rootResizeDeferred {
+		pending = append(pending, fmt.Sprintf("root volume resize to %d GiB", flavor.RootDisk.G()))
+	}
+	if !reflect.DeepEqual(boot, existing.Boot()) {
+		deferred = append(deferred, "boot order and menu")
+	}
+	data := struct {
+		Title    string
+		Vm       *compute.VirtualMachine
+		Live     []string
+		Deferred []string
+		Pending  []string
+		Request  *http.Request
+	}{"Virtual Machine Updated", vm, hotplug.Describe(existing), deferred, pending, req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/updated", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineRenewFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if _, err := env.expiry.Renew(urlvars["id"], urlvars["node"]); err != nil {
+		env.error(rw, req, err, "cannot renew virtual machine", http.StatusInternalServerError)
+		return
+	}
 	redirectUrl := env.url("virtual-machine-detail", "id", urlvars["id"], "node", urlvars["node"])
 	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
 }
 
+func (env *Environ) VirtualMachineMemoryTargetFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	target, err := strconv.ParseUint(req.Form.Get("MemoryTargetM"), 10, 32)
+	if err != nil {
+		http.Error(rw, "invalid memory target: "+req.Form.Get("MemoryTargetM"), http.StatusBadRequest)
+		return
+	}
+	if err := env.vms.SetMemoryTarget(urlvars["id"], urlvars["node"], target*1024*1024); err != nil {
+		env.error(rw, req, err, "cannot set memory target", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("virtual-machine-detail", "id", urlvars["id"], "node", urlvars["node"])
+	http.Redirect(rw, req, redirectUrl.Path+"?tab=memory", http.StatusFound)
+}
+
+func (env *Environ) VirtualMachineAttachHostDeviceFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can attach host devices", http.StatusForbidden)
+		return
+	}
+	node, err := env.nodes.Get(urlvars["node"], compute.NodeGetOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "cannot get node", http.StatusInternalServerError)
+		return
+	}
+	devices, err := env.nodes.ListDevices(node.Id)
+	if err != nil {
+		env.error(rw, req, err, "cannot list node devices", http.StatusInternalServerError)
+		return
+	}
+	device, err := compute.CheckHostDeviceAttach(node, devices, req.Form.Get("Device"), urlvars["id"])
+	if err != nil {
+		env.error(rw, req, err, "cannot attach host device", http.StatusBadRequest)
+		return
+	}
+	attachedDevice := &compute.VirtualMachineAttachedHostDevice{Type: device.Type, Address: device.Address}
+	if err := env.vms.AttachHostDevice(urlvars["id"], node.Id, attachedDevice); err != nil {
+		env.error(rw, req, err, "cannot attach host device", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("virtual-machine-detail", "id", urlvars["id"], "node", urlvars["node"])
+	http.Redirect(rw, req, redirectUrl.Path+"?tab=devices", http.StatusFound)
+}
+
+func (env *Environ) VirtualMachineDetachHostDeviceFormProcess(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can detach host devices", http.StatusForbidden)
+		return
+	}
+	attachedDevice := &compute.VirtualMachineAttachedHostDevice{Type: req.Form.Get("Type"), Address: req.Form.Get("Address")}
+	if err := env.vms.DetachHostDevice(urlvars["id"], urlvars["node"], attachedDevice); err != nil {
+		env.error(rw, req, err, "cannot detach host device", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("virtual-machine-detail", "id", urlvars["id"], "node", urlvars["node"])
+	http.Redirect(rw, req, redirectUrl.Path+"?tab=devices", http.StatusFound)
+}
+
 func (env *Environ) VirtualMachineAttachDiskFormProcess(rw http.ResponseWriter, req *http.Request) {
 	urlvars := mux.Vars(req)
 	if err := req.ParseForm(); err != nil {
@@ -528,6 +1389,12 @@ func (env *Environ) VirtualMachineAttachDiskFormProcess(rw http.ResponseWriter,
 		Alias:      req.Form.Get("Alias"),
 		DeviceType: deviceType,
 		DeviceBus:  deviceBus,
+		Driver: compute.VirtualMachineVolumeDriver{
+			Cache:        req.Form.Get("DiskCache"),
+			Io:           req.Form.Get("DiskIo"),
+			Discard:      req.Form.Get("DiskDiscard"),
+			DetectZeroes: req.Form.Get("DiskDetectZeroes"),
+		},
 	}
 	if err := env.vms.AttachVolume(urlvars["id"], urlvars["node"], attachedVolume); err != nil {
 		env.error(rw, req, err, "cannot attach disk", http.StatusInternalServerError)
@@ -731,3 +1598,786 @@ func (env *Environ) VirtualMachineVncWs(rw http.ResponseWriter, req *http.Reques
 
 	}
 }
+
+func (env *Environ) VirtualMachineExport(rw http.ResponseWriter, req *http.Request) {
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "virtual-machine get failed", http.StatusInternalServerError)
+		return
+	}
+	if vm.IsRunning() {
+		env.error(rw, req, fmt.Errorf("vm must be stopped"), "cannot export running virtual machine", http.StatusBadRequest)
+		return
+	}
+	if vm.HasFirmwareState() {
+		env.error(rw, req, fmt.Errorf("nvram and tpm state would be lost"), "cannot export virtual machine with secure boot or tpm", http.StatusBadRequest)
+		return
+	}
+	export, err := env.vmanager.PrepareExport(vm.Id, vm.NodeId)
+	if err != nil {
+		env.error(rw, req, err, "cannot export virtual machine", http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/x-tar")
+	rw.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.tar"`, export.Id))
+	if err := env.vmanager.WriteExport(export, rw); err != nil {
+		// Headers are already sent, client gets truncated archive
+		env.logger.Warn().Err(err).Str("id", export.Id).Str("node", export.NodeId).Msg("virtual machine export failed")
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineImportFormShow(rw http.ResponseWriter, req *http.Request) {
+	nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "cannot list nodes", http.StatusInternalServerError)
+		return
+	}
+	if len(nodes) == 0 {
+		env.error(rw, req, fmt.Errorf("no nodes available"), "cannot import virtual machine", http.StatusInternalServerError)
+		return
+	}
+	selectedNodeId := req.URL.Query().Get("node")
+	if selectedNodeId == "" {
+		selectedNodeId = nodes[0].Id
+	}
+	pools, err := env.volpools.List(compute.VolumePoolListOptions{NodeIds: []string{selectedNodeId}})
+	if err != nil {
+		env.error(rw, req, err, "cannot list pools", http.StatusInternalServerError)
+		return
+	}
+	networks, err := env.networks.List(compute.NetworkListOptions{NodeIds: []string{selectedNodeId}})
+	if err != nil {
+		env.error(rw, req, err, "cannot list networks", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title    string
+		NodeId   string
+		Nodes    []*compute.Node
+		Pools    []*compute.VolumePool
+		Networks []*compute.Network
+		User     *User
+		Request  *http.Request
+	}{"Import Virtual Machine", selectedNodeId, nodes, pools, networks, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/import", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineImportFormProcess(rw http.ResponseWriter, req *http.Request) {
+	// Large archives are spooled to disk by multipart parser
+	if err := req.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	archive, _, err := req.FormFile("Archive")
+	if err != nil {
+		http.Error(rw, "archive file required: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer archive.Close()
+	options := compute.VirtualMachineImportOptions{
+		NodeId:      req.Form.Get("NodeId"),
+		Pool:        req.Form.Get("Pool"),
+		Id:          req.Form.Get("Name"),
+		NetworkName: req.Form.Get("Network"),
+		ResetMacs:   req.Form.Get("ResetMacs") == "true",
+		Start:       req.Form.Get("Start") == "true",
+	}
+	if options.NodeId == "" || options.Pool == "" {
+		http.Error(rw, "node and pool required", http.StatusBadRequest)
+		return
+	}
+	vm, err := env.vmanager.Import(archive, options)
+	if err != nil {
+		env.error(rw, req, err, "cannot import virtual machine", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("virtual-machine-detail", "id", vm.Id, "node", vm.NodeId)
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+// formTags splits tags by commas and spaces, duplicates are removed
+func formTags(value string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		if seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// formExpiresAt parses expiration date, machine expires at the start of that day.
+// Past dates are rejected, expired machine would be stopped or deleted right away.
+func formExpiresAt(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	expiresAt, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration date: %s", value)
+	}
+	if !expiresAt.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("expiration date %s is not in the future", value)
+	}
+	return expiresAt, nil
+}
+
+// formUpdateExpiresAt parses expiration date of existing machine, unchanged date
+// is kept as is even if it is already passed. Earlier date than the current one
+// requires ConfirmExpiry flag, because machine is stopped and deleted on expiry.
+func formUpdateExpiresAt(form url.Values, existing *compute.VirtualMachine) (time.Time, error) {
+	value := form.Get("ExpiresAt")
+	if existing.HasExpiry() && value == existing.ExpiresAt.Format("2006-01-02") {
+		return existing.ExpiresAt, nil
+	}
+	expiresAt, err := formExpiresAt(value)
+	if err != nil {
+		return expiresAt, err
+	}
+	shortened := !expiresAt.IsZero() && (!existing.HasExpiry() || expiresAt.Before(existing.ExpiresAt))
+	if shortened && form.Get("ConfirmExpiry") != "true" {
+		return expiresAt, fmt.Errorf("new expiration date %s is earlier than the current one, confirm shortening the expiry", value)
+	}
+	return expiresAt, nil
+}
+
+// formBoot parses boot menu flag and boot order of devices,
+// devices with empty or zero order are not bootable
+func formBoot(form url.Values) (compute.VirtualMachineBoot, error) {
+	boot := compute.VirtualMachineBoot{Menu: form.Get("BootMenu") == "true", Order: map[string]uint{}}
+	if len(form["BootDevice"]) != len(form["BootOrder"]) {
+		return boot, fmt.Errorf("boot device and order count mismatch")
+	}
+	for idx, device := range form["BootDevice"] {
+		if form["BootOrder"][idx] == "" {
+			continue
+		}
+		order, err := strconv.ParseUint(form["BootOrder"][idx], 10, 16)
+		if err != nil {
+			return boot, fmt.Errorf("invalid boot order for %s: %s", device, form["BootOrder"][idx])
+		}
+		if order > 0 {
+			boot.Order[device] = uint(order)
+		}
+	}
+	return boot, nil
+}
+
+// ApiVirtualMachineBootUpdate changes boot settings of the machine.
+// Boot menu and device order are left unchanged if not specified in request.
+func (env *Environ) ApiVirtualMachineBootUpdate(rw http.ResponseWriter, req *http.Request) {
+	if user := env.apiAuthenticate(rw, req); user == nil {
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		http.Error(rw, "cannot get vm: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	boot, err := formBoot(req.PostForm)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	current := vm.Boot()
+	if _, ok := req.PostForm["BootMenu"]; !ok {
+		boot.Menu = current.Menu
+	}
+	if _, ok := req.PostForm["BootDevice"]; !ok {
+		boot.Order = current.Order
+	}
+	if err := env.vms.SetBoot(vm.Id, vm.NodeId, boot); err != nil {
+		http.Error(rw, "cannot update boot order: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := env.render.JSON(rw, http.StatusOK, boot); err != nil {
+		env.logger.Warn().Err(err).Msg("cannot render response")
+	}
+}
+
+// ApiVirtualMachineMemory returns balloon stats of running machine, memory
+// target is changed first if MemoryTargetM is posted
+func (env *Environ) ApiVirtualMachineMemory(rw http.ResponseWriter, req *http.Request) {
+	if user := env.apiAuthenticate(rw, req); user == nil {
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	urlvars := mux.Vars(req)
+	if req.Method == http.MethodPost {
+		target, err := strconv.ParseUint(req.PostForm.Get("MemoryTargetM"), 10, 32)
+		if err != nil {
+			http.Error(rw, "invalid memory target: "+req.PostForm.Get("MemoryTargetM"), http.StatusBadRequest)
+			return
+		}
+		if err := env.vms.SetMemoryTarget(urlvars["id"], urlvars["node"], target*1024*1024); err != nil {
+			http.Error(rw, "cannot set memory target: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	stats, err := env.vms.GetMemoryStats(urlvars["id"], urlvars["node"])
+	if err != nil {
+		http.Error(rw, "cannot get memory stats: "+err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := env.render.JSON(rw, http.StatusOK, stats); err != nil {
+		env.logger.Warn().Err(err).Msg("cannot render response")
+	}
+}
+
+// formCpu parses cpu mode, model, features and topology, empty topology
+// fields mean that topology is derived from vcpu count
+func formCpu(form url.Values, vcpus int) (compute.VirtualMachineCpu, error) {
+	cpu := compute.VirtualMachineCpu{
+		Mode:  form.Get("CpuMode"),
+		Model: strings.TrimSpace(form.Get("CpuModel")),
+	}
+	if cpu.Mode != compute.CpuModeCustom {
+		cpu.Model = ""
+	}
+	features, err := compute.ParseCpuFeatures(form.Get("CpuFeatures"))
+	if err != nil {
+		return cpu, err
+	}
+	cpu.Features = features
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{{"CpuSockets", &cpu.Sockets}, {"CpuCores", &cpu.Cores}, {"CpuThreads", &cpu.Threads}} {
+		if form.Get(field.name) == "" {
+			continue
+		}
+		value, err := strconv.ParseUint(form.Get(field.name), 10, 16)
+		if err != nil {
+			return cpu, fmt.Errorf("invalid %s value: %s", field.name, form.Get(field.name))
+		}
+		*field.value = int(value)
+	}
+	if err := cpu.Validate(vcpus); err != nil {
+		return cpu, err
+	}
+	return cpu, nil
+}
+
+// formHotplug parses vcpu and memory hotplug limits, absent fields are
+// copied from existing machine, so flavor only forms keep them unchanged
+func formHotplug(form url.Values, vm, existing *compute.VirtualMachine) error {
+	if existing == nil {
+		existing = &compute.VirtualMachine{}
+	}
+	vm.MaxVCpus = existing.MaxVCpus
+	if _, ok := form["MaxVcpus"]; ok {
+		vm.MaxVCpus = 0
+		if value := form.Get("MaxVcpus"); value != "" {
+			maxVcpus, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid max vcpus value: %s", value)
+			}
+			if int(maxVcpus) < vm.VCpus {
+				return fmt.Errorf("max vcpus %d cannot be less than vcpus %d", maxVcpus, vm.VCpus)
+			}
+			vm.MaxVCpus = int(maxVcpus)
+		}
+	}
+	vm.MemorySlots = existing.MemorySlots
+	vm.MaxMemory = existing.MaxMemory
+	if _, ok := form["MemorySlots"]; ok {
+		vm.MemorySlots = 0
+		vm.MaxMemory = compute.Size{}
+		if value := form.Get("MemorySlots"); value != "" && value != "0" {
+			slots, err := strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid memory slots value: %s", value)
+			}
+			maxMemory, err := strconv.ParseUint(form.Get("MaxMemoryM"), 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid max memory value: %s", form.Get("MaxMemoryM"))
+			}
+			vm.MemorySlots = int(slots)
+			vm.MaxMemory = compute.NewSize(maxMemory, compute.SizeUnitM)
+		}
+	}
+	return nil
+}
+
+// virtualMachineDeferredChanges lists changes of running machine
+// which are saved to config but applied only after restart
+func virtualMachineDeferredChanges(existing, vm *compute.VirtualMachine, hotplug compute.VirtualMachineHotplug) []string {
+	changes := []string{}
+	if vm.VCpus != existing.VCpus && hotplug.VCpus == 0 {
+		changes = append(changes, fmt.Sprintf("vcpus %d to %d", existing.VCpus, vm.VCpus))
+	}
+	if vm.MaxVCpuCount() != existing.MaxVCpuCount() {
+		changes = append(changes, fmt.Sprintf("max vcpus %d to %d", existing.MaxVCpuCount(), vm.MaxVCpuCount()))
+	}
+	if vm.Memory.Bytes() != existing.Memory.Bytes() && hotplug.Memory == 0 {
+		changes = append(changes, fmt.Sprintf("memory %d MiB to %d MiB", existing.Memory.M(), vm.Memory.M()))
+	}
+	if vm.MemorySlots != existing.MemorySlots || vm.MemoryHotplug() && vm.MaxMemory.Bytes() != existing.MaxMemory.Bytes() {
+		changes = append(changes, "memory hotplug limits")
+	}
+	if vm.Cpu.Mode != existing.Cpu.Mode || vm.Cpu.Model != existing.Cpu.Model || vm.Cpu.FeaturesString() != existing.Cpu.FeaturesString() ||
+		vm.Cpu.Sockets != existing.Cpu.Sockets || vm.Cpu.Cores != existing.Cpu.Cores || vm.Cpu.Threads != existing.Cpu.Threads {
+		changes = append(changes, "cpu mode, model, features or topology")
+	}
+	if vm.Hugepages != existing.Hugepages || vm.HugepageSize != existing.HugepageSize {
+		changes = append(changes, "hugepages")
+	}
+	if vm.Numa.Mode != existing.Numa.Mode || compute.FormatCpuSet(vm.Numa.Nodeset) != compute.FormatCpuSet(existing.Numa.Nodeset) || vm.Numa.CellsString() != existing.Numa.CellsString() {
+		changes = append(changes, "numa")
+	}
+	if vm.Graphic.Type != existing.Graphic.Type || vm.Graphic.Listen != existing.Graphic.Listen || vm.VideoModel != existing.VideoModel {
+		changes = append(changes, "graphic")
+	}
+	if vm.GuestAgent != existing.GuestAgent {
+		changes = append(changes, "guest agent")
+	}
+	if vm.Tpm != existing.Tpm {
+		changes = append(changes, "tpm")
+	}
+	if vm.Rng != existing.Rng || vm.Watchdog != existing.Watchdog || vm.Pvpanic != existing.Pvpanic {
+		changes = append(changes, "rng, watchdog or pvpanic device")
+	}
+	if compute.LifecycleAction(vm.OnPoweroff, compute.DefaultOnPoweroff) != compute.LifecycleAction(existing.OnPoweroff, compute.DefaultOnPoweroff) ||
+		compute.LifecycleAction(vm.OnReboot, compute.DefaultOnReboot) != compute.LifecycleAction(existing.OnReboot, compute.DefaultOnReboot) ||
+		compute.LifecycleAction(vm.OnCrash, compute.DefaultOnCrash) != compute.LifecycleAction(existing.OnCrash, compute.DefaultOnCrash) {
+		changes = append(changes, "lifecycle actions")
+	}
+	return changes
+}
+
+// formDevices parses auxiliary devices and lifecycle actions, validation is done by service
+func formDevices(form url.Values, vm *compute.VirtualMachine) {
+	vm.Rng = form.Get("Rng") == "true"
+	vm.Watchdog = form.Get("Watchdog")
+	vm.Pvpanic = form.Get("Pvpanic") == "true"
+	vm.OnPoweroff = form.Get("OnPoweroff")
+	vm.OnReboot = form.Get("OnReboot")
+	vm.OnCrash = form.Get("OnCrash")
+}
+
+// formNumaSettings parses hugepage size, numatune mode and nodeset, and guest numa cells
+func formNumaSettings(form url.Values, vm *compute.VirtualMachine) error {
+	vm.HugepageSize = ""
+	if vm.Hugepages {
+		vm.HugepageSize = form.Get("HugepageSize")
+	}
+	nodeset, err := compute.ParseCpuSet(form.Get("NumaNodeset"))
+	if err != nil {
+		return fmt.Errorf("invalid numa nodeset: %s", err)
+	}
+	cells, err := compute.ParseNumaCells(form.Get("NumaCells"))
+	if err != nil {
+		return fmt.Errorf("invalid guest numa cells: %s", err)
+	}
+	vm.Numa = compute.VirtualMachineNuma{Mode: form.Get("NumaMode"), Nodeset: nodeset, Cells: cells}
+	if len(cells) == 0 {
+		vm.Numa.Cells = nil
+	}
+	if len(nodeset) == 0 {
+		vm.Numa.Nodeset = nil
+	}
+	return vm.ValidateMemory()
+}
+
+// formNuma parses numa settings of a new machine and checks free hugepages on its node
+func (env *Environ) formNuma(form url.Values, vm *compute.VirtualMachine) error {
+	if err := formNumaSettings(form, vm); err != nil {
+		return err
+	}
+	return env.checkHugepages(vm)
+}
+
+func (env *Environ) checkHugepages(vm *compute.VirtualMachine) error {
+	if !vm.Hugepages {
+		return nil
+	}
+	node, err := env.nodes.Get(vm.NodeId, compute.NodeGetOptions{NoPins: true})
+	if err != nil {
+		return util.NewError(err, "cannot get node")
+	}
+	return compute.CheckHugepages(node, vm)
+}
+
+// formCpuPin parses cpu pinning form, Vcpu and IOThread fields are
+// cpusets ordered by vcpu and iothread id, empty cpuset means unpinned
+func formCpuPin(form url.Values) (*compute.VirtualMachineCpuPin, error) {
+	pin := &compute.VirtualMachineCpuPin{Vcpus: map[uint][]uint{}, IOThreads: map[uint][]uint{}}
+	empty := true
+	for vcpu, value := range form["Vcpu"] {
+		cpus, err := compute.ParseCpuSet(value)
+		if err != nil {
+			return nil, fmt.Errorf("vcpu %d: %s", vcpu, err)
+		}
+		if len(cpus) > 0 {
+			pin.Vcpus[uint(vcpu)] = cpus
+			empty = false
+		}
+	}
+	for idx, value := range form["IOThread"] {
+		cpus, err := compute.ParseCpuSet(value)
+		if err != nil {
+			return nil, fmt.Errorf("iothread %d: %s", idx+1, err)
+		}
+		if len(cpus) > 0 {
+			pin.IOThreads[uint(idx+1)] = cpus
+			empty = false
+		}
+	}
+	cpus, err := compute.ParseCpuSet(form.Get("Emulator"))
+	if err != nil {
+		return nil, fmt.Errorf("emulator: %s", err)
+	}
+	if len(cpus) > 0 {
+		pin.Emulator = cpus
+		empty = false
+	}
+	memoryNodes, err := compute.ParseCpuSet(form.Get("MemoryNodes"))
+	if err != nil {
+		return nil, fmt.Errorf("memory numa cells: %s", err)
+	}
+	if len(memoryNodes) > 0 {
+		pin.MemoryNodes = memoryNodes
+		empty = false
+	}
+	if empty {
+		return nil, nil
+	}
+	return pin, nil
+}
+
+func nodeHasNumaCell(node *compute.Node, numaId uint) bool {
+	for _, cpu := range node.Cpus {
+		if cpu.NumaId == int(numaId) {
+			return true
+		}
+	}
+	return false
+}
+
+func (env *Environ) renderCpuPinForm(rw http.ResponseWriter, req *http.Request, vm *compute.VirtualMachine, node *compute.Node, pin *compute.VirtualMachineCpuPin, message string) {
+	if pin == nil {
+		pin = &compute.VirtualMachineCpuPin{}
+	}
+	vcpus := make([]string, vm.MaxVCpuCount())
+	for idx := range vcpus {
+		vcpus[idx] = compute.FormatCpuSet(pin.Vcpus[uint(idx)])
+	}
+	memoryNodes := pin.MemoryNodes
+	if len(memoryNodes) == 0 && vm.Numa.Mode == "strict" {
+		memoryNodes = vm.Numa.Nodeset
+	}
+	type iothreadPin struct {
+		Id     uint
+		CpuSet string
+	}
+	iothreads := []iothreadPin{}
+	for iothread := uint(1); iothread <= vm.IOThreads; iothread++ {
+		iothreads = append(iothreads, iothreadPin{iothread, compute.FormatCpuSet(pin.IOThreads[iothread])})
+	}
+	data := struct {
+		Title       string
+		Vm          *compute.VirtualMachine
+		Node        *compute.Node
+		Vcpus       []string
+		Emulator    string
+		IOThreads   []iothreadPin
+		MemoryNodes string
+		Message     string
+		Request     *http.Request
+	}{"Cpu Pinning", vm, node, vcpus, compute.FormatCpuSet(pin.Emulator), iothreads, compute.FormatCpuSet(memoryNodes), message, req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/cpupin", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineCpuPinFormShow(rw http.ResponseWriter, req *http.Request) {
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can change cpu pinning", http.StatusForbidden)
+		return
+	}
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "cannot get virtual machine", http.StatusInternalServerError)
+		return
+	}
+	node, err := env.nodes.Get(vm.NodeId, compute.NodeGetOptions{})
+	if err != nil {
+		env.error(rw, req, err, "cannot get node", http.StatusInternalServerError)
+		return
+	}
+	env.renderCpuPinForm(rw, req, vm, node, vm.Cpupin, "")
+}
+
+// VirtualMachineCpuPinFormProcess saves pinning from the form, removes it,
+// or fills the form with automatically chosen cpus for review
+func (env *Environ) VirtualMachineCpuPinFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can change cpu pinning", http.StatusForbidden)
+		return
+	}
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "cannot get virtual machine", http.StatusInternalServerError)
+		return
+	}
+	node, err := env.nodes.Get(vm.NodeId, compute.NodeGetOptions{})
+	if err != nil {
+		env.error(rw, req, err, "cannot get node", http.StatusInternalServerError)
+		return
+	}
+
+	var pin *compute.VirtualMachineCpuPin
+	switch req.Form.Get("Action") {
+	case "auto":
+		autoPin, err := compute.AutoCpuPin(node, vm.Id, vm.MaxVCpuCount(), vm.IOThreads)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		env.renderCpuPinForm(rw, req, vm, node, autoPin, "Automatically chosen cpus are not saved yet, review and save them.")
+		return
+	case "clear":
+	default:
+		pin, err = formCpuPin(req.Form)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if pin != nil {
+			if err := pin.Validate(vm.MaxVCpuCount(), vm.IOThreads, len(node.Cpus)); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			for _, numaId := range pin.MemoryNodes {
+				if !nodeHasNumaCell(node, numaId) {
+					http.Error(rw, fmt.Sprintf("numa cell %d doesn't exist on node %s", numaId, node.Id), http.StatusBadRequest)
+					return
+				}
+			}
+		}
+	}
+	if err := env.vms.SetCpuPin(vm.Id, vm.NodeId, pin); err != nil {
+		env.error(rw, req, err, "cannot set cpu pinning", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("virtual-machine-detail", "id", vm.Id, "node", vm.NodeId)
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+// ioTuneFields maps form fields to volume limits, bytes fields are in MiB/s
+var ioTuneFields = []struct {
+	name  string
+	bytes bool
+	value func(*compute.VirtualMachineVolumeIoTune) *uint64
+}{
+	{"TotalBytesSec", true, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.TotalBytesSec }},
+	{"ReadBytesSec", true, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.ReadBytesSec }},
+	{"WriteBytesSec", true, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.WriteBytesSec }},
+	{"TotalIopsSec", false, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.TotalIopsSec }},
+	{"ReadIopsSec", false, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.ReadIopsSec }},
+	{"WriteIopsSec", false, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.WriteIopsSec }},
+	{"TotalBytesSecMax", true, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.TotalBytesSecMax }},
+	{"ReadBytesSecMax", true, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.ReadBytesSecMax }},
+	{"WriteBytesSecMax", true, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.WriteBytesSecMax }},
+	{"TotalIopsSecMax", false, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.TotalIopsSecMax }},
+	{"ReadIopsSecMax", false, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.ReadIopsSecMax }},
+	{"WriteIopsSecMax", false, func(t *compute.VirtualMachineVolumeIoTune) *uint64 { return &t.WriteIopsSecMax }},
+}
+
+// formIoTune parses volume limits, empty or zero value means unlimited
+func formIoTune(form url.Values) (compute.VirtualMachineVolumeIoTune, error) {
+	iotune := compute.VirtualMachineVolumeIoTune{}
+	for _, field := range ioTuneFields {
+		raw := strings.TrimSpace(form.Get(field.name))
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return iotune, fmt.Errorf("invalid %s value: %s", field.name, raw)
+		}
+		if field.bytes {
+			value = value * 1024 * 1024
+		}
+		*field.value(&iotune) = value
+	}
+	return iotune, nil
+}
+
+func (env *Environ) renderIoTuneForm(rw http.ResponseWriter, req *http.Request, vm *compute.VirtualMachine, volume *compute.VirtualMachineAttachedVolume) {
+	values := map[string]uint64{}
+	for _, field := range ioTuneFields {
+		value := *field.value(&volume.IoTune)
+		if field.bytes {
+			value = value / 1024 / 1024
+		}
+		values[field.name] = value
+	}
+	data := struct {
+		Title   string
+		Vm      *compute.VirtualMachine
+		Volume  *compute.VirtualMachineAttachedVolume
+		Values  map[string]uint64
+		Request *http.Request
+	}{"Disk I/O Limits", vm, volume, values, req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/iotune", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineIoTuneFormShow(rw http.ResponseWriter, req *http.Request) {
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can change disk limits", http.StatusForbidden)
+		return
+	}
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "cannot get virtual machine", http.StatusInternalServerError)
+		return
+	}
+	volume := vm.AttachmentInfo(req.URL.Query().Get("path"))
+	if volume == nil {
+		http.Error(rw, "volume is not attached", http.StatusNotFound)
+		return
+	}
+	env.renderIoTuneForm(rw, req, vm, volume)
+}
+
+func (env *Environ) VirtualMachineIoTuneFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can change disk limits", http.StatusForbidden)
+		return
+	}
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	iotune := compute.VirtualMachineVolumeIoTune{}
+	if req.Form.Get("Action") != "clear" {
+		parsed, err := formIoTune(req.Form)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		iotune = parsed
+	}
+	if err := env.vms.SetVolumeIoTune(urlvars["id"], urlvars["node"], req.Form.Get("Path"), iotune); err != nil {
+		env.error(rw, req, err, "cannot set disk limits", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("virtual-machine-detail", "id", urlvars["id"], "node", urlvars["node"])
+	http.Redirect(rw, req, redirectUrl.Path, http.StatusFound)
+}
+
+// formBandwidth parses interface traffic limits, empty values mean unlimited
+func formBandwidth(form url.Values) (compute.VirtualMachineInterfaceBandwidth, error) {
+	bandwidth := compute.VirtualMachineInterfaceBandwidth{}
+	for _, field := range []struct {
+		name  string
+		value *uint
+	}{
+		{"InboundAverage", &bandwidth.Inbound.Average},
+		{"InboundPeak", &bandwidth.Inbound.Peak},
+		{"InboundBurst", &bandwidth.Inbound.Burst},
+		{"OutboundAverage", &bandwidth.Outbound.Average},
+		{"OutboundPeak", &bandwidth.Outbound.Peak},
+		{"OutboundBurst", &bandwidth.Outbound.Burst},
+	} {
+		raw := strings.TrimSpace(form.Get(field.name))
+		if raw == "" {
+			continue
+		}
+		value, err := strconv.ParseUint(raw, 10, 32)
+		if err != nil {
+			return bandwidth, fmt.Errorf("invalid %s value: %s", field.name, raw)
+		}
+		*field.value = uint(value)
+	}
+	return bandwidth, nil
+}
+
+func (env *Environ) VirtualMachineBandwidthFormShow(rw http.ResponseWriter, req *http.Request) {
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can change interface limits", http.StatusForbidden)
+		return
+	}
+	urlvars := mux.Vars(req)
+	vm, err := env.vms.Get(urlvars["id"], urlvars["node"])
+	if err != nil {
+		env.error(rw, req, err, "cannot get virtual machine", http.StatusInternalServerError)
+		return
+	}
+	var iface *compute.VirtualMachineAttachedInterface
+	for _, attachedIface := range vm.Interfaces {
+		if strings.EqualFold(attachedIface.Mac, req.URL.Query().Get("mac")) {
+			iface = attachedIface
+		}
+	}
+	if iface == nil {
+		http.Error(rw, "interface is not attached", http.StatusNotFound)
+		return
+	}
+	data := struct {
+		Title     string
+		Vm        *compute.VirtualMachine
+		Interface *compute.VirtualMachineAttachedInterface
+		Request   *http.Request
+	}{"Interface Bandwidth Limits", vm, iface, req}
+	if err := env.render.HTML(rw, http.StatusOK, "virtual-machine/bandwidth", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VirtualMachineBandwidthFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if !env.isAdmin(env.Session(req).AuthUser()) {
+		http.Error(rw, "only administrators can change interface limits", http.StatusForbidden)
+		return
+	}
+	urlvars := mux.Vars(req)
+	if err := req.ParseForm(); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bandwidth := compute.VirtualMachineInterfaceBandwidth{}
+	if req.Form.Get("Action") != "clear" {
+		parsed, err := formBandwidth(req.Form)
+		if err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		bandwidth = parsed
+	}
+	if err := env.vms.SetInterfaceBandwidth(urlvars["id"], urlvars["node"], req.Form.Get("Mac"), bandwidth); err != nil {
+		env.error(rw, req, err, "cannot set interface limits", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("virtual-machine-detail", "id", urlvars["id"], "node", urlvars["node"])
+	http.Redirect(rw, req, redirectUrl.Path+"?tab=interfaces", http.StatusFound)
+}
@@ -1,7 +1,13 @@
 package web
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"subuk/vmango/compute"
@@ -225,3 +231,116 @@ func (env *Environ) VolumeAddFormProcess(rw http.ResponseWriter, req *http.Reque
 	}
 	http.Redirect(rw, req, redirectUrl, http.StatusFound)
 }
+
+func (env *Environ) VolumeImportFormShow(rw http.ResponseWriter, req *http.Request) {
+	nodes, err := env.nodes.List(compute.NodeListOptions{NoPins: true})
+	if err != nil {
+		env.error(rw, req, err, "nodes list failed", http.StatusInternalServerError)
+		return
+	}
+	if len(nodes) == 0 {
+		env.error(rw, req, fmt.Errorf("no nodes available"), "cannot import volume", http.StatusInternalServerError)
+		return
+	}
+	selectedNodeId := req.URL.Query().Get("node")
+	if selectedNodeId == "" {
+		selectedNodeId = nodes[0].Id
+	}
+	pools, err := env.volpools.List(compute.VolumePoolListOptions{NodeIds: []string{selectedNodeId}})
+	if err != nil {
+		env.error(rw, req, err, "pool list failed", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Title         string
+		NodeId        string
+		Nodes         []*compute.Node
+		Pools         []*compute.VolumePool
+		SourceFormats []compute.VolumeFormat
+		VolumeFormats []compute.VolumeFormat
+		User          *User
+		Request       *http.Request
+	}{"Import Volume", selectedNodeId, nodes, pools, ImportVolumeFormats, UIVolumeFormats, env.Session(req).AuthUser(), req}
+	if err := env.render.HTML(rw, http.StatusOK, "volume/import", data); err != nil {
+		env.error(rw, req, err, "failed to render template", http.StatusInternalServerError)
+		return
+	}
+}
+
+func (env *Environ) VolumeImportFormProcess(rw http.ResponseWriter, req *http.Request) {
+	if err := req.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(rw, err.Error(), http.StatusBadRequest)
+		return
+	}
+	upload, uploadHeader, err := req.FormFile("File")
+	if err != nil {
+		http.Error(rw, "image file required: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer upload.Close()
+
+	// qemu-img works with files only, small uploads are kept in memory by multipart parser
+	tmpfile, err := ioutil.TempFile("", "vmango-upload")
+	if err != nil {
+		env.error(rw, req, err, "cannot create temporary file", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+	if _, err := io.Copy(tmpfile, upload); err != nil {
+		env.error(rw, req, err, "cannot save uploaded file", http.StatusInternalServerError)
+		return
+	}
+
+	nodeId := req.Form.Get("NodeId")
+	pool := req.Form.Get("Pool")
+	format := compute.NewVolumeFormat(req.Form.Get("Format"))
+	sourceFormat := req.Form.Get("SourceFormat")
+	if sourceFormat == "" {
+		// Format is never probed, image header of untrusted file may refer to host files
+		http.Error(rw, "source format required", http.StatusBadRequest)
+		return
+	}
+	if sourceFormat == "ova" {
+		machine, err := env.volumes.ImportOva(nodeId, pool, format, tmpfile.Name())
+		if err != nil {
+			env.error(rw, req, err, "cannot import ova", http.StatusInternalServerError)
+			return
+		}
+		query := url.Values{}
+		query.Set("mode", "advanced")
+		query.Set("node", nodeId)
+		query.Set("name", machine.Name)
+		query.Set("firmware", machine.Firmware)
+		if machine.VCpus > 0 {
+			query.Set("vcpus", strconv.Itoa(machine.VCpus))
+		}
+		if machine.Memory.Bytes() > 0 {
+			query.Set("memory", strconv.FormatUint(machine.Memory.M(), 10))
+		}
+		for _, volume := range machine.Volumes {
+			query.Add("attach", volume.Path)
+		}
+		redirectUrl := env.url("virtual-machine-add")
+		http.Redirect(rw, req, redirectUrl.Path+"?"+query.Encode(), http.StatusFound)
+		return
+	}
+
+	name := req.Form.Get("Name")
+	if name == "" {
+		name = strings.TrimSuffix(uploadHeader.Filename, filepath.Ext(uploadHeader.Filename))
+	}
+	params := compute.VolumeImportParams{
+		NodeId:       nodeId,
+		Name:         name,
+		Pool:         pool,
+		Format:       format,
+		SourceFormat: compute.NewVolumeFormat(sourceFormat),
+	}
+	if _, err := env.volumes.Import(params, tmpfile.Name()); err != nil {
+		env.error(rw, req, err, "cannot import volume", http.StatusInternalServerError)
+		return
+	}
+	redirectUrl := env.url("volume-list")
+	http.Redirect(rw, req, redirectUrl.Path+"?node="+url.QueryEscape(nodeId), http.StatusFound)
+}
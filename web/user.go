@@ -5,4 +5,5 @@ type User struct {
 	FullName      string
 	Email         string
 	Authenticated bool
+	Admin         bool
 }